@@ -0,0 +1,70 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"net"
+	"net/http"
+)
+
+// 握手之前调用, 返回false拒绝这个连接, 可以用来做黑白名单, 按租户限流等
+// attach保存到Conn里, 之后(包括OnOpen)通过Conn.Session取出
+// http1的fd是底层tcp连接的fd, 只在回调期间有效, 可以用来getsockopt; http2和拿不到fd的连接(见UpgradeConn)传-1
+type OnAcceptFunc func(fd int, addr net.Addr) (allow bool, attach any)
+
+var bytesAcceptRejected = []byte("HTTP/1.1 403 Forbidden\r\nContent-Length: 0\r\nConnection: close\r\n\r\n")
+
+// http1, 已经hijack, 还没有回101
+func (c *Config) accept(conn net.Conn) (session any, err error) {
+	if c.onAccept == nil {
+		return nil, nil
+	}
+
+	allow := false
+	err = controlConn(conn, func(fd int) {
+		allow, session = c.onAccept(fd, conn.RemoteAddr())
+	})
+	if err != nil {
+		allow, session = c.onAccept(-1, conn.RemoteAddr())
+	}
+
+	if !allow {
+		conn.Write(bytesAcceptRejected)
+		return nil, ErrAcceptRejected
+	}
+	return session, nil
+}
+
+// http2, 还没有回200
+func (c *Config) acceptHTTP2(w http.ResponseWriter, r *http.Request) (session any, err error) {
+	if c.onAccept == nil {
+		return nil, nil
+	}
+
+	_, addr := addrFromRequest(r)
+	allow, session := c.onAccept(-1, addr)
+	if !allow {
+		return nil, c.reject(w, r, http.StatusForbidden, ErrAcceptRejected)
+	}
+	return session, nil
+}
+
+// 返回OnAccept保存的attach
+func (c *Conn) Session() any {
+	return c.session
+}
@@ -0,0 +1,71 @@
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_OnAccept(t *testing.T) {
+	m := NewMultiEventLoopMust(WithEventLoops(1))
+	m.Start()
+
+	sessions := make(chan any, 1)
+	upgradeErr := make(chan error, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := Upgrade(w, r, WithServerMultiEventLoop(m),
+			WithServerOnAccept(func(fd int, addr net.Addr) (bool, any) {
+				if fd < 0 || addr == nil {
+					t.Errorf("fd = %d, addr = %v", fd, addr)
+				}
+				if r.URL.Query().Get("deny") != "" {
+					return false, nil
+				}
+				return true, "tenant-1"
+			}),
+			WithServerCallbackFunc(func(c *Conn) {
+				sessions <- c.Session()
+			}, nil, nil))
+		upgradeErr <- err
+	}))
+	defer ts.Close()
+
+	url := "ws://" + strings.TrimPrefix(ts.URL, "http://")
+
+	t.Run("allow", func(t *testing.T) {
+		c, err := Dial(url, WithClientMultiEventLoop(m))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		if err := <-upgradeErr; err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case s := <-sessions:
+			if s != "tenant-1" {
+				t.Fatalf("session = %v", s)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("OnOpen not called")
+		}
+	})
+
+	t.Run("deny", func(t *testing.T) {
+		_, err := Dial(url+"?deny=1", WithClientMultiEventLoop(m))
+		var he *HandshakeError
+		if !errors.As(err, &he) || he.Response.StatusCode != http.StatusForbidden {
+			t.Fatalf("err = %v", err)
+		}
+		if err := <-upgradeErr; err != ErrAcceptRejected {
+			t.Fatalf("upgrade err = %v", err)
+		}
+	})
+}
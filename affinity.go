@@ -0,0 +1,184 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 默认的亲和性token的http头和cookie的名字
+const defaultAffinityName = "X-Websocket-Affinity"
+
+// 亲和性token的编解码, 可以自己实现签名或者加密, 防止客户端伪造节点, 也不暴露内部的节点名
+type AffinityCodec interface {
+	Encode(node string) (token string, err error)
+	Decode(token string) (node string, err error)
+}
+
+// 粘性会话, 服务端在握手响应里下发当前节点的token, 客户端重连时带上,
+// 前面的l4/l7负载均衡按token把重连路由回保存会话状态的节点
+type Affinity struct {
+	Name   string        // http头或者cookie的名字, 为空使用X-Websocket-Affinity
+	Node   string        // 当前节点的标识
+	Cookie bool          // 用Set-Cookie下发, 默认用响应头. 大部分负载均衡可以直接按cookie路由
+	MaxAge time.Duration // cookie的有效期, 0表示会话cookie
+	Codec  AffinityCodec // 为空不编码, token就是Node
+}
+
+func (a *Affinity) name() string {
+	if a.Name == "" {
+		return defaultAffinityName
+	}
+	return a.Name
+}
+
+// 握手响应里带的token, 编码失败不下发
+func (a *Affinity) responseHeader(*http.Request) http.Header {
+	token := a.Node
+	if a.Codec != nil {
+		var err error
+		if token, err = a.Codec.Encode(a.Node); err != nil {
+			return nil
+		}
+	}
+
+	h := make(http.Header)
+	if !a.Cookie {
+		h.Set(a.name(), token)
+		return h
+	}
+	cookie := &http.Cookie{Name: a.name(), Value: token, Path: "/", HttpOnly: true}
+	if a.MaxAge > 0 {
+		cookie.MaxAge = int(a.MaxAge / time.Second)
+	}
+	h.Add("Set-Cookie", cookie.String())
+	return h
+}
+
+// 取出握手请求里带的token, 解码出客户端上一次连接的节点, 不一致说明被路由到了别的节点, 会话状态需要迁移
+// 请求里没有token返回空字符串, token不合法返回ErrAffinityToken
+func (a *Affinity) RequestNode(r *http.Request) (string, error) {
+	var token string
+	if a.Cookie {
+		if cookie, err := r.Cookie(a.name()); err == nil {
+			token = cookie.Value
+		}
+	} else {
+		token = r.Header.Get(a.name())
+	}
+	if token == "" {
+		return "", nil
+	}
+	if a.Codec == nil {
+		return token, nil
+	}
+	node, err := a.Codec.Decode(token)
+	if err != nil {
+		return "", ErrAffinityToken
+	}
+	return node, nil
+}
+
+// 用hmac-sha256签名的codec, token是base64(node).base64(签名), 客户端可以看到节点名, 但是不能伪造
+// 所有节点使用同一个key
+func NewHMACAffinityCodec(key []byte) AffinityCodec {
+	return &hmacAffinityCodec{key: append([]byte(nil), key...)}
+}
+
+type hmacAffinityCodec struct {
+	key []byte
+}
+
+// 签名截断到16字节, token短一些, 放在cookie里也够用
+const affinitySigSize = 16
+
+func (h *hmacAffinityCodec) sign(node string) []byte {
+	mac := hmac.New(sha256.New, h.key)
+	mac.Write([]byte(node))
+	return mac.Sum(nil)[:affinitySigSize]
+}
+
+func (h *hmacAffinityCodec) Encode(node string) (string, error) {
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString([]byte(node)) + "." + enc.EncodeToString(h.sign(node)), nil
+}
+
+func (h *hmacAffinityCodec) Decode(token string) (string, error) {
+	enc := base64.RawURLEncoding
+	n, s, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", ErrAffinityToken
+	}
+	node, err := enc.DecodeString(n)
+	if err != nil {
+		return "", ErrAffinityToken
+	}
+	sig, err := enc.DecodeString(s)
+	if err != nil || !hmac.Equal(sig, h.sign(string(node))) {
+		return "", ErrAffinityToken
+	}
+	return string(node), nil
+}
+
+// 客户端保存的token, 同一个WithClientAffinity返回的option多次Dial共用, 比如ReconnectingDialer
+type affinityToken struct {
+	mu     sync.Mutex
+	name   string
+	value  string
+	cookie bool // 服务端是用Set-Cookie下发的, 重连时用Cookie带回去
+}
+
+// 握手请求带上上一次保存的token, token是cookie并且配置了CookieJar的话, 由jar带上
+func (t *affinityToken) prepareRequest(req *http.Request, jar http.CookieJar) {
+	t.mu.Lock()
+	value, cookie := t.value, t.cookie
+	t.mu.Unlock()
+
+	switch {
+	case value == "":
+	case !cookie:
+		req.Header.Set(t.name, value)
+	case jar == nil:
+		req.AddCookie(&http.Cookie{Name: t.name, Value: value})
+	}
+}
+
+// 保存服务端下发的token, 响应里没有的话保留原来的, 握手失败也保存
+func (t *affinityToken) save(rsp *http.Response) {
+	value, cookie := rsp.Header.Get(t.name), false
+	if value == "" {
+		for _, c := range rsp.Cookies() {
+			if c.Name == t.name {
+				value, cookie = c.Value, true
+			}
+		}
+	}
+	if value == "" {
+		return
+	}
+
+	t.mu.Lock()
+	t.value, t.cookie = value, cookie
+	t.mu.Unlock()
+}
@@ -0,0 +1,36 @@
+// Copyright 2023-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux
+// +build linux
+
+package greatws
+
+import (
+	"runtime"
+
+	"golang.org/x/sys/unix"
+)
+
+// 把当前go程锁在os线程上, 再把线程绑定到el.cpu
+// 必须在事件循环的go程里调用
+func (el *EventLoop) pinCPU() {
+	runtime.LockOSThread()
+
+	var set unix.CPUSet
+	set.Set(el.cpu)
+	if err := unix.SchedSetaffinity(0, &set); err != nil {
+		el.parent.Error("sched_setaffinity", "cpu", el.cpu, "err", err.Error())
+	}
+}
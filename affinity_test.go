@@ -0,0 +1,95 @@
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_HMACAffinityCodec(t *testing.T) {
+	codec := NewHMACAffinityCodec([]byte("secret"))
+	token, err := codec.Encode("node-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node, err := codec.Decode(token); err != nil || node != "node-1" {
+		t.Fatalf("node = %q, err = %v", node, err)
+	}
+
+	// 换一个key或者改了节点名都解不出来
+	if _, err := NewHMACAffinityCodec([]byte("other")).Decode(token); !errors.Is(err, ErrAffinityToken) {
+		t.Fatalf("other key: %v", err)
+	}
+	forged, _ := NewHMACAffinityCodec([]byte("other")).Encode("node-2")
+	for _, bad := range []string{"", "node-1", forged, strings.Replace(token, ".", "x.", 1)} {
+		if _, err := codec.Decode(bad); !errors.Is(err, ErrAffinityToken) {
+			t.Fatalf("%q: %v", bad, err)
+		}
+	}
+}
+
+func Test_Affinity(t *testing.T) {
+	for _, cookie := range []bool{false, true} {
+		name := "header"
+		if cookie {
+			name = "cookie"
+		}
+		t.Run(name, func(t *testing.T) {
+			m := NewMultiEventLoopMust(WithEventLoops(1))
+			m.Start()
+
+			a := Affinity{Name: "X-Node", Node: "node-1", Cookie: cookie, Codec: NewHMACAffinityCodec([]byte("secret"))}
+			nodes := make(chan string, 2)
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				node, err := a.RequestNode(r)
+				if err != nil {
+					t.Error(err)
+				}
+				nodes <- node
+				if _, err := Upgrade(w, r, WithServerMultiEventLoop(m), WithServerAffinity(a)); err != nil {
+					t.Error(err)
+				}
+			}))
+			defer ts.Close()
+
+			// 同一个option给两次Dial用, 第二次带上第一次拿到的token
+			opt := WithClientAffinity("X-Node")
+			url := "ws://" + strings.TrimPrefix(ts.URL, "http://")
+			for i, want := range []string{"", "node-1"} {
+				c, err := Dial(url, WithClientMultiEventLoop(m), opt)
+				if err != nil {
+					t.Fatal(err)
+				}
+				c.Close()
+				if got := <-nodes; got != want {
+					t.Fatalf("dial %d: node = %q, want %q", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func Test_AffinityRequestNode(t *testing.T) {
+	a := Affinity{Node: "node-1", Codec: NewHMACAffinityCodec([]byte("secret"))}
+	r := httptest.NewRequest("GET", "/", nil)
+	if node, err := a.RequestNode(r); node != "" || err != nil {
+		t.Fatalf("no token: %q, %v", node, err)
+	}
+
+	r.Header.Set(defaultAffinityName, "forged")
+	if _, err := a.RequestNode(r); !errors.Is(err, ErrAffinityToken) {
+		t.Fatalf("forged: %v", err)
+	}
+
+	// 没有codec, token就是节点名
+	a.Codec = nil
+	h := a.responseHeader(r)
+	if h.Get(defaultAffinityName) != "node-1" {
+		t.Fatalf("header = %v", h)
+	}
+}
@@ -20,6 +20,7 @@ package greatws
 import (
 	"errors"
 	"io"
+	"log/slog"
 	"time"
 
 	"golang.org/x/sys/unix"
@@ -33,6 +34,7 @@ const (
 
 type epollState struct {
 	epfd   int
+	wakeFd int // eventfd, 用来唤醒阻塞在epoll_wait里的事件循环
 	events []unix.EpollEvent
 
 	parent *EventLoop
@@ -46,6 +48,20 @@ func apiEpollCreate(parent *EventLoop) (la linuxApi, err error) {
 		return nil, err
 	}
 
+	e.wakeFd, err = unix.Eventfd(0, unix.EFD_NONBLOCK|unix.EFD_CLOEXEC)
+	if err != nil {
+		unix.Close(e.epfd)
+		return nil, err
+	}
+	if err = unix.EpollCtl(e.epfd, unix.EPOLL_CTL_ADD, e.wakeFd, &unix.EpollEvent{
+		Fd:     int32(e.wakeFd),
+		Events: unix.EPOLLIN,
+	}); err != nil {
+		unix.Close(e.wakeFd)
+		unix.Close(e.epfd)
+		return nil, err
+	}
+
 	e.events = make([]unix.EpollEvent, 128)
 	e.parent = parent
 	return &e, nil
@@ -53,22 +69,32 @@ func apiEpollCreate(parent *EventLoop) (la linuxApi, err error) {
 
 // 释放
 func (e *epollState) apiFree() {
+	unix.Close(e.wakeFd)
 	unix.Close(e.epfd)
 }
 
+// 在另外一个线程唤醒epoll_wait
+func (e *epollState) wakeup() error {
+	var one = [8]byte{1}
+	_, err := unix.Write(e.wakeFd, one[:])
+	return err
+}
+
 // 新加读事件
 func (e *epollState) addRead(c *Conn) error {
 	fd := int(c.getFd())
 	return unix.EpollCtl(e.epfd, unix.EPOLL_CTL_ADD, fd, &unix.EpollEvent{
 		Fd:     int32(fd),
+		Pad:    int32(c.gen),
 		Events: unix.EPOLLERR | unix.EPOLLHUP | unix.EPOLLRDHUP | unix.EPOLLPRI | unix.EPOLLIN | EPOLLET,
 	})
 }
 
-func (e *epollState) addWrite(c *Conn, writeSeq uint16) error {
+func (e *epollState) addWrite(c *Conn, writeSeq uint32) error {
 	fd := int(c.getFd())
 	return unix.EpollCtl(e.epfd, unix.EPOLL_CTL_MOD, fd, &unix.EpollEvent{
 		Fd:     int32(fd),
+		Pad:    int32(c.gen),
 		Events: unix.EPOLLERR | unix.EPOLLHUP | unix.EPOLLRDHUP | unix.EPOLLPRI | unix.EPOLLIN | EPOLLET | unix.EPOLLOUT,
 	})
 }
@@ -77,10 +103,22 @@ func (e *epollState) delWrite(c *Conn) error {
 	fd := int(c.getFd())
 	return unix.EpollCtl(e.epfd, unix.EPOLL_CTL_MOD, fd, &unix.EpollEvent{
 		Fd:     int32(fd),
+		Pad:    int32(c.gen),
 		Events: unix.EPOLLERR | unix.EPOLLHUP | unix.EPOLLRDHUP | unix.EPOLLPRI | unix.EPOLLIN,
 	})
 }
 
+// 边缘触发下, 暂停期间内核里积压的数据不会再产生事件
+// 重新MOD一次, 如果已经可读会马上再报一次, 写事件也一起带上, 避免丢掉等待中的可写事件
+func (e *epollState) rearmRead(c *Conn) error {
+	fd := int(c.getFd())
+	return unix.EpollCtl(e.epfd, unix.EPOLL_CTL_MOD, fd, &unix.EpollEvent{
+		Fd:     int32(fd),
+		Pad:    int32(c.gen),
+		Events: unix.EPOLLERR | unix.EPOLLHUP | unix.EPOLLRDHUP | unix.EPOLLPRI | unix.EPOLLIN | EPOLLET | unix.EPOLLOUT,
+	})
+}
+
 // 删除事件
 func (e *epollState) del(fd int) error {
 	return unix.EpollCtl(e.epfd, unix.EPOLL_CTL_DEL, fd, &unix.EpollEvent{Fd: int32(fd)})
@@ -88,8 +126,9 @@ func (e *epollState) del(fd int) error {
 
 // 事件循环
 func (e *epollState) apiPoll(tv time.Duration) (retVal int, err error) {
+	// 小于0一直等待, 等于0不等待, TestEventLoop.Tick用
 	msec := -1
-	if tv > 0 {
+	if tv >= 0 {
 		msec = int(tv) / int(time.Millisecond)
 	}
 
@@ -105,9 +144,19 @@ func (e *epollState) apiPoll(tv time.Duration) (retVal int, err error) {
 		numEvents = retVal
 		for i := 0; i < numEvents; i++ {
 			ev := &e.events[i]
-			conn := e.parent.parent.getConn(int(ev.Fd))
+			if e.parent.parent.debug.Load() {
+				e.parent.parent.Debug("epoll event", slog.Int("fd", int(ev.Fd)), slog.Uint64("events", uint64(ev.Events)))
+			}
+			if int(ev.Fd) == e.wakeFd {
+				var buf [8]byte
+				unix.Read(e.wakeFd, buf[:])
+				continue
+			}
+
+			// 事件里带着注册时的代数, fd关闭之后被新连接复用, 旧的事件不会交给新连接
+			// 找不到连接也不能关闭fd, 它可能已经属于别的连接
+			conn := e.parent.parent.getConnGen(int(ev.Fd), uint32(ev.Pad))
 			if conn == nil {
-				unix.Close(int(ev.Fd))
 				continue
 			}
 
@@ -115,16 +164,17 @@ func (e *epollState) apiPoll(tv time.Duration) (retVal int, err error) {
 				// 读取数据，这里要发行下websocket的解析变成流式解析
 				_, err = conn.processWebsocketFrame()
 				if err != nil {
-					go conn.closeAndWaitOnMessage(true, err)
+					conn.asyncClose(err)
 				}
 			}
 			if ev.Events&unix.EPOLLOUT > 0 {
 				// 刷新下直接写入失败的数据
-				conn.flushOrClose()
+				e.parent.flushWritable(conn)
 			}
-			if ev.Events&(unix.EPOLLERR|unix.EPOLLHUP|unix.EPOLLRDHUP) > 0 {
-				// TODO 完善下细节
-				go conn.closeAndWaitOnMessage(true, io.EOF)
+			// EPOLLRDHUP是对端关闭了写, 上面读到eof的时候会先把数据交给回调, 再走关闭握手
+			// 读暂停的时候等恢复读之后再处理, 这里不关闭
+			if ev.Events&(unix.EPOLLERR|unix.EPOLLHUP) > 0 {
+				conn.asyncClose(io.EOF)
 			}
 		}
 
@@ -13,6 +13,7 @@ import (
 	"unsafe"
 
 	"github.com/pawelgaczynski/giouring"
+	"golang.org/x/sys/unix"
 )
 
 type iouringState struct {
@@ -20,20 +21,87 @@ type iouringState struct {
 	ring        *giouring.Ring // ring 对象
 	ringEntries uint32
 	parent      *EventLoop
+	sendZC      bool                             // 内核支持IORING_OP_SEND_ZC(>=6.0)
+	cqes        []*giouring.CompletionQueueEvent // 每次最多取出的cqe
 	submitter
+
+	writeTimeout time.Duration // 见ioUringConfig.writeTimeout
+	lastReap     time.Time     // 上一次检查超时写请求的时间
+
+	// 超时取消的写请求, key是写请求的UserData
+	// 连接已经关闭了, 这里持有内存直到内核不再引用, 只在事件循环的go程里访问
+	canceled map[uint64]*ioUringWrite
+}
+
+const (
+	defaultIoUringEntries     = 16384
+	defaultIoUringWaitTimeout = time.Millisecond
+	minIoUringCQEBatch        = 32
+	maxIoUringCQEBatch        = 4096
+
+	defaultIoUringWriteTimeout = 30 * time.Second
+)
+
+// 没有配置的字段使用默认值
+// cqe的批量大小没有配置时按ring的大小计算, 16384个entry对应256, cq的长度是entry的两倍, 批量大小不会超过它
+func (conf *ioUringConfig) fill() {
+	if conf.entries == 0 {
+		conf.entries = defaultIoUringEntries
+	}
+	if conf.waitTimeout <= 0 {
+		conf.waitTimeout = defaultIoUringWaitTimeout
+	}
+	if conf.writeTimeout == 0 {
+		conf.writeTimeout = defaultIoUringWriteTimeout
+	}
+	if conf.cqeBatch <= 0 {
+		conf.cqeBatch = int(conf.entries / 64)
+		if conf.cqeBatch < minIoUringCQEBatch {
+			conf.cqeBatch = minIoUringCQEBatch
+		}
+		if conf.cqeBatch > maxIoUringCQEBatch {
+			conf.cqeBatch = maxIoUringCQEBatch
+		}
+	}
+	if max := int(conf.entries) * 2; conf.cqeBatch > max {
+		conf.cqeBatch = max
+	}
 }
 
-func apiIoUringCreate(el *EventLoop, ringEntries uint32) (la linuxApi, err error) {
+func apiIoUringCreate(el *EventLoop) (la linuxApi, err error) {
 	var iouringState iouringState
 
-	ring, err := giouring.CreateRing(ringEntries)
-	iouringState.submitter = newBatchSubmitter(ring)
+	var conf ioUringConfig
+	if el.parent != nil {
+		conf = el.parent.ioUring
+	}
+	conf.fill()
+
+	ring, err := giouring.CreateRing(conf.entries)
+	if err != nil {
+		return nil, err
+	}
+	if probe, err := ring.GetProbeRing(); err == nil {
+		iouringState.sendZC = probe.IsSupported(giouring.OpSendZC)
+	}
+	iouringState.submitter = newBatchSubmitter(ring, conf.waitTimeout)
 	iouringState.ring = ring
+	iouringState.ringEntries = conf.entries
+	iouringState.cqes = make([]*giouring.CompletionQueueEvent, conf.cqeBatch)
 	iouringState.parent = el
+	iouringState.writeTimeout = conf.writeTimeout
+	iouringState.lastReap = time.Now()
+	iouringState.canceled = make(map[uint64]*ioUringWrite)
 	return &iouringState, nil
 }
 
 func (e *iouringState) apiFree() {
+	e.ring.QueueExit()
+}
+
+// apiPoll每次最多等待ioUringConfig.waitTimeout, 不需要额外唤醒
+func (e *iouringState) wakeup() error {
+	return nil
 }
 
 type iouringConn struct {
@@ -71,17 +139,22 @@ func (e *iouringState) addRead(c *Conn) error {
 	if entry == nil {
 		return errors.New("addRead: fail:GetSQE is nil")
 	}
+	if c.fd > userDataFdMask {
+		return fmt.Errorf("addRead: fail: fd too large for io_uring user data:%d", c.fd)
+	}
 
+	// processWebsocketFrameOnlyIoUring已经把能解析的frame都解析完了, 这里一定有空闲空间
+	ws := c.rbuf.WriteSlice()
 	entry.PrepareRecv(
 		int(c.fd),
-		uintptr((*reflect.SliceHeader)(unsafe.Pointer(c.rbuf)).Data+uintptr(c.rw)),
-		uint32(len((*c.rbuf)[c.rw:])),
+		uintptr(unsafe.Pointer(unsafe.SliceData(ws))),
+		uint32(len(ws)),
 		0)
 	entry.UserData = encodeUserData(uint32(c.fd), opRead, 0)
 	return nil
 }
 
-func (e *iouringState) addWrite(c *Conn, writeSeq uint16) error {
+func (e *iouringState) addWrite(c *Conn, writeSeq uint32) error {
 	e.mu.Lock()
 	entry := e.ring.GetSQE()
 	e.mu.Unlock()
@@ -91,7 +164,7 @@ func (e *iouringState) addWrite(c *Conn, writeSeq uint16) error {
 
 	conn := e.getConn(uint32(c.fd))
 
-	v, ok := conn.m.Load(uint32(writeSeq))
+	v, ok := conn.m.Load(writeSeq)
 	if !ok {
 		return fmt.Errorf("addWrite: fail: writeSeq not found:%d", writeSeq)
 	}
@@ -106,10 +179,20 @@ func (e *iouringState) addWrite(c *Conn, writeSeq uint16) error {
 		uintptr((*reflect.SliceHeader)(unsafe.Pointer(&ioState.writeBuf)).Data),
 		uint32(len(ioState.writeBuf)),
 		0)
-	entry.UserData = encodeUserData(uint32(c.fd), opWrite, uint32(writeSeq))
+	if e.useSendZC(len(ioState.writeBuf)) {
+		// giouring的PrepareSendZC传的是slice头的地址, 这里只换opcode, 其他字段和send一样
+		entry.OpCode = giouring.OpSendZC
+	}
+	entry.UserData = encodeUserData(uint32(c.fd), opWrite, writeSeq)
 	return nil
 }
 
+// 大于等于阈值的数据使用零拷贝发送
+func (e *iouringState) useSendZC(n int) bool {
+	threshold := e.parent.parent.sendZCThreshold
+	return e.sendZC && threshold > 0 && n >= threshold
+}
+
 func (e *iouringState) del(c *Conn) error {
 	fd := c.fd
 
@@ -134,8 +217,17 @@ func (e *iouringState) getConn(fd uint32) *Conn {
 
 // io-uring 处理事件的入口函数
 func (e *iouringState) processConn(cqe *giouring.CompletionQueueEvent) error {
+	if w, ok := e.canceled[cqe.UserData]; ok {
+		e.processCanceledWrite(cqe.UserData, cqe, w)
+		return nil
+	}
+
 	// c := (*Conn)(unsafe.Pointer(uintptr(cqe.UserData)))
 	fd, op, writeSeq := decodeUserData(cqe.UserData)
+	if op == 0 {
+		e.processCancel(cqe, fd, writeSeq)
+		return nil
+	}
 
 	c := e.getConn(fd)
 	if c == nil {
@@ -160,9 +252,9 @@ func (e *iouringState) processConn(cqe *giouring.CompletionQueueEvent) error {
 	return nil
 }
 
-func (e *iouringState) run(timeout time.Duration) error {
+func (e *iouringState) run() error {
 	var err error
-	cqes := make([]*giouring.CompletionQueueEvent, 256 /*TODO:*/)
+	cqes := e.cqes
 
 	e.mu.Lock()
 	if err = e.submit(); err != nil {
@@ -188,11 +280,80 @@ func (e *iouringState) run(timeout time.Duration) error {
 	}
 	e.advance(numberOfCQEs)
 
+	e.reapStaleWrites(time.Now())
+	return nil
+}
+
+// 每半个写超时检查一次这个事件循环上的连接
+// 有写请求超时的连接直接关闭, 对端收到的数据已经不完整了
+// 超时的写请求提交IORING_OP_ASYNC_CANCEL, 内存等写请求自己的cqe到了再释放
+func (e *iouringState) reapStaleWrites(now time.Time) {
+	if e.writeTimeout <= 0 || now.Sub(e.lastReap) < e.writeTimeout/2 {
+		return
+	}
+	e.lastReap = now
+	for _, c := range e.parent.snapshotConns() {
+		stale := c.takeStaleWrites(now, e.writeTimeout)
+		if len(stale) == 0 {
+			continue
+		}
+
+		e.getLogger().Warn("io_uring write timeout", slog.Int64("fd", c.fd), slog.Int("canceled", len(stale)))
+		for seq, w := range stale {
+			userData := encodeUserData(uint32(c.fd), opWrite, seq)
+			e.canceled[userData] = w
+			if err := e.cancelWrite(userData); err != nil {
+				// 取消不了就一直持有这块内存, 写请求的cqe到了照样释放
+				e.getLogger().Error("io_uring cancel write", "err", err, "fd", c.fd, "seq", seq)
+			}
+		}
+		c.setCloseReason(ErrIoUringWriteTimeout)
+		c.asyncClose(ErrIoUringWriteTimeout)
+	}
+}
+
+// 取消一个写请求, 取消自己的cqe的op是0, fd和seq和写请求一样
+func (e *iouringState) cancelWrite(userData uint64) error {
+	e.mu.Lock()
+	entry := e.ring.GetSQE()
+	e.mu.Unlock()
+	if entry == nil {
+		return errors.New("cancelWrite: fail: GetSQE is nil")
+	}
+
+	fd, _, seq := decodeUserData(userData)
+	entry.PrepareCancel64(userData, 0)
+	entry.UserData = encodeUserData(fd, 0, seq)
 	return nil
 }
 
+// 被取消的写请求的cqe, 成功失败都一样, 没有后续的cqe了就释放
+func (e *iouringState) processCanceledWrite(userData uint64, cqe *giouring.CompletionQueueEvent, w *ioUringWrite) {
+	if cqe.Flags&giouring.CQEFMore != 0 {
+		// SEND_ZC, 等通知的cqe
+		w.sent = true
+		return
+	}
+	delete(e.canceled, userData)
+	w.free()
+}
+
+// 取消请求自己的cqe
+// ENOENT说明写请求已经不在内核里了, 它的cqe丢了, 除了还在等SEND_ZC通知的, 都可以释放
+// 其他结果写请求自己还会有cqe, 在processCanceledWrite里释放
+func (e *iouringState) processCancel(cqe *giouring.CompletionQueueEvent, fd uint32, writeSeq uint32) {
+	if cqe.Res != -int32(unix.ENOENT) {
+		return
+	}
+	userData := encodeUserData(fd, opWrite, writeSeq)
+	if w, ok := e.canceled[userData]; ok && !w.sent {
+		delete(e.canceled, userData)
+		w.free()
+	}
+}
+
 func (e *iouringState) apiPoll(tv time.Duration) (retVal int, err error) {
-	if err := e.run(time.Millisecond * 333); err != nil {
+	if err := e.run(); err != nil {
 		return 0, err
 	}
 	return 0, nil
@@ -202,6 +363,11 @@ func (e *iouringState) delWrite(c *Conn) error {
 	return nil
 }
 
+// io_uring模式不支持暂停读
+func (e *iouringState) rearmRead(c *Conn) error {
+	return nil
+}
+
 func (e *iouringState) apiName() string {
 	return "io_uring"
 }
@@ -99,10 +99,10 @@ func (s *batchSubmitter) advance(n uint32) {
 	s.waitFor = waitForArray[s.waitForIndex]
 }
 
-func newBatchSubmitter(ring *giouring.Ring) *batchSubmitter {
+func newBatchSubmitter(ring *giouring.Ring, timeout time.Duration) *batchSubmitter {
 	submitter := &batchSubmitter{
 		ring:            ring,
-		timeoutTimeSpec: syscall.NsecToTimespec((time.Millisecond).Nanoseconds()),
+		timeoutTimeSpec: syscall.NsecToTimespec(timeout.Nanoseconds()),
 	}
 	submitter.waitFor = waitForArray[submitter.waitForIndex]
 
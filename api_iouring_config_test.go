@@ -0,0 +1,45 @@
+//go:build linux
+// +build linux
+
+package greatws
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_IoUringConfigFill(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		in   ioUringConfig
+		want ioUringConfig
+	}{
+		{"default", ioUringConfig{}, ioUringConfig{entries: 16384, cqeBatch: 256, waitTimeout: time.Millisecond, writeTimeout: 30 * time.Second}},
+		{"scale small", ioUringConfig{entries: 256}, ioUringConfig{entries: 256, cqeBatch: 32, waitTimeout: time.Millisecond, writeTimeout: 30 * time.Second}},
+		{"scale large", ioUringConfig{entries: 1 << 20}, ioUringConfig{entries: 1 << 20, cqeBatch: 4096, waitTimeout: time.Millisecond, writeTimeout: 30 * time.Second}},
+		{"batch capped by cq", ioUringConfig{entries: 8, cqeBatch: 100}, ioUringConfig{entries: 8, cqeBatch: 16, waitTimeout: time.Millisecond, writeTimeout: 30 * time.Second}},
+		{"explicit", ioUringConfig{entries: 1024, cqeBatch: 500, waitTimeout: time.Second, writeTimeout: time.Minute}, ioUringConfig{entries: 1024, cqeBatch: 500, waitTimeout: time.Second, writeTimeout: time.Minute}},
+		{"write timeout disabled", ioUringConfig{writeTimeout: -1}, ioUringConfig{entries: 16384, cqeBatch: 256, waitTimeout: time.Millisecond, writeTimeout: -1}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := tc.in
+			got.fill()
+			if got != tc.want {
+				t.Fatalf("got %+v, want %+v", got, tc.want)
+			}
+		})
+	}
+}
+
+func Test_IoUringOptions(t *testing.T) {
+	m := NewMultiEventLoopMust(WithEventLoops(1), WithIoUringEntries(512), WithIoUringCQEBatch(64), WithIoUringWaitTimeout(5*time.Millisecond), WithIoUringWriteTimeout(time.Second))
+	want := ioUringConfig{entries: 512, cqeBatch: 64, waitTimeout: 5 * time.Millisecond, writeTimeout: time.Second}
+	if m.ioUring != want {
+		t.Fatalf("got %+v, want %+v", m.ioUring, want)
+	}
+	for _, el := range m.loops {
+		if el.parent != m {
+			t.Fatal("parent not set")
+		}
+	}
+}
@@ -11,9 +11,16 @@ import (
 )
 
 func (c *Conn) processRead(cqe *giouring.CompletionQueueEvent) error {
-	// 返回值小于等于0，表示读取完毕，关闭连接
-	if cqe.Res <= 0 {
-		go c.closeAndWaitOnMessage(true, io.EOF)
+	c.assertInLoop("read buffer")
+	// 返回值等于0, 对端关闭了写, 之前读到的帧已经交给了回调, 走关闭握手
+	if cqe.Res == 0 {
+		c.closeOnPeerEOF()
+		c.getLogger().Debug("read eof", "fd", c.fd)
+		return nil
+	}
+	// 返回值小于0, 读出错, 直接关闭连接
+	if cqe.Res < 0 {
+		c.asyncClose(io.EOF)
 		c.getLogger().Debug("read res <= 0", "res", cqe.Res, "fd", c.fd)
 		return nil
 	}
@@ -21,7 +28,8 @@ func (c *Conn) processRead(cqe *giouring.CompletionQueueEvent) error {
 	c.getLogger().Debug("read res", "res", cqe.Res, "fd", c.fd)
 
 	// 处理websocket数据
-	c.rw += int(cqe.Res)
+	c.stats.addRead(int(cqe.Res))
+	c.rbuf.Commit(int(cqe.Res))
 	_, err := c.processWebsocketFrameOnlyIoUring()
 	if err != nil {
 		c.getLogger().Error("processWebsocketFrameOnlyIoUring", "err", err)
@@ -31,7 +39,7 @@ func (c *Conn) processRead(cqe *giouring.CompletionQueueEvent) error {
 	parent := c.getParent()
 	if parent == nil {
 		c.processClose(cqe)
-		c.getLogger().Info("parent is nil", "close", c.closed)
+		c.getLogger().Info("parent is nil", "state", c.State())
 		return nil
 	}
 
@@ -43,6 +51,15 @@ func (c *Conn) processRead(cqe *giouring.CompletionQueueEvent) error {
 }
 
 func (c *Conn) processWrite(cqe *giouring.CompletionQueueEvent, writeSeq uint32) error {
+	// SEND_ZC的第二个cqe, 内核不再引用这块内存, 这时候才能还到池里
+	// 写失败关闭了连接也会有这个cqe, 放在isClosed前面
+	if cqe.Flags&giouring.CQEFNotif != 0 {
+		if w, ok := c.takeWrite(writeSeq); ok {
+			w.free()
+		}
+		return nil
+	}
+
 	if c.isClosed() {
 		return nil
 	}
@@ -50,8 +67,18 @@ func (c *Conn) processWrite(cqe *giouring.CompletionQueueEvent, writeSeq uint32)
 	c.getLogger().Debug("write res", "res", cqe.Res)
 
 	if cqe.Res < 0 {
+		if cqe.Flags&giouring.CQEFMore != 0 {
+			// SEND_ZC失败了后面也还有通知的cqe, 内核还在引用这块内存, 留给通知的cqe释放
+			if v, ok := c.m.Load(writeSeq); ok {
+				v.(*ioUringWrite).sent = true
+			}
+		} else if w, ok := c.takeWrite(writeSeq); ok {
+			// 写失败, 内核不再引用这块内存
+			w.free()
+		}
+		// processClose之后fd会被关闭连接的go程改掉, 先记日志
+		c.getLogger().Error("write res < 0", "res", cqe.Res, "fd", c.getFd())
 		c.processClose(cqe)
-		c.getLogger().Error("write res < 0", "res", cqe.Res, "fd", c.fd)
 		return nil
 	}
 
@@ -69,7 +96,13 @@ func (c *Conn) processWrite(cqe *giouring.CompletionQueueEvent, writeSeq uint32)
 		panic("processWrite: ioState.writeBuf != res")
 	}
 
-	c.m.Delete(writeSeq)
+	c.stats.addWritten(int(cqe.Res))
+	if cqe.Flags&giouring.CQEFMore != 0 {
+		// 零拷贝发送, 后面还有一个通知的cqe, 等通知到了再释放
+		ioState.sent = true
+		return nil
+	}
+	c.takeWrite(writeSeq)
 	c.getLogger().Debug("processWrite.Delete", "writeSeq", writeSeq, "res", cqe.Res, "fd", c.fd)
 	// 写成功就把free还到池里面
 	ioState.free()
@@ -78,6 +111,6 @@ func (c *Conn) processWrite(cqe *giouring.CompletionQueueEvent, writeSeq uint32)
 }
 
 func (c *Conn) processClose(cqe *giouring.CompletionQueueEvent) error {
-	go c.closeAndWaitOnMessage(true, io.EOF)
+	c.asyncClose(io.EOF)
 	return nil
 }
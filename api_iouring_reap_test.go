@@ -0,0 +1,151 @@
+//go:build linux
+// +build linux
+
+package greatws
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pawelgaczynski/giouring"
+	"golang.org/x/sys/unix"
+)
+
+func Test_ReapStaleWrites(t *testing.T) {
+	conf := &Config{}
+	conf.defaultSetting()
+	conf.multiEventLoop = NewMultiEventLoopMust(WithEventLoops(1))
+	c := newConn(-1, false, conf)
+
+	now := time.Now()
+	store := func(seq uint32, submitted time.Time) *bool {
+		freed := new(bool)
+		c.storeWrite(seq, &ioUringWrite{writeBuf: make([]byte, 10), free: func() { *freed = true }, submitted: submitted.UnixNano()})
+		return freed
+	}
+	staleFreed := store(1, now.Add(-2*time.Minute))
+	freshFreed := store(1<<16+1, now)
+	if n := c.Stats().PendingWrites; n != 2 {
+		t.Fatalf("pending = %d", n)
+	}
+
+	stale := c.takeStaleWrites(now, time.Minute)
+	if len(stale) != 1 || stale[1] == nil {
+		t.Fatalf("stale = %v", stale)
+	}
+	if _, ok := c.m.Load(uint32(1)); ok {
+		t.Fatal("stale write not taken")
+	}
+	// 内核可能还在引用, 要等取消完成再释放
+	if *staleFreed {
+		t.Fatal("stale buffer returned to pool")
+	}
+	if n := c.Stats().PendingWrites; n != 1 {
+		t.Fatalf("pending = %d", n)
+	}
+
+	// 没有超时的照常完成, seq超过16位也能找到
+	if err := c.processWrite(&giouring.CompletionQueueEvent{Res: 10}, 1<<16+1); err != nil {
+		t.Fatal(err)
+	}
+	if !*freshFreed {
+		t.Fatal("buffer not freed")
+	}
+	if n := c.Stats().PendingWrites; n != 0 {
+		t.Fatalf("pending = %d", n)
+	}
+}
+
+func Test_ProcessWriteError(t *testing.T) {
+	conf := &Config{}
+	conf.defaultSetting()
+	conf.multiEventLoop = NewMultiEventLoopMust(WithEventLoops(1))
+	c := newConn(-1, false, conf)
+
+	freed := false
+	c.storeWrite(3, &ioUringWrite{writeBuf: make([]byte, 10), free: func() { freed = true }})
+	if err := c.processWrite(&giouring.CompletionQueueEvent{Res: -32}, 3); err != nil {
+		t.Fatal(err)
+	}
+	if !freed || c.Stats().PendingWrites != 0 {
+		t.Fatalf("freed = %t, pending = %d", freed, c.Stats().PendingWrites)
+	}
+}
+
+// SEND_ZC失败的cqe带着CQEFMore, 要等通知的cqe再释放
+func Test_ProcessWriteErrorMore(t *testing.T) {
+	conf := &Config{}
+	conf.defaultSetting()
+	conf.multiEventLoop = NewMultiEventLoopMust(WithEventLoops(1))
+	c := newConn(-1, false, conf)
+
+	freed := false
+	c.storeWrite(3, &ioUringWrite{writeBuf: make([]byte, 10), free: func() { freed = true }})
+	if err := c.processWrite(&giouring.CompletionQueueEvent{Res: -32, Flags: giouring.CQEFMore}, 3); err != nil {
+		t.Fatal(err)
+	}
+	if freed || c.Stats().PendingWrites != 1 {
+		t.Fatalf("freed = %t, pending = %d", freed, c.Stats().PendingWrites)
+	}
+
+	if err := c.processWrite(&giouring.CompletionQueueEvent{Flags: giouring.CQEFNotif}, 3); err != nil {
+		t.Fatal(err)
+	}
+	if !freed || c.Stats().PendingWrites != 0 {
+		t.Fatalf("freed = %t, pending = %d", freed, c.Stats().PendingWrites)
+	}
+}
+
+func Test_CanceledWrite(t *testing.T) {
+	e := &iouringState{canceled: make(map[uint64]*ioUringWrite)}
+	cancel := func(seq uint32, flags uint32) (userData uint64, freed *bool) {
+		freed = new(bool)
+		userData = encodeUserData(7, opWrite, seq)
+		e.canceled[userData] = &ioUringWrite{free: func() { *freed = true }}
+		// 写请求收到过SEND_ZC的第一个cqe
+		if flags&giouring.CQEFMore != 0 {
+			if err := e.processConn(&giouring.CompletionQueueEvent{UserData: userData, Res: 10, Flags: flags}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return userData, freed
+	}
+	cancelDone := func(seq uint32, res int32) {
+		if err := e.processConn(&giouring.CompletionQueueEvent{UserData: encodeUserData(7, 0, seq), Res: res}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 取消成功, 写请求自己的cqe到了才释放
+	ud, freed := cancel(1, 0)
+	cancelDone(1, 0)
+	if *freed {
+		t.Fatal("freed before write cqe")
+	}
+	if err := e.processConn(&giouring.CompletionQueueEvent{UserData: ud, Res: -int32(unix.ECANCELED)}); err != nil {
+		t.Fatal(err)
+	}
+	if !*freed {
+		t.Fatal("canceled write not freed")
+	}
+
+	// 写请求已经不在内核里了, 取消的结果是ENOENT
+	_, freed = cancel(2, 0)
+	cancelDone(2, -int32(unix.ENOENT))
+	if !*freed {
+		t.Fatal("lost write not freed")
+	}
+
+	// SEND_ZC已经发出去了, 还在等通知
+	ud, freed = cancel(3, giouring.CQEFMore)
+	cancelDone(3, -int32(unix.ENOENT))
+	if *freed {
+		t.Fatal("freed before notif")
+	}
+	if err := e.processConn(&giouring.CompletionQueueEvent{UserData: ud, Flags: giouring.CQEFNotif}); err != nil {
+		t.Fatal(err)
+	}
+	if !*freed || len(e.canceled) != 0 {
+		t.Fatalf("freed = %t, canceled = %d", *freed, len(e.canceled))
+	}
+}
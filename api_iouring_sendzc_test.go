@@ -0,0 +1,82 @@
+//go:build linux
+// +build linux
+
+package greatws
+
+import (
+	"testing"
+
+	"github.com/pawelgaczynski/giouring"
+)
+
+func Test_UseSendZC(t *testing.T) {
+	m := NewMultiEventLoopMust(WithEventLoops(1))
+	el := &EventLoop{parent: m}
+	e := &iouringState{parent: el, sendZC: true}
+
+	if e.useSendZC(defaultSendZCThreshold - 1) {
+		t.Fatal("small payload should not use send_zc")
+	}
+	if !e.useSendZC(defaultSendZCThreshold) {
+		t.Fatal("large payload should use send_zc")
+	}
+
+	// 内核不支持的时候退回send
+	e.sendZC = false
+	if e.useSendZC(defaultSendZCThreshold) {
+		t.Fatal("send_zc not supported")
+	}
+
+	e.sendZC = true
+	m.sendZCThreshold = 0
+	if e.useSendZC(1 << 20) {
+		t.Fatal("send_zc disabled")
+	}
+}
+
+func Test_ProcessWriteSendZC(t *testing.T) {
+	conf := &Config{}
+	conf.defaultSetting()
+	conf.multiEventLoop = NewMultiEventLoopMust(WithEventLoops(1))
+	c := newConn(-1, false, conf)
+
+	store := func(seq uint32, n int) *bool {
+		freed := new(bool)
+		c.storeWrite(seq, &ioUringWrite{writeBuf: make([]byte, n), free: func() { *freed = true }})
+		return freed
+	}
+
+	t.Run("send", func(t *testing.T) {
+		freed := store(1, 10)
+		if err := c.processWrite(&giouring.CompletionQueueEvent{Res: 10}, 1); err != nil {
+			t.Fatal(err)
+		}
+		if !*freed {
+			t.Fatal("buffer not freed")
+		}
+	})
+
+	t.Run("send_zc", func(t *testing.T) {
+		freed := store(2, 10)
+		if err := c.processWrite(&giouring.CompletionQueueEvent{Res: 10, Flags: giouring.CQEFMore}, 2); err != nil {
+			t.Fatal(err)
+		}
+		// 内核还在引用这块内存, 不能释放
+		if *freed {
+			t.Fatal("buffer freed before notification")
+		}
+		if _, ok := c.m.Load(uint32(2)); !ok {
+			t.Fatal("seq deleted before notification")
+		}
+
+		if err := c.processWrite(&giouring.CompletionQueueEvent{Flags: giouring.CQEFNotif}, 2); err != nil {
+			t.Fatal(err)
+		}
+		if !*freed {
+			t.Fatal("buffer not freed after notification")
+		}
+		if _, ok := c.m.Load(uint32(2)); ok {
+			t.Fatal("seq not deleted")
+		}
+	})
+}
@@ -1,14 +1,33 @@
+//go:build !js
+// +build !js
+
 package greatws
 
-// UserData， 低32位存放fd，高32位存放op等控制信息
-// 对于写事件来说，低32位存放fd, 高32位的高16位存放write seq。低16位存放op
+// UserData, 低30位存放fd, 接着2位存放op, 高32位存放write seq
+// write seq是32位的, 一个连接同时在途的写请求再多也不会回绕到还没有完成的seq
+// fd超过30位(10亿多)的时候不能使用io_uring, addRead会返回错误
+const (
+	userDataFdBits = 30
+	userDataFdMask = 1<<userDataFdBits - 1
+)
+
+// op在UserData里只占2位, 按编号存放
+var userDataOps = [...]ioUringOpState{0, opRead, opWrite, opClose}
+
 func encodeUserData(fd uint32, op ioUringOpState, writeSeq uint32) uint64 {
-	return uint64(op)<<32 | uint64(fd) | uint64(writeSeq)<<(32+16)
+	var code uint64
+	for i, o := range userDataOps {
+		if o == op {
+			code = uint64(i)
+			break
+		}
+	}
+	return uint64(writeSeq)<<32 | code<<userDataFdBits | uint64(fd&userDataFdMask)
 }
 
 func decodeUserData(userData uint64) (fd uint32, op ioUringOpState, writeSeq uint32) {
-	fd = uint32(userData & 0xffffffff)
-	op = ioUringOpState(userData >> 32 & 0xFFFF)
-	writeSeq = uint32(userData >> (32 + 16))
+	fd = uint32(userData & userDataFdMask)
+	op = userDataOps[userData>>userDataFdBits&0x3]
+	writeSeq = uint32(userData >> 32)
 	return
 }
@@ -1,3 +1,6 @@
+//go:build !js
+// +build !js
+
 package greatws
 
 import "testing"
@@ -19,6 +22,10 @@ func Test_UserDataEncodeDecode(t *testing.T) {
 		{4, opRead, 6},
 		{4, opWrite, 7},
 		{4, opClose, 8},
+		// seq是32位的, fd占低30位
+		{5, opWrite, 1<<16 + 1},
+		{userDataFdMask, opWrite, 1<<32 - 1},
+		{userDataFdMask, opRead, 0},
 	} {
 		userData := encodeUserData(v.fd, v.op, v.writeSeq)
 		fd, op, writeSeq := decodeUserData(userData)
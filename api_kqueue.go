@@ -19,7 +19,7 @@ package greatws
 
 import (
 	"errors"
-	"io"
+	"log/slog"
 	"syscall"
 	"time"
 
@@ -51,10 +51,18 @@ func (e *EventLoop) apiCreate(flag evFlag) (err error) {
 
 func (e *EventLoop) apiFree() {
 	if e.apiState != nil {
-		unix.Close(e.apiState.kqfd)
+		e.apiState.free()
 	}
 }
 
+func (s *apiState) free() {
+	unix.Close(s.kqfd)
+}
+
+func (e *EventLoop) wakeup() error {
+	return e.trigger()
+}
+
 // 在另外一个线程唤醒kqueue
 func (e *EventLoop) trigger() (err error) {
 	_, err = unix.Kevent(e.apiState.kqfd, []unix.Kevent_t{{Ident: 0, Filter: unix.EVFILT_USER, Fflags: unix.NOTE_TRIGGER}}, nil, nil)
@@ -70,6 +78,11 @@ func (e *EventLoop) addRead(c *Conn) error {
 	return e.trigger()
 }
 
+// EV_CLEAR下, 暂停期间积压的数据不会再产生事件, 重新添加一次读事件, 已经可读会马上再报一次
+func (e *EventLoop) rearmRead(c *Conn) error {
+	return e.addRead(c)
+}
+
 func (e *EventLoop) delWrite(c *Conn) (err error) {
 	e.mu.Lock()
 	fd := c.getFd()
@@ -79,7 +92,7 @@ func (e *EventLoop) delWrite(c *Conn) (err error) {
 }
 
 // 新加写事件
-func (e *EventLoop) addWrite(c *Conn, writeSeq uint16) error {
+func (e *EventLoop) addWrite(c *Conn, writeSeq uint32) error {
 	e.mu.Lock()
 	fd := c.getFd()
 	e.apiState.changes = append(e.apiState.changes, unix.Kevent_t{Ident: uint64(fd), Filter: unix.EVFILT_WRITE, Flags: unix.EV_ADD | unix.EV_CLEAR})
@@ -123,30 +136,28 @@ func (e *EventLoop) apiPoll(tv time.Duration) (retVal int, err error) {
 		for j := 0; j < retVal; j++ {
 			ev := &state.events[j]
 			fd := int(ev.Ident)
-			// fmt.Printf("fd :%d, filter :%x, flags :%x\n", fd, ev.Filter, ev.Flags)
+			if e.parent.debug.Load() {
+				e.parent.Debug("kqueue event", slog.Int("fd", fd), slog.Int("filter", int(ev.Filter)), slog.Int("flags", int(ev.Flags)))
+			}
+			// 找不到连接不能关闭fd, 它可能已经被新的连接复用
 			conn := e.parent.getConn(fd)
 			if conn == nil {
-				unix.Close(fd)
 				continue
 			}
 
 			if ev.Filter == unix.EVFILT_READ {
 				// 读取数据，这里要发行下websocket的解析变成流式解析
+				// 对端关闭了写会带上EV_EOF, 读到eof的时候会先把数据交给回调, 再走关闭握手
 				_, err = conn.processWebsocketFrame()
 				if err != nil {
-					go conn.closeAndWaitOnMessage(true, err)
-					continue
-				}
-
-				if ev.Flags&unix.EV_EOF != 0 {
-					go conn.closeAndWaitOnMessage(true, io.EOF)
+					conn.asyncClose(err)
 					continue
 				}
 			}
 
 			if ev.Filter == unix.EVFILT_WRITE {
 				// 刷新下直接写入失败的数据
-				conn.flushOrClose()
+				e.flushWritable(conn)
 			}
 
 		}
@@ -18,7 +18,6 @@
 package greatws
 
 import (
-	"fmt"
 	"time"
 )
 
@@ -32,8 +31,10 @@ type linuxApi interface {
 	apiPoll(tv time.Duration) (retVal int, err error)
 	apiName() string
 	addRead(c *Conn) error
-	addWrite(c *Conn, writeSeq uint16) error
+	addWrite(c *Conn, writeSeq uint32) error
 	delWrite(c *Conn) error
+	rearmRead(c *Conn) error
+	wakeup() error
 }
 
 // 创建
@@ -47,15 +48,19 @@ func (e *EventLoop) apiCreate(flag evFlag) (err error) {
 		}
 		state.linuxApi = la
 	} else if flag&EVENT_IOURING != 0 {
-		la, err := apiIoUringCreate(e, 16384)
+		la, err := apiIoUringCreate(e)
 		if err != nil {
 			return err
 		}
 		state.linuxApi = la
 	} else {
-		return fmt.Errorf("not support api")
+		return ErrEventNotSupported
 	}
 
 	e.apiState = &state
 	return nil
 }
+
+func (s *apiState) free() {
+	s.apiFree()
+}
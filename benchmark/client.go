@@ -0,0 +1,268 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math/bits"
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/antlabs/greatws"
+)
+
+// 标准场景, 不同的实现, 不同的提交之间用同样的参数对比
+type scenario struct {
+	name   string
+	conns  int // 总连接数
+	active int // 其中一直收发消息的连接数, 其余的连接建立之后空闲
+	size   int // 消息大小
+}
+
+var scenarios = []scenario{
+	{name: "echo", conns: 1000, active: 1000, size: 1024},
+	{name: "idle", conns: 100000, active: 1000, size: 1024}, // 10万连接, 1%活跃
+	{name: "large", conns: 16, active: 16, size: 1 << 20},
+}
+
+// 按比例缩小连接数, 用于CI或者文件描述符受限的机器, 至少保留一个活跃连接
+func (s scenario) scale(f float64) scenario {
+	if f <= 0 || f == 1 {
+		return s
+	}
+	s.conns = max(int(float64(s.conns)*f), 1)
+	s.active = min(max(int(float64(s.active)*f), 1), s.conns)
+	return s
+}
+
+// 延迟的直方图, 对数分桶, 每个2的幂分8个桶, 误差在12.5%以内
+const histBuckets = 320
+
+type histogram [histBuckets]int64
+
+func histIndex(us uint64) int {
+	if us < 16 {
+		return int(us)
+	}
+	shift := bits.Len64(us) - 4
+	i := 16 + (shift-1)*8 + int(us>>shift-8)
+	if i >= histBuckets {
+		i = histBuckets - 1
+	}
+	return i
+}
+
+// 桶的下界, 微秒
+func histValue(i int) uint64 {
+	if i < 16 {
+		return uint64(i)
+	}
+	k := i - 16
+	return uint64(8+k%8) << (k/8 + 1)
+}
+
+func (h *histogram) record(d time.Duration) {
+	atomic.AddInt64(&h[histIndex(uint64(d/time.Microsecond))], 1)
+}
+
+// 第q分位的延迟
+func (h *histogram) quantile(q float64) time.Duration {
+	var total int64
+	for i := range h {
+		total += atomic.LoadInt64(&h[i])
+	}
+	if total == 0 {
+		return 0
+	}
+	want := int64(float64(total)*q + 0.5)
+	var n int64
+	for i := range h {
+		n += atomic.LoadInt64(&h[i])
+		if n >= want {
+			return time.Duration(histValue(i)) * time.Microsecond
+		}
+	}
+	return time.Duration(histValue(histBuckets-1)) * time.Microsecond
+}
+
+type clientStats struct {
+	running int32 // 为0之后收到回复不再发送
+	msgs    int64
+	latency int64 // 延迟总和, 纳秒
+	errs    int64
+	hist    histogram
+}
+
+// 活跃连接: 发一条, 收到回复之后再发下一条
+type pingPong struct {
+	*clientStats
+	payload []byte
+	sentAt  int64
+}
+
+func (p *pingPong) send(c *greatws.Conn) {
+	atomic.StoreInt64(&p.sentAt, time.Now().UnixNano())
+	if err := c.WriteMessage(greatws.Binary, p.payload); err != nil {
+		atomic.AddInt64(&p.errs, 1)
+	}
+}
+
+func (p *pingPong) OnOpen(c *greatws.Conn) {}
+
+func (p *pingPong) OnMessage(c *greatws.Conn, op greatws.Opcode, msg []byte) {
+	d := time.Now().UnixNano() - atomic.LoadInt64(&p.sentAt)
+	if atomic.LoadInt32(&p.running) == 0 {
+		return
+	}
+	atomic.AddInt64(&p.latency, d)
+	atomic.AddInt64(&p.msgs, 1)
+	p.hist.record(time.Duration(d))
+	if !bytes.Equal(msg, p.payload) {
+		atomic.AddInt64(&p.errs, 1)
+	}
+	p.send(c)
+}
+
+func (p *pingPong) OnClose(c *greatws.Conn, err error) {
+	if atomic.LoadInt32(&p.running) == 1 {
+		atomic.AddInt64(&p.errs, 1)
+	}
+}
+
+// 一个场景的结果, 对应csv的一行
+type result struct {
+	impl       string
+	scenario   scenario
+	duration   time.Duration
+	connect    time.Duration // 建立所有连接的耗时
+	msgs       int64
+	errs       int64
+	avg        time.Duration
+	p50        time.Duration
+	p99        time.Duration
+	server     serverStats
+	serverErrs string
+}
+
+func (r *result) qps() float64 {
+	return float64(r.msgs) / r.duration.Seconds()
+}
+
+// 每秒往返的字节数, MB
+func (r *result) mbps() float64 {
+	return r.qps() * float64(r.scenario.size) * 2 / (1 << 20)
+}
+
+// 一个本地地址最多用这么多连接, 超过之后换下一个127.0.0.x, 避免临时端口用完
+const connsPerSource = 20000
+
+// 连接addr, 本地回环地址上连接数多的时候轮换源地址, 只有linux的127.0.0.0/8都能直接绑定
+func dialSource(addr string, i int) (net.Conn, error) {
+	d := net.Dialer{Timeout: 10 * time.Second}
+	host, _, _ := net.SplitHostPort(addr)
+	if runtime.GOOS == "linux" && host == "127.0.0.1" {
+		ip := net.IPv4(127, 0, byte(i/connsPerSource>>8), byte(1+i/connsPerSource%255))
+		d.LocalAddr = &net.TCPAddr{IP: ip}
+	}
+	return d.Dial("tcp", addr)
+}
+
+// 对addr上的服务跑一个场景
+func runScenario(m *greatws.MultiEventLoop, impl, addr string, s scenario, duration time.Duration, dialers int) (*result, error) {
+	st := &clientStats{running: 1}
+	payload := bytes.Repeat([]byte("a"), s.size)
+	idle := &greatws.DefCallback{}
+
+	conns := make([]*greatws.Conn, s.conns)
+	actives := make([]*pingPong, s.active)
+	for i := range actives {
+		actives[i] = &pingPong{clientStats: st, payload: payload}
+	}
+	var (
+		wg      sync.WaitGroup
+		next    int64 = -1
+		errOnce sync.Once
+		dialErr error
+	)
+	start := time.Now()
+	for w := 0; w < dialers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(atomic.AddInt64(&next, 1))
+				if i >= s.conns {
+					return
+				}
+				var cb greatws.Callback = idle
+				if i < s.active {
+					cb = actives[i]
+				}
+				raw, err := dialSource(addr, i)
+				if err == nil {
+					conns[i], err = greatws.ClientFromConn(raw, "ws://"+addr+"/ws",
+						greatws.WithClientCallback(cb),
+						greatws.WithClientMultiEventLoop(m))
+				}
+				if err != nil {
+					errOnce.Do(func() { dialErr = fmt.Errorf("dial %d: %w", i, err) })
+					atomic.StoreInt64(&next, int64(s.conns))
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	connect := time.Since(start)
+	defer func() {
+		for _, c := range conns {
+			if c != nil {
+				c.Close()
+			}
+		}
+	}()
+	if dialErr != nil {
+		return nil, dialErr
+	}
+
+	for i, p := range actives {
+		p.send(conns[i])
+	}
+	time.Sleep(duration)
+	atomic.StoreInt32(&st.running, 0)
+
+	r := &result{
+		impl:     impl,
+		scenario: s,
+		duration: duration,
+		connect:  connect,
+		msgs:     atomic.LoadInt64(&st.msgs),
+		errs:     atomic.LoadInt64(&st.errs),
+		p50:      st.hist.quantile(0.5),
+		p99:      st.hist.quantile(0.99),
+	}
+	if r.msgs > 0 {
+		r.avg = time.Duration(atomic.LoadInt64(&st.latency) / r.msgs)
+	}
+	var err error
+	if r.server, err = fetchServerStats(addr); err != nil {
+		r.serverErrs = err.Error()
+	}
+	return r, nil
+}
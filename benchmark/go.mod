@@ -0,0 +1,22 @@
+module github.com/antlabs/greatws/benchmark
+
+go 1.21
+
+require (
+	github.com/antlabs/greatws v0.0.0-00010101000000-000000000000
+	github.com/gobwas/ws v1.4.0
+	github.com/gorilla/websocket v1.5.3
+	github.com/lesismal/nbio v1.5.12
+)
+
+require (
+	github.com/antlabs/wsutil v0.1.4 // indirect
+	github.com/gobwas/httphead v0.1.0 // indirect
+	github.com/gobwas/pool v0.2.1 // indirect
+	github.com/lesismal/llib v1.1.13 // indirect
+	github.com/pawelgaczynski/giouring v0.0.0-20230826085535-69588b89acb9 // indirect
+	golang.org/x/crypto v0.0.0-20210513122933-cd7d49e622d5 // indirect
+	golang.org/x/sys v0.12.0 // indirect
+)
+
+replace github.com/antlabs/greatws => ../
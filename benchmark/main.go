@@ -0,0 +1,196 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// benchmark 用同样的场景对比greatws, gorilla, gobwas, nbio的echo服务, 结果输出成csv
+//
+// 每个实现的每个场景单独起一个服务端子进程, 压测客户端是greatws, 跑完取服务端的内存统计:
+//
+//	echo:  1000连接全部收发1KB消息
+//	idle:  10万连接, 其中1%收发1KB消息, 看空闲连接的内存和对活跃连接的影响
+//	large: 16连接收发1MB消息
+//
+// 检查退化: 先在基线提交上跑一次保存csv, 改动之后带上-baseline再跑, qps下降或者内存上涨超过-threshold时退出码为1
+//
+//	go run . -impls greatws -out base.csv
+//	go run . -impls greatws -baseline base.csv -out new.csv
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/antlabs/greatws"
+)
+
+func main() {
+	mode := flag.String("mode", "run", "run: run scenarios against server subprocesses; server: serve one impl")
+	impl := flag.String("impl", "greatws", "server mode: impl to serve")
+	addr := flag.String("addr", "127.0.0.1:0", "server mode: listen address")
+	impls := flag.String("impls", strings.Join(implNames, ","), "comma separated impls to benchmark")
+	names := flag.String("scenarios", "echo,idle,large", "comma separated scenarios to run")
+	duration := flag.Duration("duration", 10*time.Second, "how long each scenario sends messages")
+	scale := flag.Float64("scale", 1, "scale connection counts, e.g. 0.01 in CI")
+	dialers := flag.Int("dialers", 64, "concurrent dials when connecting")
+	out := flag.String("out", "", "write csv to file, empty for stdout")
+	baseline := flag.String("baseline", "", "compare with a previous csv, exit 1 on regression")
+	threshold := flag.Float64("threshold", 0.1, "allowed relative regression of qps and server heap")
+	flag.Parse()
+
+	if *mode == "server" {
+		serve, ok := servers[*impl]
+		if !ok {
+			log.Fatalf("unknown impl %q", *impl)
+		}
+		raiseNoFile()
+		log.Fatal(serve(*addr))
+	}
+
+	if n, err := raiseNoFile(); err != nil {
+		log.Printf("raise nofile: %v", err)
+	} else {
+		log.Printf("nofile limit %d", n)
+	}
+
+	var run []scenario
+	for _, name := range strings.Split(*names, ",") {
+		s, ok := findScenario(name)
+		if !ok {
+			log.Fatalf("unknown scenario %q", name)
+		}
+		run = append(run, s.scale(*scale))
+	}
+
+	m := greatws.NewMultiEventLoopMust(
+		greatws.WithEventLoops(runtime.NumCPU()/2+1),
+		greatws.WithBusinessGoNum(50, 10, 10000),
+		greatws.WithMaxEventNum(1000),
+	)
+	m.Start()
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		w = f
+	}
+	cw := csv.NewWriter(w)
+	cw.Write(csvHeader)
+	cw.Flush()
+
+	var results []*result
+	for _, name := range strings.Split(*impls, ",") {
+		if _, ok := servers[name]; !ok {
+			log.Fatalf("unknown impl %q", name)
+		}
+		for _, s := range run {
+			log.Printf("%s/%s: %d conns, %d active, %d bytes", name, s.name, s.conns, s.active, s.size)
+			r, err := runWithServer(m, name, s, *duration, *dialers)
+			if err != nil {
+				log.Printf("%s/%s: %v", name, s.name, err)
+				continue
+			}
+			if r.serverErrs != "" {
+				log.Printf("%s/%s: server stats: %s", name, s.name, r.serverErrs)
+			}
+			results = append(results, r)
+			cw.Write(r.record())
+			cw.Flush()
+		}
+	}
+
+	if *baseline != "" {
+		rows, err := readCSV(*baseline)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if n := compareBaseline(os.Stderr, rows, results, *threshold); n > 0 {
+			log.Printf("%d regressions", n)
+			os.Exit(1)
+		}
+	}
+}
+
+func findScenario(name string) (scenario, bool) {
+	for _, s := range scenarios {
+		if s.name == name {
+			return s, true
+		}
+	}
+	return scenario{}, false
+}
+
+// 起一个服务端子进程跑场景, 每个场景用新的进程, 内存统计不受上一个场景影响
+func runWithServer(m *greatws.MultiEventLoop, impl string, s scenario, duration time.Duration, dialers int) (*result, error) {
+	addr, err := freeAddr()
+	if err != nil {
+		return nil, err
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cmd := exec.CommandContext(ctx, self, "-mode", "server", "-impl", impl, "-addr", addr)
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		cancel()
+		return nil, err
+	}
+	defer func() {
+		cancel()
+		cmd.Wait()
+	}()
+
+	if err := waitListen(addr, 10*time.Second); err != nil {
+		return nil, err
+	}
+	return runScenario(m, impl, addr, s, duration, dialers)
+}
+
+// 找一个空闲的端口, 关闭之后交给子进程监听
+func freeAddr() (string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer l.Close()
+	return l.Addr().String(), nil
+}
+
+func waitListen(addr string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		c, err := net.Dial("tcp", addr)
+		if err == nil {
+			return c.Close()
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("server %s not ready: %w", addr, err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,121 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+)
+
+var csvHeader = []string{
+	"impl", "scenario", "conns", "active", "size", "duration_s", "connect_s",
+	"msgs", "qps", "mb_per_s", "avg_us", "p50_us", "p99_us", "errors",
+	"server_heap_mb", "server_sys_mb", "server_goroutines",
+}
+
+func (r *result) record() []string {
+	us := func(d interface{ Microseconds() int64 }) string { return strconv.FormatInt(d.Microseconds(), 10) }
+	mb := func(n uint64) string { return strconv.FormatFloat(float64(n)/(1<<20), 'f', 1, 64) }
+	return []string{
+		r.impl, r.scenario.name,
+		strconv.Itoa(r.scenario.conns), strconv.Itoa(r.scenario.active), strconv.Itoa(r.scenario.size),
+		strconv.FormatFloat(r.duration.Seconds(), 'f', 1, 64), strconv.FormatFloat(r.connect.Seconds(), 'f', 2, 64),
+		strconv.FormatInt(r.msgs, 10), strconv.FormatFloat(r.qps(), 'f', 0, 64), strconv.FormatFloat(r.mbps(), 'f', 1, 64),
+		us(r.avg), us(r.p50), us(r.p99), strconv.FormatInt(r.errs, 10),
+		mb(r.server.HeapInuse), mb(r.server.Sys), strconv.Itoa(r.server.Goroutines),
+	}
+}
+
+// csv的一行, 按列名取值
+type row map[string]string
+
+func (r row) key() string {
+	return r["impl"] + "/" + r["scenario"]
+}
+
+func (r row) float(name string) float64 {
+	f, _ := strconv.ParseFloat(r[name], 64)
+	return f
+}
+
+func readCSV(name string) ([]row, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("%s: empty", name)
+	}
+	rows := make([]row, 0, len(records)-1)
+	for _, rec := range records[1:] {
+		r := make(row, len(rec))
+		for i, v := range rec {
+			if i < len(records[0]) {
+				r[records[0][i]] = v
+			}
+		}
+		rows = append(rows, r)
+	}
+	return rows, nil
+}
+
+// 和基线比较, qps下降或者服务端内存上涨超过threshold算退化, 返回退化的个数
+// 基线里没有的实现/场景跳过
+func compareBaseline(w io.Writer, baseline []row, results []*result, threshold float64) int {
+	base := make(map[string]row, len(baseline))
+	for _, r := range baseline {
+		base[r.key()] = r
+	}
+
+	regressions := 0
+	for _, res := range results {
+		cur := make(row)
+		for i, v := range res.record() {
+			cur[csvHeader[i]] = v
+		}
+		old, ok := base[cur.key()]
+		if !ok {
+			continue
+		}
+		check := func(name string, higherIsBetter bool) {
+			o, n := old.float(name), cur.float(name)
+			if o == 0 {
+				return
+			}
+			change := (n - o) / o
+			bad := change < -threshold
+			if !higherIsBetter {
+				bad = change > threshold
+			}
+			mark := "ok"
+			if bad {
+				mark = "REGRESSION"
+				regressions++
+			}
+			fmt.Fprintf(w, "%-20s %-16s %12.1f -> %12.1f %+7.1f%% %s\n", cur.key(), name, o, n, change*100, mark)
+		}
+		check("qps", true)
+		check("server_heap_mb", false)
+	}
+	return regressions
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+func Test_Histogram(t *testing.T) {
+	// 桶的下界不大于落进去的值, 误差在12.5%以内
+	for _, us := range []uint64{0, 1, 15, 16, 17, 31, 32, 100, 1000, 123456, 1 << 30} {
+		v := histValue(histIndex(us))
+		if v > us || float64(us-v) > float64(us)*0.125 {
+			t.Fatalf("us %d -> %d", us, v)
+		}
+	}
+
+	var h histogram
+	for i := 1; i <= 100; i++ {
+		h.record(time.Duration(i) * time.Millisecond)
+	}
+	if p := h.quantile(0.5); p < 44*time.Millisecond || p > 50*time.Millisecond {
+		t.Fatalf("p50 = %v", p)
+	}
+	if p := h.quantile(0.99); p < 88*time.Millisecond || p > 99*time.Millisecond {
+		t.Fatalf("p99 = %v", p)
+	}
+}
+
+func Test_CompareBaseline(t *testing.T) {
+	s := scenario{name: "echo", conns: 10, active: 10, size: 1024}
+	base := []row{{"impl": "greatws", "scenario": "echo", "qps": "1000", "server_heap_mb": "10.0"}}
+	res := func(msgs int64, heap uint64) []*result {
+		return []*result{{impl: "greatws", scenario: s, duration: time.Second, msgs: msgs, server: serverStats{HeapInuse: heap << 20}}}
+	}
+
+	if n := compareBaseline(io.Discard, base, res(950, 10), 0.1); n != 0 {
+		t.Fatalf("within threshold: %d", n)
+	}
+	if n := compareBaseline(io.Discard, base, res(800, 10), 0.1); n != 1 {
+		t.Fatalf("qps regression: %d", n)
+	}
+	if n := compareBaseline(io.Discard, base, res(1000, 12), 0.1); n != 1 {
+		t.Fatalf("heap regression: %d", n)
+	}
+	// 基线里没有的场景跳过
+	s.name = "idle"
+	if n := compareBaseline(io.Discard, base, res(1, 100), 0.1); n != 0 {
+		t.Fatalf("missing baseline: %d", n)
+	}
+}
@@ -0,0 +1,181 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"runtime"
+
+	"github.com/antlabs/greatws"
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"github.com/gorilla/websocket"
+	"github.com/lesismal/nbio/nbhttp"
+	nbws "github.com/lesismal/nbio/nbhttp/websocket"
+)
+
+// 每种实现的echo服务, 在addr上监听, websocket在/ws, 内存统计在/stats, 一直阻塞
+var servers = map[string]func(addr string) error{
+	"greatws": serveGreatws,
+	"gorilla": serveGorilla,
+	"gobwas":  serveGobwas,
+	"nbio":    serveNbio,
+}
+
+// 所有实现的名字, 按这个顺序跑
+var implNames = []string{"greatws", "gorilla", "gobwas", "nbio"}
+
+// 服务端进程的内存和go程数, 连接建立之后取一次
+type serverStats struct {
+	HeapInuse  uint64 `json:"heap_inuse"`
+	Sys        uint64 `json:"sys"`
+	Goroutines int    `json:"goroutines"`
+}
+
+func newMux(ws http.HandlerFunc) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", ws)
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		runtime.GC()
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+		json.NewEncoder(w).Encode(serverStats{HeapInuse: ms.HeapInuse, Sys: ms.Sys, Goroutines: runtime.NumGoroutine()})
+	})
+	return mux
+}
+
+func listenAndServe(addr string, h http.Handler) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return http.Serve(l, h)
+}
+
+type greatwsEcho struct{}
+
+func (greatwsEcho) OnOpen(c *greatws.Conn) {}
+
+func (greatwsEcho) OnMessage(c *greatws.Conn, op greatws.Opcode, msg []byte) {
+	c.WriteMessage(op, msg)
+}
+
+func (greatwsEcho) OnClose(c *greatws.Conn, err error) {}
+
+func serveGreatws(addr string) error {
+	m := greatws.NewMultiEventLoopMust(
+		greatws.WithEventLoops(runtime.NumCPU()/2+1),
+		greatws.WithBusinessGoNum(50, 10, 10000),
+		greatws.WithMaxEventNum(1000),
+		greatws.WithLogLevel(slog.LevelError),
+	)
+	m.Start()
+	upgrader := greatws.NewUpgrade(
+		greatws.WithServerReplyPing(),
+		greatws.WithServerCallback(greatwsEcho{}),
+		greatws.WithServerMultiEventLoop(m),
+	)
+	return listenAndServe(addr, newMux(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := upgrader.Upgrade(w, r); err != nil {
+			log.Printf("greatws upgrade: %v", err)
+		}
+	}))
+}
+
+// gorilla每个连接一个go程
+func serveGorilla(addr string) error {
+	upgrader := websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
+	return listenAndServe(addr, newMux(func(w http.ResponseWriter, r *http.Request) {
+		c, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			log.Printf("gorilla upgrade: %v", err)
+			return
+		}
+		go func() {
+			defer c.Close()
+			for {
+				op, msg, err := c.ReadMessage()
+				if err != nil {
+					return
+				}
+				if err := c.WriteMessage(op, msg); err != nil {
+					return
+				}
+			}
+		}()
+	}))
+}
+
+// gobwas每个连接一个go程
+func serveGobwas(addr string) error {
+	return listenAndServe(addr, newMux(func(w http.ResponseWriter, r *http.Request) {
+		c, _, _, err := ws.UpgradeHTTP(r, w)
+		if err != nil {
+			log.Printf("gobwas upgrade: %v", err)
+			return
+		}
+		go func() {
+			defer c.Close()
+			for {
+				msg, op, err := wsutil.ReadClientData(c)
+				if err != nil {
+					return
+				}
+				if err := wsutil.WriteServerMessage(c, op, msg); err != nil {
+					return
+				}
+			}
+		}()
+	}))
+}
+
+// nbio和greatws一样是事件驱动的
+func serveNbio(addr string) error {
+	upgrader := nbws.NewUpgrader()
+	upgrader.CheckOrigin = func(r *http.Request) bool { return true }
+	upgrader.OnMessage(func(c *nbws.Conn, op nbws.MessageType, msg []byte) {
+		c.WriteMessage(op, msg)
+	})
+	engine := nbhttp.NewEngine(nbhttp.Config{
+		Network: "tcp",
+		Addrs:   []string{addr},
+		Handler: newMux(func(w http.ResponseWriter, r *http.Request) {
+			if _, err := upgrader.Upgrade(w, r, nil); err != nil {
+				log.Printf("nbio upgrade: %v", err)
+			}
+		}),
+		ReleaseWebsocketPayload: true,
+	})
+	if err := engine.Start(); err != nil {
+		return err
+	}
+	select {}
+}
+
+// 取服务端的内存统计
+func fetchServerStats(addr string) (st serverStats, err error) {
+	rsp, err := http.Get(fmt.Sprintf("http://%s/stats", addr))
+	if err != nil {
+		return st, err
+	}
+	defer rsp.Body.Close()
+	err = json.NewDecoder(rsp.Body).Decode(&st)
+	return st, err
+}
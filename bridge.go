@@ -0,0 +1,101 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux || darwin || netbsd || freebsd || openbsd || dragonfly
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package greatws
+
+import (
+	"errors"
+	"io"
+	"net"
+)
+
+const (
+	defaultBridgeHighWater  = 1 << 20
+	defaultBridgeBufferSize = 32 * 1024
+)
+
+type bridgeConfig struct {
+	highWater int
+	lowWater  int
+	bufSize   int
+}
+
+type BridgeOption func(*bridgeConfig)
+
+// 1.配置每个方向最多缓冲多少字节, 默认1MB, 降到一半以下恢复
+func WithBridgeHighWater(n int) BridgeOption {
+	return func(c *bridgeConfig) {
+		c.highWater = n
+		c.lowWater = n / 2
+	}
+}
+
+// 2.配置从tcp读数据的缓冲区大小, 也是发给websocket的单条消息的最大长度, 默认32KB
+func WithBridgeBufferSize(n int) BridgeOption {
+	return func(c *bridgeConfig) {
+		c.bufSize = n
+	}
+}
+
+// 在ws和tcp之间双向转发, 阻塞到有一端关闭, 返回之前两端都会被关闭
+// ws收到的text/binary消息按字节流写给tcp, tcp读到的数据作为binary消息发给ws
+// 两个方向都有流控:
+// tcp写得慢, ws这边缓冲超过highWater就暂停读fd, 由tcp的窗口把压力传回websocket的对端
+// websocket的对端收得慢, 写缓冲区超过highWater就不再读tcp
+// 正常关闭返回nil, 否则返回先结束的方向的错误
+func Bridge(ws *Conn, tcp net.Conn, opts ...BridgeOption) error {
+	conf := bridgeConfig{
+		highWater: defaultBridgeHighWater,
+		lowWater:  defaultBridgeHighWater / 2,
+		bufSize:   defaultBridgeBufferSize,
+	}
+	for _, o := range opts {
+		o(&conf)
+	}
+
+	nc := ws.NetConn().(*netConnAdapter)
+	nc.setWaterMarks(conf.highWater, conf.lowWater)
+
+	errc := make(chan error, 2)
+	go func() {
+		_, err := io.Copy(tcp, nc)
+		errc <- err
+	}()
+	go func() {
+		_, err := io.CopyBuffer(nc, tcp, make([]byte, conf.bufSize))
+		errc <- err
+	}()
+
+	err := bridgeErr(<-errc)
+	// 一个方向结束了, 关掉两端, 让另一个方向也退出
+	tcp.Close()
+	nc.Close()
+	<-errc
+	return err
+}
+
+// 关闭引起的错误不算错误
+func bridgeErr(err error) error {
+	var ce *CloseErrMsg
+	if errors.As(err, &ce) && ce.Code == NormalClosure {
+		return nil
+	}
+	if errors.Is(err, net.ErrClosed) || errors.Is(err, io.EOF) {
+		return nil
+	}
+	return err
+}
@@ -13,6 +13,8 @@
 // limitations under the License.
 package greatws
 
+// OnMessage的payload是借给回调的, 只在OnMessage返回之前有效, 返回之后库会回收复用这块内存
+// 需要在返回之后继续使用的话, 自己拷贝一份, 或者配置WithServerCopyPayload/WithClientCopyPayload
 type (
 	Callback interface {
 		OnOpen(*Conn)
@@ -88,30 +90,13 @@ func (f *funcToCallback) OnClose(c *Conn, err error) {
 	}
 }
 
-type goCallback struct {
-	c Callback
-	t *task
-}
-
-func newGoCallback(c Callback, t *task) *goCallback {
-	return &goCallback{c: c, t: t}
-}
-
-func (g *goCallback) OnOpen(c *Conn) {
-	g.c.OnOpen(c)
-}
-
-func (g *goCallback) OnMessage(c *Conn, op Opcode, data []byte) {
-	//	g.c.OnMessage(c, op, data)
-	c.waitOnMessageRun.Add(1)
-	g.t.addTask(func() (exit bool) {
-		defer c.waitOnMessageRun.Done()
-		g.c.OnMessage(c, op, data)
-		PutPayloadBytes(&data)
-		return false
-	})
-}
+// 5. 中间件, 包装Callback, 可以做日志, 监控, 链路追踪等
+// 有多个中间件时, 第一个在最外层, 中间件在业务go程里运行
+type Middleware func(next Callback) Callback
 
-func (g *goCallback) OnClose(c *Conn, err error) {
-	g.c.OnClose(c, err)
+func chainMiddleware(cb Callback, mws []Middleware) Callback {
+	for i := len(mws) - 1; i >= 0; i-- {
+		cb = mws[i](cb)
+	}
+	return cb
 }
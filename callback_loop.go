@@ -0,0 +1,94 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+package greatws
+
+// 包装用户的Callback, 决定回调在哪个go程里运行
+// 默认每条消息交给业务go程池, 同一个连接的消息可能并发执行, 不保证顺序, 回调阻塞不影响事件循环
+// 配置了WithOrderedCallback, 仍然在业务go程池里执行, 但同一个连接的消息按顺序一条一条执行
+// 配置了WithCallbackInEventLoop, 在事件循环里同步调用, 保证顺序, 延迟最低, 但是回调不能阻塞,
+// 阻塞会卡住同一个事件循环上的所有连接
+// 两种模式下payload都只在OnMessage返回之前有效, 除非配置了WithServerCopyPayload, 详见payloadMode
+func (c *Config) initCallback() {
+	c.detectFrameCallback()
+	cb := chainMiddleware(c.Callback, c.middlewares)
+	mode := payloadMode{copyPayload: c.copyPayload, poison: c.poisonPayload}
+	if c.multiEventLoop.callbackInLoop {
+		c.Callback = &loopCallback{c: cb, payloadMode: mode}
+		return
+	}
+	g := newGoCallback(cb, &c.multiEventLoop.t)
+	g.ordered = c.multiEventLoop.orderedCallback
+	g.payloadMode = mode
+	c.Callback = g
+}
+
+// 在事件循环里同步调用
+type loopCallback struct {
+	c Callback
+	payloadMode
+}
+
+func (l *loopCallback) OnOpen(c *Conn) {
+	l.c.OnOpen(c)
+}
+
+func (l *loopCallback) OnMessage(c *Conn, op Opcode, data []byte) {
+	l.c.OnMessage(c, op, l.lend(data))
+	l.release(data)
+}
+
+func (l *loopCallback) OnClose(c *Conn, err error) {
+	l.c.OnClose(c, err)
+}
+
+// 每条消息交给业务go程池
+type goCallback struct {
+	c       Callback
+	t       *task
+	ordered bool // 同一个连接的消息按顺序执行
+	payloadMode
+}
+
+func newGoCallback(c Callback, t *task) *goCallback {
+	return &goCallback{c: c, t: t}
+}
+
+func (g *goCallback) OnOpen(c *Conn) {
+	g.c.OnOpen(c)
+}
+
+func (g *goCallback) OnMessage(c *Conn, op Opcode, data []byte) {
+	//	g.c.OnMessage(c, op, data)
+	c.waitOnMessageRun.Add(1)
+	// 拷贝在事件循环里完成, 库的缓冲区不用等到业务go程执行完才回收
+	data = g.lend(data)
+	if g.ordered {
+		g.onMessageOrdered(c, op, data)
+		return
+	}
+	g.t.addTask(func() (exit bool) {
+		defer c.waitOnMessageRun.Done()
+		g.c.OnMessage(c, op, data)
+		g.release(data)
+		return false
+	})
+}
+
+func (g *goCallback) OnClose(c *Conn, err error) {
+	g.c.OnClose(c, err)
+}
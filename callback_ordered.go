@@ -0,0 +1,73 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+package greatws
+
+// 按连接排队执行OnMessage, 同一个连接同时只有一个业务go程在处理, 顺序和收到的顺序一致
+// 不同的连接之间仍然并发
+type mailboxMsg struct {
+	op   Opcode
+	data []byte
+}
+
+type mailbox struct {
+	msgs    []mailboxMsg
+	spare   []mailboxMsg // 上一批处理完的slice, 复用底层数组
+	running bool         // 是否已经有业务go程在处理这个连接的消息
+}
+
+func (g *goCallback) onMessageOrdered(c *Conn, op Opcode, data []byte) {
+	c.mailboxMu.Lock()
+	c.mailbox.msgs = append(c.mailbox.msgs, mailboxMsg{op: op, data: data})
+	if c.mailbox.running {
+		c.mailboxMu.Unlock()
+		return
+	}
+	c.mailbox.running = true
+	c.mailboxMu.Unlock()
+
+	g.t.addTask(func() (exit bool) {
+		g.drainMailbox(c)
+		return false
+	})
+}
+
+// 一批一批地取出消息处理, 直到队列为空
+func (g *goCallback) drainMailbox(c *Conn) {
+	for {
+		c.mailboxMu.Lock()
+		batch := c.mailbox.msgs
+		if len(batch) == 0 {
+			c.mailbox.running = false
+			c.mailboxMu.Unlock()
+			return
+		}
+		c.mailbox.msgs = c.mailbox.spare[:0]
+		c.mailboxMu.Unlock()
+
+		for i := range batch {
+			g.c.OnMessage(c, batch[i].op, batch[i].data)
+			g.release(batch[i].data)
+			batch[i] = mailboxMsg{}
+			c.waitOnMessageRun.Done()
+		}
+
+		c.mailboxMu.Lock()
+		c.mailbox.spare = batch[:0]
+		c.mailboxMu.Unlock()
+	}
+}
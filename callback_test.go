@@ -0,0 +1,69 @@
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_CallbackOrder(t *testing.T) {
+	t.Run("in event loop", func(t *testing.T) {
+		testCallbackOrder(t, WithCallbackInEventLoop())
+	})
+	t.Run("ordered go pool", func(t *testing.T) {
+		testCallbackOrder(t, WithOrderedCallback(), WithBusinessGoNum(8, 8, 8))
+	})
+}
+
+func testCallbackOrder(t *testing.T, opts ...EvOption) {
+	const total = 500
+	m := NewMultiEventLoopMust(append([]EvOption{WithEventLoops(1)}, opts...)...)
+	m.Start()
+
+	errc := make(chan error, 1)
+	next := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := Upgrade(w, r, WithServerMultiEventLoop(m), WithServerOnMessageFunc(func(c *Conn, op Opcode, payload []byte) {
+			// 同一个连接的回调不会并发, 不需要加锁, 顺序和发送的一致
+			if n, _ := strconv.Atoi(string(payload)); n != next {
+				errc <- fmt.Errorf("got %d, want %d", n, next)
+				return
+			}
+			next++
+			if next == total {
+				close(errc)
+			}
+		}))
+		if err != nil {
+			t.Error(err)
+		}
+	}))
+	defer ts.Close()
+
+	c, err := Dial("ws://"+strings.TrimPrefix(ts.URL, "http://"), WithClientMultiEventLoop(m))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	for i := 0; i < total; i++ {
+		if err := c.WriteMessage(Text, []byte(strconv.Itoa(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// 故障注入的配置, 用来在测试里验证业务的重连, 背压等逻辑
+// 对MultiEventLoop上的所有连接生效, 不要在生产环境配置
+type ChaosConfig struct {
+	DropFrameRate    float64       // 发送的text/binary消息被直接丢弃的概率, WriteMessage仍然返回nil
+	WriteDelay       time.Duration // 每次发送text/binary消息之前等待的时间, 在调用WriteMessage的go程里等待
+	WriteJitter      time.Duration // 在WriteDelay上再加[0, WriteJitter)的随机时间
+	TruncateReadRate float64       // 每次从fd读到数据之后, 只保留前面随机一段并断开连接的概率, OnClose收到ErrChaosTruncated
+	Seed             int64         // 随机数种子, 0表示使用当前时间, 固定种子可以复现
+}
+
+type chaos struct {
+	ChaosConfig
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+func newChaos(conf ChaosConfig) *chaos {
+	seed := conf.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &chaos{ChaosConfig: conf, rnd: rand.New(rand.NewSource(seed))}
+}
+
+// rand.Rand不是并发安全的
+func (ch *chaos) float64() float64 {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	return ch.rnd.Float64()
+}
+
+func (ch *chaos) int63n(n int64) int64 {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	return ch.rnd.Int63n(n)
+}
+
+// 返回true表示这条消息丢掉不发送
+func (ch *chaos) beforeWrite() (drop bool) {
+	d := ch.WriteDelay
+	if ch.WriteJitter > 0 {
+		d += time.Duration(ch.int63n(int64(ch.WriteJitter)))
+	}
+	if d > 0 {
+		time.Sleep(d)
+	}
+	return ch.DropFrameRate > 0 && ch.float64() < ch.DropFrameRate
+}
+
+// 读到n字节之后调用, 返回保留的长度, 小于n表示需要断开连接
+func (ch *chaos) afterRead(n int) int {
+	if ch.TruncateReadRate <= 0 || n == 0 || ch.float64() >= ch.TruncateReadRate {
+		return n
+	}
+	return int(ch.int63n(int64(n)))
+}
+
+func (c *Conn) getChaos() *chaos {
+	if c.multiEventLoop == nil {
+		return nil
+	}
+	return c.multiEventLoop.chaos
+}
@@ -0,0 +1,44 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package greatws
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_Chaos(t *testing.T) {
+	t.Run("drop", func(t *testing.T) {
+		c, remote := newTestConn(t, withTestEvOptions(WithChaos(ChaosConfig{DropFrameRate: 1})))
+		if err := c.WriteMessage(Binary, []byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		remote.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		if n, err := remote.Read(make([]byte, 16)); !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Fatalf("n = %d, err = %v", n, err)
+		}
+	})
+
+	t.Run("delay", func(t *testing.T) {
+		c, _ := newTestConn(t, withTestEvOptions(WithChaos(ChaosConfig{WriteDelay: 50 * time.Millisecond})))
+		start := time.Now()
+		if err := c.WriteMessage(Binary, []byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		if d := time.Since(start); d < 50*time.Millisecond {
+			t.Fatalf("write took %v", d)
+		}
+	})
+
+	t.Run("truncate read", func(t *testing.T) {
+		r := newCloseRecorder()
+		_, remote := newTestConn(t, withTestEvOptions(WithChaos(ChaosConfig{TruncateReadRate: 1, Seed: 1})), withTestCallback(r))
+		remote.Write([]byte{0x82, 0x85, 0x01, 0x02, 0x03, 0x04, 'h', 'e', 'l', 'l', 'o'})
+		if err := r.wait(t); !errors.Is(err, ErrChaosTruncated) {
+			t.Fatalf("err = %v", err)
+		}
+	})
+}
@@ -12,10 +12,14 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+//go:build !js
+// +build !js
+
 package greatws
 
 import (
 	"bufio"
+	"context"
 	"crypto/tls"
 	"fmt"
 	"net"
@@ -23,19 +27,42 @@ import (
 	"net/url"
 	"strings"
 	"time"
+
+	"github.com/antlabs/wsutil/bytespool"
 )
 
 var (
-	defaultTimeout = time.Minute * 30
-	strExtensions  = "permessage-deflate; server_no_context_takeover; client_no_context_takeover"
+	defaultDialTimeout          = time.Second * 30
+	defaultTLSHandshakeTimeout  = time.Second * 10
+	defaultHTTPHandshakeTimeout = time.Second * 30
 )
 
 type DialOption struct {
 	Header               http.Header
 	u                    *url.URL
 	tlsConfig            *tls.Config
-	dialTimeout          time.Duration
-	bindClientHttpHeader *http.Header // 握手成功之后, 客户端获取http.Header,
+	dialTimeout          time.Duration     // tcp建立连接的超时时间
+	tlsHandshakeTimeout  time.Duration     // tls握手的超时时间
+	httpHandshakeTimeout time.Duration     // 发送upgrade请求到读完响应的超时时间
+	bindClientHttpHeader *http.Header      // 握手成功之后, 客户端获取http.Header,
+	useHTTP2             bool              // 使用http2 extended CONNECT(rfc 8441)建立连接
+	http2Transport       http.RoundTripper // http2模式下使用的transport
+	jar                  http.CookieJar    // 握手时带上的cookie, 并保存服务端返回的cookie
+	authorization        string            // 握手时的Authorization头
+	maxRedirects         int               // 握手返回3xx时最多跳转的次数, 默认0不跳转
+	fallbackDelay        time.Duration     // Happy Eyeballs, 发起下一个地址连接前等待的时间
+	resolver             *net.Resolver     // 解析域名使用的resolver, 默认net.DefaultResolver
+	dnsCacheTTL          time.Duration     // dns结果缓存的时间, 0表示不缓存
+
+	tlsSessionCache tls.ClientSessionCache // tls会话缓存, 多次Dial共用的时候可以恢复会话, 省掉完整握手
+	tlsNextProtos   []string               // 覆盖tls.Config里的ALPN
+	tlsMinVersion   uint16                 // 覆盖tls.Config里的MinVersion
+	tlsMaxVersion   uint16                 // 覆盖tls.Config里的MaxVersion
+
+	affinity *affinityToken // 粘性会话的token, 多次Dial共用
+
+	unixSocket string // 通过unix域套接字连接, 不为空时不再按url里的host建立tcp连接
+	host       string // 握手请求里的Host头, 为空使用url里的host
 	Config
 }
 
@@ -55,12 +82,17 @@ func DialConf(rawUrl string, conf *DialOption) (*Conn, error) {
 	}
 
 	conf.u = u
-	conf.dialTimeout = defaultTimeout
 	if conf.Header == nil {
 		conf.Header = make(http.Header)
 	}
 
-	conf.Callback = newGoCallback(conf.Callback, &conf.multiEventLoop.t)
+	if conf.multiEventLoop == nil {
+		return nil, ErrNotFoundMultiEventLoop
+	}
+	if err := conf.Validate(); err != nil {
+		return nil, err
+	}
+	conf.initCallback()
 	return conf.Dial()
 }
 
@@ -74,7 +106,6 @@ func Dial(rawUrl string, opts ...ClientOption) (*Conn, error) {
 	}
 
 	dial.u = u
-	dial.dialTimeout = defaultTimeout
 	if dial.Header == nil {
 		dial.Header = make(http.Header)
 	}
@@ -83,7 +114,13 @@ func Dial(rawUrl string, opts ...ClientOption) (*Conn, error) {
 	for _, o := range opts {
 		o(&dial)
 	}
-	dial.Callback = newGoCallback(dial.Callback, &dial.multiEventLoop.t)
+	if dial.multiEventLoop == nil {
+		return nil, ErrNotFoundMultiEventLoop
+	}
+	if err := dial.Validate(); err != nil {
+		return nil, err
+	}
+	dial.initCallback()
 
 	return dial.Dial()
 }
@@ -95,8 +132,12 @@ func (d *DialOption) handshake() (*http.Request, string, error) {
 		d.u.Scheme = "https"
 	case d.u.Scheme == "ws":
 		d.u.Scheme = "http"
+	case d.u.Scheme == unixScheme:
+		if err := d.parseUnixURL(); err != nil {
+			return nil, "", err
+		}
 	default:
-		return nil, "", fmt.Errorf("Unknown scheme, only supports ws:// or wss://: got %s", d.u.Scheme)
+		return nil, "", fmt.Errorf("%w: only supports ws://, wss:// or ws+unix://, got %s", ErrUnknownScheme, d.u.Scheme)
 	}
 
 	// 满足4.1
@@ -105,22 +146,28 @@ func (d *DialOption) handshake() (*http.Request, string, error) {
 	if err != nil {
 		return nil, "", err
 	}
+	if d.host != "" {
+		req.Host = d.host
+	}
+	// 用Set, 同一个Header多次Dial(比如重连)不会重复添加
 	// 第5点
-	d.Header.Add("Upgrade", "websocket")
+	d.Header.Set("Upgrade", "websocket")
 	// 第6点
-	d.Header.Add("Connection", "Upgrade")
+	d.Header.Set("Connection", "Upgrade")
 	// 第7点
 	secWebSocket := secWebSocketAccept()
-	d.Header.Add("Sec-WebSocket-Key", secWebSocket)
+	d.Header.Set("Sec-WebSocket-Key", secWebSocket)
 	// TODO 第8点
 	// 第9点
-	d.Header.Add("Sec-WebSocket-Version", "13")
+	d.Header.Set("Sec-WebSocket-Version", "13")
 
 	if d.decompression && d.compression {
-		d.Header.Add("Sec-WebSocket-Extensions", strExtensions)
+		d.Header.Set("Sec-WebSocket-Extensions", clientDeflateOffer(d.takeoverWindowBits))
 	}
 
-	req.Header = d.Header
+	// clone一份, cookie和鉴权信息不会污染用户的Header
+	req.Header = d.Header.Clone()
+	d.prepareRequest(req)
 	return req, secWebSocket, nil
 }
 
@@ -128,7 +175,7 @@ func (d *DialOption) handshake() (*http.Request, string, error) {
 // 4.2.2.5
 func (d *DialOption) validateRsp(rsp *http.Response, secWebSocket string) error {
 	if rsp.StatusCode != 101 {
-		return fmt.Errorf("%w %d", ErrWrongStatusCode, rsp.StatusCode)
+		return newHandshakeError(rsp, fmt.Errorf("%w %d", ErrWrongStatusCode, rsp.StatusCode))
 	}
 
 	// 第2点
@@ -152,15 +199,39 @@ func (d *DialOption) validateRsp(rsp *http.Response, secWebSocket string) error
 	return nil
 }
 
+// WithClientTLSConfig的基础上再应用单独配置的tls选项, 返回的是拷贝, 不修改用户传进来的tls.Config
+func (d *DialOption) tlsClientConfig() *tls.Config {
+	cfg := &tls.Config{}
+	if d.tlsConfig != nil {
+		cfg = d.tlsConfig.Clone()
+	}
+
+	if d.tlsSessionCache != nil {
+		cfg.ClientSessionCache = d.tlsSessionCache
+	}
+	if d.tlsNextProtos != nil {
+		cfg.NextProtos = d.tlsNextProtos
+	}
+	if d.tlsMinVersion != 0 {
+		cfg.MinVersion = d.tlsMinVersion
+	}
+	if d.tlsMaxVersion != 0 {
+		cfg.MaxVersion = d.tlsMaxVersion
+	}
+	return cfg
+}
+
+// 有没有配置过tls相关的选项, 没有的话http2模式使用默认的transport
+func (d *DialOption) customTLS() bool {
+	return d.tlsConfig != nil || d.tlsSessionCache != nil || d.tlsNextProtos != nil ||
+		d.tlsMinVersion != 0 || d.tlsMaxVersion != 0
+}
+
 // wss已经修改为https
-func (d *DialOption) tlsConn(c net.Conn) net.Conn {
+// tls握手在这里完成, 受tlsHandshakeTimeout限制
+func (d *DialOption) tlsConn(c net.Conn) (net.Conn, error) {
 	if d.u.Scheme == "https" {
-		cfg := d.tlsConfig
-		if cfg == nil {
-			cfg = &tls.Config{}
-		} else {
-			cfg = cfg.Clone()
-		}
+		cfg := d.tlsClientConfig()
 
 		if cfg.ServerName == "" {
 			host := d.u.Host
@@ -169,28 +240,70 @@ func (d *DialOption) tlsConn(c net.Conn) net.Conn {
 			}
 			cfg.ServerName = host
 		}
-		return tls.Client(c, cfg)
+		tc := tls.Client(c, cfg)
+		if err := tc.SetDeadline(time.Now().Add(d.tlsHandshakeTimeout)); err != nil {
+			return nil, err
+		}
+		if err := tc.Handshake(); err != nil {
+			return nil, err
+		}
+		return tc, nil
 	}
 
-	return c
+	return c, nil
+}
+
+// 没有配置的超时时间使用默认值
+func (d *DialOption) defaultTimeouts() {
+	if d.dialTimeout <= 0 {
+		d.dialTimeout = defaultDialTimeout
+	}
+	if d.tlsHandshakeTimeout <= 0 {
+		d.tlsHandshakeTimeout = defaultTLSHandshakeTimeout
+	}
+	if d.httpHandshakeTimeout <= 0 {
+		d.httpHandshakeTimeout = defaultHTTPHandshakeTimeout
+	}
 }
 
 func (d *DialOption) Dial() (c *Conn, err error) {
+	d.defaultTimeouts()
+	for hops := 0; ; hops++ {
+		if d.useHTTP2 {
+			c, err = d.dialHTTP2()
+		} else {
+			c, err = d.dial()
+		}
+
+		loc, ok := redirectLocation(err)
+		if !ok || hops >= d.maxRedirects {
+			return c, err
+		}
+
+		if err = d.redirect(loc); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (d *DialOption) dial() (c *Conn, err error) {
 	req, secWebSocket, err := d.handshake()
 	if err != nil {
 		return nil, err
 	}
 
-	begin := time.Now()
-	// conn, err := net.DialTimeout("tcp", d.u.Host /* TODO 加端号*/, d.dialTimeout)
-	conn, err := net.Dial("tcp", d.u.Host /* TODO 加端号*/)
+	ctx, cancel := context.WithTimeout(context.Background(), d.dialTimeout)
+	var conn net.Conn
+	if d.unixSocket != "" {
+		conn, err = d.dialUnix(ctx)
+	} else {
+		conn, err = d.dialTCP(ctx, hostPort(d.u))
+	}
+	cancel()
 	if err != nil {
 		return nil, err
 	}
 
-	dialDuration := time.Since(begin)
-
-	conn = d.tlsConn(conn)
 	defer func() {
 		if err != nil && conn != nil {
 			conn.Close()
@@ -198,17 +311,21 @@ func (d *DialOption) Dial() (c *Conn, err error) {
 		}
 	}()
 
-	if to := d.dialTimeout - dialDuration; to > 0 {
-		if err = conn.SetDeadline(time.Now().Add(to)); err != nil {
-			return
-		}
+	if conn, err = d.tlsConn(conn); err != nil {
+		return nil, err
 	}
 
-	defer func() {
-		if err == nil {
-			err = conn.SetDeadline(time.Time{})
-		}
-	}()
+	// 事件循环还不能直接读写tls, wss的连接通过socketpair转发, 和ClientFromConn一样
+	return d.upgradeConn(conn, req, secWebSocket)
+}
+
+// 在conn上发送握手请求, 检查响应, 成功之后把连接加入事件循环
+// conn拿不到fd的时候(比如tls.Conn), 通过socketpair转发
+// 出错时由调用方关闭conn
+func (d *DialOption) upgradeConn(conn net.Conn, req *http.Request, secWebSocket string) (c *Conn, err error) {
+	if err = conn.SetDeadline(time.Now().Add(d.httpHandshakeTimeout)); err != nil {
+		return
+	}
 
 	if err = req.Write(conn); err != nil {
 		return
@@ -219,12 +336,16 @@ func (d *DialOption) Dial() (c *Conn, err error) {
 	if err != nil {
 		return nil, err
 	}
+	d.saveCookies(rsp)
 
 	if d.bindClientHttpHeader != nil {
 		*d.bindClientHttpHeader = rsp.Header.Clone()
 	}
 
-	cd := maybeCompressionDecompression(rsp.Header)
+	var cd bool
+	if d.deflate, cd, err = acceptDeflateResponse(rsp.Header, d.takeoverWindowBits); err != nil {
+		return nil, err
+	}
 	if d.decompression {
 		d.decompression = cd
 	}
@@ -236,5 +357,66 @@ func (d *DialOption) Dial() (c *Conn, err error) {
 		return
 	}
 
-	return nil, nil
+	if err = conn.SetDeadline(time.Time{}); err != nil {
+		return
+	}
+
+	localAddr, remoteAddr := conn.LocalAddr(), conn.RemoteAddr()
+	var remote net.Conn
+	fd, err := getFdFromConn(conn)
+	if err != nil {
+		// 拿不到fd, 事件循环读写socketpair的一端, 另一端和conn互相拷贝
+		if fd, remote, err = newSocketPair(); err != nil {
+			return nil, err
+		}
+	} else {
+		if err = setSocketOptions(fd, d.tcpNoDelay, &d.socketOptions); err != nil {
+			closeFd(fd)
+			return nil, err
+		}
+		// 已经dup了一份fd，所以这里可以关闭
+		conn.Close()
+	}
+
+	// DialConf可能复用同一个DialOption, 每个连接用一份拷贝, UpdateConfig只修改这个连接的
+	conf := d.Config
+	c = newConn(int64(fd), true, &conf)
+	c.localAddr, c.remoteAddr = localAddr, remoteAddr
+	// 握手的时候可能已经多读了websocket数据, 放到读缓冲区里
+	if n := br.Buffered(); n > 0 {
+		if n > c.rbuf.Cap() {
+			bytespool.PutBytes(c.rbuf.Swap(bytespool.GetBytes(n)))
+		}
+		buffered, _ := br.Peek(n)
+		c.rbuf.Write(buffered)
+	}
+
+	// 加入事件循环之前处理, 不然会和事件循环同时读写rbuf
+	if c.rbuf.Len() > 0 {
+		if err = c.processBufferedFrames(); err != nil {
+			// 还没有加入事件循环, 直接关闭fd
+			c.setClosed()
+			closeFd(fd)
+			if remote != nil {
+				remote.Close()
+			}
+			return nil, err
+		}
+	}
+
+	if err = d.multiEventLoop.add(c); err != nil {
+		// 没有加入事件循环, fd还归这里, 直接关闭
+		closeFd(fd)
+		if remote != nil {
+			remote.Close()
+		}
+		return nil, err
+	}
+	if remote != nil {
+		bridgeStream(remote, conn, conn)
+	}
+	c.startHeartbeat()
+	c.startTick()
+	conf.Callback.OnOpen(c)
+	return c, nil
 }
@@ -0,0 +1,84 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+)
+
+// 握手失败时保留的响应body的最大长度
+const maxHandshakeErrorBody = 4096
+
+// 握手失败, 服务端返回了http响应, 但是状态码不对
+// 可以通过Response.StatusCode区分401/403等鉴权失败和网络错误
+// 用errors.As获取, errors.Is(err, ErrWrongStatusCode)仍然成立
+type HandshakeError struct {
+	Response *http.Response // Body已经读取(最多4k)并关闭, 可以直接读
+	err      error
+}
+
+func (e *HandshakeError) Error() string {
+	return e.err.Error()
+}
+
+func (e *HandshakeError) Unwrap() error {
+	return e.err
+}
+
+// 连接马上会被关闭, 先把body读出来, 给调用方留着
+func newHandshakeError(rsp *http.Response, err error) *HandshakeError {
+	if rsp.Body != nil {
+		body, _ := io.ReadAll(io.LimitReader(rsp.Body, maxHandshakeErrorBody))
+		rsp.Body.Close()
+		rsp.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	return &HandshakeError{Response: rsp, err: err}
+}
+
+// 握手请求带上cookie和鉴权信息
+func (d *DialOption) prepareRequest(req *http.Request) {
+	if d.jar != nil {
+		for _, cookie := range d.jar.Cookies(req.URL) {
+			req.AddCookie(cookie)
+		}
+	}
+
+	if d.authorization != "" {
+		req.Header.Set("Authorization", d.authorization)
+	}
+
+	if d.affinity != nil {
+		d.affinity.prepareRequest(req, d.jar)
+	}
+}
+
+// 保存服务端返回的cookie和粘性会话的token, 握手失败也保存
+func (d *DialOption) saveCookies(rsp *http.Response) {
+	if d.affinity != nil {
+		d.affinity.save(rsp)
+	}
+	if d.jar == nil {
+		return
+	}
+
+	if rc := rsp.Cookies(); len(rc) > 0 {
+		d.jar.SetCookies(rsp.Request.URL, rc)
+	}
+}
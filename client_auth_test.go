@@ -0,0 +1,52 @@
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_HandshakeAuthAndCookie(t *testing.T) {
+	m := NewMultiEventLoopMust(WithEventLoops(1))
+	m.Start()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("Authorization = %q", r.Header.Get("Authorization"))
+		}
+		if c, err := r.Cookie("session"); err != nil || c.Value != "old" {
+			t.Errorf("cookie = %v, %v", c, err)
+		}
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "new"})
+		http.Error(w, "forbidden", http.StatusForbidden)
+	}))
+	defer ts.Close()
+
+	jar, _ := cookiejar.New(nil)
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	jar.SetCookies(req.URL, []*http.Cookie{{Name: "session", Value: "old"}})
+
+	_, err := Dial("ws://"+strings.TrimPrefix(ts.URL, "http://"),
+		WithClientMultiEventLoop(m), WithClientCookieJar(jar), WithClientBearerToken("token"))
+
+	var he *HandshakeError
+	if !errors.As(err, &he) || !errors.Is(err, ErrWrongStatusCode) {
+		t.Fatalf("err = %v", err)
+	}
+	if he.Response.StatusCode != http.StatusForbidden {
+		t.Fatalf("status = %d", he.Response.StatusCode)
+	}
+	if body, _ := io.ReadAll(he.Response.Body); !strings.Contains(string(body), "forbidden") {
+		t.Fatalf("body = %q", body)
+	}
+	if cs := jar.Cookies(req.URL); len(cs) != 1 || cs[0].Value != "new" {
+		t.Fatalf("jar = %v", cs)
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// 在调用方已经建立好的连接上做websocket握手, 比如走了自定义的隧道, 自己做了tls或者多路复用
+// 只发送握手请求, 不做tcp连接和tls握手, 也不处理3xx跳转, rawUrl只用来生成请求行和Host头
+// conn能拿到fd(比如*net.TCPConn)的时候dup一份直接加入事件循环, 拿不到的(比如*tls.Conn)通过socketpair转发
+// 握手失败时conn会被关闭, 成功之后conn归Conn所有, 调用方不要再读写
+func ClientFromConn(conn net.Conn, rawUrl string, opts ...ClientOption) (c *Conn, err error) {
+	defer func() {
+		if err != nil {
+			conn.Close()
+		}
+	}()
+
+	var dial DialOption
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	dial.u = u
+	dial.Header = make(http.Header)
+	dial.defaultSetting()
+	for _, o := range opts {
+		o(&dial)
+	}
+	if dial.multiEventLoop == nil {
+		return nil, ErrNotFoundMultiEventLoop
+	}
+	if err := dial.Validate(); err != nil {
+		return nil, err
+	}
+	dial.initCallback()
+	dial.defaultTimeouts()
+
+	req, secWebSocket, err := dial.handshake()
+	if err != nil {
+		return nil, err
+	}
+	return dial.upgradeConn(conn, req, secWebSocket)
+}
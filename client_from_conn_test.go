@@ -0,0 +1,91 @@
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 隐藏SyscallConn, 模拟拿不到fd的连接(隧道, 多路复用的stream)
+type noFdConn struct {
+	net.Conn
+}
+
+func Test_ClientFromConn(t *testing.T) {
+	m := NewMultiEventLoopMust(WithEventLoops(1))
+	m.Start()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := Upgrade(w, r, WithServerMultiEventLoop(m), WithServerOnMessageFunc(func(c *Conn, op Opcode, payload []byte) {
+			c.WriteMessage(op, payload)
+		}))
+		if err != nil {
+			t.Error(err)
+		}
+	}))
+	defer ts.Close()
+
+	for _, tc := range []struct {
+		name string
+		wrap func(net.Conn) net.Conn
+	}{
+		{"fd", func(c net.Conn) net.Conn { return c }},
+		{"bridge", func(c net.Conn) net.Conn { return noFdConn{c} }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			raw, err := net.Dial("tcp", ts.Listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := make(chan string, 1)
+			c, err := ClientFromConn(tc.wrap(raw), "ws://"+strings.TrimPrefix(ts.URL, "http://"),
+				WithClientMultiEventLoop(m), WithClientOnMessageFunc(func(c *Conn, op Opcode, payload []byte) {
+					got <- string(payload)
+				}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+
+			if c.RemoteAddr().String() != ts.Listener.Addr().String() {
+				t.Fatalf("remote = %v", c.RemoteAddr())
+			}
+			if err := c.WriteMessage(Text, []byte("hello")); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case s := <-got:
+				if s != "hello" {
+					t.Fatalf("got %q", s)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("echo timeout")
+			}
+		})
+	}
+
+	t.Run("handshake error closes conn", func(t *testing.T) {
+		a, b := net.Pipe()
+		defer b.Close()
+		go func() {
+			// 读走请求, 返回一个非101的响应
+			buf := make([]byte, 4096)
+			b.Read(buf)
+			b.Write([]byte("HTTP/1.1 403 Forbidden\r\nContent-Length: 0\r\n\r\n"))
+		}()
+
+		if _, err := ClientFromConn(a, "ws://example.com/", WithClientMultiEventLoop(m)); err == nil {
+			t.Fatal("expected error")
+		}
+		if _, err := a.Write([]byte("x")); err == nil {
+			t.Fatal("conn not closed")
+		}
+	})
+}
@@ -0,0 +1,154 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"time"
+)
+
+// https://datatracker.ietf.org/doc/html/rfc8305#section-5
+// 推荐的Connection Attempt Delay是250ms
+const defaultFallbackDelay = 250 * time.Millisecond
+
+type dialResult struct {
+	conn net.Conn
+	err  error
+}
+
+// url里没有端口号, 按scheme补上默认端口, 这时候ws/wss已经被改成http/https
+func hostPort(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+
+	port := "80"
+	if u.Scheme == "https" {
+		port = "443"
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// Happy Eyeballs v2, 解析出所有地址, ipv6和ipv4交替排列,
+// 每隔fallbackDelay发起一个新的连接, 前一个连接失败就立即发起下一个, 最先成功的胜出
+func (d *DialOption) dialTCP(ctx context.Context, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	ips, err := d.lookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	delay := d.fallbackDelay
+	if delay <= 0 {
+		delay = defaultFallbackDelay
+	}
+	c, err := happyEyeballs(ctx, interleaveAddrs(ips), port, delay)
+	if err != nil {
+		d.invalidateDNSCache(host)
+	}
+	return c, err
+}
+
+// https://datatracker.ietf.org/doc/html/rfc8305#section-4
+// 地址族交替排列, ipv6优先
+func interleaveAddrs(ips []net.IPAddr) []net.IPAddr {
+	var v6, v4 []net.IPAddr
+	for _, ip := range ips {
+		if ip.IP.To4() != nil {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+
+	out := make([]net.IPAddr, 0, len(ips))
+	for len(v6) > 0 || len(v4) > 0 {
+		if len(v6) > 0 {
+			out = append(out, v6[0])
+			v6 = v6[1:]
+		}
+		if len(v4) > 0 {
+			out = append(out, v4[0])
+			v4 = v4[1:]
+		}
+	}
+	return out
+}
+
+func happyEyeballs(ctx context.Context, addrs []net.IPAddr, port string, delay time.Duration) (net.Conn, error) {
+	if len(addrs) == 0 {
+		return nil, &net.AddrError{Err: "no suitable address found", Addr: port}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var dialer net.Dialer
+	results := make(chan dialResult, len(addrs))
+	next, pending := 0, 0
+	var fallback <-chan time.Time
+	start := func() {
+		addr := net.JoinHostPort(addrs[next].String(), port)
+		next++
+		pending++
+		go func() {
+			c, err := dialer.DialContext(ctx, "tcp", addr)
+			results <- dialResult{conn: c, err: err}
+		}()
+		fallback = nil
+		if next < len(addrs) {
+			fallback = time.After(delay)
+		}
+	}
+
+	start()
+	var firstErr error
+	for pending > 0 {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				// 还没有返回的连接, 成功了也要关掉
+				go func(n int) {
+					for ; n > 0; n-- {
+						if r := <-results; r.conn != nil {
+							r.conn.Close()
+						}
+					}
+				}(pending)
+				return r.conn, nil
+			}
+
+			if firstErr == nil {
+				firstErr = r.err
+			}
+			if next < len(addrs) {
+				start()
+			}
+		case <-fallback:
+			start()
+		}
+	}
+	return nil, firstErr
+}
@@ -0,0 +1,45 @@
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_InterleaveAddrs(t *testing.T) {
+	ips := []net.IPAddr{
+		{IP: net.ParseIP("127.0.0.1")},
+		{IP: net.ParseIP("127.0.0.2")},
+		{IP: net.ParseIP("::1")},
+	}
+	want := []string{"::1", "127.0.0.1", "127.0.0.2"}
+	got := interleaveAddrs(ips)
+	for i := range want {
+		if got[i].String() != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func Test_HappyEyeballsFallback(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	// 第一个地址不可达, 应该在fallback之后连上第二个
+	addrs := []net.IPAddr{{IP: net.ParseIP("192.0.2.1")}, {IP: net.ParseIP("127.0.0.1")}}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	c, err := happyEyeballs(ctx, addrs, port, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+}
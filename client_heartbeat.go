@@ -0,0 +1,79 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// 客户端心跳, 每隔pingInterval发送一个ping
+// 发送ping之后pongTimeout内没有收到pong, 关闭连接, OnClose收到ErrPongTimeout
+func (c *Conn) startHeartbeat() {
+	if c.pingInterval <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	if !c.isClosed() {
+		c.pingTimer = c.multiEventLoop.wheel.AfterFunc(c.pingInterval, c.heartbeat)
+	}
+	c.mu.Unlock()
+}
+
+func (c *Conn) heartbeat() {
+	// 关闭握手中不再发送ping
+	if c.State() != StateOpen {
+		return
+	}
+
+	atomic.StoreInt32(&c.pongPending, 1)
+	if err := c.WriteControl(Ping, c.heartbeatPayload(), time.Time{}); err != nil {
+		c.asyncClose(err)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.isClosed() {
+		return
+	}
+
+	if c.pongTimeout > 0 {
+		c.pongTimer = c.multiEventLoop.wheel.AfterFunc(c.pongTimeout, func() {
+			if atomic.LoadInt32(&c.pongPending) == 1 {
+				c.asyncClose(ErrPongTimeout)
+			}
+		})
+	}
+	c.pingTimer = c.multiEventLoop.wheel.AfterFunc(c.pingInterval, c.heartbeat)
+}
+
+// 收到pong
+func (c *Conn) onPong() {
+	atomic.StoreInt32(&c.pongPending, 0)
+}
+
+func (c *Conn) stopHeartbeat() {
+	if c.pingTimer != nil {
+		c.pingTimer.Stop()
+	}
+	if c.pongTimer != nil {
+		c.pongTimer.Stop()
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// 默认的http2 transport, 所有的websocket stream共享同一个tcp/tls连接
+var defaultHTTP2Transport http.RoundTripper = &http.Transport{ForceAttemptHTTP2: true}
+
+// https://datatracker.ietf.org/doc/html/rfc8441#section-4
+// 通过http2 extended CONNECT建立websocket连接
+// stream没有fd, 用socketpair的一端交给event loop, 另一端和stream对接
+func (d *DialOption) dialHTTP2() (c *Conn, err error) {
+	switch {
+	case d.u.Scheme == "wss":
+		d.u.Scheme = "https"
+	case d.u.Scheme == "ws":
+		d.u.Scheme = "http"
+	default:
+		return nil, fmt.Errorf("%w: only supports ws:// or wss://, got %s", ErrUnknownScheme, d.u.Scheme)
+	}
+
+	rt := d.http2Transport
+	if rt == nil {
+		rt = defaultHTTP2Transport
+		if d.customTLS() {
+			rt = &http.Transport{ForceAttemptHTTP2: true, TLSClientConfig: d.tlsClientConfig()}
+		}
+	}
+
+	// 请求的body就是客户端发往服务端的数据
+	pr, pw := io.Pipe()
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodConnect, d.u.String(), pr)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	req.Header = d.Header.Clone()
+	d.prepareRequest(req)
+	req.Header.Set(":protocol", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if d.decompression && d.compression {
+		req.Header.Set("Sec-WebSocket-Extensions", clientDeflateOffer(d.takeoverWindowBits))
+	}
+
+	// 只限制握手的时间, 握手成功之后的stream不受影响
+	// tcp连接和tls握手由transport完成, 这里限制的是三者的总时间
+	timer := time.AfterFunc(d.dialTimeout+d.tlsHandshakeTimeout+d.httpHandshakeTimeout, cancel)
+	rsp, err := rt.RoundTrip(req)
+	timer.Stop()
+	if err != nil {
+		cancel()
+		pw.Close()
+		return nil, err
+	}
+
+	defer func() {
+		if err != nil {
+			rsp.Body.Close()
+			pw.Close()
+			cancel()
+		}
+	}()
+
+	d.saveCookies(rsp)
+	if rsp.ProtoMajor != 2 {
+		return nil, ErrHTTP2NotNegotiated
+	}
+
+	if rsp.StatusCode != http.StatusOK {
+		return nil, newHandshakeError(rsp, fmt.Errorf("%w %d", ErrWrongStatusCode, rsp.StatusCode))
+	}
+
+	if d.bindClientHttpHeader != nil {
+		*d.bindClientHttpHeader = rsp.Header.Clone()
+	}
+
+	var cd bool
+	if d.deflate, cd, err = acceptDeflateResponse(rsp.Header, d.takeoverWindowBits); err != nil {
+		return nil, err
+	}
+	if d.decompression {
+		d.decompression = cd
+	}
+	if d.compression {
+		d.compression = cd
+	}
+
+	localFd, remote, err := newSocketPair()
+	if err != nil {
+		return nil, err
+	}
+
+	// DialConf可能复用同一个DialOption, 每个连接用一份拷贝, UpdateConfig只修改这个连接的
+	conf := d.Config
+	c = newConn(int64(localFd), true, &conf)
+	if err = d.multiEventLoop.add(c); err != nil {
+		// 没有加入事件循环, fd还归这里, 直接关闭
+		closeFd(localFd)
+		remote.Close()
+		return nil, err
+	}
+
+	bridgeStream(remote, rsp.Body, pw)
+	c.startHeartbeat()
+	c.startTick()
+	conf.Callback.OnOpen(c)
+	return c, nil
+}
@@ -0,0 +1,90 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build js && wasm
+// +build js,wasm
+
+package greatws
+
+// 浏览器里只能配置回调相关的选项, tls, http头, 代理等都由浏览器决定
+type DialOption struct {
+	Config
+}
+
+type ClientOption func(*DialOption)
+
+// 0. CallbackFunc
+func WithClientCallbackFunc(open OnOpenFunc, m OnMessageFunc, c OnCloseFunc) ClientOption {
+	return func(o *DialOption) {
+		o.Callback = &funcToCallback{
+			onOpen:    open,
+			onMessage: m,
+			onClose:   c,
+		}
+	}
+}
+
+// 1. 配置客户端回调函数
+func WithClientCallback(cb Callback) ClientOption {
+	return func(o *DialOption) {
+		o.Callback = cb
+	}
+}
+
+// 2. 仅仅配置OnMessae函数
+func WithClientOnMessageFunc(cb OnMessageFunc) ClientOption {
+	return func(o *DialOption) {
+		o.Callback = OnMessageFunc(cb)
+	}
+}
+
+// 3. 配置客户端OnClose
+func WithClientOnCloseFunc(onClose func(c *Conn, err error)) ClientOption {
+	return func(o *DialOption) {
+		o.Callback = OnCloseFunc(onClose)
+	}
+}
+
+// 4. 配置客户端的中间件
+func WithClientMiddleware(mws ...Middleware) ClientOption {
+	return func(o *DialOption) {
+		o.middlewares = append(o.middlewares, mws...)
+	}
+}
+
+// 用浏览器的WebSocket建立连接, 等到握手完成或者失败才返回
+// 不能在浏览器的事件回调里直接调用, 会卡住事件循环, 需要放到单独的go程里
+func Dial(rawUrl string, opts ...ClientOption) (*Conn, error) {
+	var dial DialOption
+	for _, o := range opts {
+		o(&dial)
+	}
+	return DialConf(rawUrl, &dial)
+}
+
+func DialConf(rawUrl string, conf *DialOption) (*Conn, error) {
+	if conf.Callback == nil {
+		conf.Callback = &DefCallback{}
+	}
+	conf.Callback = chainMiddleware(conf.Callback, conf.middlewares)
+
+	c, err := newBrowserConn(rawUrl, &conf.Config)
+	if err != nil {
+		return nil, err
+	}
+	if err = <-c.opened; err != nil {
+		return nil, err
+	}
+	return c, nil
+}
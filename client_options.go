@@ -12,10 +12,15 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+//go:build !js
+// +build !js
+
 package greatws
 
 import (
 	"crypto/tls"
+	"encoding/base64"
+	"net"
 	"net/http"
 	"time"
 )
@@ -36,7 +41,7 @@ func WithClientHTTPHeader(h http.Header) ClientOption {
 	}
 }
 
-// 3.配置握手时的timeout
+// 3.配置tcp建立连接的timeout, 默认30s
 func WithClientDialTimeout(t time.Duration) ClientOption {
 	return func(o *DialOption) {
 		o.dialTimeout = t
@@ -64,3 +69,173 @@ func WithClientBindHTTPHeader(h *http.Header) ClientOption {
 		o.bindClientHttpHeader = h
 	}
 }
+
+// 7.使用http2 extended CONNECT(rfc 8441)建立websocket连接
+// rt为nil时使用默认的transport, 通过同一个rt建立的连接共享同一个tcp/tls连接
+// rt需要支持extended CONNECT, 比如golang.org/x/net/http2.Transport, 部分版本的net/http.Transport会拒绝:protocol头
+// ws://(h2c)需要传入一个支持明文http2的transport
+func WithClientHTTP2(rt http.RoundTripper) ClientOption {
+	return func(o *DialOption) {
+		o.useHTTP2 = true
+		o.http2Transport = rt
+	}
+}
+
+// 8.配置心跳, 每隔d发送一个ping
+func WithClientPingInterval(d time.Duration) ClientOption {
+	return func(o *DialOption) {
+		o.pingInterval = d
+	}
+}
+
+// 9.配置发送ping之后等待pong的时间, 超时关闭连接, OnClose收到ErrPongTimeout
+func WithClientPongTimeout(d time.Duration) ClientOption {
+	return func(o *DialOption) {
+		o.pongTimeout = d
+	}
+}
+
+// 10.配置cookie jar, 握手时带上jar里的cookie, 并保存服务端返回的cookie
+func WithClientCookieJar(jar http.CookieJar) ClientOption {
+	return func(o *DialOption) {
+		o.jar = jar
+	}
+}
+
+// 11.配置basic auth, 和WithClientBearerToken同时使用时, 后面的生效
+func WithClientBasicAuth(username, password string) ClientOption {
+	return func(o *DialOption) {
+		o.authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+	}
+}
+
+// 12.配置bearer token, 和WithClientBasicAuth同时使用时, 后面的生效
+func WithClientBearerToken(token string) ClientOption {
+	return func(o *DialOption) {
+		o.authorization = "Bearer " + token
+	}
+}
+
+// 13.握手返回301/302/303/307/308时, 跟随Location跳转, 最多跳转n次
+// http/https的Location会映射成ws/wss, 跳到别的host时不再带上鉴权信息
+func WithClientFollowRedirects(n int) ClientOption {
+	return func(o *DialOption) {
+		o.maxRedirects = n
+	}
+}
+
+// 14.配置tls握手的timeout, 默认10s
+func WithClientTLSHandshakeTimeout(t time.Duration) ClientOption {
+	return func(o *DialOption) {
+		o.tlsHandshakeTimeout = t
+	}
+}
+
+// 15.配置http握手(发送upgrade请求, 读取响应)的timeout, 默认30s
+func WithClientHTTPHandshakeTimeout(t time.Duration) ClientOption {
+	return func(o *DialOption) {
+		o.httpHandshakeTimeout = t
+	}
+}
+
+// 16.配置Happy Eyeballs(rfc 8305)的Connection Attempt Delay, 默认250ms
+// 域名解析出多个地址时, ipv6和ipv4交替尝试, 前一个地址d时间内没有连上就并行连接下一个
+func WithClientFallbackDelay(d time.Duration) ClientOption {
+	return func(o *DialOption) {
+		o.fallbackDelay = d
+	}
+}
+
+// 17.配置解析域名使用的resolver
+func WithClientResolver(r *net.Resolver) ClientOption {
+	return func(o *DialOption) {
+		o.resolver = r
+	}
+}
+
+// 18.缓存dns解析结果ttl时间, 适合频繁建立连接的场景(压测, serverless)
+// 缓存在所有Dial之间共享, 缓存的地址全部连接失败时会重新解析
+func WithClientDNSCache(ttl time.Duration) ClientOption {
+	return func(o *DialOption) {
+		o.dnsCacheTTL = ttl
+	}
+}
+
+// 19.客户端用crypto/rand生成掩码, 默认math/rand
+func WithClientCryptoMaskKey() ClientOption {
+	return func(o *DialOption) {
+		o.newMaskKey = func() MaskKeyFunc { return CryptoMaskKey }
+	}
+}
+
+// 20.客户端每个连接使用自己的xorshift生成掩码, 种子来自crypto/rand, 详见NewXorshiftMaskKey
+func WithClientXorshiftMaskKey() ClientOption {
+	return func(o *DialOption) {
+		o.newMaskKey = NewXorshiftMaskKey
+	}
+}
+
+// 21.自定义生成掩码的函数, 所有连接共用f, 用于生成确定的测试向量
+func WithClientMaskKeyFunc(f MaskKeyFunc) ClientOption {
+	return func(o *DialOption) {
+		o.newMaskKey = func() MaskKeyFunc { return f }
+	}
+}
+
+// 所有配置了WithClientTLSSessionCache(nil)的Dial共用的会话缓存
+var defaultTLSSessionCache = tls.NewLRUClientSessionCache(0)
+
+// 22.tls会话缓存, 多次Dial传同一个cache就可以恢复会话(session ticket), 省掉完整握手
+// cache为nil时使用包里共享的LRU缓存. 会覆盖WithClientTLSConfig里的ClientSessionCache
+func WithClientTLSSessionCache(cache tls.ClientSessionCache) ClientOption {
+	return func(o *DialOption) {
+		if cache == nil {
+			cache = defaultTLSSessionCache
+		}
+		o.tlsSessionCache = cache
+	}
+}
+
+// 23.覆盖tls握手的ALPN, 会覆盖WithClientTLSConfig里的NextProtos
+// http2模式下transport会自己加上h2
+func WithClientTLSNextProtos(protos ...string) ClientOption {
+	return func(o *DialOption) {
+		o.tlsNextProtos = append([]string{}, protos...)
+	}
+}
+
+// 24.tls的最低和最高版本, 比如tls.VersionTLS12, 为0表示使用WithClientTLSConfig里的值或者crypto/tls的默认值
+func WithClientTLSVersion(min, max uint16) ClientOption {
+	return func(o *DialOption) {
+		o.tlsMinVersion = min
+		o.tlsMaxVersion = max
+	}
+}
+
+// 25.粘性会话, 保存服务端握手响应里下发的token(http头或者cookie), 之后的握手带上, name为空使用X-Websocket-Affinity
+// token保存在返回的option里, 同一个option多次Dial(比如传给ReconnectingDialer)共用, 重连会回到原来的节点
+func WithClientAffinity(name string) ClientOption {
+	if name == "" {
+		name = defaultAffinityName
+	}
+	t := &affinityToken{name: name}
+	return func(o *DialOption) {
+		o.affinity = t
+	}
+}
+
+// 26.通过unix域套接字path连接, url还是ws://或者wss://, 用来确定握手的Host头和请求路径
+// 也可以直接Dial("ws+unix:///path/to.sock:/endpoint"), 这时候url里的路径优先
+func WithClientUnixSocket(path string) ClientOption {
+	return func(o *DialOption) {
+		o.unixSocket = path
+	}
+}
+
+// 27.握手请求里的Host头, 为空使用url里的host, ws+unix的url默认是localhost
+// 只修改Host头, 不影响连接的地址和tls的ServerName
+func WithClientHost(host string) ClientOption {
+	return func(o *DialOption) {
+		o.host = host
+	}
+}
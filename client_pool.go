@@ -0,0 +1,258 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type PoolOption func(*Pool)
+
+// 到同一个地址的客户端连接池, 适合把websocket当作rpc通道的场景
+// 最多维护size个连接, Get借出一个空闲的连接, 用完之后Put还回来
+// 连接断开之后从池子里移除, 后台补一个新的, 补不上的话下一次Get的时候再建立
+type Pool struct {
+	rawUrl string
+	opts   []ClientOption
+	cb     Callback // 用户的callback
+	size   int
+
+	mu      sync.Mutex
+	all     map[*Conn]struct{} // 池子里的所有连接, 包括借出去的
+	idle    []*Conn            // 空闲的连接, 后还回来的先借出
+	dialing int                // 正在建立的连接数, 和all一起不超过size
+	notify  chan struct{}      // 有连接归还, 断开或者池子关闭时close, 唤醒等待的Get
+	closed  bool
+}
+
+// 1.连接数, 默认4个
+func WithPoolSize(n int) PoolOption {
+	return func(p *Pool) {
+		p.size = n
+	}
+}
+
+// 2.健康检查, 每个连接每隔interval发送一个ping, timeout之内没有收到pong就关闭, 关闭的连接会被替换
+// 使用的是客户端的心跳, 和WithClientPingInterval, WithClientPongTimeout一样
+func WithPoolHealthCheck(interval, timeout time.Duration) PoolOption {
+	return func(p *Pool) {
+		p.opts = append(p.opts, WithClientPingInterval(interval), WithClientPongTimeout(timeout))
+	}
+}
+
+// 3.配置Dial的参数, 所有的连接共用
+func WithPoolClientOption(opts ...ClientOption) PoolOption {
+	return func(p *Pool) {
+		p.opts = append(p.opts, opts...)
+	}
+}
+
+// 创建连接池, 马上建立size个连接, 有一个失败就关闭已经建立的连接, 返回错误
+func NewPool(rawUrl string, opts ...PoolOption) (*Pool, error) {
+	p := &Pool{
+		rawUrl: rawUrl,
+		size:   4,
+		all:    make(map[*Conn]struct{}),
+		notify: make(chan struct{}),
+	}
+	for _, o := range opts {
+		o(p)
+	}
+	if p.size <= 0 {
+		p.size = 1
+	}
+
+	// 取出用户配置的callback, Dial的时候换成自己, 用来感知连接断开
+	p.cb = ClientOptionToConf(p.opts...).Callback
+	p.opts = append(p.opts[:len(p.opts):len(p.opts)], WithClientCallback(p))
+
+	for i := 0; i < p.size; i++ {
+		c, err := Dial(p.rawUrl, p.opts...)
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+		p.mu.Lock()
+		if !c.isClosed() {
+			p.all[c] = struct{}{}
+			p.idle = append(p.idle, c)
+		}
+		p.mu.Unlock()
+	}
+	return p, nil
+}
+
+// 借出一个连接, 用完之后必须Put还回来
+// 没有空闲的连接时, 连接数不够size就新建一个, 否则等到有连接归还或者ctx结束
+func (p *Pool) Get(ctx context.Context) (*Conn, error) {
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return nil, ErrPoolClosed
+		}
+
+		for len(p.idle) > 0 {
+			c := p.idle[len(p.idle)-1]
+			p.idle = p.idle[:len(p.idle)-1]
+			if !c.isClosed() {
+				p.mu.Unlock()
+				return c, nil
+			}
+		}
+
+		if len(p.all)+p.dialing < p.size {
+			p.dialing++
+			p.mu.Unlock()
+			return p.dial(true)
+		}
+
+		notify := p.notify
+		p.mu.Unlock()
+
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// 归还借出的连接, 已经断开的连接直接丢掉
+func (p *Pool) Put(c *Conn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.all[c]; !ok || c.isClosed() {
+		return
+	}
+	if p.closed {
+		delete(p.all, c)
+		go c.Close()
+		return
+	}
+	p.idle = append(p.idle, c)
+	p.wakeup()
+}
+
+// 池子里的连接数, 包括借出去的
+func (p *Pool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.all)
+}
+
+// 空闲的连接数
+func (p *Pool) Idle() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.idle)
+}
+
+// 关闭所有空闲的连接, 借出去的连接在Put的时候关闭, 之后的Get返回ErrPoolClosed
+func (p *Pool) Close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	idle := p.idle
+	p.idle = nil
+	for _, c := range idle {
+		delete(p.all, c)
+	}
+	p.wakeup()
+	p.mu.Unlock()
+
+	for _, c := range idle {
+		c.Close()
+	}
+}
+
+// 调用之前已经占了一个dialing的名额, borrow为true时直接借出去, 否则放进空闲列表
+func (p *Pool) dial(borrow bool) (*Conn, error) {
+	c, err := Dial(p.rawUrl, p.opts...)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.dialing--
+	if err != nil {
+		p.wakeup()
+		return nil, err
+	}
+	if p.closed {
+		go c.Close()
+		return nil, ErrPoolClosed
+	}
+	// 还没放进池子就断开了, OnClose不会处理它
+	if c.isClosed() {
+		p.wakeup()
+		return nil, ErrClosed
+	}
+
+	p.all[c] = struct{}{}
+	if !borrow {
+		p.idle = append(p.idle, c)
+		p.wakeup()
+	}
+	return c, nil
+}
+
+// 唤醒所有等待的Get, 调用方持有p.mu
+func (p *Pool) wakeup() {
+	close(p.notify)
+	p.notify = make(chan struct{})
+}
+
+func (p *Pool) OnOpen(c *Conn) {
+	p.cb.OnOpen(c)
+}
+
+func (p *Pool) OnMessage(c *Conn, op Opcode, data []byte) {
+	p.cb.OnMessage(c, op, data)
+}
+
+func (p *Pool) OnClose(c *Conn, err error) {
+	p.cb.OnClose(c, err)
+
+	// 还没放进池子, 或者池子关闭时已经移出去的连接不用处理
+	p.mu.Lock()
+	_, ok := p.all[c]
+	if ok {
+		delete(p.all, c)
+		for i, ic := range p.idle {
+			if ic == c {
+				p.idle = append(p.idle[:i], p.idle[i+1:]...)
+				break
+			}
+		}
+		p.wakeup()
+	}
+	refill := ok && !p.closed && len(p.all)+p.dialing < p.size
+	if refill {
+		p.dialing++
+	}
+	p.mu.Unlock()
+
+	if refill {
+		// 补不上也没关系, 下一次Get的时候再建立
+		go p.dial(false)
+	}
+}
@@ -0,0 +1,119 @@
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_ClientPool(t *testing.T) {
+	m := NewMultiEventLoopMust(WithEventLoops(1))
+	m.Start()
+
+	var accepted int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&accepted, 1)
+		_, err := Upgrade(w, r, WithServerMultiEventLoop(m), WithServerOnMessageFunc(func(c *Conn, op Opcode, payload []byte) {
+			c.WriteMessage(op, payload)
+		}))
+		if err != nil {
+			t.Error(err)
+		}
+	}))
+	defer ts.Close()
+
+	got := make(chan string, 1)
+	p, err := NewPool("ws://"+strings.TrimPrefix(ts.URL, "http://"), WithPoolSize(2),
+		WithPoolClientOption(WithClientMultiEventLoop(m), WithClientOnMessageFunc(func(c *Conn, op Opcode, payload []byte) {
+			got <- string(payload)
+		})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if p.Len() != 2 || p.Idle() != 2 || atomic.LoadInt32(&accepted) != 2 {
+		t.Fatalf("len = %d, idle = %d, accepted = %d", p.Len(), p.Idle(), accepted)
+	}
+
+	c1, err := p.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c1.WriteMessage(Text, []byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	if s := <-got; s != "ping" {
+		t.Fatalf("got %q", s)
+	}
+
+	c2, err := p.Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c1 == c2 || p.Idle() != 0 {
+		t.Fatalf("idle = %d", p.Idle())
+	}
+
+	// 都借出去了, 等到超时
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := p.Get(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("err = %v", err)
+	}
+
+	// 归还之后等待的Get拿到这个连接
+	borrowed := make(chan *Conn, 1)
+	go func() {
+		c, err := p.Get(context.Background())
+		if err != nil {
+			t.Error(err)
+		}
+		borrowed <- c
+	}()
+	time.Sleep(10 * time.Millisecond)
+	p.Put(c1)
+	select {
+	case c := <-borrowed:
+		if c != c1 {
+			t.Fatal("not the returned conn")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Get not woken up")
+	}
+
+	// 断开的连接从池子里移除, 后台补一个新的
+	c2.Close()
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&accepted) != 3 || p.Idle() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("not refilled: len = %d, idle = %d, accepted = %d", p.Len(), p.Idle(), atomic.LoadInt32(&accepted))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	p.Put(c2) // 已经断开的连接不会放回去
+	if p.Len() != 2 || p.Idle() != 1 {
+		t.Fatalf("len = %d, idle = %d", p.Len(), p.Idle())
+	}
+
+	p.Close()
+	if _, err := p.Get(context.Background()); !errors.Is(err, ErrPoolClosed) {
+		t.Fatalf("err = %v", err)
+	}
+	// 关闭之后归还的连接直接关闭
+	p.Put(c1)
+	deadline = time.Now().Add(time.Second)
+	for !c1.isClosed() {
+		if time.Now().After(deadline) {
+			t.Fatal("returned conn not closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
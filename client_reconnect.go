@@ -0,0 +1,229 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+type ReconnectOption func(*ReconnectingDialer)
+
+// 断线自动重连的客户端
+// 连接断开之后, 按指数退避+随机抖动的间隔重新Dial, 新的连接继续使用原来的Callback
+type ReconnectingDialer struct {
+	rawUrl string
+	opts   []ClientOption
+	cb     Callback // 用户的callback
+
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+	factor      float64
+	onReconnect func(c *Conn) // 重连成功之后调用, 可以在这里重新订阅
+
+	mu     sync.Mutex
+	conn   *Conn
+	closed bool
+
+	closedEarly *Conn // 还没有保存到conn就断开的连接, 见takeIfClosed
+}
+
+// 1.配置退避时间, 第一次重连等待min, 之后每次乘以factor, 最多等待max
+func WithReconnectBackoff(min, max time.Duration, factor float64) ReconnectOption {
+	return func(r *ReconnectingDialer) {
+		r.minBackoff = min
+		r.maxBackoff = max
+		r.factor = factor
+	}
+}
+
+// 2.配置重连成功之后的回调
+func WithOnReconnect(f func(c *Conn)) ReconnectOption {
+	return func(r *ReconnectingDialer) {
+		r.onReconnect = f
+	}
+}
+
+// 3.配置Dial的参数
+func WithReconnectClientOption(opts ...ClientOption) ReconnectOption {
+	return func(r *ReconnectingDialer) {
+		r.opts = append(r.opts, opts...)
+	}
+}
+
+func NewReconnectingDialer(rawUrl string, opts ...ReconnectOption) *ReconnectingDialer {
+	r := &ReconnectingDialer{
+		rawUrl:     rawUrl,
+		minBackoff: 100 * time.Millisecond,
+		maxBackoff: 30 * time.Second,
+		factor:     2,
+	}
+	for _, o := range opts {
+		o(r)
+	}
+
+	if r.minBackoff <= 0 {
+		r.minBackoff = 100 * time.Millisecond
+	}
+	if r.maxBackoff < r.minBackoff {
+		r.maxBackoff = r.minBackoff
+	}
+	if r.factor < 1 {
+		r.factor = 1
+	}
+
+	// 取出用户配置的callback, Dial的时候换成自己, 用来感知连接断开
+	r.cb = ClientOptionToConf(r.opts...).Callback
+	r.opts = append(r.opts[:len(r.opts):len(r.opts)], WithClientCallback(r))
+	return r
+}
+
+// 建立第一个连接, 失败直接返回错误, 不会重试
+func (r *ReconnectingDialer) Dial() (*Conn, error) {
+	c, err := Dial(r.rawUrl, r.opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	r.setConn(c)
+	return c, nil
+}
+
+// 保存第一个连接, 已经断开的话开始重连
+func (r *ReconnectingDialer) setConn(c *Conn) {
+	r.mu.Lock()
+	r.conn = c
+	r.mu.Unlock()
+	if r.takeIfClosed(c) {
+		go r.reconnect()
+	}
+}
+
+// Dial返回之前连接就可能已经断开, 那时OnClose看到的r.conn还不是c, 不会发起重连, 只记在closedEarly里
+// 所以保存之后再检查一次, c已经断开的话从r.conn里取出来, 由调用方重连
+func (r *ReconnectingDialer) takeIfClosed(c *Conn) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closedEarly != c {
+		return false
+	}
+	r.closedEarly = nil
+	if r.conn == c {
+		r.conn = nil
+	}
+	return !r.closed
+}
+
+// 当前的连接, 正在重连的时候返回nil
+func (r *ReconnectingDialer) Conn() *Conn {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.conn
+}
+
+// 关闭当前的连接, 并且不再重连
+func (r *ReconnectingDialer) Close() {
+	r.mu.Lock()
+	r.closed = true
+	c := r.conn
+	r.conn = nil
+	r.mu.Unlock()
+
+	if c != nil {
+		c.Close()
+	}
+}
+
+func (r *ReconnectingDialer) isClosed() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.closed
+}
+
+// 第n次重连的等待时间, 一半固定一半随机, 避免大量客户端同时重连
+func (r *ReconnectingDialer) backoff(n int) time.Duration {
+	d := float64(r.minBackoff)
+	for i := 0; i < n && d < float64(r.maxBackoff); i++ {
+		d *= r.factor
+	}
+	if d > float64(r.maxBackoff) {
+		d = float64(r.maxBackoff)
+	}
+
+	half := d / 2
+	return time.Duration(half + rand.Float64()*half)
+}
+
+func (r *ReconnectingDialer) reconnect() {
+	for n := 0; ; n++ {
+		time.Sleep(r.backoff(n))
+		if r.isClosed() {
+			return
+		}
+
+		c, err := Dial(r.rawUrl, r.opts...)
+		if err != nil {
+			continue
+		}
+
+		r.mu.Lock()
+		if r.closed {
+			r.mu.Unlock()
+			c.Close()
+			return
+		}
+		r.conn = c
+		r.mu.Unlock()
+
+		if r.takeIfClosed(c) {
+			continue
+		}
+		if r.onReconnect != nil {
+			r.onReconnect(c)
+		}
+		return
+	}
+}
+
+func (r *ReconnectingDialer) OnOpen(c *Conn) {
+	r.cb.OnOpen(c)
+}
+
+func (r *ReconnectingDialer) OnMessage(c *Conn, op Opcode, data []byte) {
+	r.cb.OnMessage(c, op, data)
+}
+
+func (r *ReconnectingDialer) OnClose(c *Conn, err error) {
+	r.cb.OnClose(c, err)
+
+	// 当前连接断开才重连, 调用过Close之后不再重连
+	r.mu.Lock()
+	current := r.conn == c && !r.closed
+	if current {
+		r.conn = nil
+	} else if r.conn == nil {
+		// 可能是Dial刚建立, 还没有保存的连接
+		r.closedEarly = c
+	}
+	r.mu.Unlock()
+
+	if current {
+		go r.reconnect()
+	}
+}
@@ -0,0 +1,113 @@
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_ReconnectBackoff(t *testing.T) {
+	r := NewReconnectingDialer("ws://127.0.0.1:1", WithReconnectBackoff(100*time.Millisecond, time.Second, 2))
+	for n := 0; n < 10; n++ {
+		want := 100 * time.Millisecond << n
+		if want > time.Second {
+			want = time.Second
+		}
+
+		d := r.backoff(n)
+		if d < want/2 || d > want {
+			t.Fatalf("backoff(%d) = %v, want [%v, %v]", n, d, want/2, want)
+		}
+	}
+}
+
+// 回显的服务端, 每个升级成功的连接都放进conns
+func newReconnectTestServer(t *testing.T, m *MultiEventLoop, conns chan *Conn) string {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := Upgrade(w, r, WithServerMultiEventLoop(m))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conns <- c
+	}))
+	t.Cleanup(ts.Close)
+	return "ws" + strings.TrimPrefix(ts.URL, "http")
+}
+
+func newReconnectTestLoop(t *testing.T) *MultiEventLoop {
+	m := NewMultiEventLoopMust(WithEventLoops(1))
+	m.Start()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		m.Shutdown(ctx)
+	})
+	return m
+}
+
+// 服务端关闭连接之后, 客户端重连, Conn()换成新的连接
+func Test_ReconnectAfterServerClose(t *testing.T) {
+	m := newReconnectTestLoop(t)
+	conns := make(chan *Conn, 4)
+	u := newReconnectTestServer(t, m, conns)
+
+	reconnected := make(chan *Conn, 4)
+	r := NewReconnectingDialer(u,
+		WithReconnectBackoff(10*time.Millisecond, 50*time.Millisecond, 2),
+		WithOnReconnect(func(c *Conn) { reconnected <- c }),
+		WithReconnectClientOption(WithClientMultiEventLoop(m)))
+	defer r.Close()
+
+	first, err := r.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	(<-conns).Close()
+
+	select {
+	case c := <-reconnected:
+		if c == first || r.Conn() != c {
+			t.Fatalf("first = %p, reconnected = %p, Conn() = %p", first, c, r.Conn())
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("not reconnected")
+	}
+}
+
+// 连接在Dial返回之前就被关闭, OnClose的时候还没有保存到Conn(), 也要重连
+func Test_ReconnectClosedBeforeDialReturns(t *testing.T) {
+	m := newReconnectTestLoop(t)
+	conns := make(chan *Conn, 4)
+	u := newReconnectTestServer(t, m, conns)
+
+	reconnected := make(chan *Conn, 4)
+	r := NewReconnectingDialer(u,
+		WithReconnectBackoff(10*time.Millisecond, 50*time.Millisecond, 2),
+		WithOnReconnect(func(c *Conn) { reconnected <- c }),
+		WithReconnectClientOption(WithClientMultiEventLoop(m)))
+	defer r.Close()
+
+	// 相当于Dial里建立连接之后, 保存之前连接就断开了
+	c, err := Dial(u, r.opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	r.setConn(c)
+
+	select {
+	case nc := <-reconnected:
+		if nc == c || r.Conn() != nc {
+			t.Fatalf("reconnected = %p, Conn() = %p", nc, r.Conn())
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("not reconnected")
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// 握手返回3xx, 取出需要跳转的Location
+func redirectLocation(err error) (string, bool) {
+	var he *HandshakeError
+	if !errors.As(err, &he) {
+		return "", false
+	}
+
+	switch he.Response.StatusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+	default:
+		return "", false
+	}
+
+	loc := he.Response.Header.Get("Location")
+	return loc, loc != ""
+}
+
+// 跳转到Location, 握手时d.u已经被改成http/https, 这里再映射回ws/wss
+func (d *DialOption) redirect(loc string) error {
+	u, err := d.u.Parse(loc)
+	if err != nil {
+		return err
+	}
+
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	case "ws", "wss":
+	default:
+		return fmt.Errorf("%w: redirect only supports ws/wss/http/https, got %s", ErrUnknownScheme, u.Scheme)
+	}
+
+	// 和net/http一样, 跳到别的host不带上鉴权信息
+	if u.Host != d.u.Host {
+		d.authorization = ""
+	}
+	d.u = u
+	return nil
+}
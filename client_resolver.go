@@ -0,0 +1,96 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// 缓存条目超过这个数量时, 顺便清理一下过期的条目
+const dnsCacheSweepSize = 1024
+
+type dnsCacheKey struct {
+	r    *net.Resolver
+	host string
+}
+
+type dnsCacheEntry struct {
+	ips    []net.IPAddr
+	expire time.Time
+}
+
+// 所有Dial共享的dns缓存, 不同的resolver分开缓存
+var dnsCache = struct {
+	sync.Mutex
+	m map[dnsCacheKey]dnsCacheEntry
+}{m: make(map[dnsCacheKey]dnsCacheEntry)}
+
+func (d *DialOption) getResolver() *net.Resolver {
+	if d.resolver != nil {
+		return d.resolver
+	}
+	return net.DefaultResolver
+}
+
+// 解析域名, 配置了dnsCacheTTL时优先使用缓存
+func (d *DialOption) lookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	r := d.getResolver()
+	if d.dnsCacheTTL <= 0 {
+		return r.LookupIPAddr(ctx, host)
+	}
+
+	key := dnsCacheKey{r: r, host: host}
+	now := time.Now()
+	dnsCache.Lock()
+	e, ok := dnsCache.m[key]
+	dnsCache.Unlock()
+	if ok && now.Before(e.expire) {
+		return e.ips, nil
+	}
+
+	ips, err := r.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+
+	dnsCache.Lock()
+	if len(dnsCache.m) >= dnsCacheSweepSize {
+		for k, v := range dnsCache.m {
+			if !now.Before(v.expire) {
+				delete(dnsCache.m, k)
+			}
+		}
+	}
+	dnsCache.m[key] = dnsCacheEntry{ips: ips, expire: now.Add(d.dnsCacheTTL)}
+	dnsCache.Unlock()
+	return ips, nil
+}
+
+// 缓存的地址全部连不上, 删掉缓存, 下次重新解析
+func (d *DialOption) invalidateDNSCache(host string) {
+	if d.dnsCacheTTL <= 0 {
+		return
+	}
+
+	dnsCache.Lock()
+	delete(dnsCache.m, dnsCacheKey{r: d.getResolver(), host: host})
+	dnsCache.Unlock()
+}
@@ -0,0 +1,41 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package greatws
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Dial成功之后调用OnOpen, 和服务端一样
+func Test_ClientOnOpen(t *testing.T) {
+	m := NewMultiEventLoopMust(WithEventLoops(1))
+	m.Start()
+	t.Cleanup(func() { shutdownTestLoop(m) })
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Upgrade(w, r, WithServerMultiEventLoop(m))
+	}))
+	defer ts.Close()
+
+	opened := make(chan *Conn, 1)
+	c, err := Dial("ws"+strings.TrimPrefix(ts.URL, "http"), WithClientMultiEventLoop(m),
+		WithClientCallbackFunc(func(c *Conn) { opened <- c }, nil, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	select {
+	case got := <-opened:
+		if got != c {
+			t.Fatal("OnOpen got another conn")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("OnOpen not called")
+	}
+}
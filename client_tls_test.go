@@ -0,0 +1,171 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package greatws
+
+import (
+	"bufio"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func Test_ClientTLSOptions(t *testing.T) {
+	user := &tls.Config{ServerName: "example.com", NextProtos: []string{"h2"}}
+	cache := tls.NewLRUClientSessionCache(1)
+	d := ClientOptionToConf(
+		WithClientTLSConfig(user),
+		WithClientTLSSessionCache(cache),
+		WithClientTLSNextProtos("http/1.1"),
+		WithClientTLSVersion(tls.VersionTLS12, tls.VersionTLS13),
+	)
+
+	cfg := d.tlsClientConfig()
+	if cfg.ServerName != "example.com" || cfg.ClientSessionCache != cache ||
+		len(cfg.NextProtos) != 1 || cfg.NextProtos[0] != "http/1.1" ||
+		cfg.MinVersion != tls.VersionTLS12 || cfg.MaxVersion != tls.VersionTLS13 {
+		t.Fatalf("cfg = %+v", cfg)
+	}
+	// 不修改用户的tls.Config
+	if user.ClientSessionCache != nil || user.NextProtos[0] != "h2" || user.MinVersion != 0 {
+		t.Fatalf("user config modified: %+v", user)
+	}
+
+	if d := ClientOptionToConf(WithClientTLSSessionCache(nil)); d.tlsClientConfig().ClientSessionCache != defaultTLSSessionCache {
+		t.Fatal("nil cache should use the shared cache")
+	}
+	if ClientOptionToConf().customTLS() {
+		t.Fatal("no tls options")
+	}
+}
+
+func Test_ClientTLSSessionResumption(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	u, _ := url.Parse(ts.URL)
+	cache := tls.NewLRUClientSessionCache(1)
+	d := ClientOptionToConf(WithClientTLSConfig(ts.Client().Transport.(*http.Transport).TLSClientConfig),
+		WithClientTLSSessionCache(cache))
+	d.u = u
+	d.defaultTimeouts()
+
+	handshake := func() bool {
+		raw, err := net.Dial("tcp", u.Host)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer raw.Close()
+
+		c, err := d.tlsConn(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// tls 1.3的session ticket在握手之后发送, 读一次响应才能收到
+		req, _ := http.NewRequest("GET", ts.URL, nil)
+		if err := req.Write(c); err != nil {
+			t.Fatal(err)
+		}
+		rsp, err := http.ReadResponse(bufio.NewReader(c), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rsp.Body.Close()
+		return c.(*tls.Conn).ConnectionState().DidResume
+	}
+
+	if handshake() {
+		t.Fatal("first handshake resumed")
+	}
+	if !handshake() {
+		t.Fatal("second handshake not resumed")
+	}
+}
+
+// tls的回显服务端: 自己做tls握手和读请求, 再交给UpgradeConn(通过socketpair转发)
+// 每个连接tls握手的结果放进states
+func newTLSEchoServer(t *testing.T, m *MultiEventLoop, states chan tls.ConnectionState, opts ...ServerOption) string {
+	ts := httptest.NewUnstartedServer(nil)
+	ts.StartTLS()
+	cert := ts.TLS.Certificates[0]
+	ts.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	opts = append([]ServerOption{
+		WithServerMultiEventLoop(m),
+		WithServerOnMessageFunc(func(c *Conn, op Opcode, payload []byte) {
+			c.WriteMessage(op, payload)
+		}),
+	}, opts...)
+	go func() {
+		for {
+			raw, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				conn := tls.Server(raw, &tls.Config{Certificates: []tls.Certificate{cert}, NextProtos: []string{"http/1.1"}})
+				r, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil {
+					conn.Close()
+					return
+				}
+				if states != nil {
+					states <- conn.ConnectionState()
+				}
+				UpgradeConn(conn, r, opts...)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// wss的Dial: tls选项用在握手上, 握手之后通过socketpair转发, 可以正常收发
+func Test_ClientDialWSS(t *testing.T) {
+	m := NewMultiEventLoopMust(WithEventLoops(1))
+	m.Start()
+	t.Cleanup(func() { shutdownTestLoop(m) })
+
+	states := make(chan tls.ConnectionState, 1)
+	addr := newTLSEchoServer(t, m, states)
+
+	got := make(chan string, 1)
+	c, err := Dial("wss://"+addr+"/",
+		WithClientMultiEventLoop(m),
+		WithClientTLSConfig(&tls.Config{InsecureSkipVerify: true}),
+		WithClientTLSNextProtos("http/1.1"),
+		WithClientTLSVersion(tls.VersionTLS12, tls.VersionTLS12),
+		WithClientOnMessageFunc(func(c *Conn, op Opcode, payload []byte) {
+			got <- string(payload)
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	cs := <-states
+	if cs.NegotiatedProtocol != "http/1.1" || cs.Version != tls.VersionTLS12 {
+		t.Fatalf("alpn = %q, version = %x", cs.NegotiatedProtocol, cs.Version)
+	}
+
+	if err := c.WriteMessage(Text, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case s := <-got:
+		if s != "hello" {
+			t.Fatalf("got %q", s)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("echo timeout")
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// ws+unix:///path/to.sock:/endpoint, 通过unix域套接字连接本机的sidecar
+const unixScheme = "ws+unix"
+
+// socket的路径和请求的路径用第一个冒号分开, 没有请求路径时使用/
+// 解析之后d.u换成http://localhost/endpoint, Host头可以用WithClientHost修改
+// url里的socket路径优先于WithClientUnixSocket
+func (d *DialOption) parseUnixURL() error {
+	sock, endpoint, _ := strings.Cut(d.u.Path, ":")
+	// ws+unix://tmp/a.sock会把tmp当成host, 路径要用三个斜杠开头
+	if sock == "" || d.u.Host != "" {
+		return fmt.Errorf("%w: %s", ErrUnixSocketPath, d.u.String())
+	}
+	if endpoint == "" {
+		endpoint = "/"
+	}
+
+	d.unixSocket = sock
+	d.u = &url.URL{Scheme: "http", Host: "localhost", Path: endpoint, RawQuery: d.u.RawQuery}
+	return nil
+}
+
+func (d *DialOption) dialUnix(ctx context.Context) (net.Conn, error) {
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "unix", d.unixSocket)
+}
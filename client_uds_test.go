@@ -0,0 +1,104 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package greatws
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_ParseUnixURL(t *testing.T) {
+	for _, tc := range []struct {
+		raw, sock, url string
+	}{
+		{"ws+unix:///tmp/a.sock:/chat?id=1", "/tmp/a.sock", "http://localhost/chat?id=1"},
+		{"ws+unix:///tmp/a.sock", "/tmp/a.sock", "http://localhost/"},
+		{"ws+unix:///tmp/a.sock:", "/tmp/a.sock", "http://localhost/"},
+	} {
+		d := ClientOptionToConf()
+		d.Header = make(http.Header)
+		d.u, _ = url.Parse(tc.raw)
+		req, _, err := d.handshake()
+		if err != nil {
+			t.Fatalf("%s: %v", tc.raw, err)
+		}
+		if d.unixSocket != tc.sock || req.URL.String() != tc.url || req.Host != "localhost" {
+			t.Fatalf("%s: sock=%q, url=%q, host=%q", tc.raw, d.unixSocket, req.URL, req.Host)
+		}
+	}
+
+	for _, raw := range []string{"ws+unix://", "ws+unix://tmp/a.sock:/chat"} {
+		d := ClientOptionToConf()
+		d.Header = make(http.Header)
+		d.u, _ = url.Parse(raw)
+		if _, _, err := d.handshake(); !errors.Is(err, ErrUnixSocketPath) {
+			t.Fatalf("%s: err = %v", raw, err)
+		}
+	}
+}
+
+func Test_DialUnixSocket(t *testing.T) {
+	m := NewMultiEventLoopMust(WithEventLoops(1))
+	m.Start()
+
+	sock := filepath.Join(t.TempDir(), "ws.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Skip("unix socket:", err)
+	}
+	hosts := make(chan string, 2)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hosts <- r.Host + r.URL.Path
+		_, err := Upgrade(w, r, WithServerMultiEventLoop(m), WithServerOnMessageFunc(func(c *Conn, op Opcode, payload []byte) {
+			c.WriteMessage(op, payload)
+		}))
+		if err != nil {
+			t.Error(err)
+		}
+	})}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		name string
+		url  string
+		opts []ClientOption
+		host string
+	}{
+		{"url", "ws+unix://" + sock + ":/echo", []ClientOption{WithClientHost("sidecar.local")}, "sidecar.local/echo"},
+		{"option", "ws://example.com/chat", []ClientOption{WithClientUnixSocket(sock)}, "example.com/chat"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := make(chan string, 1)
+			opts := append([]ClientOption{WithClientMultiEventLoop(m), WithClientOnMessageFunc(func(c *Conn, op Opcode, payload []byte) {
+				got <- string(payload)
+			})}, tc.opts...)
+			c, err := Dial(tc.url, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+
+			if h := <-hosts; h != tc.host {
+				t.Fatalf("host = %q, want %q", h, tc.host)
+			}
+			if err := c.WriteMessage(Text, []byte("hello")); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case s := <-got:
+				if s != "hello" {
+					t.Fatalf("got %q", s)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("timeout")
+			}
+		})
+	}
+}
@@ -0,0 +1,292 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package greatws
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/antlabs/wsutil/bytespool"
+)
+
+type closeRecorder struct {
+	DefCallback
+	n    int32
+	err  atomic.Pointer[closeReason]
+	done chan struct{}
+}
+
+func (r *closeRecorder) OnClose(_ *Conn, err error) {
+	if atomic.AddInt32(&r.n, 1) == 1 {
+		r.err.Store(&closeReason{err: err})
+		close(r.done)
+	}
+}
+
+// 等OnClose, 再多等一会儿, 确认没有第二次
+func (r *closeRecorder) wait(t *testing.T) error {
+	t.Helper()
+	select {
+	case <-r.done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("OnClose not called")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt32(&r.n); n != 1 {
+		t.Fatalf("OnClose called %d times", n)
+	}
+	return r.err.Load().err
+}
+
+func newCloseRecorder() *closeRecorder {
+	return &closeRecorder{done: make(chan struct{})}
+}
+
+func Test_OnCloseOnce(t *testing.T) {
+	t.Run("eof", func(t *testing.T) {
+		r := newCloseRecorder()
+		_, remote := newTestConn(t, withTestCallback(r))
+		remote.Close()
+		if err := r.wait(t); !errors.Is(err, io.EOF) {
+			t.Fatalf("err = %v", err)
+		}
+	})
+
+	t.Run("write error", func(t *testing.T) {
+		r := newCloseRecorder()
+		c, remote := newTestConn(t, withTestCallback(r))
+		// 对端不再读, 本端写的时候出错, 但不会读到EOF
+		if err := remote.(*net.UnixConn).CloseRead(); err != nil {
+			t.Skip("CloseRead:", err)
+		}
+		for i := 0; i < 100 && atomic.LoadInt32(&r.n) == 0; i++ {
+			c.WriteMessage(Binary, make([]byte, 1024))
+			time.Sleep(time.Millisecond)
+		}
+		if err := r.wait(t); err == nil || errors.Is(err, io.EOF) {
+			t.Fatalf("err = %v", err)
+		}
+	})
+
+	t.Run("protocol error", func(t *testing.T) {
+		r := newCloseRecorder()
+		_, remote := newTestConn(t, withTestCallback(r))
+		// opcode 3是保留的
+		remote.Write([]byte{0x83, 0x80, 0x01, 0x02, 0x03, 0x04})
+		if err := r.wait(t); !errors.Is(err, ErrOpcode) {
+			t.Fatalf("err = %v", err)
+		}
+	})
+
+	t.Run("close race", func(t *testing.T) {
+		r := newCloseRecorder()
+		c, remote := newTestConn(t, withTestCallback(r))
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				c.Close()
+			}()
+		}
+		remote.Close()
+		wg.Wait()
+		r.wait(t)
+	})
+}
+
+func Test_ConnState(t *testing.T) {
+	t.Run("close sent", func(t *testing.T) {
+		r := newCloseRecorder()
+		c, _ := newTestConn(t, withTestCallback(r))
+		if c.State() != StateOpen {
+			t.Fatalf("state = %v", c.State())
+		}
+		if err := c.WriteClose(NormalClosure, ""); err != nil {
+			t.Fatal(err)
+		}
+		if c.State() != StateCloseSent {
+			t.Fatalf("state = %v", c.State())
+		}
+		if err := c.WriteMessage(Text, []byte("a")); err != ErrWriteClosed {
+			t.Fatalf("WriteMessage err = %v", err)
+		}
+		if err := c.WriteControl(Ping, nil, time.Time{}); err != ErrWriteClosed {
+			t.Fatalf("WriteControl err = %v", err)
+		}
+
+		// 对端没有回应, closeLinger之后关闭
+		if err := r.wait(t); err != ErrCloseTimeout {
+			t.Fatalf("err = %v", err)
+		}
+		if !c.IsClosed() || c.State() != StateClosed {
+			t.Fatalf("state = %v", c.State())
+		}
+		if err := c.WriteMessage(Text, []byte("a")); err != ErrClosed {
+			t.Fatalf("WriteMessage err = %v", err)
+		}
+	})
+
+	// WriteControl和WriteMessage发送close帧也要等closeLinger, 不能一直停在StateCloseSent
+	for _, tc := range []struct {
+		name  string
+		write func(c *Conn) error
+	}{
+		{"write control", func(c *Conn) error {
+			return c.WriteControl(Close, FormatCloseMessage(NormalClosure, ""), time.Time{})
+		}},
+		{"write message", func(c *Conn) error {
+			return c.WriteMessage(Close, FormatCloseMessage(NormalClosure, ""))
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := newCloseRecorder()
+			c, _ := newTestConn(t, withTestCallback(r))
+			if err := tc.write(c); err != nil {
+				t.Fatal(err)
+			}
+			if c.State() != StateCloseSent {
+				t.Fatalf("state = %v", c.State())
+			}
+			if err := r.wait(t); err != ErrCloseTimeout {
+				t.Fatalf("err = %v", err)
+			}
+		})
+	}
+
+	t.Run("close received", func(t *testing.T) {
+		r := newCloseRecorder()
+		c, remote := newTestConn(t, withTestCallback(r))
+		// 对端发送close帧, 1000, 带掩码
+		remote.Write([]byte{0x88, 0x82, 0, 0, 0, 0, 0x03, 0xe8})
+		err := r.wait(t)
+		var ce *CloseErrMsg
+		if !errors.As(err, &ce) || ce.Code != NormalClosure {
+			t.Fatalf("err = %v", err)
+		}
+		if c.State() != StateClosed {
+			t.Fatalf("state = %v", c.State())
+		}
+
+		// 本端回应的close帧
+		buf := make([]byte, 4)
+		remote.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := io.ReadFull(remote, buf); err != nil || buf[0] != 0x88 {
+			t.Fatalf("reply = %x, %v", buf, err)
+		}
+	})
+}
+
+func Test_UnmaskedClientFrame(t *testing.T) {
+	t.Run("strict", func(t *testing.T) {
+		r := newCloseRecorder()
+		_, remote := newTestConn(t, withTestCallback(r))
+		remote.Write([]byte{0x82, 0x01, 'x'})
+		if err := r.wait(t); !errors.Is(err, ErrUnmaskedFrame) {
+			t.Fatalf("err = %v", err)
+		}
+
+		remote.SetReadDeadline(time.Now().Add(time.Second))
+		head := make([]byte, 4)
+		if _, err := io.ReadFull(remote, head); err != nil {
+			t.Fatal(err)
+		}
+		if head[0] != 0x88 || binary.BigEndian.Uint16(head[2:]) != uint16(ProtocolError) {
+			t.Fatalf("close frame = % x", head)
+		}
+	})
+
+	t.Run("allow", func(t *testing.T) {
+		c := newFuzzParseConn([]byte{0x82, 0x01, 'x'})
+		defer func() { bytespool.PutBytes(c.rbuf.buf) }()
+		c.allowUnmasked = true
+
+		var got []byte
+		c.Callback = OnMessageFunc(func(_ *Conn, _ Opcode, payload []byte) {
+			got = append(got, payload...)
+		})
+		if ok, err := c.readHeader(); !ok || err != nil {
+			t.Fatalf("readHeader: %t, %v", ok, err)
+		}
+		f, ok, err := c.readPayload()
+		if !ok || err != nil {
+			t.Fatalf("readPayload: %t, %v", ok, err)
+		}
+		if err := c.processCallback(f); err != nil || string(got) != "x" {
+			t.Fatalf("err = %v, got = %q", err, got)
+		}
+	})
+}
+
+func Test_CloseReasonTooLong(t *testing.T) {
+	reason := strings.Repeat("a", 200)
+
+	t.Run("truncate", func(t *testing.T) {
+		c, remote := newTestConn(t)
+		if err := c.WriteClose(NormalClosure, reason); err != nil {
+			t.Fatal(err)
+		}
+
+		remote.SetReadDeadline(time.Now().Add(time.Second))
+		head := make([]byte, 2)
+		if _, err := io.ReadFull(remote, head); err != nil {
+			t.Fatal(err)
+		}
+		if head[0] != 0x88 || head[1] != 2+maxCloseReasonSize {
+			t.Fatalf("close frame = % x", head)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		c, _ := newTestConn(t)
+		c.closeReasonStrict = true
+		if err := c.WriteClose(NormalClosure, reason); !errors.Is(err, ErrCloseReasonTooLong) {
+			t.Fatalf("err = %v", err)
+		}
+		if c.State() != StateOpen {
+			t.Fatalf("state = %v", c.State())
+		}
+	})
+}
+
+func Test_StatsInOnClose(t *testing.T) {
+	c, remote := newTestConn(t)
+	stats := make(chan ConnStats, 1)
+	c.Callback = OnCloseFunc(func(c *Conn, _ error) {
+		stats <- c.Stats()
+	})
+
+	if err := c.WriteMessage(Binary, []byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	remote.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(remote, make([]byte, 7)); err != nil {
+		t.Fatal(err)
+	}
+	// 空的binary帧, 带mask
+	remote.Write([]byte{0x82, 0x80, 0x01, 0x02, 0x03, 0x04})
+	remote.Close()
+
+	select {
+	case st := <-stats:
+		if st.BytesRead != 6 || st.BytesWritten != 7 {
+			t.Fatalf("read = %d, written = %d", st.BytesRead, st.BytesWritten)
+		}
+		if st.ClosedAt.IsZero() || st.Duration != st.ClosedAt.Sub(st.OpenedAt) {
+			t.Fatalf("stats = %+v", st)
+		}
+		if after := c.Stats(); after != st {
+			t.Fatalf("stats changed after OnClose: %+v", after)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("OnClose not called")
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+// greatws-bench-client 压测echo服务, 每个连接发送一条消息, 收到回复之后再发下一条
+// 每秒打印一次qps和平均延迟
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/antlabs/greatws"
+)
+
+type stats struct {
+	msgs    int64 // 收到的回复数
+	latency int64 // 延迟总和, 纳秒
+	errs    int64 // 回复内容不对或者写失败
+	closed  int64 // 关闭的连接数
+}
+
+type benchConn struct {
+	*stats
+	payload []byte
+	sentAt  int64
+}
+
+func (b *benchConn) send(c *greatws.Conn) {
+	atomic.StoreInt64(&b.sentAt, time.Now().UnixNano())
+	if err := c.WriteMessage(greatws.Binary, b.payload); err != nil {
+		atomic.AddInt64(&b.errs, 1)
+	}
+}
+
+func (b *benchConn) OnOpen(c *greatws.Conn) {}
+
+func (b *benchConn) OnMessage(c *greatws.Conn, op greatws.Opcode, msg []byte) {
+	atomic.AddInt64(&b.latency, time.Now().UnixNano()-atomic.LoadInt64(&b.sentAt))
+	atomic.AddInt64(&b.msgs, 1)
+	if !bytes.Equal(msg, b.payload) {
+		atomic.AddInt64(&b.errs, 1)
+	}
+	b.send(c)
+}
+
+func (b *benchConn) OnClose(c *greatws.Conn, err error) {
+	atomic.AddInt64(&b.closed, 1)
+}
+
+func main() {
+	url := flag.String("url", "ws://127.0.0.1:9001/", "echo server url")
+	conns := flag.Int("conns", 100, "number of connections")
+	size := flag.Int("size", 1024, "message size in bytes")
+	duration := flag.Duration("duration", 10*time.Second, "how long to run")
+	loops := flag.Int("loops", runtime.NumCPU()/2, "number of event loops")
+	ioUring := flag.Bool("iouring", false, "use io_uring instead of epoll (linux only)")
+	compress := flag.Bool("compress", false, "enable permessage-deflate")
+	flag.Parse()
+
+	opts := []greatws.EvOption{
+		greatws.WithEventLoops(*loops),
+		greatws.WithBusinessGoNum(50, 10, 10000),
+		greatws.WithMaxEventNum(1000),
+	}
+	if *ioUring {
+		opts = append(opts, greatws.WithIoUring())
+	}
+
+	m := greatws.NewMultiEventLoopMust(opts...)
+	m.Start()
+
+	var st stats
+	payload := bytes.Repeat([]byte("a"), *size)
+	for i := 0; i < *conns; i++ {
+		b := &benchConn{stats: &st, payload: payload}
+		clientOpts := []greatws.ClientOption{
+			greatws.WithClientCallback(b),
+			greatws.WithClientMultiEventLoop(m),
+		}
+		if *compress {
+			clientOpts = append(clientOpts, greatws.WithClientDecompressAndCompress())
+		}
+
+		c, err := greatws.Dial(*url, clientOpts...)
+		if err != nil {
+			log.Fatalf("dial %d fail: %v", i, err)
+		}
+		b.send(c)
+	}
+	log.Printf("greatws-bench-client %d conns, %d bytes, api:%s", *conns, *size, m.GetApiName())
+
+	var lastMsgs, lastLatency int64
+	end := time.After(*duration)
+	tick := time.NewTicker(time.Second)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			msgs, latency := atomic.LoadInt64(&st.msgs), atomic.LoadInt64(&st.latency)
+			n := msgs - lastMsgs
+			var avg time.Duration
+			if n > 0 {
+				avg = time.Duration((latency - lastLatency) / n)
+			}
+			fmt.Printf("qps:%d, avg latency:%v, errs:%d, closed:%d\n", n, avg, atomic.LoadInt64(&st.errs), atomic.LoadInt64(&st.closed))
+			lastMsgs, lastLatency = msgs, latency
+		case <-end:
+			total := atomic.LoadInt64(&st.msgs)
+			fmt.Printf("total:%d, avg qps:%.0f\n", total, float64(total)/duration.Seconds())
+			return
+		}
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+// greatws-echo 收到什么消息就回什么消息, 用来验证部署, 对比epoll和io_uring, 复现问题
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"time"
+
+	"github.com/antlabs/greatws"
+)
+
+type echoHandler struct {
+	quiet bool
+}
+
+func (e *echoHandler) OnOpen(c *greatws.Conn) {}
+
+func (e *echoHandler) OnMessage(c *greatws.Conn, op greatws.Opcode, msg []byte) {
+	if err := c.WriteMessage(op, msg); err != nil {
+		slog.Error("write fail", "err", err.Error())
+	}
+}
+
+func (e *echoHandler) OnClose(c *greatws.Conn, err error) {
+	if !e.quiet && err != nil {
+		slog.Info("close", "err", err.Error())
+	}
+}
+
+func main() {
+	addr := flag.String("addr", ":9001", "listen address")
+	path := flag.String("path", "/", "websocket path")
+	loops := flag.Int("loops", runtime.NumCPU()/2, "number of event loops")
+	ioUring := flag.Bool("iouring", false, "use io_uring instead of epoll (linux only)")
+	compress := flag.Bool("compress", false, "enable permessage-deflate")
+	utf8 := flag.Bool("utf8", false, "check text messages are valid utf8")
+	stat := flag.Duration("stat", 0, "print conn/task count every interval, 0 disables")
+	quiet := flag.Bool("quiet", true, "do not log conn close")
+	flag.Parse()
+
+	opts := []greatws.EvOption{
+		greatws.WithEventLoops(*loops),
+		greatws.WithBusinessGoNum(50, 10, 10000),
+		greatws.WithMaxEventNum(1000),
+	}
+	if *ioUring {
+		opts = append(opts, greatws.WithIoUring())
+	}
+
+	m := greatws.NewMultiEventLoopMust(opts...)
+	m.Start()
+	log.Printf("greatws-echo listen %s%s, api:%s", *addr, *path, m.GetApiName())
+
+	if *stat > 0 {
+		go func() {
+			for range time.Tick(*stat) {
+				fmt.Printf("curConn:%d, curTask:%d\n", m.GetCurConnNum(), m.GetCurTaskNum())
+			}
+		}()
+	}
+
+	serverOpts := []greatws.ServerOption{
+		greatws.WithServerReplyPing(),
+		greatws.WithServerIgnorePong(),
+		greatws.WithServerCallback(&echoHandler{quiet: *quiet}),
+		greatws.WithServerMultiEventLoop(m),
+	}
+	if *compress {
+		serverOpts = append(serverOpts, greatws.WithServerDecompressAndCompress())
+	}
+	if *utf8 {
+		serverOpts = append(serverOpts, greatws.WithServerEnableUTF8Check())
+	}
+	upgrader := greatws.NewUpgrade(serverOpts...)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(*path, func(w http.ResponseWriter, r *http.Request) {
+		if _, err := upgrader.Upgrade(w, r); err != nil {
+			slog.Error("upgrade fail", "err", err.Error())
+		}
+	})
+	log.Fatal(http.ListenAndServe(*addr, mux))
+}
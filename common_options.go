@@ -11,9 +11,13 @@
 // WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
 // See the License for the specific language governing permissions and
 // limitations under the License.
+//go:build !js
+// +build !js
+
 package greatws
 
 import (
+	"log/slog"
 	"time"
 	"unicode/utf8"
 )
@@ -131,6 +135,7 @@ func WithServerIgnorePong() ServerOption {
 // 设置几倍payload的缓冲区
 // 只有解析方式是窗口的时候才有效
 // 如果为1.0就是1024 + 14， 如果是2.0就是2048 + 14
+// 配置了WithServerReadBufferSize之后, 初始大小以它为准, 这里只影响读缓冲区扩容的大小
 func WithServerWindowsMultipleTimesPayloadSize(mt float32) ServerOption {
 	return func(o *ConnOption) {
 		if mt < 1.0 {
@@ -280,9 +285,392 @@ func WithClientOnCloseFunc(onClose func(c *Conn, err error)) ClientOption {
 	}
 }
 
+// 18. 配置关闭握手的等待时间
+// 发送close帧之后, 继续读取数据直到收到对端的close帧或者超时, 为0时发送完close帧直接关闭
+// 18.1 配置服务端关闭握手的等待时间
+func WithServerCloseLinger(t time.Duration) ServerOption {
+	return func(o *ConnOption) {
+		o.closeLinger = t
+	}
+}
+
+// 18.2 配置客户端关闭握手的等待时间
+func WithClientCloseLinger(t time.Duration) ClientOption {
+	return func(o *DialOption) {
+		o.closeLinger = t
+	}
+}
+
+// 19. 配置帧追踪, 收发的每一帧都会调用f, 方便调试和审计, 不需要抓包和tls密钥
+// 19.1 配置服务端的帧追踪
+func WithServerFrameTracer(f FrameTracer) ServerOption {
+	return func(o *ConnOption) {
+		o.frameTracer = f
+	}
+}
+
+// 19.2 配置客户端的帧追踪
+func WithClientFrameTracer(f FrameTracer) ClientOption {
+	return func(o *DialOption) {
+		o.frameTracer = f
+	}
+}
+
+// 20. 配置连接使用的日志, 不配置使用MultiEventLoop的日志
+// 20.1 配置服务端连接的日志
+func WithServerLogger(l *slog.Logger) ServerOption {
+	return func(o *ConnOption) {
+		o.logger = l
+	}
+}
+
+// 20.2 配置客户端连接的日志
+func WithClientLogger(l *slog.Logger) ClientOption {
+	return func(o *DialOption) {
+		o.logger = l
+	}
+}
+
+// 21. 配置中间件, 可以多次调用, 按添加的顺序从外到内包装Callback
+// 21.1 配置服务端的中间件
+func WithServerMiddleware(mws ...Middleware) ServerOption {
+	return func(o *ConnOption) {
+		o.middlewares = append(o.middlewares, mws...)
+	}
+}
+
+// 21.2 配置客户端的中间件
+func WithClientMiddleware(mws ...Middleware) ClientOption {
+	return func(o *DialOption) {
+		o.middlewares = append(o.middlewares, mws...)
+	}
+}
+
+// 22. 配置关闭码的校验, 收到和发送的close帧都会检查, 默认DefaultCloseCodeValidator
+// 22.1 配置服务端的关闭码校验
+func WithServerCloseCodeValidator(v *CloseCodeValidator) ServerOption {
+	return func(o *ConnOption) {
+		o.closeCodeValidator = v
+	}
+}
+
+// 22.2 配置客户端的关闭码校验
+func WithClientCloseCodeValidator(v *CloseCodeValidator) ClientOption {
+	return func(o *DialOption) {
+		o.closeCodeValidator = v
+	}
+}
+
+// 23. 配置读缓冲区的扩容策略, 默认ReadBufferGrowthExact
+// 小包多选Exact, 大小变化频繁选Double, 连接多又偶尔有大包选Fixed
+// 23.1 配置服务端读缓冲区的扩容策略
+func WithServerReadBufferGrowth(strategy ReadBufferGrowth) ServerOption {
+	return func(o *ConnOption) {
+		o.readBufferGrowth = strategy
+	}
+}
+
+// 23.2 配置客户端读缓冲区的扩容策略
+func WithClientReadBufferGrowth(strategy ReadBufferGrowth) ClientOption {
+	return func(o *DialOption) {
+		o.readBufferGrowth = strategy
+	}
+}
+
+// 24. 配置socket选项, 拿到fd之后马上设置
+// 24.1 配置服务端的TCP keepalive, idle, interval, count为0时使用系统默认值
+func WithServerTCPKeepAlive(idle, interval time.Duration, count int) ServerOption {
+	return func(o *ConnOption) {
+		o.socketOptions.keepAlive = true
+		o.socketOptions.keepAliveIdle = idle
+		o.socketOptions.keepAliveInterval = interval
+		o.socketOptions.keepAliveCount = count
+	}
+}
+
+// 24.2 配置客户端的TCP keepalive, idle, interval, count为0时使用系统默认值
+func WithClientTCPKeepAlive(idle, interval time.Duration, count int) ClientOption {
+	return func(o *DialOption) {
+		o.socketOptions.keepAlive = true
+		o.socketOptions.keepAliveIdle = idle
+		o.socketOptions.keepAliveInterval = interval
+		o.socketOptions.keepAliveCount = count
+	}
+}
+
+// 24.3 配置服务端socket的发送和接收缓冲区大小(SO_SNDBUF, SO_RCVBUF), 0表示不修改
+func WithServerSocketBufferSize(sndBuf, rcvBuf int) ServerOption {
+	return func(o *ConnOption) {
+		o.socketOptions.sendBufferSize = sndBuf
+		o.socketOptions.recvBufferSize = rcvBuf
+	}
+}
+
+// 24.4 配置客户端socket的发送和接收缓冲区大小(SO_SNDBUF, SO_RCVBUF), 0表示不修改
+func WithClientSocketBufferSize(sndBuf, rcvBuf int) ClientOption {
+	return func(o *DialOption) {
+		o.socketOptions.sendBufferSize = sndBuf
+		o.socketOptions.recvBufferSize = rcvBuf
+	}
+}
+
+// 24.5 服务端开启TCP_QUICKACK, 只有linux有效
+func WithServerTCPQuickAck() ServerOption {
+	return func(o *ConnOption) {
+		o.socketOptions.quickAck = true
+	}
+}
+
+// 24.6 客户端开启TCP_QUICKACK, 只有linux有效
+func WithClientTCPQuickAck() ClientOption {
+	return func(o *DialOption) {
+		o.socketOptions.quickAck = true
+	}
+}
+
+// 24.7 配置服务端的SO_LINGER, 0表示close的时候丢弃未发送的数据, 直接发送RST
+func WithServerSoLinger(d time.Duration) ServerOption {
+	return func(o *ConnOption) {
+		o.socketOptions.lingerSet = true
+		o.socketOptions.linger = d
+	}
+}
+
+// 24.8 配置客户端的SO_LINGER, 0表示close的时候丢弃未发送的数据, 直接发送RST
+func WithClientSoLinger(d time.Duration) ClientOption {
+	return func(o *DialOption) {
+		o.socketOptions.lingerSet = true
+		o.socketOptions.linger = d
+	}
+}
+
+// 25. 允许对端保留压缩上下文(context takeover), 压缩率更高
+// maxWindowBits(8~15)是愿意给每个连接分配的最大窗口, 每个连接保存2^maxWindowBits字节的解压字典
+// 窗口越小越省内存, 比如10只需要1KB, 默认不允许对端保留上下文
+// 25.1 服务端在握手回应里通过client_max_window_bits告诉客户端
+func WithServerContextTakeover(maxWindowBits int) ServerOption {
+	return func(o *ConnOption) {
+		o.takeoverWindowBits = clampWindowBits(maxWindowBits)
+	}
+}
+
+// 25.2 客户端在握手请求里通过server_max_window_bits告诉服务端
+func WithClientContextTakeover(maxWindowBits int) ClientOption {
+	return func(o *DialOption) {
+		o.takeoverWindowBits = clampWindowBits(maxWindowBits)
+	}
+}
+
+// 26. 校验pong是否原样带回了ping的payload, 不一致时调用f
+// 开启之后心跳的ping会带上发送时间作为payload
+// 26.1 服务端校验pong
+func WithServerPongVerify(f PongMismatchFunc) ServerOption {
+	return func(o *ConnOption) {
+		o.pongMismatch = f
+	}
+}
+
+// 26.2 客户端校验pong
+func WithClientPongVerify(f PongMismatchFunc) ClientOption {
+	return func(o *DialOption) {
+		o.pongMismatch = f
+	}
+}
+
+// 27. 每隔interval在业务go程里调用一次f, 由时间轮驱动
+// 可以用来实现自定义的心跳, 定时重置配额, 定时flush等, 精度是时间轮的间隔
+// 27.1 配置服务端的OnTick
+func WithServerOnTick(interval time.Duration, f func(c *Conn)) ServerOption {
+	return func(o *ConnOption) {
+		o.tickInterval = interval
+		o.onTick = f
+	}
+}
+
+// 27.2 配置客户端的OnTick
+func WithClientOnTick(interval time.Duration, f func(c *Conn)) ClientOption {
+	return func(o *DialOption) {
+		o.tickInterval = interval
+		o.onTick = f
+	}
+}
+
+// 28. 按帧接收数据, 数据帧不再合并和解压缩, 也不再调用OnMessage, 详见FrameCallback
+// Callback实现了FrameCallback接口的话, 不需要单独配置
+// 28.1 配置服务端的OnFrame
+func WithServerOnFrameFunc(f OnFrameFunc) ServerOption {
+	return func(o *ConnOption) {
+		o.onFrame = f
+	}
+}
+
+// 28.2 配置客户端的OnFrame
+func WithClientOnFrameFunc(f OnFrameFunc) ClientOption {
+	return func(o *DialOption) {
+		o.onFrame = f
+	}
+}
+
+// 29. close帧的reason超过123字节时, WriteClose返回ErrCloseReasonTooLong, 不发送close帧
+// 默认按utf8字符截断到123字节. 库内部因为出错发送的close帧总是截断
+// 29.1 配置服务端
+func WithServerCloseReasonError() ServerOption {
+	return func(o *ConnOption) {
+		o.closeReasonStrict = true
+	}
+}
+
+// 29.2 配置客户端
+func WithClientCloseReasonError() ClientOption {
+	return func(o *DialOption) {
+		o.closeReasonStrict = true
+	}
+}
+
+// 30. 读缓冲区的初始大小, 单位字节, 每个连接创建时分配
+// 默认是(1024+14)*windowsMultipleTimesPayloadSize, 配置之后直接使用n, 不再乘以windowsMultipleTimesPayloadSize
+// 一个frame比读缓冲区大的时候会按ReadBufferGrowth扩容, ReadBufferGrowthExact扩容时仍然乘以windowsMultipleTimesPayloadSize
+// 想让每个连接的读缓冲区固定为n, 搭配WithServerReadBufferGrowth(ReadBufferGrowthFixed)使用
+// 30.1 配置服务端
+func WithServerReadBufferSize(n int) ServerOption {
+	return func(o *ConnOption) {
+		o.readBufferSize = n
+	}
+}
+
+// 30.2 配置客户端
+func WithClientReadBufferSize(n int) ClientOption {
+	return func(o *DialOption) {
+		o.readBufferSize = n
+	}
+}
+
+// 31. 写缓冲区最多积压的字节数, 对端读得慢时, 内核写不进去的数据放在写缓冲区里
+// 超过n之后WriteMessage等发送数据帧的函数返回ErrWriteBufferFull, 连接不关闭, 可以稍后重试
+// 写缓冲区为空时单帧不受限制, 控制帧不受限制. 默认为0, 不限制
+// 31.1 配置服务端
+func WithServerWriteBufferSize(n int) ServerOption {
+	return func(o *ConnOption) {
+		o.writeBufferSize = n
+	}
+}
+
+// 31.2 配置客户端
+func WithClientWriteBufferSize(n int) ClientOption {
+	return func(o *DialOption) {
+		o.writeBufferSize = n
+	}
+}
+
+// 32. 收到带rsv2/rsv3的帧时, 清掉这两位之后照常处理, 不再关闭连接
+// greatws没有实现使用rsv2/rsv3的扩展, 默认按rfc 6455 5.2回1002关闭连接.
+// 对端违反协议乱设保留位, 但payload是正常的时候, 打开这个选项互通. rsv1仍然按压缩协商的结果检查
+// 32.1 配置服务端
+func WithServerStripReservedBits() ServerOption {
+	return func(o *ConnOption) {
+		o.stripRsv23 = true
+	}
+}
+
+// 32.2 配置客户端
+func WithClientStripReservedBits() ClientOption {
+	return func(o *DialOption) {
+		o.stripRsv23 = true
+	}
+}
+
+// 33. 写缓冲区里积压的数据消息超过limit条时, 按p处理新发送的消息, 0表示不限制
+// 运行时可以用Conn.SetDropPolicy单独修改某个连接, 详细的说明见SetDropPolicy
+// 33.1 配置服务端
+func WithServerDropPolicy(limit int, p DropPolicy) ServerOption {
+	return func(o *ConnOption) {
+		o.maxPendingMsgs = limit
+		o.dropPolicy = p
+	}
+}
+
+// 33.2 配置客户端
+func WithClientDropPolicy(limit int, p DropPolicy) ClientOption {
+	return func(o *DialOption) {
+		o.maxPendingMsgs = limit
+		o.dropPolicy = p
+	}
+}
+
+// 34. 一条压缩消息解压之后最多n字节, 超过时回1009(TooBigMessage)关闭连接, OnClose收到ErrDecompressTooLarge
+// 防止对端发送高压缩比的数据(压缩炸弹)把内存撑爆, 解压时最多只多读1个字节. 默认64MB, n <= 0表示不限制
+// 34.1 配置服务端
+func WithServerMaxDecompressedSize(n int64) ServerOption {
+	return func(o *ConnOption) {
+		o.maxDecompressedSize = n
+	}
+}
+
+// 34.2 配置客户端
+func WithClientMaxDecompressedSize(n int64) ClientOption {
+	return func(o *DialOption) {
+		o.maxDecompressedSize = n
+	}
+}
+
+// 35. 压缩之后不小于n字节的消息, 交给业务go程池解压, 解压完再回到事件循环分发, 0表示不开启(默认)
+// 一个连接发送几十MB的压缩数据时, 解压不会卡住同一个事件循环上的其他连接
+// 解压期间这个连接暂停解析后面的帧, 消息的顺序不变. io_uring模式下不生效
+// 35.1 配置服务端
+func WithServerDecompressOffload(n int) ServerOption {
+	return func(o *ConnOption) {
+		o.decodeOffloadSize = n
+	}
+}
+
+// 35.2 配置客户端
+func WithClientDecompressOffload(n int) ClientOption {
+	return func(o *DialOption) {
+		o.decodeOffloadSize = n
+	}
+}
+
+// 36. OnMessage拿到的payload是一份拷贝, 归回调所有, 返回之后可以继续持有(放进channel, 交给别的go程)
+// 默认payload是借给回调的, OnMessage返回之后库会回收复用, 每条消息多一次分配和拷贝, 详见payloadMode
+// 36.1 配置服务端
+func WithServerCopyPayload() ServerOption {
+	return func(o *ConnOption) {
+		o.copyPayload = true
+	}
+}
+
+// 36.2 配置客户端
+func WithClientCopyPayload() ClientOption {
+	return func(o *DialOption) {
+		o.copyPayload = true
+	}
+}
+
+// 37. 调试用, OnMessage返回之后把payload填满0xdb再回收
+// OnMessage返回之后还在读payload的代码会读到一串0xdb, 尽早暴露这类bug, 线上不要打开
+// 37.1 配置服务端
+func WithServerPoisonPayload() ServerOption {
+	return func(o *ConnOption) {
+		o.poisonPayload = true
+	}
+}
+
+// 37.2 配置客户端
+func WithClientPoisonPayload() ClientOption {
+	return func(o *DialOption) {
+		o.poisonPayload = true
+	}
+}
+
 // last 配置event
 func WithServerMultiEventLoop(m *MultiEventLoop) ServerOption {
 	return func(o *ConnOption) {
 		o.multiEventLoop = m
 	}
 }
+
+func WithClientMultiEventLoop(m *MultiEventLoop) ClientOption {
+	return func(o *DialOption) {
+		o.multiEventLoop = m
+	}
+}
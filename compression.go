@@ -2,6 +2,9 @@
 // Use of this source code is governed by a BSD-style
 // license that can be found in the LICENSE file.
 
+//go:build !js
+// +build !js
+
 package greatws
 
 import (
@@ -26,6 +29,11 @@ var (
 )
 
 func decompressNoContextTakeover(r io.Reader) io.ReadCloser {
+	return decompressWithDict(r, nil)
+}
+
+// 对端保留上下文时, dict是之前消息解压出来的最后一个窗口的数据
+func decompressWithDict(r io.Reader, dict []byte) io.ReadCloser {
 	const tail =
 	// Add four bytes as specified in RFC
 	"\x00\x00\xff\xff" +
@@ -33,7 +41,7 @@ func decompressNoContextTakeover(r io.Reader) io.ReadCloser {
 		"\x01\x00\x00\xff\xff"
 
 	fr, _ := flateReaderPool.Get().(io.ReadCloser)
-	fr.(flate.Resetter).Reset(io.MultiReader(r, strings.NewReader(tail)), nil)
+	fr.(flate.Resetter).Reset(io.MultiReader(r, strings.NewReader(tail)), dict)
 	return &flateReadWrapper{fr}
 }
 
@@ -0,0 +1,93 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package greatws
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func Test_WriteMessageCompressed(t *testing.T) {
+	c, peer := newFuzzWriteConn(t)
+	defer unix.Close(peer)
+	c.compression = true
+
+	readHead := func() byte {
+		t.Helper()
+		buf := make([]byte, 256)
+		for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+			if n, _ := unix.Read(peer, buf); n > 0 {
+				return buf[0]
+			}
+		}
+		t.Fatal("no frame")
+		return 0
+	}
+
+	payload := []byte("already compressed data")
+	if err := c.WriteMessageCompressed(Binary, payload, false); err != nil {
+		t.Fatal(err)
+	}
+	if h := readHead(); h != 0x82 {
+		t.Fatalf("head = %x, want no rsv1", h)
+	}
+
+	if err := c.WriteMessage(Binary, payload); err != nil {
+		t.Fatal(err)
+	}
+	if h := readHead(); h != 0xc2 {
+		t.Fatalf("head = %x, want rsv1", h)
+	}
+}
+
+// 压缩炸弹: 64KB的0压缩之后只有几十字节, 解压时只读到limit+1字节就停下
+func Test_MaxDecompressedSize(t *testing.T) {
+	out := getWrapBuffer()
+	defer putWrapBuffer(out)
+	w := compressNoContextTakeover(out, 9)
+	w.Write(make([]byte, 64<<10))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	bomb := out.String()
+
+	if _, err := decode([]byte(bomb), nil, 1024); err != ErrDecompressTooLarge {
+		t.Fatalf("err = %v", err)
+	}
+	if got, err := decode([]byte(bomb), nil, 64<<10); err != nil || len(got) != 64<<10 {
+		t.Fatalf("len = %d, err = %v", len(got), err)
+	}
+	if got, err := decode([]byte(bomb), nil, 0); err != nil || len(got) != 64<<10 {
+		t.Fatalf("unlimited: len = %d, err = %v", len(got), err)
+	}
+
+	// 超过限制回1009关闭连接
+	var wire bytes.Buffer
+	appendClientFrame(t, &wire, true, true, Binary, bomb)
+	c, peer, got := newFragmentTestConn(t, wire.Bytes())
+	c.maxDecompressedSize = 1024
+	if err := processAllFrames(c); !errors.Is(err, ErrDecompressTooLarge) {
+		t.Fatalf("err = %v", err)
+	}
+	if len(*got) != 0 {
+		t.Fatalf("got = %v", *got)
+	}
+	buf := make([]byte, 64)
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		n, _ := unix.Read(peer, buf)
+		if n >= 4 {
+			if buf[0] != 0x88 || StatusCode(int(buf[2])<<8|int(buf[3])) != TooBigMessage {
+				t.Fatalf("close frame = % x", buf[:n])
+			}
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("close frame not sent")
+}
@@ -12,14 +12,22 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+//go:build !js
+// +build !js
+
 package greatws
 
 import (
+	"log/slog"
+	"net/http"
 	"time"
 
 	"github.com/antlabs/wsutil/enum"
 )
 
+// 解压之后的消息默认最大64MB
+const defaultMaxDecompressedSize = 64 << 20
+
 type Config struct {
 	Callback
 	tcpNoDelay                      bool
@@ -36,14 +44,59 @@ type Config struct {
 	delayWriteInitBufferSize int32         // 延迟写入的初始缓冲区大小, 默认值是8k
 	maxDelayWriteDuration    time.Duration // 最大延迟时间, 默认值是10ms
 	subProtocols             []string      // 设置支持的子协议
+	closeLinger              time.Duration // 发送close帧之后, 等待对端close帧的最长时间, 默认值是2s
+	pingInterval             time.Duration // 客户端发送ping的间隔, 0表示不发送
+	pongTimeout              time.Duration // 客户端发送ping之后等待pong的时间, 0表示不检查
+	frameTracer              FrameTracer   // 每一帧都会调用, 用于调试和审计
+	logger                   *slog.Logger  // 连接使用的日志, 为空使用MultiEventLoop的
+	middlewares              []Middleware  // 包装Callback的中间件
 	multiEventLoop           *MultiEventLoop
+
+	closeCodeValidator *CloseCodeValidator // 收发close帧时校验关闭码
+	readBufferGrowth   ReadBufferGrowth    // 读缓冲区的扩容策略
+	socketOptions      socketOptions       // keepalive, 收发缓冲区, linger等socket选项
+	takeoverWindowBits int                 // 允许对端保留压缩上下文时, 愿意分配的最大窗口, 0表示不允许
+	deflate            deflateParams       // permessage-deflate协商的结果
+	pongMismatch       PongMismatchFunc    // 校验pong的payload, 不一致时调用
+	tickInterval       time.Duration       // 调用onTick的间隔
+	onTick             func(c *Conn)       // 定时调用, 用于自定义心跳, 重置配额等
+	handshakeLimits    handshakeLimits     // 服务端握手的请求头大小和耗时限制
+	onFrame            OnFrameFunc         // 按帧接收数据, 配置之后不再合并分片, 不再调用OnMessage
+	onAccept           OnAcceptFunc        // 服务端握手之前调用, 决定是否接受连接
+	allowUnmasked      bool                // 服务端接受客户端没有mask的帧, 默认按rfc 6455拒绝
+	closeReasonStrict  bool                // close帧的reason太长时返回错误, 默认截断
+	newMaskKey         func() MaskKeyFunc  // 客户端每个连接创建一个生成掩码的函数, 为空使用math/rand
+
+	upgradeRespHeaders []UpgradeResponseHeaderFunc // 服务端握手响应里加的http头
+	rejectFunc         RejectFunc                  // 服务端拒绝握手时回http错误的函数
+	checkOrigin        func(r *http.Request) bool  // 服务端检查Origin, 返回false拒绝握手
+
+	readBufferSize  int // 读缓冲区的初始大小, 0表示按windowsMultipleTimesPayloadSize计算
+	writeBufferSize int // 写缓冲区里最多积压的字节数, 超过之后发送数据帧返回ErrWriteBufferFull, 0表示不限制
+
+	stripRsv23 bool // 收到带rsv2/rsv3的帧时清掉这两位继续处理, 默认按rfc 6455关闭连接
+
+	maxPendingMsgs int        // 写缓冲区里最多积压的数据消息条数, 0表示不限制
+	dropPolicy     DropPolicy // 超过maxPendingMsgs时的处理方式
+
+	maxDecompressedSize int64 // 一条消息解压之后的最大字节数, 超过回1009关闭连接, 0表示不限制
+	decodeOffloadSize   int   // 压缩数据不小于这个大小时, 交给业务go程池解压, 0表示不开启
+
+	tlsRoute TLSRouteFunc // 服务端按SNI/ALPN给连接追加选项
+
+	copyPayload   bool // OnMessage拿到payload的拷贝, 返回之后可以继续持有
+	poisonPayload bool // 调试用, OnMessage返回之后把payload填满payloadPoison
 }
 
 func (c *Config) useIoUring() bool {
 	return c.multiEventLoop.flag == EVENT_IOURING
 }
 
+// 读缓冲区的初始大小, 配置了readBufferSize就直接使用, 不再乘以windowsMultipleTimesPayloadSize
 func (c *Config) initPayloadSize() int {
+	if c.readBufferSize > 0 {
+		return c.readBufferSize
+	}
 	return int((1024.0 + float32(enum.MaxFrameHeaderSize)) * c.windowsMultipleTimesPayloadSize)
 }
 
@@ -55,6 +108,9 @@ func (c *Config) defaultSetting() {
 	c.delayWriteInitBufferSize = 8 * 1024
 	c.maxDelayWriteDuration = 10 * time.Millisecond
 	c.tcpNoDelay = true
+	c.closeLinger = 2 * time.Second
+	c.closeCodeValidator = DefaultCloseCodeValidator
+	c.maxDecompressedSize = defaultMaxDecompressedSize
 	// c.parseMode = ParseModeWindows
 	// 对于text消息，默认不检查text是utf8字符
 	c.utf8Check = func(b []byte) bool { return true }
@@ -0,0 +1,103 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+)
+
+// 在运行时修改配置, 不用重启服务就能应对线上问题, 比如调小解压的上限, 放宽或者收紧协议检查
+// f拿到的是连接配置的一份拷贝, 修改完之后只有下面这些字段会生效, 其它字段的修改会被忽略:
+// replyPing, ignorePong, allowUnmasked, stripRsv23, maxDecompressedSize, decodeOffloadSize, maxPendingMsgs, dropPolicy
+// 已经建立的连接在各自的事件循环里修改, 返回的时候修改可能还没有生效; 之后加入的连接在加入事件循环的时候修改
+// f对每个连接都会调用一次, 可能在不同的go程里同时调用, 只能修改传进来的Config
+// Pipe建立的内存连接不在事件循环里, 不受影响
+func (m *MultiEventLoop) UpdateConfig(f func(*Config)) error {
+	// 先在默认配置上试一次, 检查修改之后的值
+	var probe Config
+	probe.defaultSetting()
+	f(&probe)
+	if err := probe.validateUpdate(); err != nil {
+		return err
+	}
+
+	m.configMu.Lock()
+	defer m.configMu.Unlock()
+	m.configUpdates = append(m.configUpdates, f)
+	for _, el := range m.loops {
+		conns := el.snapshotConns()
+		if len(conns) == 0 {
+			continue
+		}
+		// 事件循环已经关闭, 上面的连接也都关闭了
+		_ = el.Execute(func() {
+			for _, c := range conns {
+				if !c.isClosed() {
+					c.updateConfig(f)
+				}
+			}
+		})
+	}
+	return nil
+}
+
+// 运行时修改默认日志的级别, 使用WithLogger设置的日志时, 级别由日志自己控制, 这里只刷新debug级别的检查
+func (m *MultiEventLoop) SetLogLevel(level slog.Level) {
+	m.logLevel.Set(level)
+	m.debug.Store(m.Logger.Enabled(context.Background(), slog.LevelDebug))
+	for _, el := range m.loops {
+		for _, c := range el.snapshotConns() {
+			c.debug.Store(c.getLogger().Enabled(context.Background(), slog.LevelDebug))
+		}
+	}
+}
+
+// UpdateConfig只检查可以修改的字段, 其它字段在建立连接的时候已经检查过了
+func (c *Config) validateUpdate() error {
+	var errs []error
+	if c.decodeOffloadSize < 0 {
+		errs = append(errs, fmt.Errorf("%w: negative decompress offload(%d)", ErrInvalidConfig, c.decodeOffloadSize))
+	}
+	if c.maxPendingMsgs < 0 || c.dropPolicy > DropOldest {
+		errs = append(errs, fmt.Errorf("%w: drop policy: limit=%d, policy=%v", ErrInvalidConfig, c.maxPendingMsgs, c.dropPolicy))
+	}
+	return errors.Join(errs...)
+}
+
+// 在事件循环里调用, 或者连接还没有加入事件循环
+// 写拷贝: f修改的是一份拷贝, 再把可以修改的字段拷回去, 这些字段只在事件循环里读
+func (c *Conn) updateConfig(f func(*Config)) {
+	nc := *c.Config
+	f(&nc)
+
+	c.replyPing = nc.replyPing
+	c.ignorePong = nc.ignorePong
+	c.allowUnmasked = nc.allowUnmasked
+	c.stripRsv23 = nc.stripRsv23
+	c.maxDecompressedSize = nc.maxDecompressedSize
+	c.decodeOffloadSize = nc.decodeOffloadSize
+
+	// 积压的上限在写的go程里读, 由SetDropPolicy加锁修改
+	if nc.maxPendingMsgs != c.maxPendingMsgs || nc.dropPolicy != c.dropPolicy {
+		c.maxPendingMsgs, c.dropPolicy = nc.maxPendingMsgs, nc.dropPolicy
+		c.SetDropPolicy(nc.maxPendingMsgs, nc.dropPolicy)
+	}
+}
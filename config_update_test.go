@@ -0,0 +1,79 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package greatws
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"testing"
+)
+
+func Test_UpdateConfig(t *testing.T) {
+	tl := newTestLoop(t)
+	c, _ := newTestConn(t, withTestLoop(tl))
+
+	err := tl.UpdateConfig(func(conf *Config) {
+		conf.maxDecompressedSize = 1024
+		conf.stripRsv23 = true
+		conf.maxPendingMsgs, conf.dropPolicy = 8, DropOldest
+		conf.writeBufferSize = 4096 // 不能修改的字段
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 在事件循环里修改
+	if c.maxDecompressedSize != defaultMaxDecompressedSize {
+		t.Fatal("updated outside the event loop")
+	}
+	if _, err := tl.Tick(); err != nil {
+		t.Fatal(err)
+	}
+	if c.maxDecompressedSize != 1024 || !c.stripRsv23 {
+		t.Fatalf("not updated: maxDecompressedSize=%d, stripRsv23=%v", c.maxDecompressedSize, c.stripRsv23)
+	}
+	if c.pendingLimit != 8 || c.pendingPolicy != DropOldest {
+		t.Fatalf("drop policy: limit=%d, policy=%v", c.pendingLimit, c.pendingPolicy)
+	}
+	if c.writeBufferSize != 0 {
+		t.Fatalf("writeBufferSize = %d", c.writeBufferSize)
+	}
+
+	// 之后加入的连接也要修改
+	c2, _ := newTestConn(t, withTestLoop(tl))
+	if c2.maxDecompressedSize != 1024 || c2.pendingLimit != 8 {
+		t.Fatalf("new conn: maxDecompressedSize=%d, limit=%d", c2.maxDecompressedSize, c2.pendingLimit)
+	}
+
+	// 不合法的修改直接返回错误, 不保存
+	err = tl.UpdateConfig(func(conf *Config) { conf.decodeOffloadSize = -1 })
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("err = %v", err)
+	}
+	if n := len(tl.configUpdates); n != 1 {
+		t.Fatalf("updates = %d", n)
+	}
+}
+
+func Test_SetLogLevel(t *testing.T) {
+	tl := newTestLoop(t)
+	c, _ := newTestConn(t, withTestLoop(tl))
+	if tl.debug.Load() || c.debugEnabled() {
+		t.Fatal("debug enabled by default")
+	}
+
+	tl.SetLogLevel(slog.LevelDebug)
+	if !tl.debug.Load() || !c.debugEnabled() {
+		t.Fatal("debug not enabled")
+	}
+	if !tl.Logger.Enabled(context.Background(), slog.LevelDebug) {
+		t.Fatal("logger level not changed")
+	}
+
+	tl.SetLogLevel(slog.LevelError)
+	if c.debugEnabled() || tl.Logger.Enabled(context.Background(), slog.LevelInfo) {
+		t.Fatal("debug still enabled")
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"errors"
+	"fmt"
+)
+
+// 检查配置里互相冲突或者没有意义的组合, 这些组合不会报错, 只会在运行时悄悄不生效
+// 返回的错误用errors.Join合并了所有的问题, 每一个都满足errors.Is(err, ErrInvalidConfig)
+// Upgrade和Dial会自动调用, 配置不对的时候服务端回500, 客户端不发起连接
+func (c *Config) Validate() error {
+	var errs []error
+	bad := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%w: "+format, append([]any{ErrInvalidConfig}, args...)...))
+	}
+
+	// 只有开启解压缩的时候才会协商permessage-deflate
+	if c.compression && !c.decompression {
+		bad("compression needs decompression, permessage-deflate is only negotiated when decompression is enabled")
+	}
+	if c.decodeOffloadSize > 0 && !c.decompression {
+		bad("decompress offload(%d) without decompression", c.decodeOffloadSize)
+	}
+
+	if c.maxDelayWriteNum < 0 || c.delayWriteInitBufferSize < 0 || c.maxDelayWriteDuration < 0 {
+		bad("negative delay write settings: num=%d, buffer=%d, duration=%v", c.maxDelayWriteNum, c.delayWriteInitBufferSize, c.maxDelayWriteDuration)
+	} else if c.maxDelayWriteNum > 0 && c.maxDelayWriteDuration == 0 {
+		bad("maxDelayWriteNum(%d) without maxDelayWriteDuration", c.maxDelayWriteNum)
+	}
+
+	if c.pongTimeout > 0 && c.pingInterval <= 0 {
+		bad("pongTimeout(%v) without pingInterval", c.pongTimeout)
+	}
+	if c.pingInterval < 0 || c.pongTimeout < 0 {
+		bad("negative heartbeat: pingInterval=%v, pongTimeout=%v", c.pingInterval, c.pongTimeout)
+	}
+
+	if c.readBufferSize < 0 || c.writeBufferSize < 0 {
+		bad("negative buffer size: read=%d, write=%d", c.readBufferSize, c.writeBufferSize)
+	}
+	if c.readBufferSize == 0 && c.windowsMultipleTimesPayloadSize <= 0 {
+		bad("windowsMultipleTimesPayloadSize(%v) must be > 0", c.windowsMultipleTimesPayloadSize)
+	}
+
+	if c.maxPendingMsgs < 0 || c.dropPolicy > DropOldest {
+		bad("drop policy: limit=%d, policy=%v", c.maxPendingMsgs, c.dropPolicy)
+	}
+	return errors.Join(errs...)
+}
+
+// 在Config.Validate的基础上, 检查只对客户端有意义的组合
+func (d *DialOption) Validate() error {
+	err := d.Config.Validate()
+	// 客户端两个都开启才会在握手里带上permessage-deflate
+	if d.decompression && !d.compression {
+		err = errors.Join(err, fmt.Errorf("%w: client decompression needs compression, permessage-deflate is only offered when both are enabled", ErrInvalidConfig))
+	}
+	if d.unixSocket != "" && d.useHTTP2 {
+		err = errors.Join(err, fmt.Errorf("%w: unix socket with http2, extended CONNECT only dials tcp", ErrInvalidConfig))
+	}
+	if d.maxRedirects < 0 {
+		err = errors.Join(err, fmt.Errorf("%w: negative maxRedirects(%d)", ErrInvalidConfig, d.maxRedirects))
+	}
+	return err
+}
+
+// NewMultiEventLoop里调用
+func (m *MultiEventLoop) validate() error {
+	var errs []error
+	if m.numLoops < 0 {
+		errs = append(errs, fmt.Errorf("%w: event loops(%d) must be > 0", ErrInvalidConfig, m.numLoops))
+	}
+	if m.maxEventNum <= 0 {
+		errs = append(errs, fmt.Errorf("%w: maxEventNum(%d) must be > 0", ErrInvalidConfig, m.maxEventNum))
+	}
+	if m.t.min < 0 || m.t.initCount < 0 || m.t.max <= 0 || m.t.min > m.t.max {
+		errs = append(errs, fmt.Errorf("%w: business go num: init=%d, min=%d, max=%d", ErrInvalidConfig, m.t.initCount, m.t.min, m.t.max))
+	}
+	if m.loopMaxRestarts < 0 || m.loopRestartBackoff < 0 {
+		errs = append(errs, fmt.Errorf("%w: loop restart: max=%d, backoff=%v", ErrInvalidConfig, m.loopMaxRestarts, m.loopRestartBackoff))
+	}
+	for _, cpu := range m.cpus {
+		if cpu < 0 {
+			errs = append(errs, fmt.Errorf("%w: negative cpu(%d) in affinity", ErrInvalidConfig, cpu))
+			break
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,99 @@
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_ConfigValidate(t *testing.T) {
+	var def Config
+	def.defaultSetting()
+	if err := def.Validate(); err != nil {
+		t.Fatalf("default config: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		set  func(c *Config)
+		want string
+	}{
+		{"compression without decompression", func(c *Config) { c.compression = true }, "compression needs decompression"},
+		{"offload without decompression", func(c *Config) { c.decodeOffloadSize = 1024 }, "decompress offload"},
+		{"delay write without duration", func(c *Config) { c.maxDelayWriteDuration = 0 }, "without maxDelayWriteDuration"},
+		{"pong without ping", func(c *Config) { c.pongTimeout = time.Second }, "without pingInterval"},
+		{"negative buffer", func(c *Config) { c.writeBufferSize = -1 }, "negative buffer size"},
+		{"bad drop policy", func(c *Config) { c.dropPolicy = DropOldest + 1 }, "drop policy"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := def
+			tc.set(&c)
+			err := c.Validate()
+			if !errors.Is(err, ErrInvalidConfig) || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("err = %v", err)
+			}
+		})
+	}
+
+	// 所有的问题一起返回
+	c := def
+	c.compression = true
+	c.readBufferSize = -1
+	if err := c.Validate(); strings.Count(err.Error(), ErrInvalidConfig.Error()) != 2 {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func Test_DialOptionValidate(t *testing.T) {
+	d := ClientOptionToConf(WithClientDecompression())
+	if err := d.Validate(); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("err = %v", err)
+	}
+	d = ClientOptionToConf(WithClientDecompressAndCompress())
+	if err := d.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewMultiEventLoopMust(WithEventLoops(1))
+	if _, err := Dial("ws://127.0.0.1:1/", WithClientMultiEventLoop(m), WithClientCompression()); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("dial err = %v", err)
+	}
+}
+
+func Test_MultiEventLoopValidate(t *testing.T) {
+	for _, opt := range []EvOption{WithEventLoops(-1), WithMaxEventNum(-1), WithBusinessGoNum(1, 10, 5)} {
+		if _, err := NewMultiEventLoop(opt); !errors.Is(err, ErrInvalidConfig) {
+			t.Fatalf("err = %v", err)
+		}
+	}
+}
+
+func Test_UpgradeValidate(t *testing.T) {
+	m := NewMultiEventLoopMust(WithEventLoops(1))
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := Upgrade(w, r, WithServerMultiEventLoop(m), WithServerDecompressOffload(1024)); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("upgrade err = %v", err)
+		}
+	}))
+	defer ts.Close()
+
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	rsp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp.Body.Close()
+	if rsp.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("status = %d", rsp.StatusCode)
+	}
+}
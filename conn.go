@@ -12,6 +12,9 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+//go:build !js
+// +build !js
+
 package greatws
 
 import (
@@ -20,21 +23,23 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
-	"math/rand"
+	"math"
+	"os"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/antlabs/wsutil/bytespool"
 	"github.com/antlabs/wsutil/enum"
-	"github.com/antlabs/wsutil/errs"
 	"github.com/antlabs/wsutil/fixedwriter"
 	"github.com/antlabs/wsutil/frame"
-	"github.com/antlabs/wsutil/mask"
 	"github.com/antlabs/wsutil/opcode"
 )
 
 const (
 	maxControlFrameSize = 125
+	// 单个frame payload的最大长度, 超过这个值计算缓冲区大小会溢出
+	maxFramePayloadSize = math.MaxInt32
 )
 
 type frameState int
@@ -59,21 +64,41 @@ const (
 
 type conn struct {
 	fd             int64      // 文件描述符fd
-	rbuf           *[]byte    // 读缓冲区
-	rr             int        // rbuf读索引
-	rw             int        // rbuf写索引
+	rbuf           ringBuffer // 读缓冲区, 环形
 	curState       frameState // 保存当前状态机的状态
 	lenAndMaskSize int        // payload长度和掩码的长度
 	rh             frame.FrameHeader
 
-	fragmentFramePayload []byte // 存放分片帧的缓冲区
-	fragmentFrameHeader  *frame.FrameHeader
+	fragmentFramePayload []byte        // 存放分片帧的缓冲区
+	fragment             fragmentState // 正在拼接的分片消息
+
+	streamPayload *[]byte // ReadBufferGrowthFixed模式下, 正在拼接的大payload
+	streamN       int     // streamPayload已经拷贝的长度
+
+	lingerTimer *wheelTimer // 发送close帧之后, 等待对端close帧的定时器
+
+	connLogger atomic.Pointer[slog.Logger] // 连接自己的日志, 为空使用MultiEventLoop的
+	debug      atomic.Bool                 // 日志是否开启了debug级别
 }
 
 func (c *Conn) getLogger() *slog.Logger {
+	if l := c.connLogger.Load(); l != nil {
+		return l
+	}
 	return c.multiEventLoop.Logger
 }
 
+// 热路径上打debug日志之前先检查, 避免构造日志参数
+func (c *Conn) debugEnabled() bool {
+	return c.debug.Load()
+}
+
+// 给这个连接的日志加上属性, 比如用户id, 之后这个连接打的日志都会带上
+// 一般在OnOpen里调用
+func (c *Conn) WithLogAttrs(args ...any) {
+	c.connLogger.Store(c.getLogger().With(args...))
+}
+
 func (c *Conn) getFd() int {
 	return int(c.fd)
 }
@@ -83,15 +108,13 @@ func (c *Conn) readHeader() (sucess bool, err error) {
 	state := c.curState
 	// 开始解析frame
 	if state == frameStateHeaderStart {
-		// 小于最小的frame头部长度, 有空间就挪一挪
-		if len(*c.rbuf)-c.rr < enum.MaxFrameHeaderSize {
-			c.leftMove()
-		}
 		// fin rsv1 rsv2 rsv3 opcode
-		if c.rw-c.rr < 2 {
+		if c.rbuf.Len() < 2 {
 			return false, nil
 		}
-		c.rh.Head = (*c.rbuf)[c.rr]
+		var head [2]byte
+		c.rbuf.Peek(head[:])
+		c.rh.Head = head[0]
 
 		// h.Fin = head[0]&(1<<7) > 0
 		// h.Rsv1 = head[0]&(1<<6) > 0
@@ -99,7 +122,7 @@ func (c *Conn) readHeader() (sucess bool, err error) {
 		// h.Rsv3 = head[0]&(1<<4) > 0
 		c.rh.Opcode = opcode.Opcode(c.rh.Head & 0xF)
 
-		maskAndPayloadLen := (*c.rbuf)[c.rr+1]
+		maskAndPayloadLen := head[1]
 		have := 0
 		c.rh.Mask = maskAndPayloadLen&(1<<7) > 0
 
@@ -111,9 +134,6 @@ func (c *Conn) readHeader() (sucess bool, err error) {
 		switch {
 		// 长度
 		case c.rh.PayloadLen >= 0 && c.rh.PayloadLen <= 125:
-			if c.rh.PayloadLen == 0 && !c.rh.Mask {
-				return
-			}
 		case c.rh.PayloadLen == 126:
 			// 2字节长度
 			have += 2
@@ -124,26 +144,35 @@ func (c *Conn) readHeader() (sucess bool, err error) {
 			// size += 8
 		default:
 			// 预期之外的, 直接报错
-			return sucess, errs.ErrFramePayloadLength
+			c.curState = frameStateHeaderStart
+			return sucess, ErrFramePayloadLength
 		}
 		c.curState, state = frameStateHeaderPayloadAndMask, frameStateHeaderPayloadAndMask
 		c.lenAndMaskSize = have
-		c.rr += 2
+		c.rbuf.Discard(2)
 
 	}
 
 	if state == frameStateHeaderPayloadAndMask {
-		if c.rw-c.rr < c.lenAndMaskSize {
+		if c.rbuf.Len() < c.lenAndMaskSize {
 			return
 		}
 		have := c.lenAndMaskSize
-		head := (*c.rbuf)[c.rr : c.rr+have]
+		var headArray [enum.MaxFrameHeaderSize]byte
+		head := headArray[:have]
+		c.rbuf.Peek(head)
 		switch c.rh.PayloadLen {
 		case 126:
 			c.rh.PayloadLen = int64(binary.BigEndian.Uint16(head[:2]))
 			head = head[2:]
 		case 127:
-			c.rh.PayloadLen = int64(binary.BigEndian.Uint64(head[:8]))
+			// rfc 6455 5.2, 8字节长度的最高位必须是0, 不然转成int64会变成负数
+			payloadLen := binary.BigEndian.Uint64(head[:8])
+			if payloadLen > maxFramePayloadSize {
+				c.curState = frameStateHeaderStart
+				return false, ErrFramePayloadLength
+			}
+			c.rh.PayloadLen = int64(payloadLen)
 			head = head[8:]
 		}
 
@@ -151,20 +180,38 @@ func (c *Conn) readHeader() (sucess bool, err error) {
 			c.rh.MaskKey = binary.LittleEndian.Uint32(head[:4])
 		}
 		c.curState = frameStatePayload
-		c.rr += c.lenAndMaskSize
+		c.rbuf.Discard(c.lenAndMaskSize)
 		return true, nil
 	}
 
 	return state == frameStatePayload, nil
 }
 
+// 帧头第一个字节里rsv2和rsv3的位置
+const rsv23Mask = 1<<5 | 1<<4
+
+// 正在拼接的分片消息, opcode和是否压缩只从第一帧取(rfc 7692 6.1)
+// continuation帧只提供数据和fin, 带rsv1在failRsv1里按协议错误处理
+type fragmentState struct {
+	op         Opcode // 第一帧的opcode, 为0(Continuation)表示没有正在拼接的分片消息
+	compressed bool   // 第一帧带rsv1, 拼完之后整条消息一起解压
+}
+
+func (s *fragmentState) active() bool {
+	return s.op != Continuation
+}
+
+func (s *fragmentState) reset() {
+	*s = fragmentState{}
+}
+
 func (c *Conn) failRsv1(op opcode.Opcode) bool {
 	// 解压缩没有开启
 	if !c.decompression {
 		return true
 	}
 
-	// 不是text和binary
+	// 不是text和binary, 包括continuation帧, 压缩的标记只在消息的第一帧上
 	if op != opcode.Text && op != opcode.Binary {
 		return true
 	}
@@ -172,113 +219,129 @@ func (c *Conn) failRsv1(op opcode.Opcode) bool {
 	return false
 }
 
-func decode(payload []byte) ([]byte, error) {
+// 解压payload, limit大于0时解压出来的数据最多limit字节, 超过返回ErrDecompressTooLarge
+// 只多读1个字节判断是否超过, 对端发来的压缩炸弹不会把内存撑爆
+func decode(payload []byte, dict []byte, limit int64) ([]byte, error) {
 	r := bytes.NewReader(payload)
-	r2 := decompressNoContextTakeover(r)
+	r2 := decompressWithDict(r, dict)
+	defer r2.Close()
+
+	var src io.Reader = r2
+	if limit > 0 {
+		src = io.LimitReader(r2, limit+1)
+	}
 	var o bytes.Buffer
-	if _, err := io.Copy(&o, r2); err != nil {
-		return nil, err
+	if _, err := io.Copy(&o, src); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrDecompress, err)
+	}
+	if limit > 0 && int64(o.Len()) > limit {
+		return nil, ErrDecompressTooLarge
 	}
-	r2.Close()
 	return o.Bytes(), nil
 }
 
-func (c *Conn) leftMove() {
-	if c.rr == 0 {
-		return
+// 读取payload
+// 1. payload比整个读缓冲区还大, 按扩容策略换一个更大的缓冲区, 或者边读边拷贝
+// 2. 数据还没有读完整, 等下次可读
+func (c *Conn) readPayload() (f frame.Frame, success bool, err error) {
+	if c.rh.PayloadLen > int64(c.rbuf.Cap()) {
+		// 读缓冲区不扩容, 边读边拷贝
+		if c.readBufferGrowth == ReadBufferGrowthFixed {
+			return c.readPayloadStreaming()
+		}
+		// 按扩容策略重新分配, 旧的buf放回池子里
+		c.putRbuf(c.rbuf.Swap(c.getRbuf(c.growReadBufferSize())))
 	}
-	// b.CountMove++
-	// b.MoveBytes += b.W - b.R
-	copy(*c.rbuf, (*c.rbuf)[c.rr:c.rw])
-	c.rw -= c.rr
-	c.rr = 0
-}
-
-func (c *Conn) writeCap() int {
-	return len((*c.rbuf)[c.rw:])
-}
 
-// 需要考虑几种情况
-// 返回完整Payload逻辑
-// 1. 当前的rbuf长度不够，需要重新分配
-// 2. 当前的rbuf长度够，但是数据没有读完整
-// 返回分片Paylod逻辑
-// TODO
-func (c *Conn) readPayload() (f frame.Frame, success bool, err error) {
-	// 如果缓存区不够, 重新分配
-	multipletimes := c.windowsMultipleTimesPayloadSize
-	// 已读取未处理的数据
-	readUnhandle := int64(c.rw - c.rr)
-	// 情况 1，需要读的长度 > 剩余可用空间(未写的+已经被读取走的)
-	if c.rh.PayloadLen-readUnhandle > int64(len((*c.rbuf)[c.rw:])+c.rr) {
-		// 1.取得旧的buf
-		oldBuf := c.rbuf
-		// 2.获取新的buf
-		newBuf := bytespool.GetBytes(int(float32(c.rh.PayloadLen+enum.MaxFrameHeaderSize) * multipletimes))
-		// 把旧的数据拷贝到新的buf里
-		copy(*newBuf, (*oldBuf)[c.rr:c.rw])
-		c.rw -= c.rr
-		c.rr = 0
-
-		// 3.重置缓存区
-		c.rbuf = newBuf
-		// 4.将旧的buf放回池子里
-		bytespool.PutBytes(oldBuf)
-
-		// 情况 2。 空间是够的，需要挪一挪, 把已经读过的覆盖掉
-	} else if c.rh.PayloadLen-readUnhandle > int64(c.writeCap()) {
-		c.leftMove()
-	}
-
-	// 前面的reset已经保证了，buffer的大小是够的
-	needRead := c.rh.PayloadLen - readUnhandle
-
-	// fmt.Printf("needRead:%d:rr(%d):rw(%d):PayloadLen(%d), %v\n", needRead, c.rr, c.rw, c.rh.PayloadLen, c.rbuf)
-	if needRead > 0 {
+	if int64(c.rbuf.Len()) < c.rh.PayloadLen {
 		return
 	}
 
-	newBuf := GetPayloadBytes(int(c.rh.PayloadLen))
-	copy(*newBuf, (*c.rbuf)[c.rr:c.rr+int(c.rh.PayloadLen)])
-	newBuf2 := (*newBuf)[:c.rh.PayloadLen]
-	f.Payload = newBuf2
-
-	f.FrameHeader = c.rh
-	c.rr += int(c.rh.PayloadLen)
-	if len(*c.rbuf)-c.rw < 1024 {
-		c.leftMove()
+	if tail, ok := c.fragmentTail(int(c.rh.PayloadLen)); ok {
+		f.Payload = tail
+	} else {
+		newBuf := GetPayloadBytes(int(c.rh.PayloadLen))
+		f.Payload = (*newBuf)[:c.rh.PayloadLen]
 	}
+	c.rbuf.Read(f.Payload)
+	f.FrameHeader = c.rh
 
 	if c.rh.Mask {
-		mask.Mask(f.Payload, c.rh.MaskKey)
+		maskPayload(f.Payload, c.rh.MaskKey)
 	}
 
+	c.traceRead(&f)
 	return f, true, nil
 }
 
-func (c *Conn) processCallback(f frame.Frame) (err error) {
-	op := f.Opcode
-	if c.fragmentFrameHeader != nil {
-		op = c.fragmentFrameHeader.Opcode
+// 分片消息的数据帧直接读到fragmentFramePayload的尾部, 不再从池子里拿buf
+// processCallback里的append是原地拷贝, 拼接分片的时候没有堆分配
+func (c *Conn) fragmentTail(n int) ([]byte, bool) {
+	if c.onFrame != nil || c.rh.Opcode.IsControl() {
+		return nil, false
+	}
+	if c.fragment.active() {
+		if c.rh.Opcode != Continuation {
+			return nil, false
+		}
+	} else {
+		if c.rh.GetFin() || c.rh.Opcode == Continuation {
+			return nil, false
+		}
+		// 第一个分片, 上一条分片消息出错时可能有残留, 从头开始
+		c.fragmentFramePayload = c.fragmentFramePayload[:0]
 	}
 
+	buf := c.fragmentFramePayload
+	if cap(buf)-len(buf) < n {
+		buf = append(buf[:cap(buf)], make([]byte, len(buf)+n-cap(buf))...)[:len(buf)]
+		c.fragmentFramePayload = buf
+	}
+	return buf[len(buf) : len(buf)+n], true
+}
+
+// 控制帧可以插在分片消息的中间(rfc 6455 5.4), 按自己的opcode单独处理,
+// 不读也不修改fragment和fragmentFramePayload, 之后的continuation帧接着拼接
+func (c *Conn) processCallback(f frame.Frame) (err error) {
+	// 没有协商任何使用rsv2/rsv3的扩展, 兼容乱设保留位的对端时直接清掉
+	if c.stripRsv23 {
+		f.Head &^= rsv23Mask
+	}
 	rsv1 := f.GetRsv1()
 	// 检查Rsv1 rsv2 Rfd, errsv3
-	if rsv1 && c.failRsv1(op) || f.GetRsv2() || f.GetRsv3() {
+	// rsv1只能出现在text/binary(分片的话是第一帧)上(rfc 7692 6), 所以用帧自己的opcode检查, 不用分片消息的opcode
+	if rsv1 && c.failRsv1(f.Opcode) || f.GetRsv2() || f.GetRsv3() {
 		err = fmt.Errorf("%w:Rsv1(%t) Rsv2(%t) rsv2(%t) compression:%t", ErrRsv123, rsv1, f.GetRsv2(), f.GetRsv3(), c.compression)
 		return c.writeErrAndOnClose(ProtocolError, err)
 	}
 
+	// rfc 6455 5.1, 客户端发送的帧必须mask, 服务端收到没有mask的帧要关闭连接
+	if !c.client && !f.Mask && !c.allowUnmasked {
+		return c.writeErrAndOnClose(ProtocolError, ErrUnmaskedFrame)
+	}
+
+	// 已经发送过close帧, 除了对端的close帧, 其他的都丢弃
+	if c.isCloseSent() && f.Opcode != Close {
+		return nil
+	}
+
+	if c.onFrame != nil && !f.Opcode.IsControl() {
+		return c.deliverDataFrame(f)
+	}
+
 	fin := f.GetFin()
-	if c.fragmentFrameHeader != nil && !f.Opcode.IsControl() {
-		if f.Opcode == 0 {
+	if c.fragment.active() && !f.Opcode.IsControl() {
+		if f.Opcode == Continuation {
 			c.fragmentFramePayload = append(c.fragmentFramePayload, f.Payload...)
 
 			// 分段的在这返回
 			if fin {
 				// 解压缩
-				if c.fragmentFrameHeader.GetRsv1() && c.decompression {
-					tempBuf, err := decode(c.fragmentFramePayload)
+				if c.fragment.compressed {
+					if c.offloadDecode(c.fragment.op, c.fragmentFramePayload, true) {
+						return nil
+					}
+					tempBuf, err := c.decode(c.fragmentFramePayload)
 					if err != nil {
 						return err
 					}
@@ -286,14 +349,20 @@ func (c *Conn) processCallback(f frame.Frame) (err error) {
 				}
 				// 这里的check按道理应该放到f.Fin前面， 会更符合rfc的标准, 前提是c.utf8Check修改成流式解析
 				// TODO c.utf8Check 修改成流式解析
-				if c.fragmentFrameHeader.Opcode == opcode.Text && !c.utf8Check(c.fragmentFramePayload) {
-					c.Callback.OnClose(c, ErrTextNotUTF8)
+				if c.fragment.op == opcode.Text && !c.utf8Check(c.fragmentFramePayload) {
+					c.setCloseReason(ErrTextNotUTF8)
 					return ErrTextNotUTF8
 				}
 
-				c.Callback.OnMessage(c, c.fragmentFrameHeader.Opcode, c.fragmentFramePayload)
-				c.fragmentFramePayload = c.fragmentFramePayload[0:0]
-				c.fragmentFrameHeader = nil
+				keep := c.keepsDispatchedPayload()
+				c.dispatchMessage(c.fragment.op, c.fragmentFramePayload)
+				if keep {
+					c.fragmentFramePayload = c.fragmentFramePayload[0:0]
+				} else {
+					// 已经交出去了, 下一条分片消息重新分配
+					c.fragmentFramePayload = nil
+				}
+				c.fragment.reset()
 			}
 			return nil
 		}
@@ -304,21 +373,20 @@ func (c *Conn) processCallback(f frame.Frame) (err error) {
 
 	if f.Opcode == opcode.Text || f.Opcode == opcode.Binary {
 		if !fin {
-			prevFrame := f.FrameHeader
-			// 第一次分段
-			if len(c.fragmentFramePayload) == 0 {
-				c.fragmentFramePayload = append(c.fragmentFramePayload, f.Payload...)
-				f.Payload = nil
-			}
+			// 第一次分段, 上一条分片消息出错时可能有残留, 从头开始
+			c.fragmentFramePayload = append(c.fragmentFramePayload[:0], f.Payload...)
+			f.Payload = nil
 
-			// 让fragmentFrame的Payload指向readBuf, readBuf 原引用直接丢弃
-			c.fragmentFrameHeader = &prevFrame
+			c.fragment = fragmentState{op: f.Opcode, compressed: rsv1 && c.decompression}
 			return
 		}
 
 		if rsv1 && c.decompression {
 			// 不分段的解压缩
-			f.Payload, err = decode(f.Payload)
+			if c.offloadDecode(f.Opcode, f.Payload, false) {
+				return nil
+			}
+			f.Payload, err = c.decode(f.Payload)
 			if err != nil {
 				return err
 			}
@@ -326,13 +394,12 @@ func (c *Conn) processCallback(f frame.Frame) (err error) {
 
 		if f.Opcode == opcode.Text {
 			if !c.utf8Check(f.Payload) {
-				c.Callback.OnClose(c, ErrTextNotUTF8)
-				go c.closeAndWaitOnMessage(true, nil)
+				c.setCloseReason(ErrTextNotUTF8)
 				return ErrTextNotUTF8
 			}
 		}
 
-		c.Callback.OnMessage(c, f.Opcode, f.Payload)
+		c.dispatchMessage(f.Opcode, f.Payload)
 		return
 	}
 
@@ -348,6 +415,10 @@ func (c *Conn) processCallback(f frame.Frame) (err error) {
 			return ErrNOTBeFragmented
 		}
 
+		if c.onFrame != nil {
+			c.onFrame(c, f.FrameHeader, f.Payload)
+		}
+
 		if f.Opcode == Close {
 			if len(f.Payload) == 0 {
 				return c.writeErrAndOnClose(NormalClosure, ErrClosePayloadTooSmall)
@@ -361,18 +432,20 @@ func (c *Conn) processCallback(f frame.Frame) (err error) {
 				return c.writeErrAndOnClose(ProtocolError, ErrTextNotUTF8)
 			}
 
-			code := binary.BigEndian.Uint16(f.Payload)
-			if !validCode(code) {
+			if err := c.closeCodeValidator.checkPayload(f.Payload); err != nil {
 				return c.writeErrAndOnClose(ProtocolError, ErrCloseValue)
 			}
 
-			// 回敬一个close包
-			if err := c.WriteTimeout(Close, f.Payload, 2*time.Second); err != nil {
-				return err
+			// 对端主动关闭, 回敬一个close包
+			// 如果是我们先发送的close帧, 这里是对端的回应, 关闭握手完成
+			if c.casState(StateOpen, StateCloseReceived) {
+				if err := c.writeControl(Close, f.Payload, time.Now().Add(2*time.Second)); err != nil {
+					return err
+				}
 			}
 
 			err = bytesToCloseErrMsg(f.Payload)
-			c.Callback.OnClose(c, err)
+			c.setCloseReason(err)
 			return err
 		}
 
@@ -380,7 +453,7 @@ func (c *Conn) processCallback(f frame.Frame) (err error) {
 			// 回一个pong包
 			if c.replyPing {
 				if err := c.WriteTimeout(Pong, f.Payload, 2*time.Second); err != nil {
-					c.Callback.OnClose(c, err)
+					c.setCloseReason(err)
 					return err
 				}
 				c.Callback.OnMessage(c, f.Opcode, f.Payload)
@@ -388,8 +461,12 @@ func (c *Conn) processCallback(f frame.Frame) (err error) {
 			}
 		}
 
-		if f.Opcode == Pong && c.ignorePong {
-			return
+		if f.Opcode == Pong {
+			c.onPong()
+			c.verifyPong(f.Payload)
+			if c.ignorePong {
+				return
+			}
 		}
 
 		c.Callback.OnMessage(c, f.Opcode, nil)
@@ -400,20 +477,167 @@ func (c *Conn) processCallback(f frame.Frame) (err error) {
 	return ErrOpcode
 }
 
+// 发送close帧, 开始关闭握手
+// reason超过123字节默认按utf8字符截断, 配置了WithServerCloseReasonError/WithClientCloseReasonError的话返回ErrCloseReasonTooLong
+// 发送之后继续读取数据(数据帧直接丢弃), 直到收到对端的close帧或者closeLinger超时, 再关闭连接
+func (c *Conn) WriteClose(code StatusCode, reason string) error {
+	if !c.closeCodeValidator.Valid(code) {
+		return ErrCloseValue
+	}
+
+	if c.closeReasonStrict && len(reason) > maxCloseReasonSize {
+		return ErrCloseReasonTooLong
+	}
+
+	if !c.casState(StateOpen, StateCloseSent) {
+		return nil
+	}
+	c.startCloseLinger()
+
+	payload := FormatCloseMessage(code, reason)
+	if err := c.writeControl(opcode.Close, payload, time.Now().Add(2*time.Second)); err != nil {
+		return err
+	}
+	c.closeIfNoLinger()
+	return nil
+}
+
+// 进入StateCloseSent的时候调用, WriteClose, WriteControl和WriteMessage发送close帧都会经过这里
+// 最多等对端的close帧closeLinger, 超时关闭连接
+func (c *Conn) startCloseLinger() {
+	if c.closeLinger <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	if !c.isClosed() {
+		c.lingerTimer = c.multiEventLoop.wheel.AfterFunc(c.closeLinger, func() {
+			c.asyncClose(ErrCloseTimeout)
+		})
+	}
+	c.mu.Unlock()
+}
+
+// close帧发出之后调用, closeLinger<=0表示不等对端的close帧, 直接关闭
+func (c *Conn) closeIfNoLinger() {
+	if c.closeLinger <= 0 {
+		c.asyncClose(nil)
+	}
+}
+
+// 把text/binary消息交给用户
+// 调用过Proxy, 直接转发给对端; 调用过NetConn, 就交给net.Conn的适配器, 保证数据的顺序
+func (c *Conn) dispatchMessage(op Opcode, payload []byte) {
+	if c.proxyMessage(op, payload) {
+		return
+	}
+	if nc := c.netConn.Load(); nc != nil {
+		nc.push(payload)
+		return
+	}
+	c.Callback.OnMessage(c, op, payload)
+}
+
+// 发送close帧, 返回的错误会让调用方关闭连接, OnClose收到的是userErr
+// 已经在关闭握手中的话, 不再发送close帧
 func (c *Conn) writeErrAndOnClose(code StatusCode, userErr error) error {
-	defer c.Callback.OnClose(c, userErr)
-	if err := c.WriteTimeout(opcode.Close, statusCodeToBytes(code), 2*time.Second); err != nil {
+	c.setCloseReason(userErr)
+	if !c.casState(StateOpen, StateCloseSent) {
+		return userErr
+	}
+	if err := c.writeControl(opcode.Close, statusCodeToBytes(code), time.Now().Add(2*time.Second)); err != nil {
 		return err
 	}
 
 	return userErr
 }
 
+type closeReason struct {
+	err error
+}
+
+// 记录导致关闭的错误, 只有第一次生效
+// 出错的地方只记录, 由closeInner统一调用一次OnClose, 带上第一个错误
+func (c *Conn) setCloseReason(err error) {
+	c.reason.CompareAndSwap(nil, &closeReason{err: err})
+}
+
+func (c *Conn) getCloseReason() error {
+	if r := c.reason.Load(); r != nil {
+		return r.err
+	}
+	return nil
+}
+
 func (c *Conn) WriteTimeout(op Opcode, data []byte, t time.Duration) (err error) {
+	if op.IsControl() {
+		return c.WriteControl(op, data, time.Now().Add(t))
+	}
 	// TODO 超时时间
 	return c.WriteMessage(op, data)
 }
 
+// 发送控制帧(close, ping, pong), payload不能超过125字节, 不会压缩
+// 写不进内核的数据会排在写缓冲区里由事件循环发送, 不会阻塞, 可以在任意go程里调用, 包括OnMessage
+// deadline之前还没有发送出去就关闭连接, OnClose收到os.ErrDeadlineExceeded, deadline为零值表示不限制
+func (c *Conn) WriteControl(op Opcode, payload []byte, deadline time.Time) (err error) {
+	if !op.IsControl() {
+		return ErrNotControlFrame
+	}
+
+	if len(payload) > maxControlFrameSize {
+		return ErrMaxControlFrameSize
+	}
+
+	if op == Close {
+		if err = c.closeCodeValidator.checkPayload(payload); err != nil {
+			return err
+		}
+	}
+
+	if err = c.checkWrite(op); err != nil {
+		return err
+	}
+	if err = c.writeControl(op, payload, deadline); err == nil && op == Close {
+		c.closeIfNoLinger()
+	}
+	return err
+}
+
+// 不检查连接的状态, 关闭握手里发送close帧使用
+func (c *Conn) writeControl(op Opcode, payload []byte, deadline time.Time) (err error) {
+	if c.isClosed() {
+		return ErrClosed
+	}
+
+	if !deadline.IsZero() && !time.Now().Before(deadline) {
+		return os.ErrDeadlineExceeded
+	}
+
+	maskValue := c.frameMaskKey()
+	c.traceWrite(payload, true, false, op, maskValue)
+	if op == Ping {
+		c.rememberPing(payload)
+	}
+
+	if c.useIoUring() {
+		var fw fixedwriter.FixedWriter
+		return c.WriteFrameOnlyIoUring(&fw, payload, true, false, c.maskFrames(), op, maskValue)
+	}
+
+	err = c.writeFrame(payload, true, false, op, maskValue, nil)
+	if err != nil || deadline.IsZero() || !c.writePending() {
+		return err
+	}
+
+	c.multiEventLoop.wheel.AfterFunc(time.Until(deadline), func() {
+		if c.writePending() {
+			c.asyncClose(os.ErrDeadlineExceeded)
+		}
+	})
+	return nil
+}
+
 func (c *Conn) readPayloadAndCallback() (sucess bool, err error) {
 	if c.curState == frameStatePayload {
 		f, success, err := c.readPayload()
@@ -425,16 +649,44 @@ func (c *Conn) readPayloadAndCallback() (sucess bool, err error) {
 		// fmt.Printf("read payload, success:%t, %v\n", success, f.Payload)
 		if success {
 			if err := c.processCallback(f); err != nil {
-				go c.closeAndWaitOnMessage(true, err)
+				// 出错之后重置状态机, 避免残留的状态被当成下一个frame解析
+				c.curState = frameStateHeaderStart
+				c.asyncClose(err)
 				return false, err
 			}
 			c.curState = frameStateHeaderStart
+			// 解压交给了业务go程池, 解压完之前不再解析后面的帧
+			if c.decodeOffloaded {
+				return false, nil
+			}
 			return true, err
 		}
 	}
 	return false, nil
 }
 
+// 解析已经在读缓冲区里的frame, 不从fd读取数据
+func (c *Conn) processBufferedFrames() error {
+	for {
+		sucess, err := c.readHeader()
+		if err != nil {
+			return fmt.Errorf("read header err: %w", err)
+		}
+
+		if !sucess {
+			return nil
+		}
+		sucess, err = c.readPayloadAndCallback()
+		if err != nil {
+			return fmt.Errorf("read payload err: %w", err)
+		}
+
+		if !sucess {
+			return nil
+		}
+	}
+}
+
 type wrapBuffer struct {
 	bytes.Buffer
 }
@@ -443,26 +695,187 @@ func (w *wrapBuffer) Close() error {
 	return nil
 }
 
-func (c *Conn) isClosed() bool {
-	return atomic.LoadInt32(&c.closed) == 1
+// 压缩用的输出缓冲区, 复用起来避免每次写都分配
+var wrapBufferPool = sync.Pool{New: func() interface{} {
+	return &wrapBuffer{}
+}}
+
+func getWrapBuffer() *wrapBuffer {
+	return wrapBufferPool.Get().(*wrapBuffer)
+}
+
+func putWrapBuffer(w *wrapBuffer) {
+	w.Reset()
+	wrapBufferPool.Put(w)
+}
+
+// 组装frame放到发送队列里, 调用方不能持有c.mu
+// header和payload放在同一块池化的缓冲区里, 编码和掩码都在锁外完成, 持锁的时间和payload的大小无关
+// wait不为nil时, 写到fd的时候记录这一帧在发送流里的位置, 见WriteMessageDeadline
+func (c *Conn) writeFrame(payload []byte, fin bool, rsv1 bool, op Opcode, maskValue uint32, wait *writeWaiter) (err error) {
+	if err = c.checkWriteBuffer(op, len(payload)); err != nil {
+		return err
+	}
+
+	data := !op.IsControl()
+	if data {
+		if err = c.admitMessage(); err != nil {
+			return err
+		}
+	}
+
+	buf := bytespool.GetBytes(len(payload) + enum.MaxFrameHeaderSize)
+
+	mask := c.maskFrames()
+	wIndex, err := frame.WriteHeader(*buf, fin, rsv1, false, false, op, len(payload), mask, maskValue)
+	if err != nil {
+		bytespool.PutBytes(buf)
+		return err
+	}
+
+	n := copy((*buf)[wIndex:], payload)
+	if mask {
+		maskPayload((*buf)[wIndex:wIndex+n], maskValue)
+	}
+
+	f := outboundFramePool.Get().(*outboundFrame)
+	f.buf, f.n, f.wait, f.data = buf, wIndex+n, wait, data
+	c.outq.Push(f)
+	return c.drainOutbound()
+}
+
+// 把发送队列里的帧按顺序写到fd
+// 已经有go程在写就直接返回, 它放下writing之后会再检查一次队列, 刚放进来的帧不会被漏掉
+// 写出错的时候连接会被关闭, 错误只返回给负责写的go程
+func (c *Conn) drainOutbound() (err error) {
+	for !c.outq.Empty() {
+		if !atomic.CompareAndSwapInt32(&c.writing, 0, 1) {
+			return nil
+		}
+
+		c.mu.Lock()
+		for f := c.outq.PopAll(); f != nil; {
+			if f.file != nil {
+				// 帧头和文件放进写缓冲区, 由flush用sendfile发送, 保证中间不会插入别的帧
+				c.wbuf.Append((*f.buf)[:f.n])
+				c.wbuf.AppendFile(f.file, f.off, f.size)
+				c.queued += int64(f.n) + f.size
+				c.trackPendingMsg(c.queued-int64(f.n)-f.size, int64(f.n)+f.size, true)
+				if err == nil {
+					err = c.flush()
+				}
+			} else if err == nil && !f.wait.canceled() {
+				start := c.queued
+				f.wait.setRange(start, f.n)
+				_, err = c.Write((*f.buf)[:f.n])
+				if f.data {
+					c.trackPendingMsg(start, int64(f.n), f.wait != nil)
+				}
+			}
+			next := f.next
+			bytespool.PutBytes(f.buf)
+			f.buf, f.n, f.next, f.file, f.wait, f.data = nil, 0, nil, nil, nil, false
+			outboundFramePool.Put(f)
+			f = next
+		}
+		c.notifyWriteWaiters()
+		c.trimPendingMsgs()
+		c.mu.Unlock()
+		atomic.StoreInt32(&c.writing, 0)
+	}
+
+	if nc := c.netConn.Load(); nc != nil {
+		nc.notifyWritable()
+	}
+	return err
+}
+
+// 还有没写到内核的数据, 包括发送队列和写缓冲区
+func (c *Conn) writePending() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.wbuf.Len() > 0 || !c.outq.Empty()
+}
+
+// 写缓冲区里还没有写到内核的数据长度
+func (c *Conn) pendingWriteLen() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.wbuf.Len()
+}
+
+// 配置了writeBufferSize时, 写缓冲区放不下这一帧就返回ErrWriteBufferFull, 连接不关闭
+// 写缓冲区是空的时候总是放行, 单个比writeBufferSize大的帧也能发出去
+// 控制帧不受限制, close, ping, pong总是能发送
+func (c *Conn) checkWriteBuffer(op Opcode, n int) error {
+	if c.writeBufferSize <= 0 || op.IsControl() {
+		return nil
+	}
+
+	pending := c.pendingWriteLen()
+	if pending > 0 && pending+n > c.writeBufferSize {
+		return ErrWriteBufferFull
+	}
+	return nil
 }
 
 func (c *Conn) WriteMessage(op Opcode, writeBuf []byte) (err error) {
-	if atomic.LoadInt32(&c.closed) == 1 {
+	return c.writeMessage(op, writeBuf, true, nil)
+}
+
+// 和WriteMessage一样, compress为false时这条消息不压缩, 即使协商了permessage-deflate
+// 已经压缩过的数据(图片, 视频, gzip等)再deflate一次只会浪费cpu, 可以用这个跳过
+// compress为true时和WriteMessage相同, 没有协商压缩的连接不会压缩
+func (c *Conn) WriteMessageCompressed(op Opcode, writeBuf []byte, compress bool) (err error) {
+	return c.writeMessage(op, writeBuf, compress, nil)
+}
+
+func (c *Conn) writeMessage(op Opcode, writeBuf []byte, compress bool, wait *writeWaiter) (err error) {
+	if c.isClosed() {
 		return ErrClosed
 	}
 
+	if c.writeDeadlineExceeded() {
+		return os.ErrDeadlineExceeded
+	}
+
 	if op == opcode.Text {
 		if !c.utf8Check(writeBuf) {
 			return ErrTextNotUTF8
 		}
 	}
 
-	rsv1 := c.compression && (op == opcode.Text || op == opcode.Binary)
+	if op.IsControl() && len(writeBuf) > maxControlFrameSize {
+		return ErrMaxControlFrameSize
+	}
+
+	if op == Close {
+		if err = c.closeCodeValidator.checkPayload(writeBuf); err != nil {
+			return err
+		}
+	}
+
+	if err = c.checkWrite(op); err != nil {
+		return err
+	}
+
+	if op == Ping {
+		c.rememberPing(writeBuf)
+	}
+
+	if ch := c.getChaos(); ch != nil && (op == opcode.Text || op == opcode.Binary) {
+		if ch.beforeWrite() {
+			return nil
+		}
+	}
+
+	// rsv1是每条消息单独设置的, 同一个连接上压缩和不压缩的消息可以混着发
+	rsv1 := compress && c.compression && (op == opcode.Text || op == opcode.Binary)
 	if rsv1 {
-		var out wrapBuffer
-		w := compressNoContextTakeover(&out, defaultCompressionLevel)
-		if _, err = io.Copy(w, bytes.NewReader(writeBuf)); err != nil {
+		out := getWrapBuffer()
+		defer putWrapBuffer(out)
+		w := compressNoContextTakeover(out, c.compressionLevel())
+		if _, err = w.Write(writeBuf); err != nil {
 			return
 		}
 
@@ -472,20 +885,19 @@ func (c *Conn) WriteMessage(op Opcode, writeBuf []byte) (err error) {
 		writeBuf = out.Bytes()
 	}
 
-	maskValue := uint32(0)
-	if c.client {
-		maskValue = rand.Uint32()
-	}
+	maskValue := c.frameMaskKey()
+	c.traceWrite(writeBuf, true, rsv1, op, maskValue)
 
-	var fw fixedwriter.FixedWriter
 	// 没有使用io_uring
 	if !c.useIoUring() {
-		c.mu.Lock()
-		err = frame.WriteFrame(&fw, c, writeBuf, true, rsv1, c.client, op, maskValue)
-		c.mu.Unlock()
+		err = c.writeFrame(writeBuf, true, rsv1, op, maskValue, wait)
 	} else {
+		var fw fixedwriter.FixedWriter
 		// 使用io_uring
-		err = c.WriteFrameOnlyIoUring(&fw, writeBuf, true, rsv1, c.client, op, maskValue)
+		err = c.WriteFrameOnlyIoUring(&fw, writeBuf, true, rsv1, c.maskFrames(), op, maskValue)
+	}
+	if err == nil && op == Close {
+		c.closeIfNoLinger()
 	}
 	return err
 }
@@ -0,0 +1,44 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+)
+
+// 本端的地址, 在accept/dial的时候取一次, 之后不再调用getsockname
+// http2的连接是tcp连接的地址; http2客户端取不到, 返回nil
+func (c *Conn) LocalAddr() net.Addr {
+	return c.localAddr
+}
+
+// 对端的地址, 在accept/dial的时候取一次, 连接关闭之后也可以调用, 适合在OnClose里打日志
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+// http2服务端, stream没有自己的fd, 用tcp连接的地址
+func addrFromRequest(r *http.Request) (local, remote net.Addr) {
+	local, _ = r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	if ap, err := netip.ParseAddrPort(r.RemoteAddr); err == nil {
+		remote = net.TCPAddrFromAddrPort(ap)
+	}
+	return local, remote
+}
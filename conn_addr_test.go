@@ -0,0 +1,47 @@
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_ConnAddr(t *testing.T) {
+	m := NewMultiEventLoopMust(WithEventLoops(1))
+	m.Start()
+
+	server := make(chan *Conn, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := Upgrade(w, r, WithServerMultiEventLoop(m))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		server <- c
+	}))
+	defer ts.Close()
+
+	c, err := Dial("ws://"+strings.TrimPrefix(ts.URL, "http://"), WithClientMultiEventLoop(m))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := <-server
+	defer s.Close()
+
+	if c.RemoteAddr() == nil || c.RemoteAddr().String() != ts.Listener.Addr().String() {
+		t.Fatalf("client remote = %v, want %v", c.RemoteAddr(), ts.Listener.Addr())
+	}
+	if c.LocalAddr() == nil || s.RemoteAddr() == nil || c.LocalAddr().String() != s.RemoteAddr().String() {
+		t.Fatalf("client local = %v, server remote = %v", c.LocalAddr(), s.RemoteAddr())
+	}
+
+	// 关闭之后仍然可以取到
+	c.Close()
+	if c.RemoteAddr() == nil {
+		t.Fatal("remote addr lost after close")
+	}
+}
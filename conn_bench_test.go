@@ -0,0 +1,149 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package greatws
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"os"
+	"testing"
+
+	"github.com/antlabs/wsutil/frame"
+	"golang.org/x/sys/unix"
+)
+
+// 对端把数据读走丢弃, 保证写不会阻塞
+func newBenchWriteConn(b testing.TB, client bool) *Conn {
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM, 0)
+	if err != nil {
+		b.Skip("socketpair:", err)
+	}
+
+	peer := os.NewFile(uintptr(fds[1]), "peer")
+	go io.Copy(io.Discard, peer)
+	b.Cleanup(func() {
+		unix.Close(fds[0])
+		peer.Close()
+	})
+
+	m := &MultiEventLoop{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	m.loops = []*EventLoop{{parent: m}}
+
+	conf := &Config{}
+	conf.defaultSetting()
+	conf.multiEventLoop = m
+	m.flag = EVENT_EPOLL
+	return newConn(int64(fds[0]), client, conf)
+}
+
+func benchmarkWriteMessage(b *testing.B, client bool, size int) {
+	c := newBenchWriteConn(b, client)
+	payload := make([]byte, size)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(size))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := c.WriteMessage(Binary, payload); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func Benchmark_WriteMessage_Server_1024(b *testing.B) {
+	benchmarkWriteMessage(b, false, 1024)
+}
+
+func Benchmark_WriteMessage_Client_1024(b *testing.B) {
+	benchmarkWriteMessage(b, true, 1024)
+}
+
+func Benchmark_WriteMessage_Server_64K(b *testing.B) {
+	benchmarkWriteMessage(b, false, 64*1024)
+}
+
+func Benchmark_WriteMessage_Compression_1024(b *testing.B) {
+	c := newBenchWriteConn(b, false)
+	c.compression = true
+	payload := make([]byte, 1024)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(payload)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := c.WriteMessage(Binary, payload); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// 稳定状态下, 写frame不能有堆分配
+func Test_WriteMessageZeroAlloc(t *testing.T) {
+	for _, client := range []bool{false, true} {
+		c := newBenchWriteConn(t, client)
+		payload := make([]byte, 1024)
+		allocs := testing.AllocsPerRun(100, func() {
+			if err := c.WriteMessage(Binary, payload); err != nil {
+				t.Fatal(err)
+			}
+		})
+		if allocs != 0 {
+			t.Fatalf("client(%t) WriteMessage allocs = %v, want 0", client, allocs)
+		}
+	}
+}
+
+// 一条消息分成4个分片, 每片128字节, 能放进默认大小的读缓冲区
+func fragmentedWire(b testing.TB) []byte {
+	var wire bytes.Buffer
+	chunk := make([]byte, 128)
+	for i := 0; i < 4; i++ {
+		op := Continuation
+		if i == 0 {
+			op = Binary
+		}
+		if err := frame.WriteFrameToBytes(&wire, chunk, i == 3, false, true, op, 0x12345678); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return wire.Bytes()
+}
+
+func Benchmark_ReadFragmentedMessage(b *testing.B) {
+	c := newBenchWriteConn(b, false)
+	c.Callback = OnMessageFunc(func(*Conn, Opcode, []byte) {})
+	wire := fragmentedWire(b)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(wire)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.rbuf.Write(wire)
+		if err := processAllFrames(c); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// 稳定状态下, 合并分片消息不能有堆分配
+func Test_ReadFragmentedMessageZeroAlloc(t *testing.T) {
+	c := newBenchWriteConn(t, false)
+	var n int
+	c.Callback = OnMessageFunc(func(_ *Conn, _ Opcode, payload []byte) { n += len(payload) })
+	wire := fragmentedWire(t)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		c.rbuf.Write(wire)
+		if err := processAllFrames(c); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if allocs != 0 {
+		t.Fatalf("read fragmented message allocs = %v, want 0", allocs)
+	}
+	if n != 101*512 {
+		t.Fatalf("payload bytes = %d", n)
+	}
+}
@@ -0,0 +1,64 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package greatws
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func Test_ReadBufferSize(t *testing.T) {
+	conf := &Config{}
+	conf.defaultSetting()
+	if got := conf.initPayloadSize(); got != 1024+14 {
+		t.Fatalf("default size = %d", got)
+	}
+
+	conf.windowsMultipleTimesPayloadSize = 2.0
+	if got := conf.initPayloadSize(); got != (1024+14)*2 {
+		t.Fatalf("multiple size = %d", got)
+	}
+
+	// 显式配置的大小优先, 不再乘以倍数
+	conf.readBufferSize = 4096
+	if got := conf.initPayloadSize(); got != 4096 {
+		t.Fatalf("explicit size = %d", got)
+	}
+
+	c := newConn(-1, false, conf)
+	if c.rbuf.Cap() < 4096 {
+		t.Fatalf("rbuf cap = %d", c.rbuf.Cap())
+	}
+}
+
+func Test_WriteBufferSize(t *testing.T) {
+	c, _ := newTestConn(t)
+	const limit = 64 * 1024
+	c.writeBufferSize = limit
+
+	// 对端不读, 内核的缓冲区写满之后数据积压在写缓冲区里
+	payload := make([]byte, 1024)
+	var err error
+	for i := 0; i < 10000; i++ {
+		if err = c.WriteMessage(Binary, payload); err != nil {
+			break
+		}
+	}
+	if !errors.Is(err, ErrWriteBufferFull) {
+		t.Fatalf("err = %v", err)
+	}
+	if n := c.pendingWriteLen(); n > limit {
+		t.Fatalf("pending = %d, limit = %d", n, limit)
+	}
+
+	// 连接没有关闭, 控制帧不受限制
+	if err := c.WriteMessage(Ping, nil); err != nil {
+		t.Fatalf("ping: %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if c.isClosed() {
+		t.Fatal("conn closed")
+	}
+}
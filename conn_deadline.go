@@ -0,0 +1,125 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// 设置读写的deadline, t为零值时取消, 语义同net.Conn
+func (c *Conn) SetDeadline(t time.Time) error {
+	if err := c.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.SetWriteDeadline(t)
+}
+
+// 语义取决于怎么接收数据:
+// 调用过NetConn, 通过Read读数据: 和net.Conn一样, 过了deadline之后阻塞中的和之后的Read返回os.ErrDeadlineExceeded,
+// 连接不会关闭, 重新设置deadline之后可以继续读
+// 只用回调(OnMessage)接收数据: 没有Read可以失败, 到了deadline还没有被重新设置, 就关闭连接, OnClose收到os.ErrDeadlineExceeded.
+// 常见的用法是在OnMessage里把deadline往后推, 实现空闲超时. 从net.Conn移植过来的代码要注意这里和net.Conn的区别
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	if c.isClosed() {
+		return ErrClosed
+	}
+
+	c.mu.Lock()
+	if c.readDeadlineTimer != nil {
+		c.readDeadlineTimer.Stop()
+		c.readDeadlineTimer = nil
+	}
+	// NetConn在c.mu里取消这里的timer, 所以在锁里检查
+	if nc := c.netConn.Load(); nc != nil {
+		c.mu.Unlock()
+		return nc.SetReadDeadline(t)
+	}
+	defer c.mu.Unlock()
+
+	if t.IsZero() {
+		return nil
+	}
+
+	c.readDeadlineTimer = c.multiEventLoop.wheel.AfterFunc(time.Until(t), func() {
+		c.asyncClose(os.ErrDeadlineExceeded)
+	})
+	return nil
+}
+
+// 到了deadline之后, 写数据直接返回os.ErrDeadlineExceeded
+// 如果到了deadline还有数据没有写完, 就关闭连接
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	if c.isClosed() {
+		return ErrClosed
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.writeDeadlineTimer != nil {
+		c.writeDeadlineTimer.Stop()
+		c.writeDeadlineTimer = nil
+	}
+
+	if t.IsZero() {
+		atomic.StoreInt64(&c.writeDeadline, 0)
+		return nil
+	}
+
+	atomic.StoreInt64(&c.writeDeadline, t.UnixNano())
+	var timer *wheelTimer
+	timer = c.multiEventLoop.wheel.AfterFunc(time.Until(t), func() {
+		c.mu.Lock()
+		if c.writeDeadlineTimer != timer {
+			// 已经重新设置过deadline
+			c.mu.Unlock()
+			return
+		}
+		// 时间轮的精度是一个interval, 可能比t早一点触发, 触发之后就当作已经过了deadline
+		if now := time.Now().UnixNano(); now < atomic.LoadInt64(&c.writeDeadline) {
+			atomic.StoreInt64(&c.writeDeadline, now)
+		}
+		// 唤醒按Block等待积压变少的go程, 让它返回os.ErrDeadlineExceeded
+		if c.pendingCond != nil {
+			c.pendingCond.Broadcast()
+		}
+		pending := c.wbuf.Len() > 0 || !c.outq.Empty()
+		c.mu.Unlock()
+		if pending {
+			c.asyncClose(os.ErrDeadlineExceeded)
+		}
+	})
+	c.writeDeadlineTimer = timer
+	return nil
+}
+
+// 是否已经过了写的deadline
+func (c *Conn) writeDeadlineExceeded() bool {
+	d := atomic.LoadInt64(&c.writeDeadline)
+	return d != 0 && time.Now().UnixNano() >= d
+}
+
+func (c *Conn) stopDeadlineTimers() {
+	if c.readDeadlineTimer != nil {
+		c.readDeadlineTimer.Stop()
+	}
+	if c.writeDeadlineTimer != nil {
+		c.writeDeadlineTimer.Stop()
+	}
+}
@@ -0,0 +1,70 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package greatws
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+// 只用回调接收数据时, 到了读的deadline关闭连接
+func Test_ReadDeadlineCallbackCloses(t *testing.T) {
+	r := newCloseRecorder()
+	c, _ := newTestConn(t, withTestCallback(r))
+	if err := c.SetReadDeadline(time.Now().Add(20 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.wait(t); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+// 通过NetConn读数据时, 和net.Conn一样, deadline只让Read失败, 延长之后可以继续读
+func Test_ReadDeadlineNetConn(t *testing.T) {
+	r := newCloseRecorder()
+	c, remote := newTestConn(t, withTestCallback(r))
+	// 之前设置的deadline在调用NetConn之后不再关闭连接
+	if err := c.SetReadDeadline(time.Now().Add(20 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	nc := c.NetConn()
+
+	if err := c.SetReadDeadline(time.Now().Add(50 * time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 16)
+	if _, err := nc.Read(buf); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("err = %v", err)
+	}
+	// 过了deadline之后的Read也失败
+	if _, err := nc.Read(buf); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("err = %v", err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if c.isClosed() {
+		t.Fatal("conn closed by read deadline")
+	}
+	select {
+	case <-r.done:
+		t.Fatal("OnClose called")
+	default:
+	}
+
+	if err := c.SetReadDeadline(time.Now().Add(3 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	var wire bytes.Buffer
+	appendClientFrame(t, &wire, true, false, Binary, "hello")
+	if _, err := remote.Write(wire.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	n, err := nc.Read(buf)
+	if err != nil || string(buf[:n]) != "hello" {
+		t.Fatalf("read %q, err = %v", buf[:n], err)
+	}
+}
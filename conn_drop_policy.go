@@ -0,0 +1,208 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"os"
+	"sync"
+)
+
+// 写缓冲区里积压的数据消息超过上限时的处理方式, 见WithServerDropPolicy和Conn.SetDropPolicy
+type DropPolicy uint8
+
+const (
+	// 阻塞发送的go程, 直到积压的消息少于上限, 连接关闭或者过了写的deadline时返回错误
+	Block DropPolicy = iota
+	// 丢掉正在发送的这条消息, 返回ErrMessageDropped, 已经排队的消息照常发送
+	DropNewest
+	// 丢掉最早的还没有开始发送的消息, 给新消息腾位置, 适合行情这种旧数据没有价值的场景
+	DropOldest
+)
+
+func (p DropPolicy) String() string {
+	switch p {
+	case Block:
+		return "Block"
+	case DropNewest:
+		return "DropNewest"
+	case DropOldest:
+		return "DropOldest"
+	}
+	return "unknown"
+}
+
+// 写缓冲区里还没有写完的一条数据消息, 位置和queued一样按发送流计算
+type pendingMsg struct {
+	start int64
+	end   int64
+	keep  bool // WriteMessageDeadline等待的, 或者sendfile发送的, 不能丢弃
+}
+
+// 修改这个连接积压消息的上限和处理方式, 可以在任意go程里随时调用, 只影响之后发送的消息
+// limit为0表示不限制. 只统计写缓冲区里的数据消息, 控制帧不计数也不会被丢弃
+// 直接写进内核的消息不算积压, 对端读得慢, 内核的发送缓冲区满了之后才开始计数
+// Block会阻塞调用WriteMessage的go程, 不要在WithCallbackInEventLoop的回调里使用, 事件循环被卡住的时候积压不会减少
+// io_uring模式下不生效
+func (c *Conn) SetDropPolicy(limit int, p DropPolicy) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pendingLimit, c.pendingPolicy = limit, p
+	if limit <= 0 {
+		c.pendingMsgs = nil
+	}
+	if c.pendingCond != nil {
+		c.pendingCond.Broadcast()
+	}
+}
+
+// 写缓冲区里积压的数据消息条数, 只在配置了上限的时候统计
+func (c *Conn) PendingMessages() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.trimPendingMsgs()
+	return len(c.pendingMsgs)
+}
+
+// 发送数据消息之前调用, 调用方不能持有c.mu
+// 按DropNewest丢掉了这条消息时返回ErrMessageDropped
+func (c *Conn) admitMessage() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pendingLimit <= 0 {
+		return nil
+	}
+
+	c.trimPendingMsgs()
+	for c.pendingLimit > 0 && len(c.pendingMsgs) >= c.pendingLimit {
+		switch c.pendingPolicy {
+		case DropNewest:
+			c.stats.addDropped()
+			return ErrMessageDropped
+		case DropOldest:
+			// 剩下的都已经开始发送或者不能丢, 照常排队, 积压会暂时超过上限
+			if !c.dropOldestMsg() {
+				return nil
+			}
+			c.stats.addDropped()
+		default:
+			// 先检查deadline, 到了deadline之后连接可能马上就会被关闭
+			if c.writeDeadlineExceeded() {
+				return os.ErrDeadlineExceeded
+			}
+			if c.isClosed() {
+				return ErrClosed
+			}
+			if c.pendingCond == nil {
+				c.pendingCond = sync.NewCond(&c.mu)
+			}
+			c.pendingCond.Wait()
+		}
+	}
+	if c.isClosed() {
+		// 等待的时候到了deadline, 连接接着被关闭, 积压已经清空了
+		if c.writeDeadlineExceeded() {
+			return os.ErrDeadlineExceeded
+		}
+		return ErrClosed
+	}
+	return nil
+}
+
+// 持有c.mu时调用, 记录一条写完之后还有数据留在写缓冲区里的消息
+func (c *Conn) trackPendingMsg(start int64, n int64, keep bool) {
+	if c.pendingLimit <= 0 {
+		return
+	}
+	sent := c.queued - int64(c.wbuf.Len())
+	if start+n <= sent {
+		return
+	}
+	c.pendingMsgs = append(c.pendingMsgs, pendingMsg{start: start, end: start + n, keep: keep})
+}
+
+// 持有c.mu时调用, 去掉已经写到内核的消息, 唤醒Block等待的go程
+func (c *Conn) trimPendingMsgs() {
+	if len(c.pendingMsgs) == 0 {
+		return
+	}
+	sent := c.queued - int64(c.wbuf.Len())
+	i := 0
+	for i < len(c.pendingMsgs) && c.pendingMsgs[i].end <= sent {
+		i++
+	}
+	if i == 0 {
+		return
+	}
+	c.pendingMsgs = c.pendingMsgs[:copy(c.pendingMsgs, c.pendingMsgs[i:])]
+	if c.pendingCond != nil {
+		c.pendingCond.Broadcast()
+	}
+}
+
+// 持有c.mu时调用, 从写缓冲区里撤回最早的一条还没有开始发送的消息
+func (c *Conn) dropOldestMsg() bool {
+	sent := c.queued - int64(c.wbuf.Len())
+	for _, m := range c.pendingMsgs {
+		if m.keep || m.start < sent {
+			continue
+		}
+		if c.unqueue(m.start, m.end) {
+			return true
+		}
+	}
+	return false
+}
+
+// 持有c.mu时调用, 把还没有开始发送的[start, end)从写缓冲区里去掉, 后面的消息和等待的帧往前挪
+func (c *Conn) unqueue(start, end int64) bool {
+	sent := c.queued - int64(c.wbuf.Len())
+	n := end - start
+	if sent > start || !c.wbuf.Remove(int(start-sent), int(n)) {
+		return false
+	}
+
+	c.queued -= n
+	for _, w := range c.writeWaiters {
+		if w.start > start {
+			w.start -= n
+			w.end -= n
+		}
+	}
+
+	keep := c.pendingMsgs[:0]
+	for _, m := range c.pendingMsgs {
+		if m.start == start {
+			continue
+		}
+		if m.start > start {
+			m.start -= n
+			m.end -= n
+		}
+		keep = append(keep, m)
+	}
+	c.pendingMsgs = keep
+	return true
+}
+
+// 连接关闭的时候唤醒Block等待的go程
+func (c *Conn) releasePendingMsgs() {
+	c.pendingMsgs = nil
+	if c.pendingCond != nil {
+		c.pendingCond.Broadcast()
+	}
+}
@@ -0,0 +1,218 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package greatws
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/antlabs/wsutil/enum"
+	"github.com/antlabs/wsutil/frame"
+)
+
+// 对端不读, 一直发送带序号的消息, 直到积压limit条, 返回发送的条数
+func fillPendingMsgs(t *testing.T, c *Conn, limit int) uint32 {
+	t.Helper()
+	var i uint32
+	for c.PendingMessages() < limit {
+		if i > 100000 {
+			t.Fatal("write buffer never filled")
+		}
+		if err := c.WriteMessage(Binary, seqPayload(i)); err != nil {
+			t.Fatal(err)
+		}
+		i++
+	}
+	return i
+}
+
+func seqPayload(i uint32) []byte {
+	b := make([]byte, 1024)
+	binary.BigEndian.PutUint32(b, i)
+	return b
+}
+
+// 读出对端收到的所有消息的序号, 直到idle时间内没有新数据
+func readSeqs(t *testing.T, remote net.Conn, idle time.Duration) []uint32 {
+	t.Helper()
+	var seqs []uint32
+	var head [enum.MaxFrameHeaderSize]byte
+	for {
+		remote.SetReadDeadline(time.Now().Add(idle))
+		h, _, err := frame.ReadHeader(remote, &head)
+		if err != nil {
+			return seqs
+		}
+		payload := make([]byte, h.PayloadLen)
+		if _, err := io.ReadFull(remote, payload); err != nil {
+			t.Fatal(err)
+		}
+		if h.Opcode == Binary {
+			seqs = append(seqs, binary.BigEndian.Uint32(payload))
+		}
+	}
+}
+
+func Test_DropPolicy(t *testing.T) {
+	t.Run("drop newest", func(t *testing.T) {
+		c, remote := newTestConn(t)
+		c.SetDropPolicy(3, DropNewest)
+		n := fillPendingMsgs(t, c, 3)
+
+		pending := c.pendingWriteLen()
+		for i := uint32(0); i < 5; i++ {
+			if err := c.WriteMessage(Binary, seqPayload(n+i)); !errors.Is(err, ErrMessageDropped) {
+				t.Fatalf("err = %v", err)
+			}
+		}
+		if got := c.PendingMessages(); got != 3 || c.pendingWriteLen() != pending {
+			t.Fatalf("pending msgs = %d, len = %d, want %d", got, c.pendingWriteLen(), pending)
+		}
+		if got := c.Stats().MessagesDropped; got != 5 {
+			t.Fatalf("dropped = %d", got)
+		}
+
+		// 控制帧不受限制
+		if err := c.WriteMessage(Ping, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		seqs := readSeqs(t, remote, 100*time.Millisecond)
+		if len(seqs) != int(n) || seqs[len(seqs)-1] != n-1 {
+			t.Fatalf("got %d msgs, want %d", len(seqs), n)
+		}
+	})
+
+	t.Run("drop newest wait", func(t *testing.T) {
+		c, _ := newTestConn(t)
+		c.SetDropPolicy(3, DropNewest)
+		n := fillPendingMsgs(t, c, 3)
+
+		// 丢掉的消息不会写到内核, 不能一直等下去
+		done := make(chan error, 1)
+		go func() { done <- c.WriteMessageContext(context.Background(), Binary, seqPayload(n)) }()
+		select {
+		case err := <-done:
+			if !errors.Is(err, ErrMessageDropped) {
+				t.Fatalf("err = %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("still waiting for a dropped message")
+		}
+		if got := c.Stats().MessagesDropped; got != 1 {
+			t.Fatalf("dropped = %d", got)
+		}
+	})
+
+	t.Run("drop oldest", func(t *testing.T) {
+		c, remote := newTestConn(t)
+		c.SetDropPolicy(3, DropOldest)
+		n := fillPendingMsgs(t, c, 3)
+
+		for i := uint32(0); i < 5; i++ {
+			if err := c.WriteMessage(Binary, seqPayload(n+i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := c.PendingMessages(); got != 3 {
+			t.Fatalf("pending msgs = %d", got)
+		}
+		if got := c.Stats().MessagesDropped; got != 5 {
+			t.Fatalf("dropped = %d", got)
+		}
+
+		// 最新的消息都在, 按顺序, 帧没有被破坏
+		seqs := readSeqs(t, remote, 100*time.Millisecond)
+		if len(seqs) != int(n) {
+			t.Fatalf("got %d msgs, want %d", len(seqs), n)
+		}
+		for i := 1; i < len(seqs); i++ {
+			if seqs[i] <= seqs[i-1] {
+				t.Fatalf("out of order: %v", seqs[i-1:i+1])
+			}
+		}
+		if last := seqs[len(seqs)-1]; last != n+4 {
+			t.Fatalf("last = %d, want %d", last, n+4)
+		}
+	})
+
+	t.Run("block", func(t *testing.T) {
+		c, remote := newTestConn(t)
+		c.SetDropPolicy(2, Block)
+		n := fillPendingMsgs(t, c, 2)
+
+		done := make(chan error, 1)
+		go func() { done <- c.WriteMessage(Binary, seqPayload(n)) }()
+		select {
+		case err := <-done:
+			t.Fatalf("not blocked, err = %v", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		// 对端开始读, 积压减少之后发送成功
+		seqs := readSeqs(t, remote, 100*time.Millisecond)
+		if err := <-done; err != nil {
+			t.Fatal(err)
+		}
+		if len(seqs) != int(n)+1 || c.Stats().MessagesDropped != 0 {
+			t.Fatalf("got %d msgs, want %d", len(seqs), n+1)
+		}
+	})
+
+	t.Run("block closed", func(t *testing.T) {
+		c, _ := newTestConn(t)
+		c.SetDropPolicy(2, Block)
+		n := fillPendingMsgs(t, c, 2)
+
+		done := make(chan error, 1)
+		go func() { done <- c.WriteMessage(Binary, seqPayload(n)) }()
+		time.Sleep(20 * time.Millisecond)
+		c.Close()
+		select {
+		case err := <-done:
+			if !errors.Is(err, ErrClosed) {
+				t.Fatalf("err = %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("still blocked after close")
+		}
+	})
+
+	t.Run("block deadline", func(t *testing.T) {
+		c, _ := newTestConn(t)
+		c.SetDropPolicy(2, Block)
+		n := fillPendingMsgs(t, c, 2)
+
+		done := make(chan error, 1)
+		go func() { done <- c.WriteMessage(Binary, seqPayload(n)) }()
+		time.Sleep(20 * time.Millisecond)
+		c.SetWriteDeadline(time.Now().Add(20 * time.Millisecond))
+		select {
+		case err := <-done:
+			if !errors.Is(err, os.ErrDeadlineExceeded) {
+				t.Fatalf("err = %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("still blocked after write deadline")
+		}
+	})
+
+	t.Run("unlimited", func(t *testing.T) {
+		c, _ := newTestConn(t)
+		for c.pendingWriteLen() == 0 {
+			if err := c.WriteMessage(Binary, seqPayload(0)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := c.PendingMessages(); got != 0 {
+			t.Fatalf("pending msgs = %d", got)
+		}
+	})
+}
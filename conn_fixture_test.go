@@ -0,0 +1,119 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package greatws
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+// 测试连接的配置, 见newTestConn
+type testConnConfig struct {
+	evOpts   []EvOption
+	loop     *TestEventLoop
+	client   bool
+	callback Callback
+	config   func(conf *Config)
+	conn     func(c *Conn)
+}
+
+type testConnOption func(o *testConnConfig)
+
+// 创建MultiEventLoop时追加的选项, 默认只有WithEventLoops(1)
+func withTestEvOptions(opts ...EvOption) testConnOption {
+	return func(o *testConnConfig) {
+		o.evOpts = append(o.evOpts, opts...)
+	}
+}
+
+// 加入手动驱动的事件循环, 不再创建MultiEventLoop, tl由调用方关闭
+func withTestLoop(tl *TestEventLoop) testConnOption {
+	return func(o *testConnConfig) {
+		o.loop = tl
+	}
+}
+
+// 按客户端创建连接
+func withTestClient() testConnOption {
+	return func(o *testConnConfig) {
+		o.client = true
+	}
+}
+
+// 用户的回调, 会按事件循环的配置用initCallback包装
+func withTestCallback(cb Callback) testConnOption {
+	return func(o *testConnConfig) {
+		o.callback = cb
+	}
+}
+
+// 在initCallback之前修改配置
+func withTestConfig(f func(conf *Config)) testConnOption {
+	return func(o *testConnConfig) {
+		o.config = f
+	}
+}
+
+// 在连接加入事件循环之前调用
+func withTestConnSetup(f func(c *Conn)) testConnOption {
+	return func(o *testConnConfig) {
+		o.conn = f
+	}
+}
+
+// socketpair的一端作为连接加入事件循环, 另一端返回给测试当作对端
+// 测试结束时关闭对端, 并且Shutdown这里创建的事件循环
+// closeLinger默认是100ms, 需要别的值用withTestConfig修改
+func newTestConn(t testing.TB, opts ...testConnOption) (*Conn, net.Conn) {
+	var o testConnConfig
+	for _, f := range opts {
+		f(&o)
+	}
+
+	var m *MultiEventLoop
+	if o.loop != nil {
+		m = o.loop.MultiEventLoop
+	} else {
+		m = NewMultiEventLoopMust(append([]EvOption{WithEventLoops(1)}, o.evOpts...)...)
+		m.Start()
+		t.Cleanup(func() { shutdownTestLoop(m) })
+	}
+
+	fd, remote, err := newSocketPair()
+	if err != nil {
+		t.Skip("socketpair:", err)
+	}
+	t.Cleanup(func() { remote.Close() })
+
+	conf := &Config{}
+	conf.defaultSetting()
+	conf.multiEventLoop = m
+	// 对端一般不回close帧, 关闭握手不用等默认的2s
+	conf.closeLinger = 100 * time.Millisecond
+	if o.callback != nil {
+		conf.Callback = o.callback
+	}
+	if o.config != nil {
+		o.config(conf)
+	}
+	conf.initCallback()
+
+	c := newConn(int64(fd), o.client, conf)
+	if o.conn != nil {
+		o.conn(c)
+	}
+	if err := m.add(c); err != nil {
+		t.Fatal(err)
+	}
+	return c, remote
+}
+
+// 测试结束时停止事件循环, 对端已经关闭, 不用等关闭握手
+func shutdownTestLoop(m *MultiEventLoop) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	m.Shutdown(ctx)
+}
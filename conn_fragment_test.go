@@ -0,0 +1,173 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package greatws
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/antlabs/wsutil/frame"
+	"golang.org/x/sys/unix"
+)
+
+type fragmentMsg struct {
+	op      Opcode
+	payload string
+}
+
+// 客户端发过来的帧, 带mask
+func appendClientFrame(t *testing.T, buf *bytes.Buffer, fin, rsv1 bool, op Opcode, payload string) {
+	t.Helper()
+	if err := frame.WriteFrameToBytes(buf, []byte(payload), fin, rsv1, true, op, 0x12345678); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 解析读缓冲区里所有的帧, 返回第一个错误
+func processAllFrames(c *Conn) error {
+	for c.rbuf.Len() > 0 {
+		ok, err := c.readHeader()
+		if err != nil || !ok {
+			return err
+		}
+		f, ok, err := c.readPayload()
+		if err != nil || !ok {
+			return err
+		}
+		c.curState = frameStateHeaderStart
+		if err := c.processCallback(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newFragmentTestConn(t *testing.T, wire []byte) (*Conn, int, *[]fragmentMsg) {
+	c, peer := newFuzzWriteConn(t)
+	t.Cleanup(func() { unix.Close(peer) })
+
+	var got []fragmentMsg
+	c.Callback = OnMessageFunc(func(_ *Conn, op Opcode, payload []byte) {
+		got = append(got, fragmentMsg{op, string(payload)})
+	})
+	c.rbuf.Write(wire)
+	return c, peer, &got
+}
+
+// 对应autobahn 5.6 - 5.20, 分片消息中间插入ping/pong
+func Test_FragmentInterleavedControl(t *testing.T) {
+	var wire bytes.Buffer
+	appendClientFrame(t, &wire, false, false, Text, "fra")
+	appendClientFrame(t, &wire, true, false, Ping, "p1")
+	appendClientFrame(t, &wire, false, false, Continuation, "gme")
+	appendClientFrame(t, &wire, true, false, Pong, "")
+	appendClientFrame(t, &wire, true, false, Ping, "p2")
+	appendClientFrame(t, &wire, true, false, Continuation, "nted")
+	appendClientFrame(t, &wire, true, false, Binary, "next")
+
+	c, peer, got := newFragmentTestConn(t, wire.Bytes())
+	if err := processAllFrames(c); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []fragmentMsg{{Ping, "p1"}, {Pong, ""}, {Ping, "p2"}, {Text, "fragmented"}, {Binary, "next"}}
+	if len(*got) != len(want) {
+		t.Fatalf("got = %v", *got)
+	}
+	for i := range want {
+		if (*got)[i] != want[i] {
+			t.Fatalf("got[%d] = %v, want %v", i, (*got)[i], want[i])
+		}
+	}
+	if c.fragment.active() || len(c.fragmentFramePayload) != 0 {
+		t.Fatalf("fragment state not reset: %v, %q", c.fragment, c.fragmentFramePayload)
+	}
+
+	// 两个ping都要按顺序回pong
+	var pongs bytes.Buffer
+	buf := make([]byte, 64)
+	deadline := time.Now().Add(time.Second)
+	for pongs.Len() < 8 && time.Now().Before(deadline) {
+		n, err := unix.Read(peer, buf)
+		if n > 0 {
+			pongs.Write(buf[:n])
+		}
+		if err != nil {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	if want := []byte{0x8a, 0x02, 'p', '1', 0x8a, 0x02, 'p', '2'}; !bytes.Equal(pongs.Bytes(), want) {
+		t.Fatalf("pongs = % x", pongs.Bytes())
+	}
+}
+
+func Test_FragmentInterleavedInvalid(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		build func(*bytes.Buffer)
+		err   error
+	}{
+		{"fragmented ping", func(b *bytes.Buffer) {
+			appendClientFrame(t, b, false, false, Text, "a")
+			appendClientFrame(t, b, false, false, Ping, "p")
+		}, ErrNOTBeFragmented},
+		{"new message before fin", func(b *bytes.Buffer) {
+			appendClientFrame(t, b, false, false, Text, "a")
+			appendClientFrame(t, b, true, false, Binary, "b")
+		}, ErrFrameOpcode},
+		{"continuation without start", func(b *bytes.Buffer) {
+			appendClientFrame(t, b, true, false, Continuation, "a")
+		}, ErrOpcode},
+		// 压缩的分片消息中间, 控制帧和continuation帧都不能带rsv1
+		{"rsv1 on interleaved ping", func(b *bytes.Buffer) {
+			appendClientFrame(t, b, false, true, Text, "a")
+			appendClientFrame(t, b, true, true, Ping, "p")
+		}, ErrRsv123},
+		{"rsv1 on continuation", func(b *bytes.Buffer) {
+			appendClientFrame(t, b, false, true, Text, "a")
+			appendClientFrame(t, b, true, true, Continuation, "b")
+		}, ErrRsv123},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var wire bytes.Buffer
+			tc.build(&wire)
+			c, _, got := newFragmentTestConn(t, wire.Bytes())
+			if err := processAllFrames(c); !errors.Is(err, tc.err) {
+				t.Fatalf("err = %v, want %v", err, tc.err)
+			}
+			if len(*got) != 0 {
+				t.Fatalf("got = %v", *got)
+			}
+		})
+	}
+}
+
+// 压缩的分片消息, 只有第一帧带rsv1, 拼完之后整条解压
+func Test_FragmentCompressed(t *testing.T) {
+	out := getWrapBuffer()
+	defer putWrapBuffer(out)
+	w := compressNoContextTakeover(out, 1)
+	w.Write([]byte("hello compressed fragments"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	compressed := out.String()
+	half := len(compressed) / 2
+
+	var wire bytes.Buffer
+	appendClientFrame(t, &wire, false, true, Text, compressed[:half])
+	appendClientFrame(t, &wire, true, false, Ping, "")
+	appendClientFrame(t, &wire, true, false, Continuation, compressed[half:])
+
+	c, _, got := newFragmentTestConn(t, wire.Bytes())
+	if err := processAllFrames(c); err != nil {
+		t.Fatal(err)
+	}
+	want := []fragmentMsg{{Ping, ""}, {Text, "hello compressed fragments"}}
+	if len(*got) != 2 || (*got)[0] != want[0] || (*got)[1] != want[1] {
+		t.Fatalf("got = %v", *got)
+	}
+}
@@ -0,0 +1,114 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package greatws
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/antlabs/wsutil/bytespool"
+	"github.com/antlabs/wsutil/frame"
+	"golang.org/x/sys/unix"
+)
+
+// 只用来解析的conn, 不会写数据
+func newFuzzParseConn(data []byte) *Conn {
+	conf := &Config{}
+	conf.defaultSetting()
+	c := newConn(-1, false, conf)
+	c.rbuf.Write(data)
+	return c
+}
+
+// 可以写数据的conn, 对端直接丢弃
+// 事件循环没有启动, 测试结束时关闭conn的fd, 对端由调用方关闭
+func newFuzzWriteConn(t testing.TB) (c *Conn, peer int) {
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM, 0)
+	if err != nil {
+		t.Skip("socketpair:", err)
+	}
+	t.Cleanup(func() { unix.Close(fds[0]) })
+
+	m := &MultiEventLoop{Logger: slog.New(slog.NewTextHandler(io.Discard, nil))}
+	m.loops = []*EventLoop{{parent: m}}
+
+	conf := &Config{}
+	conf.defaultSetting()
+	conf.multiEventLoop = m
+	conf.decompression = true
+	conf.replyPing = true
+	return newConn(int64(fds[0]), false, conf), fds[1]
+}
+
+func Fuzz_ReadHeaderAndPayload(f *testing.F) {
+	f.Add([]byte{0x81, 0x85, 0x37, 0xfa, 0x21, 0x3d, 0x7f, 0x9f, 0x4d, 0x51, 0x58})
+	f.Add([]byte{0x82, 0x7e, 0x01, 0x00})
+	f.Add([]byte{0x82, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	f.Add([]byte{0x8a, 0x00, 0x89, 0x00})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		c := newFuzzParseConn(data)
+		defer func() { bytespool.PutBytes(c.rbuf.buf) }()
+
+		for i := 0; i < 64; i++ {
+			success, err := c.readHeader()
+			if err != nil {
+				if c.curState != frameStateHeaderStart {
+					t.Fatalf("state not reset after error: %v", c.curState)
+				}
+				return
+			}
+			if !success {
+				return
+			}
+			if c.rh.PayloadLen < 0 {
+				t.Fatalf("negative payload length: %d", c.rh.PayloadLen)
+			}
+			// 避免构造超大的缓冲区
+			if c.rh.PayloadLen > 1<<20 {
+				return
+			}
+
+			fr, success, err := c.readPayload()
+			if err != nil || !success {
+				return
+			}
+			if int64(len(fr.Payload)) != c.rh.PayloadLen {
+				t.Fatalf("payload length mismatch: %d != %d", len(fr.Payload), c.rh.PayloadLen)
+			}
+			if c.rbuf.Len() < 0 || c.rbuf.Len() > c.rbuf.Cap() {
+				t.Fatalf("ring buffer len(%d) out of range, cap(%d)", c.rbuf.Len(), c.rbuf.Cap())
+			}
+			c.curState = frameStateHeaderStart
+		}
+	})
+}
+
+func Fuzz_ProcessCallback(f *testing.F) {
+	f.Add(byte(0x81), []byte("hello"))
+	f.Add(byte(0x01), []byte("hel"))
+	f.Add(byte(0x88), []byte{0x03, 0xe8})
+	f.Add(byte(0x88), []byte{0x03})
+	f.Add(byte(0x89), []byte("ping"))
+	f.Add(byte(0xc1), []byte{0xf2, 0x48, 0xcd, 0xc9, 0xc9, 0x07, 0x00})
+	f.Fuzz(func(t *testing.T, head byte, payload []byte) {
+		c, peer := newFuzzWriteConn(t)
+		defer unix.Close(peer)
+
+		var fr frame.Frame
+		fr.Head = head
+		fr.Opcode = Opcode(head & 0xF)
+		fr.Payload = payload
+		fr.PayloadLen = int64(len(payload))
+
+		// 先来一个分片的开头, 覆盖分片的逻辑
+		first := frame.Frame{FrameHeader: frame.FrameHeader{Head: 0x01, Opcode: Text}, Payload: []byte("a")}
+		if head&0x0F == 0 {
+			if err := c.processCallback(first); err != nil {
+				t.Fatal(err)
+			}
+		}
+		_ = c.processCallback(fr)
+	})
+}
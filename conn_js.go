@@ -0,0 +1,237 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build js && wasm
+// +build js,wasm
+
+package greatws
+
+import (
+	"sync"
+	"syscall/js"
+)
+
+const maxControlFrameSize = 125
+
+// 浏览器里的连接, 包装浏览器的WebSocket对象
+// 收发的接口和Callback和其他平台一样, 业务代码可以在服务端和浏览器里共用
+// 浏览器不开放控制帧, ping/pong由浏览器自己处理, close只能通过WriteClose/Close发送
+// 浏览器的事件回调里不能阻塞(包括打印日志这类io), 所以事件先排队, 再由单独的go程按顺序调用Callback
+type Conn struct {
+	*Config
+	ws        js.Value
+	state     int32      // 连接的状态, 见ConnState
+	funcs     []js.Func  // 注册给WebSocket的事件回调, 关闭之后释放
+	opened    chan error // 握手的结果, Dial等待它
+	openDone  bool       // 握手成功过, 只在浏览器的事件回调里读写
+	closeOnce sync.Once
+
+	mu      sync.Mutex
+	events  []func() // 等待调用的Callback, 保证顺序
+	running bool     // 有go程正在调用events
+}
+
+type Config struct {
+	Callback
+	middlewares []Middleware // 包装Callback的中间件
+}
+
+// 构造函数传了非法的url会抛异常, 转换成error
+func newWebSocket(rawUrl string) (ws js.Value, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			jsErr, ok := r.(js.Error)
+			if !ok {
+				panic(r)
+			}
+			err = jsErr
+		}
+	}()
+	return js.Global().Get("WebSocket").New(rawUrl), nil
+}
+
+func newBrowserConn(rawUrl string, conf *Config) (*Conn, error) {
+	ws, err := newWebSocket(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	// 二进制消息用ArrayBuffer接收, 默认的Blob只能异步读取
+	ws.Set("binaryType", "arraybuffer")
+
+	c := &Conn{
+		Config: conf,
+		ws:     ws,
+		state:  int32(StateHandshaking),
+		opened: make(chan error, 1),
+	}
+	c.on("open", c.onOpen)
+	c.on("message", c.onMessage)
+	c.on("error", c.onError)
+	c.on("close", c.onClose)
+	return c, nil
+}
+
+func (c *Conn) on(event string, f func(ev js.Value)) {
+	fn := js.FuncOf(func(_ js.Value, args []js.Value) any {
+		f(args[0])
+		return nil
+	})
+	c.funcs = append(c.funcs, fn)
+	c.ws.Set("on"+event, fn)
+}
+
+func (c *Conn) onOpen(js.Value) {
+	// 握手期间调用了Close, 等close事件, Dial返回错误
+	if !c.casState(StateHandshaking, StateOpen) {
+		return
+	}
+	c.openDone = true
+	c.post(func() { c.OnOpen(c) })
+	c.opened <- nil
+}
+
+func (c *Conn) onMessage(ev js.Value) {
+	data := ev.Get("data")
+	if data.Type() == js.TypeString {
+		payload := []byte(data.String())
+		c.post(func() { c.OnMessage(c, Text, payload) })
+		return
+	}
+
+	arr := js.Global().Get("Uint8Array").New(data)
+	payload := make([]byte, arr.Length())
+	js.CopyBytesToGo(payload, arr)
+	c.post(func() { c.OnMessage(c, Binary, payload) })
+}
+
+// 握手失败的时候浏览器先触发error再触发close, 有的实现(比如node)只触发error
+// 握手之后的error后面一定跟着close, 交给close处理
+func (c *Conn) onError(js.Value) {
+	if c.State() != StateHandshaking {
+		return
+	}
+	c.setClosed()
+	c.release()
+	c.opened <- ErrDialFailed
+}
+
+func (c *Conn) onClose(ev js.Value) {
+	err := &CloseErrMsg{
+		Code: StatusCode(ev.Get("code").Int()),
+		Msg:  ev.Get("reason").String(),
+	}
+
+	c.setClosed()
+	c.release()
+	if !c.openDone {
+		c.opened <- err
+		return
+	}
+
+	c.closeOnce.Do(func() {
+		c.post(func() { c.OnClose(c, err) })
+	})
+}
+
+// 在浏览器的事件回调里调用, 不阻塞
+func (c *Conn) post(f func()) {
+	c.mu.Lock()
+	c.events = append(c.events, f)
+	running := c.running
+	c.running = true
+	c.mu.Unlock()
+
+	if !running {
+		go c.runEvents()
+	}
+}
+
+func (c *Conn) runEvents() {
+	for {
+		c.mu.Lock()
+		if len(c.events) == 0 {
+			c.running = false
+			c.mu.Unlock()
+			return
+		}
+		f := c.events[0]
+		c.events[0] = nil
+		c.events = c.events[1:]
+		c.mu.Unlock()
+
+		f()
+	}
+}
+
+// 解除和WebSocket对象的绑定, 在close事件里调用, 之后不会再有事件
+func (c *Conn) release() {
+	for _, event := range []string{"onopen", "onmessage", "onerror", "onclose"} {
+		c.ws.Set(event, js.Null())
+	}
+	for _, fn := range c.funcs {
+		fn.Release()
+	}
+	c.funcs = nil
+}
+
+// 发送text或者binary消息, 浏览器不允许发送控制帧, 其他的opcode返回ErrOpcode
+// 数据交给浏览器之后就返回, 浏览器内部排队发送
+func (c *Conn) WriteMessage(op Opcode, writeBuf []byte) error {
+	if op != Text && op != Binary {
+		return ErrOpcode
+	}
+	if err := c.checkWrite(op); err != nil {
+		return err
+	}
+
+	if op == Text {
+		c.ws.Call("send", string(writeBuf))
+		return nil
+	}
+
+	arr := js.Global().Get("Uint8Array").New(len(writeBuf))
+	js.CopyBytesToJS(arr, writeBuf)
+	c.ws.Call("send", arr)
+	return nil
+}
+
+// 浏览器自己决定是否压缩, compress不起作用, 只是为了和其他平台的接口一致
+func (c *Conn) WriteMessageCompressed(op Opcode, writeBuf []byte, compress bool) error {
+	return c.WriteMessage(op, writeBuf)
+}
+
+// 发送close帧, 开始关闭握手, 对端回复之后调用OnClose
+// 浏览器只允许发送1000和3000-4999的关闭码, 其他的返回ErrCloseValue
+// reason超过123字节按utf8字符截断
+func (c *Conn) WriteClose(code StatusCode, reason string) error {
+	if code != NormalClosure && (code < 3000 || code > 4999) {
+		return ErrCloseValue
+	}
+
+	if !c.casState(StateOpen, StateCloseSent) {
+		return nil
+	}
+	c.ws.Call("close", int(code), truncateCloseReason(reason))
+	return nil
+}
+
+// 浏览器自己处理关闭握手的超时, 这里不用等对端的close帧
+func (c *Conn) startCloseLinger() {}
+
+// 关闭连接, 浏览器会发送1000的close帧
+func (c *Conn) Close() {
+	if c.casState(StateOpen, StateCloseSent) || c.casState(StateHandshaking, StateCloseSent) {
+		c.ws.Call("close")
+	}
+}
@@ -0,0 +1,239 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux || darwin || netbsd || freebsd || openbsd || dragonfly
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package greatws
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// 把websocket连接包装成net.Conn, 给yamux, smux, rpc这些基于流的库使用
+// Read读到的是text/binary消息的payload, Write写出去的是binary消息
+type netConnAdapter struct {
+	c *Conn
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	buf      bytes.Buffer // 已经收到, 还没有被Read取走的数据
+	err      error        // 连接关闭的原因
+	deadline time.Time    // 读的deadline
+	timer    *wheelTimer
+
+	// 为0表示不限制
+	// 读缓冲区超过highWater暂停从fd读, 被Read取到lowWater以下再恢复
+	// 写缓冲区超过highWater时Write阻塞, 降到lowWater以下再返回
+	highWater int
+	lowWater  int
+}
+
+var _ net.Conn = (*netConnAdapter)(nil)
+
+// 返回一个net.Conn, 多次调用返回同一个对象
+// 调用之后, text/binary消息不再交给OnMessage, 按顺序追加到Read的缓冲区里
+// 之前用Conn.SetReadDeadline设置的deadline会被取消, 之后读的deadline只让Read失败, 不再关闭连接
+func (c *Conn) NetConn() net.Conn {
+	if nc := c.netConn.Load(); nc != nil {
+		return nc
+	}
+
+	nc := &netConnAdapter{c: c}
+	nc.cond = sync.NewCond(&nc.mu)
+	if !c.netConn.CompareAndSwap(nil, nc) {
+		return c.netConn.Load()
+	}
+
+	c.mu.Lock()
+	if c.readDeadlineTimer != nil {
+		c.readDeadlineTimer.Stop()
+		c.readDeadlineTimer = nil
+	}
+	c.mu.Unlock()
+	return nc
+}
+
+func (n *netConnAdapter) setWaterMarks(high, low int) {
+	n.mu.Lock()
+	n.highWater, n.lowWater = high, low
+	n.mu.Unlock()
+}
+
+// event loop里调用, 追加收到的数据
+func (n *netConnAdapter) push(payload []byte) {
+	n.mu.Lock()
+	n.buf.Write(payload)
+	if n.highWater > 0 && n.buf.Len() >= n.highWater {
+		n.c.pauseRead()
+	}
+	n.mu.Unlock()
+	n.cond.Broadcast()
+}
+
+// 写缓冲区变小了, 唤醒阻塞的Write
+// 先拿一次锁, 保证不会在Write检查完条件, 还没有Wait的时候通知
+func (n *netConnAdapter) notifyWritable() {
+	n.mu.Lock()
+	n.mu.Unlock()
+	n.cond.Broadcast()
+}
+
+// 连接关闭的时候调用, 唤醒阻塞的Read
+func (n *netConnAdapter) closeWithErr(err error) {
+	if err == nil {
+		err = io.EOF
+	}
+	n.mu.Lock()
+	if n.err == nil {
+		n.err = err
+	}
+	n.mu.Unlock()
+	n.cond.Broadcast()
+}
+
+func (n *netConnAdapter) Read(p []byte) (int, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for n.buf.Len() == 0 && n.err == nil {
+		if !n.deadline.IsZero() && !time.Now().Before(n.deadline) {
+			return 0, os.ErrDeadlineExceeded
+		}
+		n.cond.Wait()
+	}
+
+	// 连接关闭之前收到的数据, 还是要读完
+	if n.buf.Len() > 0 {
+		rn, err := n.buf.Read(p)
+		if n.highWater > 0 && n.buf.Len() <= n.lowWater {
+			n.c.resumeRead()
+		}
+		return rn, err
+	}
+	return 0, n.err
+}
+
+func (n *netConnAdapter) Write(p []byte) (int, error) {
+	if err := n.waitWritable(); err != nil {
+		return 0, err
+	}
+
+	if err := n.c.WriteMessage(Binary, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// 对端收得慢, 写缓冲区超过highWater的时候阻塞, 直到降到lowWater以下
+func (n *netConnAdapter) waitWritable() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.highWater <= 0 || n.c.pendingWriteLen() <= n.highWater {
+		return nil
+	}
+
+	for n.c.pendingWriteLen() > n.lowWater {
+		if n.err != nil {
+			return n.err
+		}
+		if n.c.writeDeadlineExceeded() {
+			return os.ErrDeadlineExceeded
+		}
+		n.cond.Wait()
+	}
+	return nil
+}
+
+// 走完整的关闭握手
+func (n *netConnAdapter) Close() error {
+	return n.c.WriteClose(NormalClosure, "")
+}
+
+func (n *netConnAdapter) LocalAddr() net.Addr {
+	if addr := n.c.LocalAddr(); addr != nil {
+		return addr
+	}
+	sa, err := unix.Getsockname(n.c.getFd())
+	if err != nil {
+		return nil
+	}
+	return sockaddrToAddr(sa)
+}
+
+func (n *netConnAdapter) RemoteAddr() net.Addr {
+	if addr := n.c.RemoteAddr(); addr != nil {
+		return addr
+	}
+	sa, err := unix.Getpeername(n.c.getFd())
+	if err != nil {
+		return nil
+	}
+	return sockaddrToAddr(sa)
+}
+
+func (n *netConnAdapter) SetDeadline(t time.Time) error {
+	if err := n.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return n.SetWriteDeadline(t)
+}
+
+// 只影响Read, 不会关闭连接
+func (n *netConnAdapter) SetReadDeadline(t time.Time) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.deadline = t
+	if n.timer != nil {
+		n.timer.Stop()
+		n.timer = nil
+	}
+	if !t.IsZero() {
+		n.timer = n.c.multiEventLoop.wheel.AfterFunc(time.Until(t), n.cond.Broadcast)
+	}
+	// 唤醒阻塞的Read, 重新检查deadline
+	n.cond.Broadcast()
+	return nil
+}
+
+func (n *netConnAdapter) SetWriteDeadline(t time.Time) error {
+	return n.c.SetWriteDeadline(t)
+}
+
+func sockaddrToAddr(sa unix.Sockaddr) net.Addr {
+	switch sa := sa.(type) {
+	case *unix.SockaddrInet4:
+		return &net.TCPAddr{IP: append(net.IP{}, sa.Addr[:]...), Port: sa.Port}
+	case *unix.SockaddrInet6:
+		var zone string
+		if sa.ZoneId != 0 {
+			if ifi, err := net.InterfaceByIndex(int(sa.ZoneId)); err == nil {
+				zone = ifi.Name
+			}
+		}
+		return &net.TCPAddr{IP: append(net.IP{}, sa.Addr[:]...), Port: sa.Port, Zone: zone}
+	case *unix.SockaddrUnix:
+		return &net.UnixAddr{Name: sa.Name, Net: "unix"}
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package greatws
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func Test_StripReservedBits(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		bits  byte
+		strip bool
+		err   error
+	}{
+		{"rsv2 fail", 1 << 5, false, ErrRsv123},
+		{"rsv3 fail", 1 << 4, false, ErrRsv123},
+		{"rsv2 strip", 1 << 5, true, nil},
+		{"rsv23 strip", 1<<5 | 1<<4, true, nil},
+		// rsv1没有协商压缩, 打开选项也不放过
+		{"rsv1 strip", 1 << 6, true, ErrRsv123},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var wire bytes.Buffer
+			appendClientFrame(t, &wire, true, false, Text, "hello")
+			wire.Bytes()[0] |= tc.bits
+
+			c, _, got := newFragmentTestConn(t, wire.Bytes())
+			c.stripRsv23 = tc.strip
+			c.decompression = false
+			if err := processAllFrames(c); !errors.Is(err, tc.err) {
+				t.Fatalf("err = %v, want %v", err, tc.err)
+			}
+			if tc.err != nil {
+				if len(*got) != 0 {
+					t.Fatalf("got = %v", *got)
+				}
+				return
+			}
+			if len(*got) != 1 || (*got)[0] != (fragmentMsg{Text, "hello"}) {
+				t.Fatalf("got = %v", *got)
+			}
+		})
+	}
+}
+
+// OnFrame收到的帧头里也不再带rsv2/rsv3
+func Test_StripReservedBitsOnFrame(t *testing.T) {
+	var wire bytes.Buffer
+	appendClientFrame(t, &wire, true, false, Binary, "abc")
+	wire.Bytes()[0] |= 1 << 5
+
+	c, _, _ := newFragmentTestConn(t, wire.Bytes())
+	c.stripRsv23 = true
+	var n int
+	c.onFrame = func(_ *Conn, h FrameHeader, payload []byte) {
+		n++
+		if h.GetRsv2() || h.GetRsv3() || string(payload) != "abc" {
+			t.Errorf("header = %+v, payload = %q", h, payload)
+		}
+	}
+	if err := processAllFrames(c); err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("onFrame called %d times", n)
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux || darwin || netbsd || freebsd || openbsd || dragonfly
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package greatws
+
+import (
+	"io"
+	"os"
+
+	"github.com/antlabs/wsutil/bytespool"
+	"github.com/antlabs/wsutil/enum"
+	"github.com/antlabs/wsutil/frame"
+	"github.com/antlabs/wsutil/opcode"
+	"golang.org/x/sys/unix"
+)
+
+// 一次sendfile最多发送的长度, 避免一个大文件长时间占住写
+const maxSendfileChunk = 1 << 20
+
+// 把文件f的[off, off+n)作为一条消息发送
+// 服务端不需要掩码, 也不压缩, 先写帧头, 再用sendfile分块发送, 数据不经过用户空间
+// 写不进内核的部分由事件循环在可写的时候继续发送
+// 客户端(需要掩码)或者io_uring模式下, 先把文件读到内存再按WriteMessage发送
+// f会被dup一份, 调用返回之后就可以关闭, text消息不做utf8检查
+func (c *Conn) WriteMessageFromFile(op Opcode, f *os.File, off, n int64) (err error) {
+	if op != opcode.Text && op != opcode.Binary {
+		return ErrOpcode
+	}
+
+	if err = c.checkWrite(op); err != nil {
+		return err
+	}
+
+	if c.writeDeadlineExceeded() {
+		return os.ErrDeadlineExceeded
+	}
+
+	if c.maskFrames() || c.useIoUring() || c.mem != nil || n == 0 {
+		payload := make([]byte, n)
+		if _, err = f.ReadAt(payload, off); err != nil {
+			return err
+		}
+		return c.WriteMessage(op, payload)
+	}
+
+	if err = c.checkWriteBuffer(op, int(n)); err != nil {
+		return err
+	}
+
+	if err = c.admitMessage(); err != nil {
+		return err
+	}
+
+	dup, err := dupFile(f)
+	if err != nil {
+		return err
+	}
+
+	buf := bytespool.GetBytes(enum.MaxFrameHeaderSize)
+	wIndex, err := frame.WriteHeader(*buf, true, false, false, false, op, int(n), false, 0)
+	if err != nil {
+		bytespool.PutBytes(buf)
+		dup.Close()
+		return err
+	}
+	c.traceWriteLen(n, nil, true, false, op, 0)
+
+	fr := outboundFramePool.Get().(*outboundFrame)
+	fr.buf, fr.n = buf, wIndex
+	fr.file, fr.off, fr.size = dup, off, n
+	c.outq.Push(fr)
+	return c.drainOutbound()
+}
+
+// dup一份文件, 不影响调用方关闭f
+func dupFile(f *os.File) (*os.File, error) {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		newFd  int
+		dupErr error
+	)
+	if err = rc.Control(func(fd uintptr) {
+		newFd, dupErr = unix.Dup(int(fd))
+	}); err != nil {
+		return nil, err
+	}
+	if dupErr != nil {
+		return nil, dupErr
+	}
+	return os.NewFile(uintptr(newFd), f.Name()), nil
+}
+
+// 发送文件的一部分, 返回发送的长度
+func sendfile(fd int, f *os.File, off int64, n int) (written int, err error) {
+	if n > maxSendfileChunk {
+		n = maxSendfileChunk
+	}
+
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+
+	if cerr := rc.Control(func(in uintptr) {
+		written, err = unix.Sendfile(fd, int(in), &off, n)
+	}); cerr != nil {
+		return 0, cerr
+	}
+
+	// 文件被截断了, 再也发不完
+	if written == 0 && err == nil {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return written, err
+}
@@ -0,0 +1,95 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package greatws
+
+import "sync/atomic"
+
+// 连接的状态
+type ConnState int32
+
+const (
+	StateHandshaking   ConnState = iota // 握手还没有完成, 正常情况下拿到的Conn不会是这个状态
+	StateOpen                           // 可以正常收发消息
+	StateCloseSent                      // 本端先发送了close帧, 等待对端的close帧, 不能再发送任何帧
+	StateCloseReceived                  // 对端先发送了close帧, 本端回应之后关闭, 不能再发送任何帧
+	StateClosed                         // 已经关闭
+)
+
+func (s ConnState) String() string {
+	switch s {
+	case StateHandshaking:
+		return "handshaking"
+	case StateOpen:
+		return "open"
+	case StateCloseSent:
+		return "closing-sent"
+	case StateCloseReceived:
+		return "closing-received"
+	case StateClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+// 返回连接当前的状态
+func (c *Conn) State() ConnState {
+	return ConnState(atomic.LoadInt32(&c.state))
+}
+
+// 连接是否已经关闭
+func (c *Conn) IsClosed() bool {
+	return c.isClosed()
+}
+
+func (c *Conn) isClosed() bool {
+	return c.State() == StateClosed
+}
+
+// 本端发送过close帧
+func (c *Conn) isCloseSent() bool {
+	return c.State() == StateCloseSent
+}
+
+// 正在关闭握手中, 不管是哪一端先发起的
+func (c *Conn) isClosing() bool {
+	s := c.State()
+	return s == StateCloseSent || s == StateCloseReceived
+}
+
+func (c *Conn) casState(old, new ConnState) bool {
+	return atomic.CompareAndSwapInt32(&c.state, int32(old), int32(new))
+}
+
+func (c *Conn) setClosed() {
+	atomic.StoreInt32(&c.state, int32(StateClosed))
+}
+
+// 用户发送帧之前检查状态, 只有open状态可以发送
+// 发送close帧会进入StateCloseSent, 开始等对端的close帧, 之后再发送任何帧都返回ErrWriteClosed
+func (c *Conn) checkWrite(op Opcode) error {
+	if op == Close {
+		if c.casState(StateOpen, StateCloseSent) {
+			c.startCloseLinger()
+			return nil
+		}
+	} else if c.State() == StateOpen {
+		return nil
+	}
+
+	if c.isClosed() {
+		return ErrClosed
+	}
+	return ErrWriteClosed
+}
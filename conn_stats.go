@@ -0,0 +1,91 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// 连接的流量统计, 通过Conn.Stats获取
+// OnClose里拿到的是最终值: 调用OnClose之前fd已经从事件循环里移除, 不会再有读写计入
+type ConnStats struct {
+	BytesRead    uint64        // 从fd读到的字节数, 包括frame头
+	BytesWritten uint64        // 写进fd的字节数, 包括frame头
+	OpenedAt     time.Time     // 握手完成的时间
+	ClosedAt     time.Time     // 关闭的时间, 还没有关闭是零值
+	Duration     time.Duration // 连接的时长, 还没有关闭的话算到现在
+
+	MessagesDropped uint64 // 积压超过上限时按DropPolicy丢掉的消息数
+
+	PendingWrites int // io_uring模式下已经提交还没有完成的写请求数, 其他模式是0
+}
+
+type connStats struct {
+	bytesRead    atomic.Uint64
+	bytesWritten atomic.Uint64
+	openedAt     time.Time // newConn里设置, 之后不再修改
+	closedAt     atomic.Int64
+	dropped      atomic.Uint64
+}
+
+func (s *connStats) addRead(n int) {
+	if n > 0 {
+		s.bytesRead.Add(uint64(n))
+	}
+}
+
+func (s *connStats) addWritten(n int) {
+	if n > 0 {
+		s.bytesWritten.Add(uint64(n))
+	}
+}
+
+func (s *connStats) addDropped() {
+	s.dropped.Add(1)
+}
+
+func (s *connStats) markOpened() {
+	s.openedAt = time.Now()
+}
+
+// 在调用OnClose之前设置
+func (s *connStats) markClosed() {
+	s.closedAt.CompareAndSwap(0, time.Now().UnixNano())
+}
+
+// 可以在任意go程里调用, 包括OnClose, 连接关闭之后也可以调用
+func (c *Conn) Stats() ConnStats {
+	st := ConnStats{
+		BytesRead:    c.stats.bytesRead.Load(),
+		BytesWritten: c.stats.bytesWritten.Load(),
+		OpenedAt:     c.stats.openedAt,
+
+		MessagesDropped: c.stats.dropped.Load(),
+
+		PendingWrites: int(c.inflight.Load()),
+	}
+
+	end := time.Now()
+	if ns := c.stats.closedAt.Load(); ns != 0 {
+		st.ClosedAt = time.Unix(0, ns)
+		end = st.ClosedAt
+	}
+	st.Duration = end.Sub(st.OpenedAt)
+	return st
+}
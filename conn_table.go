@@ -0,0 +1,93 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// 事件循环里fd到连接的表
+// fd已经按loop数取模分配, 下标是紧凑的, 直接用数组下标, 不需要hash和探测
+// 读不加锁: 先原子地加载整张表, 再原子地读一个槽位, 百万连接时每个事件查一次也没有分配和锁竞争
+// 写(保存, 删除, 扩容)用mu串行, 扩容时拷贝到新表再原子地替换, 正在读旧表的go程看到的是替换之前的数据
+type connTable struct {
+	mu    sync.Mutex
+	slots atomic.Pointer[[]atomic.Pointer[Conn]]
+}
+
+func (t *connTable) table() []atomic.Pointer[Conn] {
+	if p := t.slots.Load(); p != nil {
+		return *p
+	}
+	return nil
+}
+
+// 获取连接, 不存在返回nil
+func (t *connTable) load(index int) *Conn {
+	slots := t.table()
+	if index < 0 || index >= len(slots) {
+		return nil
+	}
+	return slots[index].Load()
+}
+
+// 保存连接, 空间不够时扩容
+func (t *connTable) store(index int, c *Conn) {
+	t.mu.Lock()
+	slots := t.table()
+	if index >= len(slots) {
+		newLen := len(slots) * 2
+		if newLen <= index {
+			newLen = index + 1
+		}
+		if newLen < 64 {
+			newLen = 64
+		}
+		newSlots := make([]atomic.Pointer[Conn], newLen)
+		for i := range slots {
+			newSlots[i].Store(slots[i].Load())
+		}
+		t.slots.Store(&newSlots)
+		slots = newSlots
+	}
+	slots[index].Store(c)
+	t.mu.Unlock()
+}
+
+// 只删除c自己, 槽位已经被别的连接占用的话什么都不做
+func (t *connTable) delete(index int, c *Conn) {
+	t.mu.Lock()
+	slots := t.table()
+	if index >= 0 && index < len(slots) {
+		slots[index].CompareAndSwap(c, nil)
+	}
+	t.mu.Unlock()
+}
+
+// 当前所有连接的快照
+func (t *connTable) snapshot() []*Conn {
+	slots := t.table()
+	conns := make([]*Conn, 0, len(slots))
+	for i := range slots {
+		if c := slots[i].Load(); c != nil {
+			conns = append(conns, c)
+		}
+	}
+	return conns
+}
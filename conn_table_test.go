@@ -0,0 +1,105 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package greatws
+
+import (
+	"sync"
+	"testing"
+)
+
+func Test_ConnTable(t *testing.T) {
+	var tab connTable
+	if tab.load(0) != nil || tab.load(-1) != nil {
+		t.Fatal("empty table")
+	}
+
+	a, b := &Conn{}, &Conn{}
+	tab.store(3, a)
+	// 扩容之后原来的连接还在
+	tab.store(1000, b)
+	if tab.load(3) != a || tab.load(1000) != b {
+		t.Fatal("load after grow")
+	}
+
+	// 槽位被新连接占用时不删除
+	tab.delete(3, b)
+	if tab.load(3) != a {
+		t.Fatal("deleted other conn")
+	}
+	tab.delete(3, a)
+	if tab.load(3) != nil {
+		t.Fatal("not deleted")
+	}
+
+	if conns := tab.snapshot(); len(conns) != 1 || conns[0] != b {
+		t.Fatalf("snapshot = %v", conns)
+	}
+}
+
+// 扩容和读并发, go test -race检查
+func Test_ConnTableConcurrent(t *testing.T) {
+	var tab connTable
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 10000; i++ {
+			tab.store(i, &Conn{})
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 10000; i++ {
+			tab.load(i)
+		}
+	}()
+	wg.Wait()
+
+	for i := 0; i < 10000; i++ {
+		if tab.load(i) == nil {
+			t.Fatalf("conn %d lost", i)
+		}
+	}
+}
+
+const benchConnNum = 1 << 20
+
+func Benchmark_ConnTable_Load(b *testing.B) {
+	var tab connTable
+	for i := 0; i < benchConnNum; i++ {
+		tab.store(i, &Conn{})
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			if tab.load(i&(benchConnNum-1)) == nil {
+				b.Fatal("conn not found")
+			}
+			i += 7919
+		}
+	})
+}
+
+// 对比用, 用sync.Map按fd保存连接, 百万连接时查找明显更慢
+func Benchmark_SyncMap_Load(b *testing.B) {
+	var m sync.Map
+	for i := 0; i < benchConnNum; i++ {
+		m.Store(i, &Conn{})
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			if _, ok := m.Load(i & (benchConnNum - 1)); !ok {
+				b.Fatal("conn not found")
+			}
+			i += 7919
+		}
+	})
+}
@@ -0,0 +1,64 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"sync/atomic"
+)
+
+// 每隔tickInterval在业务go程里调用一次onTick, 挂在时间轮上, 不需要每个连接一个go程加ticker
+// 上一次的onTick还没有返回时跳过这一次, 不会堆积
+func (c *Conn) startTick() {
+	if c.onTick == nil || c.tickInterval <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	if !c.isClosed() {
+		c.tickTimer = c.multiEventLoop.wheel.AfterFunc(c.tickInterval, c.tick)
+	}
+	c.mu.Unlock()
+}
+
+func (c *Conn) tick() {
+	if c.isClosed() {
+		return
+	}
+
+	if atomic.CompareAndSwapInt32(&c.ticking, 0, 1) {
+		c.waitOnMessageRun.Add(1)
+		c.multiEventLoop.t.addTask(func() (exit bool) {
+			defer c.waitOnMessageRun.Done()
+			defer atomic.StoreInt32(&c.ticking, 0)
+			c.onTick(c)
+			return false
+		})
+	}
+
+	c.mu.Lock()
+	if !c.isClosed() {
+		c.tickTimer = c.multiEventLoop.wheel.AfterFunc(c.tickInterval, c.tick)
+	}
+	c.mu.Unlock()
+}
+
+func (c *Conn) stopTick() {
+	if c.tickTimer != nil {
+		c.tickTimer.Stop()
+	}
+}
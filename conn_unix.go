@@ -4,20 +4,21 @@
 package greatws
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
-	"math"
+	"net"
 	"sync"
 	"sync/atomic"
+	"time"
 	"unsafe"
 
 	"github.com/antlabs/wsutil/bytespool"
 	"github.com/antlabs/wsutil/enum"
 	"github.com/antlabs/wsutil/fixedwriter"
 	"github.com/antlabs/wsutil/frame"
-	"github.com/antlabs/wsutil/mask"
 	"github.com/antlabs/wsutil/opcode"
 	"golang.org/x/sys/unix"
 )
@@ -45,14 +46,17 @@ func (s ioUringOpState) String() string {
 }
 
 type ioUringWrite struct {
-	free     func()
-	writeBuf []byte
+	free      func()
+	writeBuf  []byte
+	submitted int64 // 提交sqe的时间, UnixNano, 超过写超时还没有完成的会被取消
+	sent      bool  // SEND_ZC已经收到了第一个cqe, 还在等通知的cqe
 }
 
 // 只存放io-uring相关的控制信息
 type onlyIoUringState struct {
-	wSeq uint32
-	m    sync.Map
+	wSeq     uint32
+	m        sync.Map
+	inflight atomic.Int32 // m里还没有完成的写请求数
 }
 
 type Conn struct {
@@ -61,14 +65,152 @@ type Conn struct {
 	// 存在io-uring相关的控制信息
 	onlyIoUringState
 
-	wbuf             []byte // 写缓冲区, 当直接Write失败时，会将数据写入缓冲区
+	wbuf             writeQueue    // 写缓冲区, 当直接Write失败时，会将数据写入缓冲区
+	outq             outboundQueue // 发送队列, 编码好的帧先放这里, 再由一个go程写到fd
+	writing          int32         // 是否有go程正在把发送队列写到fd
 	mu               sync.Mutex
 	client           bool  // 客户端为true，服务端为false
 	*Config                // 配置
-	closed           int32 // 是否关闭
+	state            int32 // 连接的状态, 见ConnState
 	waitOnMessageRun sync.WaitGroup
 	closeOnce        sync.Once
 	parent           *EventLoop
+	hijack           atomic.Pointer[hijackState] // 不为nil时, 不再解析websocket frame
+
+	readDeadlineTimer  *wheelTimer // 读的deadline
+	writeDeadlineTimer *wheelTimer // 写的deadline
+	writeDeadline      int64       // 写的deadline, UnixNano, 0表示没有设置
+
+	netConn atomic.Pointer[netConnAdapter] // 调用NetConn之后, 消息交给它而不是OnMessage
+
+	pingTimer   *wheelTimer // 客户端心跳, 发送ping的定时器
+	pongTimer   *wheelTimer // 客户端心跳, 等待pong的定时器
+	pongPending int32       // 已经发送ping, 还没有收到pong
+
+	inflateDict []byte                 // 对端保留压缩上下文时, 最近一个窗口的解压数据
+	lastPing    atomic.Pointer[[]byte] // 最近一次发送的ping的payload, 用于校验pong
+
+	tickTimer *wheelTimer // OnTick的定时器
+	ticking   int32       // OnTick的回调还没有返回
+
+	proxyPeer atomic.Pointer[Conn] // 调用Proxy之后, 消息直接转发给它
+
+	readPaused  int32 // 暂停从fd读数据, 让内核的接收缓冲区填满, 由tcp把压力传给对端
+	readPending int32 // 暂停期间有可读事件, 恢复的时候需要重新触发
+
+	reason atomic.Pointer[closeReason] // 第一个导致关闭的错误, OnClose只带这一个
+
+	gen uint32 // 加入事件循环时分配的代数, 和fd一起标识连接, fd被复用之后旧连接的事件会被丢弃
+
+	mailboxMu sync.Mutex
+	mailbox   mailbox // WithOrderedCallback时, 排队等待执行的消息
+
+	session any // OnAccept返回的attach
+
+	stats connStats // 流量统计, 见Stats
+
+	maskKey      MaskKeyFunc // 客户端生成掩码的函数, 为空使用math/rand
+	maskOverride *bool       // 只给测试用, 不为空时代替client决定发出去的帧是否加掩码
+
+	localAddr  net.Addr // 建立连接的时候保存, 之后只读
+	remoteAddr net.Addr
+
+	mem *memEndpoint // Pipe建立的内存连接, 不为nil时读写不经过fd
+
+	queued       int64          // 交给Write的总字节数, 减去写缓冲区的长度就是已经写到内核的字节数, c.mu保护
+	writeWaiters []*writeWaiter // WriteMessageDeadline等待写到内核的帧, c.mu保护
+
+	pendingLimit  int          // 写缓冲区里最多积压的数据消息条数, 0表示不限制, c.mu保护
+	pendingPolicy DropPolicy   // 超过pendingLimit时的处理方式, c.mu保护
+	pendingMsgs   []pendingMsg // 写缓冲区里还没有写完的数据消息, 按发送的顺序, c.mu保护
+	pendingCond   *sync.Cond   // Block等待积压的消息变少, 第一次等待时创建
+
+	decodeOffloaded bool // 正在业务go程池里解压一条消息, 只在事件循环里读写
+	writeDeferred   bool // 超出事件循环写的预算, 在推迟队列里等下一轮, 只在事件循环里读写
+
+	sysWrite func(fd int, p []byte) (int, error) // 写fd的系统调用, 为空使用unix.Write, 测试里替换成短写, 返回EINTR/EAGAIN的版本
+
+	peerEOF int32 // 读到过eof, 已经发起了关闭握手, 之后不再读fd, 原子读写
+}
+
+type hijackState struct {
+	onRead func(c *Conn, data []byte)
+}
+
+// 摘掉websocket的帧解析, 之后读到的原始数据都交给onRead
+// onRead在event loop里同步调用, data只在回调期间有效, 回调里不要阻塞
+// 写数据使用WriteRaw, 关闭时仍然调用Callback.OnClose
+// 最好在握手成功之后, 对端发送自定义协议的数据之前调用
+func (c *Conn) Hijack(onRead func(c *Conn, data []byte)) error {
+	if c.isClosed() {
+		return ErrClosed
+	}
+	if c.useIoUring() {
+		return ErrHijackIoUring
+	}
+	if !c.hijack.CompareAndSwap(nil, &hijackState{onRead: onRead}) {
+		return ErrHijacked
+	}
+	return nil
+}
+
+func (c *Conn) isHijacked() bool {
+	return c.hijack.Load() != nil
+}
+
+// Hijack之后, 直接写原始数据
+func (c *Conn) WriteRaw(b []byte) (n int, err error) {
+	if c.isClosed() {
+		return 0, ErrClosed
+	}
+	if !c.isHijacked() {
+		return 0, ErrNotHijacked
+	}
+
+	c.mu.Lock()
+	n, err = c.Write(b)
+	c.mu.Unlock()
+	return n, err
+}
+
+// 把读缓冲区里的数据交给onRead
+func (c *Conn) deliverRaw() {
+	a, b := c.rbuf.Segments()
+	if len(a) > 0 {
+		c.hijack.Load().onRead(c, a)
+	}
+	if len(b) > 0 {
+		c.hijack.Load().onRead(c, b)
+	}
+	c.rbuf.Reset()
+	c.curState = frameStateHeaderStart
+}
+
+// Hijack之后的读取逻辑, 不解析frame
+func (c *Conn) processRaw() (n int, err error) {
+	// 先把之前没有解析完的数据交出去
+	c.deliverRaw()
+	for {
+		fd := atomic.LoadInt64(&c.fd)
+		n, err = unix.Read(int(fd), c.rbuf.WriteSlice())
+		if err != nil {
+			if errors.Is(err, unix.EINTR) {
+				continue
+			}
+			if !errors.Is(err, unix.EAGAIN) && !errors.Is(err, unix.EWOULDBLOCK) {
+				return 0, err
+			}
+			return 0, nil
+		}
+
+		if n == 0 {
+			return 0, io.EOF
+		}
+
+		c.stats.addRead(n)
+		c.rbuf.Commit(n)
+		c.deliverRaw()
+	}
 }
 
 func (c *Conn) setParent(el *EventLoop) {
@@ -80,18 +222,33 @@ func (c *Conn) getParent() *EventLoop {
 }
 
 func newConn(fd int64, client bool, conf *Config) *Conn {
-	rbuf := bytespool.GetBytes(conf.initPayloadSize())
 	c := &Conn{
 		conn: conn{
 			fd:   fd,
-			rbuf: rbuf,
+			rbuf: newRingBuffer(bytespool.GetBytes(conf.initPayloadSize())),
 		},
-		// 初始化不分配内存，只有在需要的时候才分配
-		// wbuf:   make([]byte, 0, 1024),
+		// 写缓冲区初始化不分配内存，只有在需要的时候才从池子里取
 		Config: conf,
 		client: client,
+		state:  int32(StateOpen),
+
+		pendingLimit:  conf.maxPendingMsgs,
+		pendingPolicy: conf.dropPolicy,
+	}
+	c.stats.markOpened()
+	if client && conf.newMaskKey != nil {
+		c.maskKey = conf.newMaskKey()
 	}
 
+	l := conf.logger
+	if l != nil {
+		c.connLogger.Store(l)
+	} else if conf.multiEventLoop != nil {
+		l = conf.multiEventLoop.Logger
+	}
+	if l != nil {
+		c.debug.Store(l.Enabled(context.Background(), slog.LevelDebug))
+	}
 	return c
 }
 
@@ -105,17 +262,52 @@ func (c *Conn) closeInner(wait bool, err error) {
 		c.waitOnMessageRun.Wait()
 	}
 
+	if c.lingerTimer != nil {
+		c.lingerTimer.Stop()
+		c.lingerTimer = nil
+	}
+	c.stopDeadlineTimers()
+	c.stopHeartbeat()
+	c.stopTick()
+	if nc := c.netConn.Load(); nc != nil {
+		nc.closeWithErr(err)
+	}
+	c.proxyClose(err)
+	c.releaseWriteWaiters()
+	c.releasePendingMsgs()
+
+	// 关闭握手已经完成, 先发送FIN再关闭, 避免对端收到RST
+	if c.isClosing() {
+		if fd := atomic.LoadInt64(&c.fd); fd != -1 {
+			unix.Shutdown(int(fd), unix.SHUT_WR)
+		}
+	}
+
+	if c.mem != nil {
+		c.mem.close()
+	}
 	c.multiEventLoop.del(c)
 	atomic.StoreInt64(&c.fd, -1)
+	c.setCloseReason(err)
+	c.stats.markClosed()
 	c.closeOnce.Do(func() {
-		c.OnClose(c, nil)
+		c.OnClose(c, c.getCloseReason())
 		atomic.StorePointer((*unsafe.Pointer)((unsafe.Pointer)(&c.parent)), nil)
 	})
-	atomic.StoreInt32(&c.closed, 1)
+	c.setClosed()
+}
+
+// 事件循环已经停止之后调用, 把读缓冲区还给池子
+func (c *Conn) releaseReadBuffer() {
+	if c.rbuf.buf == nil {
+		return
+	}
+	c.putRbuf(c.rbuf.buf)
+	c.rbuf = ringBuffer{}
 }
 
 func (c *Conn) closeAndWaitOnMessage(wait bool, err error) {
-	if atomic.LoadInt32(&c.closed) == 1 {
+	if c.isClosed() {
 		return
 	}
 	if wait {
@@ -123,70 +315,178 @@ func (c *Conn) closeAndWaitOnMessage(wait bool, err error) {
 	}
 
 	c.mu.Lock()
-	c.closeInner(false, err)
+	// 多个go程同时关闭的时候, 只有第一个做清理
+	if !c.isClosed() {
+		c.closeInner(false, err)
+	}
 	c.mu.Unlock()
 }
 
+// 在另外的go程里关闭连接, 调用的地方可能持有c.mu, 或者在事件循环里不能等待OnMessage
+// TestEventLoop里投递到事件循环, 在同一次Tick里关闭, 结果可以复现
+func (c *Conn) asyncClose(err error) {
+	c.runAsync(func() { c.closeAndWaitOnMessage(true, err) })
+}
+
+func (c *Conn) runAsync(f func()) {
+	if el := c.getParent(); el != nil && el.parent != nil && el.parent.manual {
+		if el.Execute(f) == nil {
+			return
+		}
+	}
+	go f()
+}
+
+// 对端shutdown(SHUT_WR)之后读到eof, 这时读缓冲区里的帧已经全部交给了回调
+// 等OnMessage执行完, 回复的数据和写缓冲区里积压的数据都排在close帧前面, close帧写到内核之后再关闭连接
+// 对端已经发过close帧, 或者本端在等对端的close帧时, 不再发送
+// eof之后fd可能还会被报告可读(写缓冲区满了重新注册事件, 或者delWrite之后变成水平触发), 只处理第一次,
+// 否则第二次看到的状态已经不是StateOpen, 会直接关闭连接, 丢掉排队中的close帧
+func (c *Conn) closeOnPeerEOF() {
+	if !atomic.CompareAndSwapInt32(&c.peerEOF, 0, 1) {
+		return
+	}
+	c.setCloseReason(io.EOF)
+	c.runAsync(func() {
+		if c.isClosed() {
+			return
+		}
+		c.waitOnMessageRun.Wait()
+		if c.State() == StateOpen {
+			c.WriteMessageDeadline(Close, statusCodeToBytes(NormalClosure), time.Now().Add(2*time.Second))
+		}
+		c.closeAndWaitOnMessage(false, io.EOF)
+	})
+}
+
 func (c *Conn) Close() {
 	c.closeAndWaitOnMessage(false, nil)
 }
 
+// 返回b里被接收的字节数, 包括写到内核的和放进写缓冲区等可写事件的
+// 出错的时候连接会被关闭, 只统计b里已经写到内核的部分
 func (c *Conn) Write(b []byte) (n int, err error) {
-	// 如果缓冲区有数据，合并数据
-	curN := len(b)
-
-	if len(c.wbuf) > 0 {
-		c.wbuf = append(c.wbuf, b...)
-		b = c.wbuf
-	}
-	_, err = c.writeOrAddPoll(b)
-	if err != nil {
-		return 0, err
-	}
-	// 出错
-	return curN, err
+	c.queued += int64(len(b))
+	return c.writeOrAddPoll(b)
 }
 
+// 直接写入b, 写不完的部分放到写缓冲区, 等可写事件
 func (c *Conn) writeOrAddPoll(b []byte) (n int, err error) {
-	total := 0
-	// i 的目的是debug的时候使用
-	for i := 0; len(b) > 0; i++ {
-
-		// 直接写入数据
-		n, err = unix.Write(int(c.fd), b)
-		// fmt.Printf("1.write %d:%v: %d\n", n, err, len(b))
+	if c.mem != nil {
+		return c.mem.write(b)
+	}
+	// 持有c.mu, 关闭也在c.mu里, fd是-1说明已经关闭, 原来的fd可能已经被别的连接复用
+	if atomic.LoadInt64(&c.fd) == -1 {
+		return 0, ErrClosed
+	}
 
+	// 缓冲区有数据, 排在后面, 保证顺序
+	if pending := c.wbuf.Len(); pending > 0 {
+		c.wbuf.Append(b)
+		total, err := c.flushLimit(-1)
 		if err != nil {
-			// 如果是EAGAIN或EINTR错误，说明是写缓冲区满了，或者被信号中断，将数据写入缓冲区
-			if errors.Is(err, unix.EAGAIN) || errors.Is(err, unix.EINTR) {
-				if n < 0 {
-					n = 0
-				}
-				if len(b) > 0 {
-					// TODO sync.Pool
-					newBuf := make([]byte, len(b)-n)
-					copy(newBuf, b[n:])
+			// 先写出去的是缓冲区里原来的数据
+			return max(total-pending, 0), err
+		}
+		return len(b), nil
+	}
 
-					c.wbuf = newBuf
-				}
+	total := 0
+	for len(b) > 0 {
+		// 出错的时候返回的n是-1, 不能直接累加
+		n, err = c.writeFd(b)
+		if c.debugEnabled() {
+			c.getLogger().Debug("write", slog.Int64("fd", c.fd), slog.Int("n", n), slog.Int("b.len", len(b)), slog.Any("err", err))
+		}
+		if n > 0 {
+			c.stats.addWritten(n)
+			b = b[n:]
+			total += n
+		}
 
+		if err != nil {
+			// 被信号中断, 重新写
+			if errors.Is(err, unix.EINTR) {
+				continue
+			}
+			// 内核的写缓冲区满了, 剩下的放到写缓冲区, 等可写事件
+			if errors.Is(err, unix.EAGAIN) {
+				c.wbuf.Append(b)
 				if err = c.multiEventLoop.addWrite(c, 0); err != nil {
-					return 0, err
+					return total, err
 				}
-				return total, nil
+				return total + len(b), nil
 			}
 			c.getLogger().Error("writeOrAddPoll", "err", err.Error(), slog.Int64("fd", c.fd), slog.Int("b.len", len(b)))
-			go c.closeInner(true, err)
-			return
+			c.setCloseReason(err)
+			c.asyncClose(err)
+			return total, err
 		}
+	}
+
+	return total, nil
+}
+
+func (c *Conn) writeFd(b []byte) (int, error) {
+	if c.sysWrite != nil {
+		return c.sysWrite(int(c.fd), b)
+	}
+	return unix.Write(int(c.fd), b)
+}
+
+// 把写缓冲区里的数据一段一段写出去, 写不完继续等可写事件
+func (c *Conn) flush() (err error) {
+	_, err = c.flushLimit(-1)
+	return err
+}
+
+// 最多写出limit个字节, 小于0表示不限制, 返回写出的字节数
+func (c *Conn) flushLimit(limit int) (total int, err error) {
+	if atomic.LoadInt64(&c.fd) == -1 {
+		return 0, ErrClosed
+	}
+	for c.wbuf.Len() > 0 {
+		left := limit - total
+		if limit >= 0 && left <= 0 {
+			return total, nil
+		}
+
+		var n int
+		if f, off, size := c.wbuf.FrontFile(); f != nil {
+			if limit >= 0 && size > left {
+				size = left
+			}
+			n, err = sendfile(int(c.fd), f, off, size)
+		} else {
+			b := c.wbuf.Front()
+			if limit >= 0 && len(b) > left {
+				b = b[:left]
+			}
+			n, err = c.writeFd(b)
+		}
+		if c.debugEnabled() {
+			c.getLogger().Debug("flush", slog.Int64("fd", c.fd), slog.Int("n", n), slog.Int("pending", c.wbuf.Len()), slog.Any("err", err))
+		}
+
+		// sendfile遇到EAGAIN的时候也可能已经发送了一部分
 		if n > 0 {
-			b = b[n:]
+			c.stats.addWritten(n)
+			c.wbuf.Advance(n)
 			total += n
 		}
-	}
 
-	if len(c.wbuf) == total {
-		c.wbuf = nil
+		if err != nil {
+			if errors.Is(err, unix.EINTR) {
+				continue
+			}
+			if errors.Is(err, unix.EAGAIN) {
+				return total, c.multiEventLoop.addWrite(c, 0)
+			}
+			c.getLogger().Error("flush", "err", err.Error(), slog.Int64("fd", c.fd), slog.Int("pending", c.wbuf.Len()))
+			c.setCloseReason(err)
+			c.asyncClose(err)
+			return total, err
+		}
 	}
 	return total, nil
 }
@@ -196,11 +496,24 @@ func (c *Conn) writeOrAddPoll(b []byte) (n int, err error) {
 // EAGAIN，等待可写再写
 // 报错，直接关闭这个fd
 func (c *Conn) flushOrClose() (err error) {
+	_, _, err = c.flushOrCloseLimit(-1)
+	return err
+}
+
+// 和flushOrClose一样, 最多写出limit个字节, 返回写出的字节数, more表示写到limit时缓冲区里还有数据
+func (c *Conn) flushOrCloseLimit(limit int) (n int, more bool, err error) {
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	n, err = c.flushLimit(limit)
+	more = limit >= 0 && n >= limit && c.wbuf.Len() > 0
+	c.notifyWriteWaiters()
+	c.trimPendingMsgs()
+	c.mu.Unlock()
 
-	_, err = c.writeOrAddPoll(c.wbuf)
-	return err
+	// 在锁外通知, 等待写缓冲区变小的go程
+	if nc := c.netConn.Load(); nc != nil {
+		nc.notifyWritable()
+	}
+	return n, more, err
 }
 
 // kqueu/epoll模式下，读取数据
@@ -209,13 +522,44 @@ func (c *Conn) flushOrClose() (err error) {
 // 1. 缓冲区空间不句够，需要扩容
 // 2. 缓冲区数据不够，并且一次性读取了多个frame
 func (c *Conn) processWebsocketFrame() (n int, err error) {
+	c.assertInLoop("read buffer")
+	// 解压完成之后会在事件循环里重新调用, 这期间到达的数据不会丢
+	// 读到过eof的话, 帧都已经交给了回调, 关闭握手由closeOnPeerEOF完成, 不用再读
+	if c.decodeOffloaded || c.readBlocked() || atomic.LoadInt32(&c.peerEOF) == 1 {
+		return 0, nil
+	}
+
+	if c.isHijacked() {
+		return c.processRaw()
+	}
+
+	eof := false
 	// 1. 处理frame header
 	if !c.useIoUring() {
 		// 不使用io_uring的直接调用read获取buffer数据
 		for i := 0; ; i++ {
 			fd := atomic.LoadInt64(&c.fd)
-			n, err = unix.Read(int(fd), (*c.rbuf)[c.rw:])
-			// fmt.Printf("i = %d, n = %d, fd = %d, rbuf = %d, rw:%d, err = %v, %v, payload:%d\n", i, n, c.fd, len((*c.rbuf)[c.rw:]), c.rw+n, err, time.Now(), c.rh.PayloadLen)
+			ws := c.rbuf.WriteSlice()
+			if len(ws) == 0 {
+				// 缓冲区满了, 先解析frame腾出空间
+				// 如果使用epoll ET mode，需要继续读取，直到返回EAGAIN, 不然会丢失数据
+				// frame头最多14字节, 比payload大的缓冲区会在readPayload里扩容, 所以解析之后一定有空闲空间
+				if _, err := c.readHeader(); err != nil {
+					return 0, fmt.Errorf("read header err: %w", err)
+				}
+				if _, err := c.readPayloadAndCallback(); err != nil {
+					return 0, fmt.Errorf("read header err: %w", err)
+				}
+				if c.decodeOffloaded {
+					return 0, nil
+				}
+				continue
+			}
+
+			n, err = unix.Read(int(fd), ws)
+			if c.debugEnabled() {
+				c.getLogger().Debug("read", slog.Int64("fd", fd), slog.Int("n", n), slog.Any("err", err))
+			}
 			if err != nil {
 				// 信号中断，继续读
 				if errors.Is(err, unix.EINTR) {
@@ -230,40 +574,43 @@ func (c *Conn) processWebsocketFrame() (n int, err error) {
 				break
 			}
 
-			// 读到eof，直接关闭
-			if n == 0 && len((*c.rbuf)[c.rw:]) > 0 {
-				go c.closeAndWaitOnMessage(true, io.EOF)
-				c.OnClose(c, io.EOF)
-				return
-			}
-
-			if n > 0 {
-				c.rw += n
+			// 读到eof, 对端关闭了写, 先把已经读到的帧解析完, 再关闭
+			if n == 0 {
+				eof = true
+				break
 			}
 
-			if len((*c.rbuf)[c.rw:]) == 0 {
-				// 说明缓存区已经满了。需要扩容
-				// 并且如果使用epoll ET mode，需要继续读取，直到返回EAGAIN, 不然会丢失数据
-				// 结合以上两种，缓存区满了就直接处理frame，解析出payload的长度，得到一个刚刚好的缓存区
-				// for i := 0; len((*c.rbuf)[c.rw:]) == 0 && i < 3; i++ {
-				if _, err := c.readHeader(); err != nil {
-					return 0, fmt.Errorf("read header err: %w", err)
-				}
-				if _, err := c.readPayloadAndCallback(); err != nil {
-					return 0, fmt.Errorf("read header err: %w", err)
-				}
-
-				// TODO
-				if len((*c.rbuf)[c.rw:]) == 0 {
-					//
-					//panic(fmt.Sprintf("需要扩容:rw(%d):rr(%d):currState(%v)", c.rw, c.rr, c.curState.String()))
+			if ch := c.getChaos(); ch != nil {
+				if keep := ch.afterRead(n); keep < n {
+					c.stats.addRead(keep)
+					c.rbuf.Commit(keep)
+					c.setCloseReason(ErrChaosTruncated)
+					c.asyncClose(ErrChaosTruncated)
+					return
 				}
-				continue
 			}
+
+			c.stats.addRead(n)
+			c.rbuf.Commit(n)
 		}
 	}
 
+	n, err = c.parseFrames()
+	// 解压交给了业务go程池的话, 解压完成之后重新调用的时候还会读到eof
+	if eof && err == nil && !c.decodeOffloaded && !c.isHijacked() {
+		c.closeOnPeerEOF()
+	}
+	return n, err
+}
+
+// 解析读缓冲区里完整的帧, 剩下不完整的等下次可读
+func (c *Conn) parseFrames() (n int, err error) {
 	for i := 0; ; i++ {
+		// 在回调里被Hijack了, 剩下的数据不再当成frame解析
+		if c.isHijacked() {
+			return c.processRaw()
+		}
+
 		sucess, err := c.readHeader()
 		if err != nil {
 			return 0, fmt.Errorf("read header err: %w", err)
@@ -303,16 +650,14 @@ func (c *Conn) WriteFrameOnlyIoUring(fw *fixedwriter.FixedWriter, payload []byte
 		goto free
 	}
 	if isMask {
-		mask.Mask(fw.Bytes()[wIndex:], maskValue)
+		maskPayload(fw.Bytes()[wIndex:], maskValue)
 	}
 
 	for i := 0; i < 3; i++ {
 
 		c.mu.Lock()
+		// 32位的seq自然回绕, 回绕之后还被占用说明那个写请求一直没有完成
 		c.wSeq++
-		if c.wSeq == math.MaxUint16 {
-			c.wSeq = 0
-		}
 		newSeq := c.wSeq
 		if _, ok := c.m.Load(newSeq); ok {
 			c.mu.Unlock()
@@ -324,11 +669,17 @@ func (c *Conn) WriteFrameOnlyIoUring(fw *fixedwriter.FixedWriter, payload []byte
 			free: func() {
 				bytespool.PutBytes(buf)
 			},
+			submitted: time.Now().UnixNano(),
 		}
-		c.onlyIoUringState.m.Store(newSeq, fb)
+		c.storeWrite(newSeq, fb)
 		fw.Free()
 		c.getLogger().Debug("store seq", slog.Int("seq", int(newSeq)), slog.Int64("fd", c.fd))
-		err = c.parent.addWrite(c, uint16(newSeq))
+		if err = c.parent.addWrite(c, newSeq); err != nil {
+			// sqe没有提交, 不会有cqe, 这里直接回收
+			if w, ok := c.takeWrite(newSeq); ok {
+				w.free()
+			}
+		}
 		c.mu.Unlock()
 		return
 	}
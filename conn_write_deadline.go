@@ -0,0 +1,177 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// WriteMessageDeadline等待写到内核的一帧, 字段都由c.mu保护
+type writeWaiter struct {
+	start  int64         // 这一帧在发送流里的位置, 还没有交给Write时是-1
+	end    int64         // 这一帧结束的位置
+	cancel bool          // 已经放弃等待, 还在发送队列里的话不再写
+	sent   bool          // 已经全部写到内核
+	done   chan struct{} // 写到内核或者连接关闭的时候关闭
+}
+
+func (w *writeWaiter) canceled() bool {
+	return w != nil && w.cancel
+}
+
+func (w *writeWaiter) setRange(start int64, n int) {
+	if w != nil {
+		w.start, w.end = start, start+int64(n)
+	}
+}
+
+// 和WriteMessage一样, 但是会等到这条消息全部写到内核, 或者到了deadline, 用于有严格时限的rpc
+// 到了deadline还没有开始发送的话, 把消息从写缓冲区里撤回, 连接不受影响, 返回os.ErrDeadlineExceeded
+// 已经发出去一部分的话, 对端收到的是半个帧, 只能关闭连接, OnClose收到os.ErrDeadlineExceeded
+// 只影响这一次调用, 不改变SetWriteDeadline设置的deadline, deadline为零值表示一直等到写完或者连接关闭
+// 会阻塞调用的go程, 不要在WithCallbackInEventLoop的回调里调用, 事件循环被卡住的时候写缓冲区不会变小
+// io_uring模式下不能撤回, 只检查调用的时候是否已经过了deadline
+func (c *Conn) WriteMessageDeadline(op Opcode, data []byte, deadline time.Time) error {
+	return c.writeMessageWait(context.Background(), op, data, deadline)
+}
+
+// 和WriteMessageDeadline一样, 使用ctx的deadline, ctx结束时放弃这条消息, 返回ctx.Err()
+func (c *Conn) WriteMessageContext(ctx context.Context, op Opcode, data []byte) error {
+	return c.writeMessageWait(ctx, op, data, time.Time{})
+}
+
+func (c *Conn) writeMessageWait(ctx context.Context, op Opcode, data []byte, deadline time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !deadline.IsZero() && !time.Now().Before(deadline) {
+		return os.ErrDeadlineExceeded
+	}
+	if c.useIoUring() {
+		return c.writeMessage(op, data, true, nil)
+	}
+
+	// 先登记, 帧可能在writeMessage返回之前就已经写完了
+	w := &writeWaiter{start: -1, done: make(chan struct{})}
+	c.mu.Lock()
+	if c.isClosed() {
+		c.mu.Unlock()
+		return ErrClosed
+	}
+	c.writeWaiters = append(c.writeWaiters, w)
+	c.mu.Unlock()
+
+	if err := c.writeMessage(op, data, true, w); err != nil {
+		c.mu.Lock()
+		c.removeWriteWaiter(w)
+		c.mu.Unlock()
+		return err
+	}
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		t := time.NewTimer(time.Until(deadline))
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	select {
+	case <-w.done:
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if !w.sent {
+			return ErrClosed
+		}
+		return nil
+	case <-timeout:
+		return c.abortWrite(w, os.ErrDeadlineExceeded)
+	case <-ctx.Done():
+		return c.abortWrite(w, ctx.Err())
+	}
+}
+
+// 放弃等待, 还没有开始发送就撤回, 发出去一部分就关闭连接
+func (c *Conn) abortWrite(w *writeWaiter, err error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if w.sent {
+		return nil
+	}
+	if c.isClosed() {
+		return ErrClosed
+	}
+	c.removeWriteWaiter(w)
+
+	// 还在发送队列里, 轮到它的时候跳过
+	if w.start < 0 {
+		w.cancel = true
+		return err
+	}
+
+	if c.unqueue(w.start, w.end) {
+		return err
+	}
+
+	c.setCloseReason(err)
+	c.asyncClose(err)
+	return err
+}
+
+// 持有c.mu时调用, 唤醒已经写到内核的帧
+func (c *Conn) notifyWriteWaiters() {
+	if len(c.writeWaiters) == 0 {
+		return
+	}
+	sent := c.queued - int64(c.wbuf.Len())
+	keep := c.writeWaiters[:0]
+	for _, w := range c.writeWaiters {
+		if w.start >= 0 && w.end <= sent {
+			w.sent = true
+			close(w.done)
+			continue
+		}
+		keep = append(keep, w)
+	}
+	for i := len(keep); i < len(c.writeWaiters); i++ {
+		c.writeWaiters[i] = nil
+	}
+	c.writeWaiters = keep
+}
+
+// 持有c.mu时调用
+func (c *Conn) removeWriteWaiter(w *writeWaiter) {
+	for i, o := range c.writeWaiters {
+		if o == w {
+			last := len(c.writeWaiters) - 1
+			copy(c.writeWaiters[i:], c.writeWaiters[i+1:])
+			c.writeWaiters[last] = nil
+			c.writeWaiters = c.writeWaiters[:last]
+			return
+		}
+	}
+}
+
+// 连接关闭的时候唤醒所有等待的go程
+func (c *Conn) releaseWriteWaiters() {
+	for _, w := range c.writeWaiters {
+		close(w.done)
+	}
+	c.writeWaiters = nil
+}
@@ -0,0 +1,118 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package greatws
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 读完对端收到的所有数据, 直到idle时间内没有新数据
+func readAllIdle(t *testing.T, r interface {
+	io.Reader
+	SetReadDeadline(time.Time) error
+}, idle time.Duration) int {
+	t.Helper()
+	buf := make([]byte, 64*1024)
+	total := 0
+	for {
+		r.SetReadDeadline(time.Now().Add(idle))
+		n, err := r.Read(buf)
+		total += n
+		if err != nil {
+			return total
+		}
+	}
+}
+
+func Test_WriteMessageDeadline(t *testing.T) {
+	t.Run("sent", func(t *testing.T) {
+		c, remote := newTestConn(t)
+		if err := c.WriteMessageDeadline(Binary, []byte("hello"), time.Now().Add(time.Second)); err != nil {
+			t.Fatal(err)
+		}
+		if n := readAllIdle(t, remote, 50*time.Millisecond); n != 2+5 {
+			t.Fatalf("read %d bytes", n)
+		}
+		c.mu.Lock()
+		waiters := len(c.writeWaiters)
+		c.mu.Unlock()
+		if waiters != 0 {
+			t.Fatalf("waiters = %d", waiters)
+		}
+	})
+
+	t.Run("withdrawn", func(t *testing.T) {
+		r := newCloseRecorder()
+		c, remote := newTestConn(t, withTestCallback(r))
+		// 对端不读, 直到数据积压在写缓冲区里
+		payload := make([]byte, 1024)
+		sent := 0
+		for c.pendingWriteLen() == 0 {
+			if err := c.WriteMessage(Binary, payload); err != nil {
+				t.Fatal(err)
+			}
+			sent += 4 + len(payload)
+		}
+
+		pending := c.pendingWriteLen()
+		err := c.WriteMessageDeadline(Binary, payload, time.Now().Add(20*time.Millisecond))
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Fatalf("err = %v", err)
+		}
+		if c.isClosed() || c.pendingWriteLen() != pending {
+			t.Fatalf("closed = %v, pending = %d, want %d", c.isClosed(), c.pendingWriteLen(), pending)
+		}
+
+		// 撤回的消息不会发出去, 连接还能继续用
+		if err := c.WriteMessage(Binary, []byte("x")); err != nil {
+			t.Fatal(err)
+		}
+		sent += 2 + 1
+		if n := readAllIdle(t, remote, 100*time.Millisecond); n != sent {
+			t.Fatalf("read %d bytes, want %d", n, sent)
+		}
+		if atomic.LoadInt32(&r.n) != 0 || c.isClosed() {
+			t.Fatal("conn closed")
+		}
+	})
+
+	t.Run("partial", func(t *testing.T) {
+		r := newCloseRecorder()
+		c, _ := newTestConn(t, withTestCallback(r))
+		// 对端不读, 大消息只能写出去一部分
+		err := c.WriteMessageDeadline(Binary, make([]byte, 16<<20), time.Now().Add(20*time.Millisecond))
+		if !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Fatalf("err = %v", err)
+		}
+		if err := r.wait(t); !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Fatalf("OnClose err = %v", err)
+		}
+	})
+
+	t.Run("context", func(t *testing.T) {
+		c, _ := newTestConn(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if err := c.WriteMessageContext(ctx, Binary, []byte("x")); !errors.Is(err, context.Canceled) {
+			t.Fatalf("err = %v", err)
+		}
+		if err := c.WriteMessageDeadline(Binary, []byte("x"), time.Now().Add(-time.Second)); !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Fatalf("err = %v", err)
+		}
+	})
+
+	t.Run("closed", func(t *testing.T) {
+		c, _ := newTestConn(t)
+		c.Close()
+		if err := c.WriteMessageDeadline(Binary, []byte("x"), time.Time{}); !errors.Is(err, ErrClosed) {
+			t.Fatalf("err = %v", err)
+		}
+	})
+}
@@ -0,0 +1,161 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package greatws
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+type writeStep struct {
+	n   int   // 最多写n个字节, 0表示不限制
+	err error // 不为空的话不写, 直接返回这个错误
+}
+
+// 按顺序执行steps, 用完之后直接写fd
+type scriptedWrite struct {
+	mu    sync.Mutex
+	steps []writeStep
+}
+
+func (s *scriptedWrite) write(fd int, p []byte) (int, error) {
+	s.mu.Lock()
+	var st writeStep
+	if len(s.steps) > 0 {
+		st = s.steps[0]
+		s.steps = s.steps[1:]
+	}
+	s.mu.Unlock()
+
+	if st.err != nil {
+		return -1, st.err
+	}
+	if st.n > 0 && len(p) > st.n {
+		p = p[:st.n]
+	}
+	return unix.Write(fd, p)
+}
+
+// 连接加入事件循环之前换掉write系统调用, 按steps返回
+func withScriptedWrite(steps ...writeStep) testConnOption {
+	return withTestConnSetup(func(c *Conn) {
+		c.sysWrite = (&scriptedWrite{steps: steps}).write
+	})
+}
+
+func readExactly(t *testing.T, r net.Conn, n int) []byte {
+	t.Helper()
+	r.SetReadDeadline(time.Now().Add(3 * time.Second))
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		t.Fatalf("read %d bytes: %v", n, err)
+	}
+	return buf
+}
+
+func Test_WriteReturnCount(t *testing.T) {
+	payload := []byte("0123456789abcdefghij")
+
+	t.Run("eintr", func(t *testing.T) {
+		c, remote := newTestConn(t, withScriptedWrite(writeStep{err: unix.EINTR}, writeStep{n: 5}, writeStep{err: unix.EINTR}))
+		c.mu.Lock()
+		n, err := c.Write(payload)
+		pending := c.wbuf.Len()
+		c.mu.Unlock()
+		if n != len(payload) || err != nil || pending != 0 {
+			t.Fatalf("n = %d, err = %v, pending = %d", n, err, pending)
+		}
+		if got := readExactly(t, remote, len(payload)); !bytes.Equal(got, payload) {
+			t.Fatalf("got %q", got)
+		}
+	})
+
+	t.Run("eagain after short write", func(t *testing.T) {
+		c, remote := newTestConn(t, withScriptedWrite(writeStep{n: 5}, writeStep{err: unix.EAGAIN}))
+		c.mu.Lock()
+		n, err := c.Write(payload)
+		c.mu.Unlock()
+		// 剩下的放进了写缓冲区, 也算接收了
+		if n != len(payload) || err != nil {
+			t.Fatalf("n = %d, err = %v", n, err)
+		}
+		// 可写事件到了之后写完剩下的
+		if got := readExactly(t, remote, len(payload)); !bytes.Equal(got, payload) {
+			t.Fatalf("got %q", got)
+		}
+	})
+
+	t.Run("error after short write", func(t *testing.T) {
+		c, _ := newTestConn(t, withScriptedWrite(writeStep{n: 5}, writeStep{err: unix.EPIPE}))
+		c.mu.Lock()
+		n, err := c.Write(payload)
+		c.mu.Unlock()
+		if n != 5 || !errors.Is(err, unix.EPIPE) {
+			t.Fatalf("n = %d, err = %v", n, err)
+		}
+	})
+
+	t.Run("error with pending data", func(t *testing.T) {
+		// 写缓冲区里原来有10字节, 一共写出去15字节, 其中5字节是b的
+		c, _ := newTestConn(t, withScriptedWrite(writeStep{n: 15}, writeStep{err: unix.EPIPE}))
+		c.mu.Lock()
+		c.wbuf.Append(make([]byte, 10))
+		n, err := c.Write(payload)
+		c.mu.Unlock()
+		if n != 5 || !errors.Is(err, unix.EPIPE) {
+			t.Fatalf("n = %d, err = %v", n, err)
+		}
+	})
+
+	t.Run("error before pending data drained", func(t *testing.T) {
+		c, _ := newTestConn(t, withScriptedWrite(writeStep{n: 4}, writeStep{err: unix.EPIPE}))
+		c.mu.Lock()
+		c.wbuf.Append(make([]byte, 10))
+		n, err := c.Write(payload)
+		c.mu.Unlock()
+		if n != 0 || !errors.Is(err, unix.EPIPE) {
+			t.Fatalf("n = %d, err = %v", n, err)
+		}
+	})
+}
+
+// 每次只写几个字节, 中间穿插EINTR和EAGAIN, 对端收到的消息不能乱序也不能缺字节
+func Test_WriteShortWrites(t *testing.T) {
+	var steps []writeStep
+	for i := 0; i < 20000; i++ {
+		switch i % 5 {
+		case 0:
+			steps = append(steps, writeStep{err: unix.EINTR})
+		case 2:
+			steps = append(steps, writeStep{err: unix.EAGAIN})
+		default:
+			steps = append(steps, writeStep{n: 1 + i%7})
+		}
+	}
+	c, remote := newTestConn(t, withScriptedWrite(steps...))
+
+	const count = 50
+	var want bytes.Buffer
+	for i := 0; i < count; i++ {
+		msg := fmt.Sprintf("message-%02d", i)
+		if err := c.WriteMessage(Binary, []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		// 服务端的帧没有mask, 头部2字节
+		want.Write([]byte{0x82, byte(len(msg))})
+		want.WriteString(msg)
+	}
+
+	if got := readExactly(t, remote, want.Len()); !bytes.Equal(got, want.Bytes()) {
+		t.Fatalf("got %q", got)
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// 一个事件循环的统计, 见MultiEventLoop.Stats
+type LoopStats struct {
+	Index         int    `json:"index"`
+	Conns         int    `json:"conns"`
+	PendingTasks  int    `json:"pending_tasks"`  // Execute投递了还没执行的任务
+	BytesRead     uint64 `json:"bytes_read"`     // 当前连接从fd读到的字节数之和
+	BytesWritten  uint64 `json:"bytes_written"`  // 当前连接写进fd的字节数之和
+	WriteBuffered int    `json:"write_buffered"` // 当前连接写缓冲区里积压的字节数之和
+	PendingWrites int    `json:"pending_writes"` // io_uring模式下当前连接已经提交还没有完成的写请求数之和
+}
+
+// 所有事件循环的统计, 见MultiEventLoop.Stats
+type MultiEventLoopStats struct {
+	Api   string      `json:"api"`
+	Conns int64       `json:"conns"`
+	Tasks int64       `json:"tasks"` // 业务go程池里正在执行的任务
+	Loops []LoopStats `json:"loops"`
+}
+
+// 遍历所有的连接做统计, 连接多的时候比较慢, 用于排查问题, 不要频繁调用
+func (m *MultiEventLoop) Stats() MultiEventLoopStats {
+	st := MultiEventLoopStats{
+		Api:   m.GetApiName(),
+		Conns: m.GetCurConnNum(),
+		Tasks: m.GetCurTaskNum(),
+		Loops: make([]LoopStats, len(m.loops)),
+	}
+
+	for i, el := range m.loops {
+		ls := &st.Loops[i]
+		ls.Index = i
+		el.tasks.mu.Lock()
+		ls.PendingTasks = len(el.tasks.tasks)
+		el.tasks.mu.Unlock()
+
+		for _, c := range el.snapshotConns() {
+			ls.Conns++
+			ls.BytesRead += c.stats.bytesRead.Load()
+			ls.BytesWritten += c.stats.bytesWritten.Load()
+			ls.WriteBuffered += c.pendingWriteLen()
+			ls.PendingWrites += int(c.inflight.Load())
+		}
+	}
+	return st
+}
+
+// 排查问题用的http handler, 挂在自己的ServeMux上, 不会注册到http.DefaultServeMux
+// /debug/pprof/        pprof, 和net/http/pprof的路径一样, 可以直接用go tool pprof
+// /debug/vars          expvar格式的json, 包括memstats和greatws(Stats的结果)
+// /debug/greatws       Stats的结果
+func (m *MultiEventLoop) DebugHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", servePprof)
+	mux.HandleFunc("/debug/vars", func(w http.ResponseWriter, r *http.Request) {
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+		writeDebugJSON(w, map[string]any{"memstats": &ms, "greatws": m.Stats()})
+	})
+	mux.HandleFunc("/debug/greatws", func(w http.ResponseWriter, r *http.Request) {
+		writeDebugJSON(w, m.Stats())
+	})
+	return mux
+}
+
+// 在addr上启动DebugHandler, 阻塞到服务出错
+// pprof能看到程序的内部状态, addr不要暴露到公网
+func (m *MultiEventLoop) ServeDebug(addr string) error {
+	return http.ListenAndServe(addr, m.DebugHandler())
+}
+
+func writeDebugJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// 不引入net/http/pprof, 它的init会往http.DefaultServeMux上注册
+func servePprof(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Path[len("/debug/pprof/"):]
+	switch name {
+	case "":
+		profiles := pprof.Profiles()
+		sort.Slice(profiles, func(i, j int) bool { return profiles[i].Name() < profiles[j].Name() })
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintln(w, "profile?seconds=30")
+		for _, p := range profiles {
+			fmt.Fprintf(w, "%s %d\n", p.Name(), p.Count())
+		}
+	case "profile":
+		seconds, _ := strconv.Atoi(r.URL.Query().Get("seconds"))
+		if seconds <= 0 {
+			seconds = 30
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		if err := pprof.StartCPUProfile(w); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		select {
+		case <-time.After(time.Duration(seconds) * time.Second):
+		case <-r.Context().Done():
+		}
+		pprof.StopCPUProfile()
+	default:
+		p := pprof.Lookup(name)
+		if p == nil {
+			http.NotFound(w, r)
+			return
+		}
+		debug, _ := strconv.Atoi(r.URL.Query().Get("debug"))
+		if debug > 0 {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		} else {
+			w.Header().Set("Content-Type", "application/octet-stream")
+		}
+		if name == "heap" && r.URL.Query().Get("gc") != "" {
+			runtime.GC()
+		}
+		p.WriteTo(w, debug)
+	}
+}
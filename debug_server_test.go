@@ -0,0 +1,77 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package greatws
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_DebugHandler(t *testing.T) {
+	c, _ := newTestConn(t)
+	if err := c.WriteMessage(Binary, make([]byte, 100)); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(c.multiEventLoop.DebugHandler())
+	defer srv.Close()
+
+	get := func(path string) []byte {
+		t.Helper()
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: status = %d", path, resp.StatusCode)
+		}
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	var st MultiEventLoopStats
+	if err := json.Unmarshal(get("/debug/greatws"), &st); err != nil {
+		t.Fatal(err)
+	}
+	if len(st.Loops) != 1 || st.Loops[0].Conns != 1 || st.Loops[0].BytesWritten == 0 {
+		t.Fatalf("stats = %+v", st)
+	}
+
+	var vars map[string]json.RawMessage
+	if err := json.Unmarshal(get("/debug/vars"), &vars); err != nil {
+		t.Fatal(err)
+	}
+	if vars["memstats"] == nil || vars["greatws"] == nil {
+		t.Fatalf("vars = %v", vars)
+	}
+
+	if !strings.Contains(string(get("/debug/pprof/")), "goroutine") {
+		t.Fatal("pprof index")
+	}
+	if !strings.Contains(string(get("/debug/pprof/goroutine?debug=1")), "Test_DebugHandler") {
+		t.Fatal("goroutine profile")
+	}
+
+	resp, err := http.Get(srv.URL + "/debug/pprof/notfound")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("status = %d", resp.StatusCode)
+	}
+
+	// 没有注册到DefaultServeMux
+	if _, pattern := http.DefaultServeMux.Handler(httptest.NewRequest("GET", "/debug/pprof/", nil)); pattern != "" {
+		t.Fatalf("registered on DefaultServeMux: %s", pattern)
+	}
+}
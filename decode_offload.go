@@ -0,0 +1,76 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+package greatws
+
+// 压缩的消息不小于decodeOffloadSize时, 解压交给业务go程池, 解压完再回到事件循环里分发
+// 解压期间不再解析这个连接后面的帧, 保证消息的顺序, 对端保留压缩上下文时字典也按顺序更新
+// 返回true表示已经交出去了, 调用方不再处理这条消息
+// io_uring模式和Pipe建立的内存连接不支持, 直接在当前go程里解压
+func (c *Conn) offloadDecode(op Opcode, payload []byte, fragmented bool) bool {
+	if c.decodeOffloadSize <= 0 || len(payload) < c.decodeOffloadSize || c.useIoUring() || c.mem != nil {
+		return false
+	}
+	el := c.getParent()
+	if el == nil {
+		return false
+	}
+
+	c.decodeOffloaded = true
+	limit := c.maxDecompressedSize
+	c.multiEventLoop.t.addTask(func() bool {
+		out, err := c.decodeLimit(payload, limit)
+		if e := el.Execute(func() { c.finishDecode(op, out, err, fragmented) }); e != nil {
+			c.asyncClose(e)
+		}
+		return false
+	})
+	return true
+}
+
+// 在事件循环里调用, 分发解压好的消息, 然后接着解析读缓冲区和fd里的数据
+func (c *Conn) finishDecode(op Opcode, payload []byte, err error, fragmented bool) {
+	c.decodeOffloaded = false
+	if c.isClosed() {
+		return
+	}
+
+	if err == nil {
+		err = c.dispatchDecoded(op, payload, fragmented)
+	}
+	if err == nil {
+		_, err = c.processWebsocketFrame()
+	}
+	if err != nil {
+		c.asyncClose(err)
+	}
+}
+
+func (c *Conn) dispatchDecoded(op Opcode, payload []byte, fragmented bool) error {
+	if op == Text && !c.utf8Check(payload) {
+		c.setCloseReason(ErrTextNotUTF8)
+		return ErrTextNotUTF8
+	}
+
+	c.dispatchMessage(op, payload)
+	if fragmented {
+		// 拼接用的缓冲区里是压缩的数据, 没有交给用户, 可以接着用
+		c.fragmentFramePayload = c.fragmentFramePayload[:0]
+		c.fragment.reset()
+	}
+	return nil
+}
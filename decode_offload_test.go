@@ -0,0 +1,80 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package greatws
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func compressForTest(t *testing.T, s string) string {
+	t.Helper()
+	out := getWrapBuffer()
+	defer putWrapBuffer(out)
+	w := compressNoContextTakeover(out, 1)
+	w.Write([]byte(s))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+// 大的压缩消息在业务go程池里解压, 后面的消息等它分发完再处理
+func Test_DecompressOffload(t *testing.T) {
+	big := strings.Repeat("offload ", 4096)
+	compressed := compressForTest(t, big)
+
+	for _, tc := range []struct {
+		name  string
+		build func(*bytes.Buffer)
+	}{
+		{"single", func(b *bytes.Buffer) {
+			appendClientFrame(t, b, true, true, Text, compressed)
+		}},
+		{"fragmented", func(b *bytes.Buffer) {
+			half := len(compressed) / 2
+			appendClientFrame(t, b, false, true, Text, compressed[:half])
+			appendClientFrame(t, b, true, false, Continuation, compressed[half:])
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tl := newTestLoop(t)
+			r := &tickRecorder{}
+			c, remote := newTestConn(t, withTestLoop(tl), withTestCallback(r), withTestConfig(func(conf *Config) {
+				conf.decompression = true
+				conf.decodeOffloadSize = 16
+			}))
+
+			var wire bytes.Buffer
+			tc.build(&wire)
+			// 小消息不解压, 也要排在大消息后面
+			appendClientFrame(t, &wire, true, true, Text, compressForTest(t, "a"))
+			appendClientFrame(t, &wire, true, false, Text, "small")
+			remote.Write(wire.Bytes())
+
+			// 只poll不执行投递的任务, 解压完的分发还没有机会执行
+			if _, err := tl.el.apiPoll(0); err != nil {
+				t.Fatal(err)
+			}
+			if !c.decodeOffloaded || len(r.msgs) != 0 {
+				t.Fatalf("offloaded = %t, msgs = %d", c.decodeOffloaded, len(r.msgs))
+			}
+
+			for deadline := time.Now().Add(time.Second); len(r.msgs) < 3 && time.Now().Before(deadline); {
+				if _, err := tl.Tick(); err != nil {
+					t.Fatal(err)
+				}
+				time.Sleep(time.Millisecond)
+			}
+			if len(r.msgs) != 3 || r.msgs[0] != big || r.msgs[1] != "a" || r.msgs[2] != "small" {
+				t.Fatalf("msgs = %d", len(r.msgs))
+			}
+			if c.decodeOffloaded || c.fragment.active() || r.closed != 0 {
+				t.Fatalf("offloaded = %t, fragment = %v, closed = %d", c.decodeOffloaded, c.fragment, r.closed)
+			}
+		})
+	}
+}
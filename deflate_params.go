@@ -0,0 +1,242 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"compress/flate"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	minWindowBits = 8
+	maxWindowBits = 15
+)
+
+// permessage-deflate协商的结果, rfc 7692
+// 发送方向始终不保留上下文(server/client_no_context_takeover), 压缩状态用完放回池子
+// 接收方向可以允许对端保留上下文, 这时每个连接要保存最近2^windowBits字节的解压数据作为字典
+type deflateParams struct {
+	serverNoContextTakeover bool
+	clientNoContextTakeover bool
+	serverMaxWindowBits     int // 0表示没有协商, 等同于15
+	clientMaxWindowBits     int // 0表示没有协商, 等同于15
+}
+
+// 协商结果写到Sec-WebSocket-Extensions里
+func (p *deflateParams) String() string {
+	var b strings.Builder
+	b.WriteString("permessage-deflate")
+	if p.serverNoContextTakeover {
+		b.WriteString("; server_no_context_takeover")
+	}
+	if p.clientNoContextTakeover {
+		b.WriteString("; client_no_context_takeover")
+	}
+	if p.serverMaxWindowBits > 0 {
+		b.WriteString("; server_max_window_bits=")
+		b.WriteString(strconv.Itoa(p.serverMaxWindowBits))
+	}
+	if p.clientMaxWindowBits > 0 {
+		b.WriteString("; client_max_window_bits=")
+		b.WriteString(strconv.Itoa(p.clientMaxWindowBits))
+	}
+	return b.String()
+}
+
+// 解析一个permessage-deflate扩展的参数, 有不认识的参数或者值不合法返回false
+// client_max_window_bits在offer里可以没有值, 这时返回maxWindowBits
+func parseDeflateExt(ext map[string]string) (p deflateParams, clientMaxWindowOffered bool, ok bool) {
+	for k, v := range ext {
+		switch k {
+		case "":
+		case "server_no_context_takeover":
+			if v != "" {
+				return p, false, false
+			}
+			p.serverNoContextTakeover = true
+		case "client_no_context_takeover":
+			if v != "" {
+				return p, false, false
+			}
+			p.clientNoContextTakeover = true
+		case "server_max_window_bits":
+			bits, ok := parseWindowBits(v)
+			if !ok {
+				return p, false, false
+			}
+			p.serverMaxWindowBits = bits
+		case "client_max_window_bits":
+			clientMaxWindowOffered = true
+			if v == "" {
+				p.clientMaxWindowBits = maxWindowBits
+				continue
+			}
+			bits, ok := parseWindowBits(v)
+			if !ok {
+				return p, false, false
+			}
+			p.clientMaxWindowBits = bits
+		default:
+			return p, false, false
+		}
+	}
+	return p, clientMaxWindowOffered, true
+}
+
+func clampWindowBits(bits int) int {
+	return max(minWindowBits, min(bits, maxWindowBits))
+}
+
+func parseWindowBits(v string) (int, bool) {
+	bits, err := strconv.Atoi(v)
+	if err != nil || bits < minWindowBits || bits > maxWindowBits {
+		return 0, false
+	}
+	return bits, true
+}
+
+// 服务端从客户端的offer里选第一个能接受的
+// maxBits是服务端愿意给每个连接的解压字典分配的最大窗口, 0表示不允许客户端保留上下文
+func negotiateDeflate(header http.Header, maxBits int) (resp deflateParams, ok bool) {
+	for _, ext := range parseExtensions(header) {
+		if ext[""] != "permessage-deflate" {
+			continue
+		}
+
+		offer, clientMaxWindowOffered, valid := parseDeflateExt(ext)
+		if !valid {
+			continue
+		}
+
+		// 服务端压缩不保留上下文, 窗口按客户端的要求回应
+		// 小于15的窗口用不带LZ77匹配的huffman编码, 一定不会超过窗口
+		resp.serverNoContextTakeover = true
+		resp.serverMaxWindowBits = offer.serverMaxWindowBits
+
+		switch {
+		case maxBits == 0 || offer.clientNoContextTakeover:
+			resp.clientNoContextTakeover = true
+		case clientMaxWindowOffered:
+			resp.clientMaxWindowBits = min(maxBits, offer.clientMaxWindowBits)
+		case maxBits < maxWindowBits:
+			// 客户端不支持client_max_window_bits, 限制不了它的窗口, 只能让它不保留上下文
+			resp.clientNoContextTakeover = true
+		}
+		return resp, true
+	}
+	return resp, false
+}
+
+// 客户端的offer
+// maxBits为0时要求服务端不保留上下文, 否则允许服务端保留上下文, 窗口不超过maxBits
+func clientDeflateOffer(maxBits int) string {
+	offer := deflateParams{clientNoContextTakeover: true}
+	if maxBits == 0 {
+		offer.serverNoContextTakeover = true
+	} else if maxBits < maxWindowBits {
+		offer.serverMaxWindowBits = maxBits
+	}
+	return offer.String()
+}
+
+// 客户端检查服务端的回应, 没有permessage-deflate时ok为false
+// 参数不合法或者服务端的窗口超过了offer里的值, 按rfc要求握手失败
+func acceptDeflateResponse(header http.Header, maxBits int) (resp deflateParams, ok bool, err error) {
+	for _, ext := range parseExtensions(header) {
+		if ext[""] != "permessage-deflate" {
+			continue
+		}
+
+		resp, _, ok = parseDeflateExt(ext)
+		if !ok {
+			return resp, false, ErrDeflateParams
+		}
+
+		if !resp.serverNoContextTakeover {
+			serverBits := resp.serverMaxWindowBits
+			if serverBits == 0 {
+				serverBits = maxWindowBits
+			}
+			if maxBits == 0 || serverBits > maxBits {
+				return resp, false, ErrDeflateParams
+			}
+		}
+		return resp, true, nil
+	}
+	return resp, false, nil
+}
+
+// 本端压缩用的级别, 对端要求的窗口小于15时只用huffman编码
+func (c *Conn) compressionLevel() int {
+	bits := c.deflate.serverMaxWindowBits
+	if c.client {
+		bits = c.deflate.clientMaxWindowBits
+	}
+	if bits > 0 && bits < maxWindowBits {
+		return flate.HuffmanOnly
+	}
+	return defaultCompressionLevel
+}
+
+// 对端保留上下文时, 解压字典的大小, 返回0表示对端不保留上下文
+func (c *Conn) inflateWindow() int {
+	noContextTakeover, bits := c.deflate.clientNoContextTakeover, c.deflate.clientMaxWindowBits
+	if c.client {
+		noContextTakeover, bits = c.deflate.serverNoContextTakeover, c.deflate.serverMaxWindowBits
+	}
+	if noContextTakeover {
+		return 0
+	}
+	if bits == 0 {
+		bits = maxWindowBits
+	}
+	return 1 << bits
+}
+
+// 解压一条消息, 对端保留上下文时带上字典, 解压之后更新字典
+func (c *Conn) decode(payload []byte) ([]byte, error) {
+	return c.decodeLimit(payload, c.maxDecompressedSize)
+}
+
+// limit是解压之后的最大字节数, 交给业务go程池解压时在事件循环里先取出来, UpdateConfig会修改
+func (c *Conn) decodeLimit(payload []byte, limit int64) ([]byte, error) {
+	window := c.inflateWindow()
+	out, err := decode(payload, c.inflateDict, limit)
+	if err == ErrDecompressTooLarge {
+		return nil, c.writeErrAndOnClose(TooBigMessage, err)
+	}
+	if err != nil || window == 0 {
+		return out, err
+	}
+
+	if len(out) >= window {
+		c.inflateDict = append(c.inflateDict[:0], out[len(out)-window:]...)
+		return out, nil
+	}
+
+	if c.inflateDict == nil {
+		c.inflateDict = make([]byte, 0, window)
+	}
+	if over := len(c.inflateDict) + len(out) - window; over > 0 {
+		c.inflateDict = c.inflateDict[:copy(c.inflateDict, c.inflateDict[over:])]
+	}
+	c.inflateDict = append(c.inflateDict, out...)
+	return out, nil
+}
@@ -0,0 +1,74 @@
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"bytes"
+	"compress/flate"
+	"net/http"
+	"testing"
+)
+
+func Test_NegotiateDeflate(t *testing.T) {
+	for _, tc := range []struct {
+		offer   string
+		maxBits int
+		want    string
+		ok      bool
+	}{
+		{"permessage-deflate", 0, "permessage-deflate; server_no_context_takeover; client_no_context_takeover", true},
+		{"permessage-deflate; server_max_window_bits=10", 0, "permessage-deflate; server_no_context_takeover; client_no_context_takeover; server_max_window_bits=10", true},
+		{"permessage-deflate; client_max_window_bits", 10, "permessage-deflate; server_no_context_takeover; client_max_window_bits=10", true},
+		{"permessage-deflate; client_max_window_bits=9", 12, "permessage-deflate; server_no_context_takeover; client_max_window_bits=9", true},
+		{"permessage-deflate", 10, "permessage-deflate; server_no_context_takeover; client_no_context_takeover", true},
+		{"permessage-deflate", 15, "permessage-deflate; server_no_context_takeover", true},
+		{"permessage-deflate; server_max_window_bits=16, permessage-deflate", 0, "permessage-deflate; server_no_context_takeover; client_no_context_takeover", true},
+		{"permessage-deflate; foo", 0, "", false},
+	} {
+		h := http.Header{"Sec-Websocket-Extensions": {tc.offer}}
+		resp, ok := negotiateDeflate(h, tc.maxBits)
+		if ok != tc.ok || ok && resp.String() != tc.want {
+			t.Errorf("negotiateDeflate(%q, %d) = %q, %t", tc.offer, tc.maxBits, resp.String(), ok)
+		}
+	}
+}
+
+func Test_AcceptDeflateResponse(t *testing.T) {
+	h := http.Header{"Sec-Websocket-Extensions": {"permessage-deflate; server_max_window_bits=12"}}
+	if _, _, err := acceptDeflateResponse(h, 10); err != ErrDeflateParams {
+		t.Fatalf("window larger than offer: %v", err)
+	}
+
+	resp, ok, err := acceptDeflateResponse(h, 12)
+	if err != nil || !ok || resp.serverMaxWindowBits != 12 {
+		t.Fatalf("acceptDeflateResponse = %v, %t, %v", resp, ok, err)
+	}
+}
+
+func Test_DecodeContextTakeover(t *testing.T) {
+	c := &Conn{Config: &Config{}, client: true}
+	c.deflate.serverMaxWindowBits = 10
+
+	var out bytes.Buffer
+	fw, _ := flate.NewWriter(&out, flate.BestCompression)
+	msgs := []string{"hello greatws hello greatws", "hello greatws again"}
+	for _, msg := range msgs {
+		out.Reset()
+		fw.Write([]byte(msg))
+		fw.Flush()
+		// 去掉结尾的00 00 ff ff
+		payload := append([]byte(nil), out.Bytes()[:out.Len()-4]...)
+
+		got, err := c.decode(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != msg {
+			t.Fatalf("decode = %q, want %q", got, msg)
+		}
+	}
+	if cap(c.inflateDict) != 1<<10 {
+		t.Fatalf("dict cap = %d", cap(c.inflateDict))
+	}
+}
@@ -14,32 +14,78 @@
 
 package greatws
 
-import "errors"
+import (
+	"errors"
 
+	"github.com/antlabs/wsutil/errs"
+)
+
+// 库返回的错误都是下面的哨兵值, 或者用%w包装过的哨兵值, 调用方统一用errors.Is判断错误的种类.
+// 包装时附带的上下文(比如具体的opcode, 状态码)只放在Error()的文本里, 文本不保证兼容, 不要按字符串匹配.
+// 握手失败的HTTP响应还可以用errors.As取*HandshakeError, 对端的close帧可以用errors.As取*CloseErrMsg
 var (
 	// conn已经被关闭
 	ErrClosed = errors.New("closed")
 
-	ErrWrongStatusCode      = errors.New("Wrong status code")
-	ErrUpgradeFieldValue    = errors.New("The value of the upgrade field is not 'websocket'")
-	ErrConnectionFieldValue = errors.New("The value of the connection field is not 'upgrade'")
-	ErrSecWebSocketAccept   = errors.New("The value of Sec-WebSocketAaccept field is invalid")
-
-	ErrHostCannotBeEmpty   = errors.New("Host cannot be empty")
-	ErrSecWebSocketKey     = errors.New("The value of SEC websocket key field is wrong")
-	ErrSecWebSocketVersion = errors.New("The value of SEC websocket version field is wrong, not 13")
-
-	ErrHTTPProtocolNotSupported = errors.New("HTTP protocol not supported")
-
-	ErrOnlyGETSupported     = errors.New("error:Only get methods are supported")
-	ErrMaxControlFrameSize  = errors.New("error:max control frame size > 125, need <= 125")
-	ErrRsv123               = errors.New("error:rsv1 or rsv2 or rsv3 has a value")
-	ErrOpcode               = errors.New("error:wrong opcode")
-	ErrNOTBeFragmented      = errors.New("error:since control message MUST NOT be fragmented")
-	ErrFrameOpcode          = errors.New("error:since all data frames after the initial data frame must have opcode 0.")
-	ErrTextNotUTF8          = errors.New("error:text is not utf8 data")
-	ErrClosePayloadTooSmall = errors.New("error:close payload too small")
-	ErrCloseValue           = errors.New("error:close value is wrong") // close值不对
-	ErrEmptyClose           = errors.New("error:close value is empty") // close的值是空的
-	ErrWriteClosed          = errors.New("write close")
+	// 握手, 客户端和服务端共用
+	ErrWrongStatusCode      = errors.New("Wrong status code")                                  // 服务端没有回101, 包装了实际的状态码
+	ErrUpgradeFieldValue    = errors.New("The value of the upgrade field is not 'websocket'")  // Upgrade头不对
+	ErrConnectionFieldValue = errors.New("The value of the connection field is not 'upgrade'") // Connection头不对
+	ErrSecWebSocketAccept   = errors.New("The value of Sec-WebSocketAaccept field is invalid") // 客户端校验Sec-WebSocket-Accept失败
+
+	ErrHostCannotBeEmpty   = errors.New("Host cannot be empty")                                      // 服务端收到的请求没有Host
+	ErrSecWebSocketKey     = errors.New("The value of SEC websocket key field is wrong")             // Sec-WebSocket-Key不是16字节的base64
+	ErrSecWebSocketVersion = errors.New("The value of SEC websocket version field is wrong, not 13") // 包装了收到的版本号
+
+	ErrHTTPProtocolNotSupported = errors.New("HTTP protocol not supported") // 不是HTTP/1.1
+
+	// 协议错误, 读到不合法的帧时先回close帧再关闭连接, OnClose收到的err满足errors.Is
+	ErrOnlyGETSupported     = errors.New("error:Only get methods are supported")                                         // 握手只接受GET, 包装了收到的方法
+	ErrMaxControlFrameSize  = errors.New("error:max control frame size > 125, need <= 125")                              // 控制帧的payload超过125字节, 收发都会检查
+	ErrRsv123               = errors.New("error:rsv1 or rsv2 or rsv3 has a value")                                       // 没有协商的保留位, 包装了rsv1-3的值
+	ErrOpcode               = errors.New("error:wrong opcode")                                                           // 未知的opcode, 或者没有开始就收到continuation帧
+	ErrNOTBeFragmented      = errors.New("error:since control message MUST NOT be fragmented")                           // 控制帧不能分片
+	ErrFrameOpcode          = errors.New("error:since all data frames after the initial data frame must have opcode 0.") // 分片消息没结束就收到新的数据帧
+	ErrTextNotUTF8          = errors.New("error:text is not utf8 data")                                                  // text消息或者close的reason不是utf8
+	ErrClosePayloadTooSmall = errors.New("error:close payload too small")                                                // close帧的payload只有1个字节
+	ErrCloseValue           = errors.New("error:close value is wrong")                                                   // close值不对
+	ErrEmptyClose           = errors.New("error:close value is empty")                                                   // close的值是空的
+	ErrWriteClosed          = errors.New("write close")                                                                  // 已经发送过close帧, 不能再发送
+	ErrCloseTimeout         = errors.New("error:wait close frame timeout")                                               // 发送close帧之后, 等待对端close帧超时
+
+	ErrNotFoundMultiEventLoop = errors.New("error:not found multi event loop") // 没有配置MultiEventLoop
+	ErrHTTP2NotNegotiated     = errors.New("error:http2 not negotiated")       // 服务端不支持http2
+	ErrHijacked               = errors.New("error:conn already hijacked")      // 已经调用过Hijack
+	ErrNotHijacked            = errors.New("error:conn not hijacked")          // 没有调用Hijack
+	ErrHijackIoUring          = errors.New("error:hijack not supported on io_uring")
+	ErrPongTimeout            = errors.New("error:wait pong timeout")   // 客户端心跳, 没有按时收到pong
+	ErrNotControlFrame        = errors.New("error:not a control frame") // WriteControl只能发送close, ping, pong
+	ErrDeflateParams          = errors.New("error:invalid permessage-deflate params")
+	ErrProxied                = errors.New("error:conn already proxied") // 已经调用过Proxy
+
+	ErrHandshakeHeaderTooLarge = errors.New("error:handshake header too large") // 握手的请求头超过限制
+	ErrHandshakeTimeout        = errors.New("error:handshake timeout")          // 从accept到升级完成超过限制
+	ErrAcceptRejected          = errors.New("error:rejected by OnAccept")       // OnAccept返回false
+	ErrUnmaskedFrame           = errors.New("error:client frame is not masked") // 客户端发过来的帧没有mask
+	ErrCloseReasonTooLong      = errors.New("error:close reason > 123 bytes")   // 配置了不截断close的reason
+	ErrDialFailed              = errors.New("error:websocket dial failed")      // 浏览器里握手失败, 浏览器不提供具体原因
+	ErrChaosTruncated          = errors.New("error:chaos truncated read")       // WithChaos注入的读到一半断开
+	ErrOriginDenied            = errors.New("error:origin not allowed")         // WithServerCheckOrigin返回false
+	ErrWriteBufferFull         = errors.New("error:write buffer full")          // 写缓冲区积压超过WriteBufferSize
+	ErrLoopShutdown            = errors.New("error:event loop shutdown")        // 事件循环已经关闭, 不能再投递任务
+	ErrTLSNotSupported         = errors.New("error:tls not supported")          // 事件循环直接读写fd, 还不支持tls
+	ErrMemoryBackend           = errors.New("error:memory backend")             // WithMemoryBackend只能使用Pipe
+	ErrAffinityToken           = errors.New("error:invalid affinity token")     // 粘性会话的token解码失败
+	ErrFramePayloadLength      = errs.ErrFramePayloadLength                     // 帧的长度字段不合法, 和wsutil/errs是同一个值
+	ErrDecompress              = errors.New("error:decompress failed")          // permessage-deflate解压失败, 包装了flate的错误
+	ErrUnknownScheme           = errors.New("error:unknown url scheme")         // Dial和重定向只支持ws/wss(重定向还支持http/https)
+	ErrRawConnUnsupported      = errors.New("error:raw conn unsupported")       // net.Conn拿不到fd
+	ErrEventNotSupported       = errors.New("error:event api not supported")    // 平台不支持配置的事件循环
+	ErrDecompressTooLarge      = errors.New("error:decompressed too large")     // 解压之后超过MaxDecompressedSize, 回1009关闭连接
+	ErrInvalidConfig           = errors.New("error:invalid config")             // Config.Validate发现的冲突配置
+	ErrUnixSocketPath          = errors.New("error:missing unix socket path")   // ws+unix的url里没有socket的路径
+	ErrPoolClosed              = errors.New("error:pool closed")                // Pool已经关闭
+	ErrIoUringWriteTimeout     = errors.New("error:io_uring write timeout")     // io_uring的写请求超过WithIoUringWriteTimeout还没有完成
+	ErrLoopFailed              = errors.New("error:event loop failed")          // 事件循环连续出错超过WithLoopRestart的次数, 关闭了上面的连接
+	ErrMessageDropped          = errors.New("error:message dropped")            // 积压的消息超过上限, 按DropNewest丢掉了这条消息, 连接不关闭
 )
@@ -0,0 +1,27 @@
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/antlabs/wsutil/errs"
+)
+
+// 内部返回的错误都要能用errors.Is判断种类
+func Test_ErrorsWrapped(t *testing.T) {
+	if !errors.Is(errs.ErrFramePayloadLength, ErrFramePayloadLength) {
+		t.Fatal("ErrFramePayloadLength should be the wsutil value")
+	}
+
+	if _, err := decode([]byte{0xff, 0xff, 0xff}, nil, 0); !errors.Is(err, ErrDecompress) {
+		t.Fatalf("decode err = %v", err)
+	}
+
+	m := NewMultiEventLoopMust(WithEventLoops(1))
+	if _, err := Dial("ftp://127.0.0.1/ws", WithClientMultiEventLoop(m)); !errors.Is(err, ErrUnknownScheme) {
+		t.Fatalf("dial err = %v", err)
+	}
+}
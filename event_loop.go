@@ -11,11 +11,15 @@
 // WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
 // See the License for the specific language governing permissions and
 // limitations under the License.
+//go:build !js
+// +build !js
+
 package greatws
 
 import (
 	"context"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -28,27 +32,109 @@ const (
 
 type EventLoop struct {
 	mu        sync.Mutex
-	conns     sync.Map
-	maxFd     int // highest file descriptor currently registered
-	setSize   int // max number of file descriptors tracked
-	*apiState     // 每个平台对应的异步io接口/epoll/kqueue/iouring
-	shutdown  bool
+	conns     connTable // 以fd为下标的连接表, 按需扩容
+	maxFd     int       // highest file descriptor currently registered
+	setSize   int       // max number of file descriptors tracked
+	*apiState           // 每个平台对应的异步io接口/epoll/kqueue/iouring
 	parent    *MultiEventLoop
+	pinned    bool           // 是否绑定cpu
+	cpu       int            // 绑定的cpu
+	bufPool   *loopBytesPool // 绑定cpu之后, 读缓冲区使用循环自己的池子
+
+	shutdown int32         // 不为0时事件循环退出
+	done     chan struct{} // 事件循环退出之后关闭
+
+	tasks loopTasks // Execute投递的任务
+	goid  int64     // 事件循环go程的id, Loop开始的时候设置, 见InLoop
+
+	writeBudget   int     // 这一轮还能刷出的字节数, 见WithLoopWriteBudget
+	deferredWrite []*Conn // 超出预算推迟到下一轮刷的连接, 按顺序轮流刷
+
+	pollFailures int // apiPoll连续出错的次数, 成功一次清零, 只在事件循环里读写
 }
 
 // 初始化函数
 func CreateEventLoop(setSize int, flag evFlag) (e *EventLoop, err error) {
+	return createEventLoop(nil, setSize, flag)
+}
+
+// 创建之前先设置parent, io_uring创建ring的时候要用MultiEventLoop上的配置
+func createEventLoop(parent *MultiEventLoop, setSize int, flag evFlag) (e *EventLoop, err error) {
 	e = &EventLoop{
 		setSize: setSize,
 		maxFd:   -1,
+		done:    make(chan struct{}),
+		parent:  parent,
 	}
 	err = e.apiCreate(flag)
 	return e, err
 }
 
-// 柔性关闭所有的连接
+// 停止事件循环, 等到事件循环的go程退出, 或者ctx结束
+// 事件循环退出的时候释放epoll/kqueue的fd和io_uring的ring, 不会关闭连接, 关闭连接见MultiEventLoop.Shutdown
 func (e *EventLoop) Shutdown(ctx context.Context) error {
-	return nil
+	if atomic.CompareAndSwapInt32(&e.shutdown, 0, 1) {
+		if err := e.wakeup(); err != nil {
+			return err
+		}
+	}
+
+	select {
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (el *EventLoop) isShutdown() bool {
+	return atomic.LoadInt32(&el.shutdown) == 1
+}
+
+// fd在conns里的下标, fd已经按loop数取模分配, 这里除以loop数压缩空间
+func (el *EventLoop) connIndex(fd int) int {
+	if el.parent == nil || len(el.parent.loops) == 0 {
+		return fd
+	}
+	return fd / len(el.parent.loops)
+}
+
+// 保存连接
+func (el *EventLoop) storeConn(fd int, c *Conn) {
+	el.conns.store(el.connIndex(fd), c)
+}
+
+// 如果不存在就保存连接
+// 已经关闭的连接不再保存, 避免关闭之后的flush把旧连接放回去, 占住被复用的fd
+func (el *EventLoop) loadOrStoreConn(fd int, c *Conn) {
+	if c.isClosed() || el.loadConn(fd) != nil {
+		return
+	}
+	el.storeConn(fd, c)
+}
+
+// 获取连接, 不存在返回nil
+func (el *EventLoop) loadConn(fd int) (c *Conn) {
+	return el.conns.load(el.connIndex(fd))
+}
+
+// 获取连接, 代数不一致说明fd已经被新的连接复用, 返回nil
+func (el *EventLoop) loadConnGen(fd int, gen uint32) *Conn {
+	c := el.loadConn(fd)
+	if c == nil || c.gen != gen {
+		return nil
+	}
+	return c
+}
+
+// 删除连接, 只删除c自己, fd已经被新的连接占用的话什么都不做
+func (el *EventLoop) deleteConn(fd int, c *Conn) {
+	el.conns.delete(el.connIndex(fd), c)
+}
+
+// 当前所有连接的快照, 遍历的时候不持有锁
+func (el *EventLoop) snapshotConns() []*Conn {
+	return el.conns.snapshot()
 }
 
 func (el *EventLoop) StartLoop() {
@@ -56,12 +142,30 @@ func (el *EventLoop) StartLoop() {
 }
 
 func (el *EventLoop) Loop() {
-	for !el.shutdown {
-		_, err := el.apiPoll(time.Duration(time.Second * 100))
+	atomic.StoreInt64(&el.goid, curGoroutineID())
+	if el.pinned {
+		el.pinCPU()
+	}
+
+	defer close(el.done)
+	// 出错之后poller可能被重建过, 释放的时候再取
+	defer func() { el.apiFree() }()
+
+	for !el.isShutdown() {
+		tv := time.Duration(time.Second * 100)
+		// 还有推迟的写, 不等待
+		if el.beginTick() {
+			tv = 0
+		}
+		_, err := el.apiPoll(tv)
 		if err != nil {
-			el.parent.Error("apiPolll", "err", err.Error())
-			return
+			if !el.recoverPoll(err) {
+				return
+			}
+			continue
 		}
+		el.pollFailures = 0
+		el.runTasks()
 	}
 }
 
@@ -0,0 +1,79 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"sync"
+	"time"
+)
+
+// 投递到事件循环里执行的任务
+// 事件循环每次poll返回之后按投递的顺序执行, 和在事件循环里调用的回调(见WithCallbackInEventLoop)不会并发
+type loopTasks struct {
+	mu    sync.Mutex
+	tasks []func()
+	spare []func() // 执行完的slice留着下次交换, 减少分配
+}
+
+// 在事件循环的go程里执行f, 用来安全地访问只在事件循环里修改的状态
+// f里不能阻塞, 阻塞会卡住这个事件循环上的所有连接
+// 事件循环已经关闭的时候返回ErrLoopShutdown, 关闭时还没来得及执行的任务会被丢弃
+func (el *EventLoop) Execute(f func()) error {
+	if el.isShutdown() {
+		return ErrLoopShutdown
+	}
+
+	el.tasks.mu.Lock()
+	el.tasks.tasks = append(el.tasks.tasks, f)
+	el.tasks.mu.Unlock()
+	return el.wakeup()
+}
+
+// d之后在事件循环的go程里执行f, 精度是时间轮的一格(100ms)
+// 返回的stop在f还没有投递到事件循环之前调用, 可以取消, 返回true表示取消成功
+func (el *EventLoop) ExecuteAfter(d time.Duration, f func()) (stop func() bool) {
+	t := el.parent.wheel.AfterFunc(d, func() {
+		if err := el.Execute(f); err != nil {
+			el.parent.Debug("ExecuteAfter", "err", err.Error())
+		}
+	})
+	return t.Stop
+}
+
+// 执行已经投递的任务, 只在事件循环的go程里调用
+// 任务里再投递的任务留到下一轮执行
+func (el *EventLoop) runTasks() {
+	el.tasks.mu.Lock()
+	tasks := el.tasks.tasks
+	el.tasks.tasks = el.tasks.spare[:0]
+	el.tasks.mu.Unlock()
+
+	for i, f := range tasks {
+		f()
+		tasks[i] = nil
+	}
+
+	el.tasks.mu.Lock()
+	el.tasks.spare = tasks[:0]
+	el.tasks.mu.Unlock()
+}
+
+// 连接所在的事件循环, 还没有加入事件循环时返回nil
+func (c *Conn) EventLoop() *EventLoop {
+	return c.getParent()
+}
@@ -0,0 +1,56 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package greatws
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func Test_EventLoopExecute(t *testing.T) {
+	m := NewMultiEventLoopMust(WithEventLoops(1))
+	m.Start()
+	el := m.loops[0]
+
+	got := make(chan int, 3)
+	for i := 0; i < 3; i++ {
+		i := i
+		if err := el.Execute(func() { got <- i }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 按投递的顺序执行
+	for i := 0; i < 3; i++ {
+		select {
+		case v := <-got:
+			if v != i {
+				t.Fatalf("got %d, want %d", v, i)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("task not executed")
+		}
+	}
+
+	after := make(chan struct{})
+	el.ExecuteAfter(10*time.Millisecond, func() { close(after) })
+	select {
+	case <-after:
+	case <-time.After(time.Second):
+		t.Fatal("ExecuteAfter not executed")
+	}
+
+	stop := el.ExecuteAfter(time.Second, func() { t.Error("stopped task executed") })
+	if !stop() {
+		t.Fatal("stop failed")
+	}
+
+	if err := m.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := el.Execute(func() {}); !errors.Is(err, ErrLoopShutdown) {
+		t.Fatalf("err = %v", err)
+	}
+}
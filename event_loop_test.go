@@ -0,0 +1,71 @@
+//go:build !js
+// +build !js
+
+package greatws
+
+import "testing"
+
+func Test_EventLoopConns(t *testing.T) {
+	m := &MultiEventLoop{}
+	m.loops = []*EventLoop{{parent: m}, {parent: m}}
+	el := m.loops[1]
+
+	for fd := 1; fd < 1000; fd += 2 {
+		el.storeConn(fd, &Conn{conn: conn{fd: int64(fd)}})
+	}
+
+	for fd := 1; fd < 1000; fd += 2 {
+		c := el.loadConn(fd)
+		if c == nil || c.getFd() != fd {
+			t.Fatalf("loadConn(%d) = %v", fd, c)
+		}
+	}
+
+	// fd被复用之后, 旧连接的删除不影响新连接
+	old := el.loadConn(3)
+	newer := &Conn{conn: conn{fd: 3}, gen: 1}
+	el.storeConn(3, newer)
+	el.deleteConn(3, old)
+	if el.loadConn(3) != newer {
+		t.Fatalf("deleteConn of a stale conn removed the new one")
+	}
+	if el.loadConnGen(3, 0) != nil || el.loadConnGen(3, 1) != newer {
+		t.Fatalf("loadConnGen should match the generation")
+	}
+
+	el.deleteConn(3, newer)
+	if el.loadConn(3) != nil {
+		t.Fatalf("loadConn(3) should be nil after deleteConn")
+	}
+
+	if el.loadConn(100001) != nil {
+		t.Fatalf("loadConn out of range should be nil")
+	}
+}
+
+func Test_MultiEventLoopRange(t *testing.T) {
+	m := &MultiEventLoop{}
+	m.loops = []*EventLoop{{parent: m}, {parent: m}}
+	for fd := 0; fd < 100; fd++ {
+		m.loops[fd%2].storeConn(fd, &Conn{conn: conn{fd: int64(fd)}})
+	}
+	m.loops[1].deleteConn(51, m.loops[1].loadConn(51))
+
+	seen := make(map[int]bool)
+	m.Range(func(c *Conn) bool {
+		seen[c.getFd()] = true
+		return true
+	})
+	if len(seen) != 99 || seen[51] {
+		t.Fatalf("Range saw %d conns", len(seen))
+	}
+
+	n := 0
+	m.Range(func(c *Conn) bool {
+		n++
+		return n < 10
+	})
+	if n != 10 {
+		t.Fatalf("Range should stop after f returns false, n = %d", n)
+	}
+}
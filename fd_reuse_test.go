@@ -0,0 +1,85 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package greatws
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 不停地创建和关闭连接, 让内核反复复用fd
+// 关闭之后的写入要被丢弃, 每个对端只能收到写给自己的数据
+func Test_FdReuseChurn(t *testing.T) {
+	m := NewMultiEventLoopMust(WithEventLoops(2))
+	m.Start()
+
+	const workers, rounds = 8, 200
+	var wg sync.WaitGroup
+	errc := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				fd, remote, err := newSocketPair()
+				if err != nil {
+					errc <- err
+					return
+				}
+
+				conf := &Config{}
+				conf.defaultSetting()
+				conf.multiEventLoop = m
+				conf.Callback = newGoCallback(&DefCallback{}, &m.t)
+				c := newConn(int64(fd), false, conf)
+				if err := m.add(c); err != nil {
+					remote.Close()
+					errc <- err
+					return
+				}
+
+				tag := []byte(fmt.Sprintf("%d-%d", w, i))
+				c.WriteMessage(Binary, tag)
+				// 关闭和写入并发, 关闭之后fd马上会被别的连接复用
+				done := make(chan struct{})
+				go func() {
+					defer close(done)
+					for j := 0; j < 10; j++ {
+						c.WriteMessage(Binary, tag)
+					}
+				}()
+				c.Close()
+				<-done
+
+				remote.SetReadDeadline(time.Now().Add(time.Second))
+				data, _ := io.ReadAll(remote)
+				remote.Close()
+				// 服务端的帧: 0x82 len payload
+				for len(data) > 0 {
+					if len(data) < 2 || int(data[1]) > len(data)-2 {
+						errc <- fmt.Errorf("truncated frame %x", data)
+						return
+					}
+					if p := data[2 : 2+data[1]]; !bytes.Equal(p, tag) {
+						errc <- fmt.Errorf("conn %s got %q", tag, p)
+						return
+					}
+					data = data[2+data[1]:]
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errc)
+	for err := range errc {
+		t.Fatal(err)
+	}
+	if n := m.ConnCount(); n != 0 {
+		t.Fatalf("ConnCount = %d", n)
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"github.com/antlabs/wsutil/frame"
+	"github.com/antlabs/wsutil/opcode"
+)
+
+// 按帧接收消息, 给协议调试工具和需要原样转发帧的网关使用
+// 实现了这个接口的Callback, 或者配置了With{Server,Client}OnFrameFunc之后:
+// text, binary和continuation帧不再合并, 也不解压缩, 直接调用OnFrame, 不会再调用OnMessage
+// payload是去掉掩码之后, 线上的原始数据; 压缩过的消息只有第一帧的rsv1是1, 后续的continuation帧需要自己记住
+// 控制帧也会调用OnFrame, 之后还是按原来的逻辑处理(自动回pong, 关闭握手等)
+// 在io go程里同步调用, 保证帧的顺序, 不要阻塞, payload只在调用期间有效, 需要保留请自行拷贝
+type FrameCallback interface {
+	OnFrame(c *Conn, h FrameHeader, payload []byte)
+}
+
+type OnFrameFunc func(c *Conn, h FrameHeader, payload []byte)
+
+func (o OnFrameFunc) OnFrame(c *Conn, h FrameHeader, payload []byte) {
+	o(c, h, payload)
+}
+
+// Callback自己实现了OnFrame, 并且没有单独配置OnFrameFunc的时候, 用Callback的
+func (c *Config) detectFrameCallback() {
+	if c.onFrame != nil {
+		return
+	}
+	if fc, ok := c.Callback.(FrameCallback); ok {
+		c.onFrame = fc.OnFrame
+	}
+}
+
+// 数据帧, 只检查帧的顺序, 不合并
+func (c *Conn) deliverDataFrame(f frame.Frame) error {
+	switch f.Opcode {
+	case opcode.Continuation:
+		if !c.fragment.active() {
+			c.writeErrAndOnClose(ProtocolError, ErrFrameOpcode)
+			return ErrFrameOpcode
+		}
+	case opcode.Text, opcode.Binary:
+		if c.fragment.active() {
+			c.writeErrAndOnClose(ProtocolError, ErrFrameOpcode)
+			return ErrFrameOpcode
+		}
+	default:
+		c.writeErrAndOnClose(ProtocolError, ErrOpcode)
+		return ErrOpcode
+	}
+
+	// 记住第一帧, 用于检查后面的continuation帧
+	if !f.GetFin() && !c.fragment.active() {
+		c.fragment = fragmentState{op: f.Opcode, compressed: f.GetRsv1()}
+	} else if f.GetFin() {
+		c.fragment.reset()
+	}
+
+	c.onFrame(c, f.FrameHeader, f.Payload)
+	PutPayloadBytes(&f.Payload)
+	return nil
+}
@@ -0,0 +1,57 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package greatws
+
+import (
+	"testing"
+
+	"github.com/antlabs/wsutil/bytespool"
+)
+
+func Test_OnFrame(t *testing.T) {
+	c := newFuzzParseConn([]byte{
+		0x01, 0x02, 'h', 'e', // text, 不是最后一帧
+		0x80, 0x03, 'l', 'l', 'o', // continuation, 最后一帧
+		0x82, 0x01, 'x', // binary
+	})
+	defer func() { bytespool.PutBytes(c.rbuf.buf) }()
+
+	type got struct {
+		op      Opcode
+		fin     bool
+		payload string
+	}
+	var frames []got
+	c.Callback = OnMessageFunc(func(*Conn, Opcode, []byte) {
+		t.Error("OnMessage should not be called")
+	})
+	c.allowUnmasked = true // 测试数据没有mask
+	c.onFrame = func(_ *Conn, h FrameHeader, payload []byte) {
+		frames = append(frames, got{h.Opcode, h.GetFin(), string(payload)})
+	}
+
+	for i := 0; i < 3; i++ {
+		if ok, err := c.readHeader(); !ok || err != nil {
+			t.Fatalf("readHeader: %t, %v", ok, err)
+		}
+		f, ok, err := c.readPayload()
+		if !ok || err != nil {
+			t.Fatalf("readPayload: %t, %v", ok, err)
+		}
+		if err := c.processCallback(f); err != nil {
+			t.Fatal(err)
+		}
+		c.curState = frameStateHeaderStart
+	}
+
+	want := []got{{Text, false, "he"}, {Continuation, true, "llo"}, {Binary, true, "x"}}
+	if len(frames) != len(want) {
+		t.Fatalf("frames = %v", frames)
+	}
+	for i := range want {
+		if frames[i] != want[i] {
+			t.Fatalf("frames[%d] = %v, want %v", i, frames[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,124 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package greatws
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/antlabs/wsutil/enum"
+	"github.com/antlabs/wsutil/frame"
+)
+
+// 读对端收到的下一帧
+func readNextFrame(t *testing.T, r net.Conn) frame.Frame {
+	t.Helper()
+	r.SetReadDeadline(time.Now().Add(3 * time.Second))
+	var (
+		head [enum.MaxFrameHeaderSize]byte
+		buf  []byte
+	)
+	f, err := frame.ReadFrameFromReader(r, &head, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Payload = append([]byte(nil), f.Payload...)
+	return f
+}
+
+// 每条写路径发一帧, 检查对端收到的帧是否加了掩码, 去掉掩码之后payload不变
+func checkFrameMask(t *testing.T, client bool, override *bool, want bool) {
+	opts := []testConnOption{withTestConnSetup(func(c *Conn) { c.maskOverride = override })}
+	if client {
+		opts = append(opts, withTestClient())
+	}
+	c, remote := newTestConn(t, opts...)
+
+	file := filepath.Join(t.TempDir(), "payload")
+	if err := os.WriteFile(file, []byte("sendfile"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	for _, tc := range []struct {
+		name    string
+		op      Opcode
+		payload string
+		write   func() error
+	}{
+		{"message", Text, "hello", func() error { return c.WriteMessage(Text, []byte("hello")) }},
+		{"deadline", Binary, "deadline", func() error {
+			return c.WriteMessageDeadline(Binary, []byte("deadline"), time.Now().Add(time.Second))
+		}},
+		{"control", Ping, "ping", func() error { return c.WriteControl(Ping, []byte("ping"), time.Time{}) }},
+		{"sendfile", Binary, "sendfile", func() error { return c.WriteMessageFromFile(Binary, f, 0, 8) }},
+		{"close", Close, string(FormatCloseMessage(NormalClosure, "bye")), func() error { return c.WriteClose(NormalClosure, "bye") }},
+	} {
+		if err := tc.write(); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		got := readNextFrame(t, remote)
+		if got.Mask != want {
+			t.Fatalf("%s: mask = %v, want %v", tc.name, got.Mask, want)
+		}
+		if got.Opcode != tc.op || string(got.Payload) != tc.payload {
+			t.Fatalf("%s: got %v %q", tc.name, got.Opcode, got.Payload)
+		}
+	}
+}
+
+// rfc 6455 5.1: 服务端发出去的帧不能加掩码, 客户端的帧必须加掩码
+func Test_FrameMaskConformance(t *testing.T) {
+	t.Run("server never masks", func(t *testing.T) {
+		checkFrameMask(t, false, nil, false)
+	})
+	t.Run("client always masks", func(t *testing.T) {
+		checkFrameMask(t, true, nil, true)
+	})
+
+	// 测试里可以构造违反协议的帧
+	on, off := true, false
+	t.Run("override server", func(t *testing.T) {
+		checkFrameMask(t, false, &on, true)
+	})
+	t.Run("override client", func(t *testing.T) {
+		checkFrameMask(t, true, &off, false)
+	})
+}
+
+// frame tracer看到的Mask和真正写出去的一致
+func Test_FrameMaskTrace(t *testing.T) {
+	for _, client := range []bool{false, true} {
+		trace := make(chan FrameHeader, 1)
+		opts := []testConnOption{withTestConnSetup(func(c *Conn) {
+			c.frameTracer = func(c *Conn, dir Direction, h FrameHeader, payload []byte) {
+				if dir == DirectionWrite {
+					select {
+					case trace <- h:
+					default:
+					}
+				}
+			}
+		})}
+		if client {
+			opts = append(opts, withTestClient())
+		}
+		c, remote := newTestConn(t, opts...)
+		if err := c.WriteMessage(Text, []byte("x")); err != nil {
+			t.Fatal(err)
+		}
+		got := readNextFrame(t, remote)
+		traced := <-trace
+		if traced.Mask != client || got.Mask != client || (client && traced.MaskKey != got.MaskKey) {
+			t.Fatalf("client = %v, traced %v/%x, wire %v/%x", client, traced.Mask, traced.MaskKey, got.Mask, got.MaskKey)
+		}
+	}
+}
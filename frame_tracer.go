@@ -0,0 +1,72 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+package greatws
+
+import "github.com/antlabs/wsutil/frame"
+
+type FrameHeader = frame.FrameHeader
+
+// 帧的方向
+type Direction int
+
+const (
+	DirectionRead  Direction = iota // 收到的帧
+	DirectionWrite                  // 发送的帧
+)
+
+func (d Direction) String() string {
+	if d == DirectionRead {
+		return "read"
+	}
+	return "write"
+}
+
+// 每一帧都会调用, 收到的帧已经去掉掩码, 还没有解压缩; 发送的帧已经压缩, 还没有加掩码
+// 在io go程里同步调用, 不要阻塞, payload只在调用期间有效, 需要保留请自行拷贝
+type FrameTracer func(c *Conn, dir Direction, h FrameHeader, payload []byte)
+
+func (c *Conn) traceRead(f *frame.Frame) {
+	if c.frameTracer != nil {
+		c.frameTracer(c, DirectionRead, f.FrameHeader, f.Payload)
+	}
+}
+
+func (c *Conn) traceWrite(payload []byte, fin bool, rsv1 bool, op Opcode, maskValue uint32) {
+	c.traceWriteLen(int64(len(payload)), payload, fin, rsv1, op, maskValue)
+}
+
+// payload不在内存里的时候(比如sendfile), 只传长度, payload为nil
+func (c *Conn) traceWriteLen(payloadLen int64, payload []byte, fin bool, rsv1 bool, op Opcode, maskValue uint32) {
+	if c.frameTracer == nil {
+		return
+	}
+
+	var h FrameHeader
+	if fin {
+		h.Head |= 1 << 7
+	}
+	if rsv1 {
+		h.Head |= 1 << 6
+	}
+	h.Head |= byte(op)
+	h.Opcode = op
+	h.PayloadLen = payloadLen
+	h.Mask = c.maskFrames()
+	h.MaskKey = maskValue
+	c.frameTracer(c, DirectionWrite, h, payload)
+}
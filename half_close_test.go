@@ -0,0 +1,212 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package greatws
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/antlabs/wsutil/enum"
+	"github.com/antlabs/wsutil/frame"
+	"golang.org/x/sys/unix"
+)
+
+// 收到的消息原样发回去
+type halfCloseRecorder struct {
+	closeRecorder
+	mu   sync.Mutex
+	msgs []string
+}
+
+func (r *halfCloseRecorder) OnMessage(c *Conn, op Opcode, payload []byte) {
+	r.mu.Lock()
+	r.msgs = append(r.msgs, string(payload))
+	r.mu.Unlock()
+	c.WriteMessage(op, payload)
+}
+
+func newHalfCloseRecorder() *halfCloseRecorder {
+	return &halfCloseRecorder{closeRecorder: closeRecorder{done: make(chan struct{})}}
+}
+
+// 读到eof为止, 返回收到的所有帧
+func readAllFrames(t *testing.T, r net.Conn) []frame.Frame {
+	t.Helper()
+	r.SetReadDeadline(time.Now().Add(3 * time.Second))
+	var (
+		head   [enum.MaxFrameHeaderSize]byte
+		frames []frame.Frame
+	)
+	for {
+		var buf []byte
+		f, err := frame.ReadFrameFromReader(r, &head, &buf)
+		if errors.Is(err, io.EOF) {
+			return frames
+		}
+		if err != nil {
+			t.Fatalf("read frame: %v, got %d frames", err, len(frames))
+		}
+		f.Payload = append([]byte(nil), f.Payload...)
+		frames = append(frames, f)
+	}
+}
+
+// 对端写完数据之后shutdown(SHUT_WR), 缓冲区里的消息都要交给回调, 回复发完之后再发close帧
+func Test_HalfCloseDeliversPending(t *testing.T) {
+	r := newHalfCloseRecorder()
+	_, conn := newTestConn(t, withTestCallback(r))
+	remote := conn.(*net.UnixConn)
+
+	var wire bytes.Buffer
+	want := []string{"one", "two", "three"}
+	for _, s := range want {
+		appendClientFrame(t, &wire, true, false, Text, s)
+	}
+	if _, err := remote.Write(wire.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.CloseWrite(); err != nil {
+		t.Skip("CloseWrite:", err)
+	}
+
+	frames := readAllFrames(t, remote)
+	if err := r.wait(t); !errors.Is(err, io.EOF) {
+		t.Fatalf("err = %v", err)
+	}
+
+	r.mu.Lock()
+	got := append([]string(nil), r.msgs...)
+	r.mu.Unlock()
+	sort.Strings(got)
+	sort.Strings(want)
+	if len(got) != len(want) {
+		t.Fatalf("messages = %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("messages = %q, want %q", got, want)
+		}
+	}
+
+	// 三条回复在前, close帧在最后
+	if len(frames) != len(want)+1 {
+		t.Fatalf("got %d frames", len(frames))
+	}
+	for _, f := range frames[:len(want)] {
+		if f.Opcode != Text {
+			t.Fatalf("opcode = %v", f.Opcode)
+		}
+	}
+	last := frames[len(frames)-1]
+	if last.Opcode != Close || len(last.Payload) < 2 || StatusCode(binary.BigEndian.Uint16(last.Payload)) != NormalClosure {
+		t.Fatalf("last frame = %v % x", last.Opcode, last.Payload)
+	}
+}
+
+// 对端先发close帧再shutdown, 只回一个close帧, OnClose收到对端的关闭码
+func Test_HalfCloseAfterCloseFrame(t *testing.T) {
+	r := newHalfCloseRecorder()
+	_, conn := newTestConn(t, withTestCallback(r))
+	remote := conn.(*net.UnixConn)
+
+	var wire bytes.Buffer
+	appendClientFrame(t, &wire, true, false, Text, "bye")
+	appendClientFrame(t, &wire, true, false, Close, string(FormatCloseMessage(EndpointGoingAway, "")))
+	if _, err := remote.Write(wire.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.CloseWrite(); err != nil {
+		t.Skip("CloseWrite:", err)
+	}
+
+	frames := readAllFrames(t, remote)
+	err := r.wait(t)
+	var ce *CloseErrMsg
+	if !errors.As(err, &ce) || ce.Code != EndpointGoingAway {
+		t.Fatalf("err = %v", err)
+	}
+
+	closes := 0
+	for _, f := range frames {
+		if f.Opcode == Close {
+			closes++
+		}
+	}
+	if closes != 1 {
+		t.Fatalf("got %d close frames", closes)
+	}
+}
+
+// 一条消息只到了一半就eof, 已经完整的消息照常交给回调
+func Test_HalfClosePartialFrame(t *testing.T) {
+	r := newHalfCloseRecorder()
+	_, conn := newTestConn(t, withTestCallback(r))
+	remote := conn.(*net.UnixConn)
+
+	var wire bytes.Buffer
+	appendClientFrame(t, &wire, true, false, Binary, "whole")
+	appendClientFrame(t, &wire, true, false, Binary, "truncated")
+	if _, err := remote.Write(wire.Bytes()[:wire.Len()-3]); err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.CloseWrite(); err != nil {
+		t.Skip("CloseWrite:", err)
+	}
+
+	readAllFrames(t, remote)
+	if err := r.wait(t); !errors.Is(err, io.EOF) {
+		t.Fatalf("err = %v", err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.msgs) != 1 || r.msgs[0] != "whole" {
+		t.Fatalf("messages = %q", r.msgs)
+	}
+}
+
+// eof之后fd还会被报告可读, 只发起一次关闭握手, 不再读fd
+// close帧第一次写返回EAGAIN, 放在写缓冲区里等可写事件, 不能被之后的可读事件提前关闭连接丢掉
+func Test_HalfCloseRepeatedReadable(t *testing.T) {
+	r := newHalfCloseRecorder()
+	c, conn := newTestConn(t, withTestCallback(r), withScriptedWrite(writeStep{}, writeStep{err: unix.EAGAIN}))
+	remote := conn.(*net.UnixConn)
+
+	var wire bytes.Buffer
+	appendClientFrame(t, &wire, true, false, Text, "hello")
+	if _, err := remote.Write(wire.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.CloseWrite(); err != nil {
+		t.Skip("CloseWrite:", err)
+	}
+
+	// 模拟重复的可读事件
+	el := c.getParent()
+	for i := 0; i < 20; i++ {
+		el.Execute(func() {
+			if !c.isClosed() {
+				c.processWebsocketFrame()
+			}
+		})
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	frames := readAllFrames(t, remote)
+	if err := r.wait(t); !errors.Is(err, io.EOF) {
+		t.Fatalf("err = %v", err)
+	}
+	if len(frames) != 2 || frames[0].Opcode != Text || frames[1].Opcode != Close {
+		t.Fatalf("got %d frames", len(frames))
+	}
+	if code := StatusCode(binary.BigEndian.Uint16(frames[1].Payload)); code != NormalClosure {
+		t.Fatalf("close code = %v", code)
+	}
+}
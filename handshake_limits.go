@@ -0,0 +1,98 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+)
+
+// 握手阶段的限制, 防止慢速攻击(slowloris)和超大的请求头占住连接
+type handshakeLimits struct {
+	maxHeaderBytes int           // 请求行加请求头的最大字节数, 0表示不限制
+	timeout        time.Duration // 从accept到升级完成的最长时间, 0表示不限制
+}
+
+type acceptTimeKey struct{}
+
+// 给http.Server.ConnContext使用, 记录连接accept的时间, Upgrade用它计算整个握手的耗时
+// 没有记录的话, 只限制hijack之后写响应的时间
+func HandshakeConnContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, acceptTimeKey{}, time.Now())
+}
+
+// 配置http.Server, 让net/http在读请求头的时候就按同样的限制拒绝
+// 请求头由net/http读取, 到Upgrade的时候已经读完了, 慢速的客户端只能在这一层挡住
+func ConfigureHandshakeLimits(srv *http.Server, maxHeaderBytes int, timeout time.Duration) {
+	if maxHeaderBytes > 0 {
+		srv.MaxHeaderBytes = maxHeaderBytes
+	}
+	if timeout > 0 {
+		srv.ReadHeaderTimeout = timeout
+	}
+
+	next := srv.ConnContext
+	srv.ConnContext = func(ctx context.Context, c net.Conn) context.Context {
+		if next != nil {
+			ctx = next(ctx, c)
+		}
+		return HandshakeConnContext(ctx, c)
+	}
+}
+
+// 估算请求行和请求头的大小, 和net/http的MaxHeaderBytes口径一致, 都是按原始字节数算
+func requestHeaderSize(r *http.Request) int {
+	// GET /path HTTP/1.1\r\n
+	n := len(r.Method) + len(r.RequestURI) + len(r.Proto) + 4
+	// Host: xxx\r\n, net/http会把Host从Header里拿出来
+	n += len("Host: ") + len(r.Host) + 2
+	for k, vs := range r.Header {
+		for _, v := range vs {
+			// k: v\r\n
+			n += len(k) + len(v) + 4
+		}
+	}
+	// 最后的空行
+	return n + 2
+}
+
+// 返回握手的截止时间, 没有限制返回零值
+func (l *handshakeLimits) deadline(r *http.Request) time.Time {
+	if l.timeout <= 0 {
+		return time.Time{}
+	}
+
+	start := time.Now()
+	if t, ok := r.Context().Value(acceptTimeKey{}).(time.Time); ok {
+		start = t
+	}
+	return start.Add(l.timeout)
+}
+
+func (l *handshakeLimits) check(r *http.Request, deadline time.Time) (ecode int, err error) {
+	if l.maxHeaderBytes > 0 && requestHeaderSize(r) > l.maxHeaderBytes {
+		return http.StatusRequestHeaderFieldsTooLarge, ErrHandshakeHeaderTooLarge
+	}
+
+	if !deadline.IsZero() && !time.Now().Before(deadline) {
+		return http.StatusRequestTimeout, ErrHandshakeTimeout
+	}
+	return 0, nil
+}
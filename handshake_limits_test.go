@@ -0,0 +1,60 @@
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_HandshakeLimits(t *testing.T) {
+	m := NewMultiEventLoopMust(WithEventLoops(1))
+	m.Start()
+
+	newReq := func() *http.Request {
+		r := httptest.NewRequest("GET", "/ws", nil)
+		r.Header.Set("Upgrade", "websocket")
+		r.Header.Set("Connection", "Upgrade")
+		r.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		r.Header.Set("Sec-WebSocket-Version", "13")
+		return r
+	}
+
+	t.Run("header too large", func(t *testing.T) {
+		r := newReq()
+		r.Header.Set("X-Big", strings.Repeat("a", 2048))
+		w := httptest.NewRecorder()
+		_, err := Upgrade(w, r, WithServerMultiEventLoop(m), WithServerMaxHandshakeHeaderBytes(1024))
+		if err != ErrHandshakeHeaderTooLarge || w.Code != http.StatusRequestHeaderFieldsTooLarge {
+			t.Fatalf("err = %v, code = %d", err, w.Code)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		r := newReq()
+		ctx := context.WithValue(r.Context(), acceptTimeKey{}, time.Now().Add(-time.Second))
+		r = r.WithContext(ctx)
+		w := httptest.NewRecorder()
+		_, err := Upgrade(w, r, WithServerMultiEventLoop(m), WithServerHandshakeTimeout(100*time.Millisecond))
+		if err != ErrHandshakeTimeout || w.Code != http.StatusRequestTimeout {
+			t.Fatalf("err = %v, code = %d", err, w.Code)
+		}
+	})
+
+	t.Run("configure server", func(t *testing.T) {
+		srv := &http.Server{}
+		ConfigureHandshakeLimits(srv, 4096, time.Second)
+		if srv.MaxHeaderBytes != 4096 || srv.ReadHeaderTimeout != time.Second {
+			t.Fatalf("srv = %d, %v", srv.MaxHeaderBytes, srv.ReadHeaderTimeout)
+		}
+		ctx := srv.ConnContext(context.Background(), nil)
+		if _, ok := ctx.Value(acceptTimeKey{}).(time.Time); !ok {
+			t.Fatal("accept time not recorded")
+		}
+	})
+}
@@ -0,0 +1,55 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux || darwin || netbsd || freebsd || openbsd || dragonfly
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package greatws
+
+import "time"
+
+// io_uring模式下, 提交的写请求按seq存放在m里, 完成的时候取出来释放内存
+func (c *Conn) storeWrite(seq uint32, w *ioUringWrite) {
+	c.m.Store(seq, w)
+	c.inflight.Add(1)
+}
+
+func (c *Conn) takeWrite(seq uint32) (*ioUringWrite, bool) {
+	v, ok := c.m.LoadAndDelete(seq)
+	if !ok {
+		return nil, false
+	}
+	c.inflight.Add(-1)
+	return v.(*ioUringWrite), true
+}
+
+// 取出提交超过timeout还没有完成的写请求
+// cqe迟迟不来的话这些请求会一直占着m和seq, 取出来交给事件循环取消
+// 内核可能还在引用这些内存, 调用方要等取消完成再释放
+func (c *Conn) takeStaleWrites(now time.Time, timeout time.Duration) (stale map[uint32]*ioUringWrite) {
+	deadline := now.Add(-timeout).UnixNano()
+	c.m.Range(func(k, v any) bool {
+		if v.(*ioUringWrite).submitted > deadline {
+			return true
+		}
+		if w, ok := c.takeWrite(k.(uint32)); ok {
+			if stale == nil {
+				stale = make(map[uint32]*ioUringWrite)
+			}
+			stale[k.(uint32)] = w
+		}
+		return true
+	})
+	return stale
+}
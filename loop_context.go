@@ -0,0 +1,62 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"bytes"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+)
+
+// 当前go程是不是这个事件循环的go程
+// 读缓冲区, 解析状态这些字段只在事件循环里修改, 在别的go程里访问之前先用Execute投递过来
+// 需要解析runtime.Stack, 每次调用微秒级, 不要放在热路径上
+func (el *EventLoop) InLoop() bool {
+	id := atomic.LoadInt64(&el.goid)
+	return id != 0 && id == curGoroutineID()
+}
+
+// 当前go程的id, 从runtime.Stack的第一行"goroutine 123 [running]:"里解析
+func curGoroutineID() int64 {
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	b = bytes.TrimPrefix(b, []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i > 0 {
+		b = b[:i]
+	}
+	id, _ := strconv.ParseInt(string(b), 10, 64)
+	return id
+}
+
+// 调试用, 检查只能在事件循环里访问的字段是不是在事件循环的go程里访问
+// 默认是空函数, 编译的时候加上-tags greatws_debug才检查, 不在事件循环里会panic
+// 连接还没加入事件循环, 或者事件循环还没启动的时候不检查, 比如握手时解析已经读到的数据
+func (c *Conn) assertInLoop(what string) {
+	if !loopAssertEnabled {
+		return
+	}
+
+	el := c.getParent()
+	if el == nil || atomic.LoadInt64(&el.goid) == 0 {
+		return
+	}
+	if !el.InLoop() {
+		panic("greatws: " + what + " accessed outside of its event loop")
+	}
+}
@@ -0,0 +1,60 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package greatws
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_InLoop(t *testing.T) {
+	c, _ := newTestConn(t)
+	el := c.EventLoop()
+	if el == nil {
+		t.Fatal("EventLoop is nil")
+	}
+	if el.InLoop() {
+		t.Fatal("test goroutine is not the loop")
+	}
+
+	got := make(chan bool, 1)
+	if err := el.Execute(func() { got <- el.InLoop() }); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case in := <-got:
+		if !in {
+			t.Fatal("Execute should run in the loop")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("task not executed")
+	}
+
+	// 没有加入事件循环的连接
+	if c := newConn(-1, false, c.Config); c.EventLoop() != nil {
+		t.Fatal("EventLoop should be nil")
+	}
+}
+
+// go test -tags greatws_debug
+func Test_AssertInLoop(t *testing.T) {
+	if !loopAssertEnabled {
+		t.Skip("build with -tags greatws_debug")
+	}
+
+	c, _ := newTestConn(t)
+	// 等事件循环的go程跑起来, 之前不检查
+	done := make(chan struct{})
+	if err := c.EventLoop().Execute(func() { close(done) }); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic")
+		}
+	}()
+	c.assertInLoop("read buffer")
+}
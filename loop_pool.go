@@ -0,0 +1,77 @@
+// Copyright 2023-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"sync"
+
+	"github.com/antlabs/wsutil/bytespool"
+)
+
+// 绑定cpu的事件循环自己的读缓冲区池, 分级方式和pool.go一样
+// 读缓冲区扩容发生在事件循环的线程上, 新分配的内存由这个线程第一次写入,
+// 按linux的first-touch策略会落在这个cpu所在的numa节点, 之后只在同一个循环的连接之间复用
+// go的堆本身不感知numa, 这里做不到更强的保证
+type loopBytesPool struct {
+	pools [maxIndex]sync.Pool
+}
+
+func (p *loopBytesPool) get(n int) *[]byte {
+	index := selectIndex(n - 1)
+	if index >= maxIndex {
+		rv := make([]byte, n)
+		return &rv
+	}
+
+	if v := p.pools[index].Get(); v != nil {
+		rv := v.(*[]byte)
+		*rv = (*rv)[:cap(*rv)]
+		return rv
+	}
+
+	rv := make([]byte, (index+1)*page)
+	return &rv
+}
+
+func (p *loopBytesPool) put(b *[]byte) {
+	if cap(*b) == 0 || cap(*b)%page != 0 {
+		return
+	}
+
+	index := selectIndex(cap(*b) - 1)
+	if index >= maxIndex {
+		return
+	}
+	p.pools[index].Put(b)
+}
+
+// 扩容读缓冲区, 所在的事件循环绑定了cpu时使用循环自己的池子
+func (c *Conn) getRbuf(n int) *[]byte {
+	if el := c.getParent(); el != nil && el.bufPool != nil {
+		return el.bufPool.get(n)
+	}
+	return bytespool.GetBytes(n)
+}
+
+func (c *Conn) putRbuf(b *[]byte) {
+	if el := c.getParent(); el != nil && el.bufPool != nil {
+		el.bufPool.put(b)
+		return
+	}
+	bytespool.PutBytes(b)
+}
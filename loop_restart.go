@@ -0,0 +1,96 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+const (
+	defaultLoopMaxRestarts    = 3
+	defaultLoopRestartBackoff = 10 * time.Millisecond
+)
+
+// apiPoll出错之后在事件循环里调用, 返回true表示poller已经重建, 继续事件循环
+// 连续出错超过loopMaxRestarts次, 或者重建失败, 关闭这个事件循环上的连接, 返回false让事件循环退出
+func (el *EventLoop) recoverPoll(err error) bool {
+	m := el.parent
+	el.pollFailures++
+	m.Error("apiPoll", slog.String("err", err.Error()), slog.Int("attempt", el.pollFailures))
+	if m.onLoopError != nil {
+		m.onLoopError(el, err, el.pollFailures)
+	}
+
+	if el.pollFailures > m.loopMaxRestarts {
+		el.closeAllConns(fmt.Errorf("%w: %w", ErrLoopFailed, err))
+		return false
+	}
+
+	time.Sleep(time.Duration(el.pollFailures) * m.loopRestartBackoff)
+	if el.isShutdown() {
+		return false
+	}
+
+	if rerr := el.rebuildPoller(); rerr != nil {
+		m.Error("rebuild poller", slog.String("err", rerr.Error()))
+		el.closeAllConns(fmt.Errorf("%w: %w", ErrLoopFailed, rerr))
+		return false
+	}
+	return true
+}
+
+// 重新创建epoll/kqueue/io_uring, 把还在的连接重新注册上去
+// 写缓冲区里有数据的连接要重新关注可写事件, 注册失败的连接直接关闭
+// io_uring模式下旧ring上还没有完成的写请求不会再有cqe, 由WithIoUringWriteTimeout回收并关闭连接
+// 业务go程在重建的时候还在用旧的poller注册可写事件的话, 会返回错误, 对应的连接被关闭
+func (el *EventLoop) rebuildPoller() error {
+	old := el.apiState
+	if err := el.apiCreate(el.parent.flag); err != nil {
+		if el.apiState != old {
+			el.apiState.free()
+			el.apiState = old
+		}
+		return err
+	}
+	old.free()
+
+	for _, c := range el.snapshotConns() {
+		if c.isClosed() {
+			continue
+		}
+		err := el.addRead(c)
+		if err == nil && c.writePending() {
+			err = el.addWrite(c, 0)
+		}
+		if err != nil {
+			c.setCloseReason(err)
+			c.asyncClose(err)
+		}
+	}
+	return nil
+}
+
+// 事件循环不能再用了, 关闭上面所有的连接
+func (el *EventLoop) closeAllConns(err error) {
+	for _, c := range el.snapshotConns() {
+		c.setCloseReason(err)
+		c.asyncClose(err)
+	}
+}
@@ -0,0 +1,104 @@
+//go:build linux
+// +build linux
+
+package greatws
+
+import (
+	"bytes"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+type loopError struct {
+	err     error
+	attempt int
+}
+
+// 事件循环出错时的通知放进errs
+func recordLoopErrors(errs chan loopError) EvOption {
+	return WithOnLoopError(func(_ *EventLoop, err error, attempt int) {
+		errs <- loopError{err, attempt}
+	})
+}
+
+// 在事件循环里把c所在的事件循环的epfd换成无效的值, 下一次epoll_wait返回EBADF
+func breakEpoll(t *testing.T, c *Conn) *EventLoop {
+	if c.multiEventLoop.flag != EVENT_EPOLL {
+		t.Skip("epoll only")
+	}
+	el := c.getParent()
+	done := make(chan int)
+	el.Execute(func() {
+		es := el.apiState.linuxApi.(*epollState)
+		epfd := es.epfd
+		es.epfd = -1
+		done <- epfd
+	})
+	epfd := <-done
+	t.Cleanup(func() { unix.Close(epfd) })
+	return el
+}
+
+func Test_LoopRestart(t *testing.T) {
+	errs := make(chan loopError, 8)
+	r := newHalfCloseRecorder()
+	c, remote := newTestConn(t, withTestEvOptions(recordLoopErrors(errs)), withTestCallback(r))
+	breakEpoll(t, c)
+
+	select {
+	case e := <-errs:
+		if !errors.Is(e.err, unix.EBADF) || e.attempt != 1 {
+			t.Fatalf("loop error = %v, attempt = %d", e.err, e.attempt)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("OnLoopError not called")
+	}
+
+	// 重建之后连接重新注册, 照常收发
+	var wire bytes.Buffer
+	appendClientFrame(t, &wire, true, false, Text, "after restart")
+	if _, err := remote.Write(wire.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0x81, byte(len("after restart"))}
+	want = append(want, "after restart"...)
+	if got := readExactly(t, remote, len(want)); !bytes.Equal(got, want) {
+		t.Fatalf("got %q", got)
+	}
+	if n := atomic.LoadInt32(&r.n); n != 0 {
+		t.Fatal("conn closed")
+	}
+}
+
+func Test_LoopRestartExhausted(t *testing.T) {
+	errs := make(chan loopError, 8)
+	r := newHalfCloseRecorder()
+	c, _ := newTestConn(t, withTestEvOptions(recordLoopErrors(errs), WithLoopRestart(0, 0)), withTestCallback(r))
+	el := breakEpoll(t, c)
+
+	if err := r.wait(t); !errors.Is(err, ErrLoopFailed) || !errors.Is(err, unix.EBADF) {
+		t.Fatalf("err = %v", err)
+	}
+	select {
+	case <-el.done:
+	case <-time.After(3 * time.Second):
+		t.Fatal("loop not stopped")
+	}
+	if e := <-errs; e.attempt != 1 {
+		t.Fatalf("attempt = %d", e.attempt)
+	}
+}
+
+func Test_LoopRestartOption(t *testing.T) {
+	m := NewMultiEventLoopMust(WithEventLoops(1))
+	if m.loopMaxRestarts != defaultLoopMaxRestarts || m.loopRestartBackoff != defaultLoopRestartBackoff {
+		t.Fatalf("default = %d, %v", m.loopMaxRestarts, m.loopRestartBackoff)
+	}
+	if _, err := NewMultiEventLoop(WithLoopRestart(-1, 0)); !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("err = %v", err)
+	}
+}
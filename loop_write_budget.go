@@ -0,0 +1,70 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+package greatws
+
+// 每一轮事件循环开始的时候调用, 重置写的预算, 先轮流刷上一轮超出预算推迟的连接
+// 返回true表示还有推迟的连接, 这一轮等待事件的时候不能阻塞
+func (el *EventLoop) beginTick() bool {
+	budget := el.parent.writeBudget
+	if budget <= 0 {
+		return false
+	}
+	el.writeBudget = budget
+
+	// 每个连接这一轮最多轮到一次, 再次超出预算的排到队尾
+	n := len(el.deferredWrite)
+	i := 0
+	for ; i < n && el.writeBudget > 0; i++ {
+		c := el.deferredWrite[i]
+		el.deferredWrite[i] = nil
+		c.writeDeferred = false
+		if !c.isClosed() {
+			el.flushWritable(c)
+		}
+	}
+	el.deferredWrite = el.deferredWrite[:copy(el.deferredWrite, el.deferredWrite[i:])]
+	return len(el.deferredWrite) > 0
+}
+
+// 可写事件里调用, 配置了WithLoopWriteBudget时最多刷出这一轮剩下的预算
+// 没有刷完的连接排到推迟队列里, 下一轮继续, 避免几个积压了大量数据的连接占住事件循环
+func (el *EventLoop) flushWritable(c *Conn) {
+	if el.parent.writeBudget <= 0 {
+		c.flushOrClose()
+		return
+	}
+	// 已经在排队了, 等轮到它
+	if c.writeDeferred {
+		return
+	}
+	if el.writeBudget <= 0 {
+		el.deferWrite(c)
+		return
+	}
+
+	n, more, err := c.flushOrCloseLimit(el.writeBudget)
+	el.writeBudget -= n
+	if err == nil && more {
+		el.deferWrite(c)
+	}
+}
+
+func (el *EventLoop) deferWrite(c *Conn) {
+	c.writeDeferred = true
+	el.deferredWrite = append(el.deferredWrite, c)
+}
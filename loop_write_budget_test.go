@@ -0,0 +1,87 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package greatws
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+)
+
+// 模拟积压在写缓冲区里的数据
+func withPendingWrite(pending []byte) testConnOption {
+	return withTestConnSetup(func(c *Conn) {
+		c.wbuf.Append(pending)
+		c.queued += int64(len(pending))
+	})
+}
+
+func Test_LoopWriteBudget(t *testing.T) {
+	tl, err := NewTestEventLoop(WithLoopWriteBudget(1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tl.Close()
+
+	payload := bytes.Repeat([]byte("0123456789"), 300)
+	c1, r1 := newTestConn(t, withTestLoop(tl), withPendingWrite(payload))
+	c2, r2 := newTestConn(t, withTestLoop(tl), withPendingWrite(payload))
+	el := tl.el
+
+	pending := func(want1, want2 int) {
+		t.Helper()
+		if n1, n2 := c1.pendingWriteLen(), c2.pendingWriteLen(); n1 != want1 || n2 != want2 {
+			t.Fatalf("pending = %d, %d, want %d, %d", n1, n2, want1, want2)
+		}
+	}
+
+	el.beginTick()
+	el.flushWritable(c1)
+	el.flushWritable(c2)
+	// c1用完了这一轮的预算, c2排在后面
+	pending(2000, 3000)
+	if len(el.deferredWrite) != 2 || !c1.writeDeferred || !c2.writeDeferred {
+		t.Fatalf("deferred = %d", len(el.deferredWrite))
+	}
+	// 已经在排队的连接不会插队
+	el.flushWritable(c1)
+	pending(2000, 3000)
+
+	// 之后每一轮轮流刷一个连接
+	for _, want := range [][2]int{{1000, 3000}, {1000, 2000}, {0, 2000}, {0, 1000}, {0, 0}} {
+		el.beginTick()
+		pending(want[0], want[1])
+	}
+	if el.beginTick() || len(el.deferredWrite) != 0 {
+		t.Fatalf("deferred = %d", len(el.deferredWrite))
+	}
+
+	for _, r := range []net.Conn{r1, r2} {
+		got := make([]byte, len(payload))
+		if _, err := io.ReadFull(r, got); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, payload) {
+			t.Fatal("payload mismatch")
+		}
+	}
+}
+
+func Test_LoopWriteBudgetUnlimited(t *testing.T) {
+	tl, err := NewTestEventLoop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tl.Close()
+
+	c, _ := newTestConn(t, withTestLoop(tl), withPendingWrite(make([]byte, 3000)))
+	if tl.el.beginTick() {
+		t.Fatal("deferred without budget")
+	}
+	tl.el.flushWritable(c)
+	if n := c.pendingWriteLen(); n != 0 || c.writeDeferred {
+		t.Fatalf("pending = %d", n)
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	crand "crypto/rand"
+	"encoding/binary"
+	"math/rand"
+	"sync/atomic"
+)
+
+// 客户端生成掩码的函数, 每发送一帧调用一次, 可能在多个go程里并发调用
+// 默认使用math/rand, 可以换成固定的序列, 方便生成确定的测试向量
+type MaskKeyFunc func() uint32
+
+// 用crypto/rand生成掩码, 中间的代理无法预测, 开销最大
+func CryptoMaskKey() uint32 {
+	var b [4]byte
+	if _, err := crand.Read(b[:]); err != nil {
+		return rand.Uint32()
+	}
+	return binary.LittleEndian.Uint32(b[:])
+}
+
+// 每个连接一个xorshift32生成器, 种子来自crypto/rand
+// 不和其他连接竞争math/rand的全局状态, 适合一个进程里有大量客户端连接的场景(压测)
+func NewXorshiftMaskKey() MaskKeyFunc {
+	var state uint32
+	for state == 0 {
+		// xorshift的状态不能是0
+		state = CryptoMaskKey()
+	}
+
+	return func() uint32 {
+		for {
+			old := atomic.LoadUint32(&state)
+			x := old
+			x ^= x << 13
+			x ^= x >> 17
+			x ^= x << 5
+			if atomic.CompareAndSwapUint32(&state, old, x) {
+				return x
+			}
+		}
+	}
+}
+
+// 客户端发送的帧使用的掩码
+func (c *Conn) genMaskKey() uint32 {
+	if c.maskKey != nil {
+		return c.maskKey()
+	}
+	return rand.Uint32()
+}
+
+// 发出去的帧是否加掩码, rfc 6455: 客户端必须加, 服务端不能加
+// 所有写路径(包括io_uring)都用这里的结果, 不要直接看c.client
+func (c *Conn) maskFrames() bool {
+	if c.maskOverride != nil {
+		return *c.maskOverride
+	}
+	return c.client
+}
+
+// 发送一帧使用的掩码, 不加掩码时返回0
+func (c *Conn) frameMaskKey() uint32 {
+	if !c.maskFrames() {
+		return 0
+	}
+	return c.genMaskKey()
+}
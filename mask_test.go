@@ -0,0 +1,125 @@
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+
+	"github.com/antlabs/wsutil/mask"
+)
+
+// gobwas/ws的实现, 用来对比
+func maskGobwas(payload []byte, key uint32) {
+	var k [4]byte
+	binary.LittleEndian.PutUint32(k[:], key)
+	mask.Cipher(payload, k, 0)
+}
+
+func Test_MaskFunc(t *testing.T) {
+	for _, n := range []int{0, 1, 7, 8, 9, 127, 128, 129, 1024, 4099} {
+		want := make([]byte, n)
+		got := make([]byte, n)
+		for i := range want {
+			want[i] = byte(i)
+			got[i] = byte(i)
+		}
+
+		maskPayload(want, 0x12345678)
+		maskGobwas(got, 0x12345678)
+		if !bytes.Equal(want, got) {
+			t.Fatalf("maskGobwas(%d) != maskPayload", n)
+		}
+	}
+}
+
+// 有连接在收发数据时调用SetMaskFunc, -race下不能报数据竞争
+func Test_SetMaskFuncConcurrent(t *testing.T) {
+	defer SetMaskFunc(nil)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		payload := make([]byte, 64)
+		for i := 0; i < 1000; i++ {
+			maskPayload(payload, 0x12345678)
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		SetMaskFunc(maskGobwas)
+		SetMaskFunc(nil)
+	}
+	<-done
+
+	// 两种实现的结果一样, 恢复默认之后也不变
+	want := []byte("hello world")
+	got := append([]byte(nil), want...)
+	SetMaskFunc(maskGobwas)
+	maskPayload(got, 0x12345678)
+	SetMaskFunc(nil)
+	maskPayload(got, 0x12345678)
+	if !bytes.Equal(got, want) {
+		t.Fatalf("got %q", got)
+	}
+}
+
+func Benchmark_Mask(b *testing.B) {
+	for _, size := range []int{125, 1024, 64 * 1024} {
+		for _, v := range []struct {
+			name string
+			f    MaskFunc
+		}{
+			{"default", maskPayload},
+			{"gobwas", maskGobwas},
+		} {
+			b.Run(fmt.Sprintf("%s_%d", v.name, size), func(b *testing.B) {
+				payload := make([]byte, size)
+				b.SetBytes(int64(size))
+				for i := 0; i < b.N; i++ {
+					v.f(payload, 0x12345678)
+				}
+			})
+		}
+	}
+}
+
+func Test_MaskKey(t *testing.T) {
+	t.Run("xorshift", func(t *testing.T) {
+		f := NewXorshiftMaskKey()
+		seen := make(map[uint32]bool)
+		for i := 0; i < 1000; i++ {
+			k := f()
+			if k == 0 || seen[k] {
+				t.Fatalf("key %d = %x", i, k)
+			}
+			seen[k] = true
+		}
+	})
+
+	t.Run("custom", func(t *testing.T) {
+		keys := []uint32{0x11223344, 0x55667788}
+		i := 0
+		var o DialOption
+		WithClientMaskKeyFunc(func() uint32 {
+			k := keys[i%len(keys)]
+			i++
+			return k
+		})(&o)
+
+		c := newConn(-1, true, &o.Config)
+		defer c.putRbuf(c.rbuf.buf)
+		for _, want := range append(keys, keys...) {
+			if got := c.genMaskKey(); got != want {
+				t.Fatalf("got %x, want %x", got, want)
+			}
+		}
+
+		// 服务端不使用
+		if s := newConn(-1, false, &o.Config); s.maskKey != nil {
+			t.Fatal("server conn has mask key func")
+		}
+	})
+}
@@ -0,0 +1,192 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// 内存里的连接, 不需要socket和epoll/kqueue, 用于测试
+// 写出去的数据拷贝一份放到对端的队列里, 对端自己的go程把数据放进读缓冲区, 走和事件循环一样的解析和回调
+type memEndpoint struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queue  [][]byte
+	eof    bool // 对端关闭了, 队列里的数据处理完之后按io.EOF关闭
+	closed bool // 自己关闭了, 丢弃之后收到的数据
+	peer   *memEndpoint
+	c      *Conn
+}
+
+func newMemEndpoint(c *Conn) *memEndpoint {
+	e := &memEndpoint{c: c}
+	e.cond = sync.NewCond(&e.mu)
+	return e
+}
+
+// 持有c.mu时调用, 总是全部写完, 不会用到写缓冲区
+func (e *memEndpoint) write(b []byte) (int, error) {
+	peer := e.peer
+	peer.mu.Lock()
+	defer peer.mu.Unlock()
+	if peer.closed || peer.eof {
+		return 0, ErrClosed
+	}
+	peer.queue = append(peer.queue, append([]byte(nil), b...))
+	peer.cond.Signal()
+	e.c.stats.addWritten(len(b))
+	return len(b), nil
+}
+
+// 关闭自己, 对端读完已经收到的数据之后会读到io.EOF
+func (e *memEndpoint) close() {
+	e.mu.Lock()
+	e.closed = true
+	e.queue = nil
+	e.cond.Signal()
+	e.mu.Unlock()
+
+	peer := e.peer
+	peer.mu.Lock()
+	peer.eof = true
+	peer.cond.Signal()
+	peer.mu.Unlock()
+}
+
+// 相当于事件循环, 每个连接一个go程
+func (e *memEndpoint) run() {
+	c := e.c
+	for {
+		e.mu.Lock()
+		for len(e.queue) == 0 && !e.eof && !e.closed {
+			e.cond.Wait()
+		}
+		if e.closed {
+			e.mu.Unlock()
+			return
+		}
+		queue := e.queue
+		e.queue = nil
+		eof := e.eof
+		e.mu.Unlock()
+
+		for _, b := range queue {
+			if err := e.feed(b); err != nil {
+				c.asyncClose(err)
+				return
+			}
+		}
+		if eof && len(queue) == 0 {
+			c.asyncClose(io.EOF)
+			return
+		}
+	}
+}
+
+// 把b放进读缓冲区, 放不下的时候先解析腾出空间, 比读缓冲区大的frame会在readPayload里扩容
+func (e *memEndpoint) feed(b []byte) error {
+	c := e.c
+	for len(b) > 0 {
+		n := c.rbuf.Write(b)
+		c.stats.addRead(n)
+		b = b[n:]
+
+		if c.isHijacked() {
+			c.deliverRaw()
+			continue
+		}
+		if err := c.processBufferedFrames(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 在内存里建立一对已经握手的连接, 不需要socket, 也不需要epoll/kqueue, 用于单元测试
+// 没有http握手, 压缩扩展按两边的配置协商, 两边都会调用OnOpen
+// 配合WithMemoryBackend可以在没有epoll权限的环境里使用; 连接不在事件循环里, 不计入ConnCount, Shutdown也不会关闭它们
+func (m *MultiEventLoop) Pipe(serverOpts []ServerOption, clientOpts []ClientOption) (server, client *Conn, err error) {
+	var sconf ConnOption
+	sconf.defaultSetting()
+	for _, o := range serverOpts {
+		o(&sconf)
+	}
+	sconf.multiEventLoop = m
+
+	var cconf DialOption
+	cconf.defaultSetting()
+	for _, o := range clientOpts {
+		o(&cconf)
+	}
+	cconf.multiEventLoop = m
+	if err = errors.Join(sconf.Validate(), cconf.Validate()); err != nil {
+		return nil, nil, err
+	}
+	sconf.initCallback()
+	cconf.initCallback()
+
+	// 和http握手一样协商permessage-deflate
+	offer := make(http.Header)
+	if cconf.decompression && cconf.compression {
+		offer.Set("Sec-WebSocket-Extensions", clientDeflateOffer(cconf.takeoverWindowBits))
+	}
+	if sconf.decompression {
+		sconf.deflate, sconf.decompression = negotiateDeflate(offer, sconf.takeoverWindowBits)
+		sconf.compression = sconf.compression && sconf.decompression
+	}
+	rsp := make(http.Header)
+	if sconf.decompression {
+		rsp.Set("Sec-WebSocket-Extensions", sconf.deflate.String())
+	}
+	var cd bool
+	if cconf.deflate, cd, err = acceptDeflateResponse(rsp, cconf.takeoverWindowBits); err != nil {
+		return nil, nil, err
+	}
+	cconf.decompression = cconf.decompression && cd
+	cconf.compression = cconf.compression && cd
+
+	server = newConn(-1, false, &sconf.Config)
+	client = newConn(-1, true, &cconf.Config)
+	addr := memAddr{}
+	server.localAddr, server.remoteAddr = addr, addr
+	client.localAddr, client.remoteAddr = addr, addr
+
+	server.mem, client.mem = newMemEndpoint(server), newMemEndpoint(client)
+	server.mem.peer, client.mem.peer = client.mem, server.mem
+	go server.mem.run()
+	go client.mem.run()
+
+	server.startTick()
+	client.startHeartbeat()
+	client.startTick()
+	sconf.Callback.OnOpen(server)
+	cconf.Callback.OnOpen(client)
+	return server, client, nil
+}
+
+// Pipe建立的连接的地址
+type memAddr struct{}
+
+func (memAddr) Network() string { return "memory" }
+func (memAddr) String() string  { return "memory" }
+
+var _ net.Addr = memAddr{}
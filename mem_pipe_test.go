@@ -0,0 +1,103 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package greatws
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newMemLoop(t *testing.T) *MultiEventLoop {
+	m := NewMultiEventLoopMust(WithMemoryBackend())
+	m.Start()
+	return m
+}
+
+func Test_Pipe(t *testing.T) {
+	echo := WithServerOnMessageFunc(func(c *Conn, op Opcode, payload []byte) {
+		c.WriteMessage(op, payload)
+	})
+
+	for _, tc := range []struct {
+		name       string
+		serverOpts []ServerOption
+		clientOpts []ClientOption
+		compressed bool
+	}{
+		{"plain", []ServerOption{echo}, nil, false},
+		{"deflate", []ServerOption{echo, WithServerDecompressAndCompress()}, []ClientOption{WithClientDecompressAndCompress()}, true},
+		{"deflate client only", []ServerOption{echo}, []ClientOption{WithClientDecompressAndCompress()}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := newMemLoop(t)
+			got := make(chan []byte, 1)
+			clientOpts := append([]ClientOption{WithClientOnMessageFunc(func(c *Conn, op Opcode, payload []byte) {
+				got <- append([]byte(nil), payload...)
+			})}, tc.clientOpts...)
+
+			server, client, err := m.Pipe(tc.serverOpts, clientOpts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+			if server.compression != tc.compressed || client.compression != tc.compressed {
+				t.Fatalf("compression = %v/%v", server.compression, client.compression)
+			}
+
+			// 比读缓冲区大的消息, 需要扩容
+			for _, size := range []int{5, 64 * 1024} {
+				msg := bytes.Repeat([]byte("a"), size)
+				if err := client.WriteMessage(Binary, msg); err != nil {
+					t.Fatal(err)
+				}
+				select {
+				case b := <-got:
+					if !bytes.Equal(b, msg) {
+						t.Fatalf("size %d: got %d bytes", size, len(b))
+					}
+				case <-time.After(time.Second):
+					t.Fatal("echo timeout")
+				}
+			}
+			if client.Stats().BytesWritten == 0 || server.Stats().BytesRead != client.Stats().BytesWritten {
+				t.Fatalf("stats: client %+v, server %+v", client.Stats(), server.Stats())
+			}
+		})
+	}
+}
+
+func Test_PipeClose(t *testing.T) {
+	m := newMemLoop(t)
+	sr := &closeRecorder{done: make(chan struct{})}
+	cr := &closeRecorder{done: make(chan struct{})}
+	server, client, err := m.Pipe([]ServerOption{WithServerCallback(sr)}, []ClientOption{WithClientCallback(cr)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 关闭握手: 客户端发close, 服务端回close, 两边都调用一次OnClose
+	if err := client.WriteClose(NormalClosure, "bye"); err != nil {
+		t.Fatal(err)
+	}
+	sr.wait(t)
+	cr.wait(t)
+	if !server.isClosed() || !client.isClosed() {
+		t.Fatal("not closed")
+	}
+	if err := client.WriteMessage(Text, []byte("x")); err == nil {
+		t.Fatal("write after close")
+	}
+}
+
+func Test_MemoryBackend(t *testing.T) {
+	m := newMemLoop(t)
+	if len(m.loops) != 0 {
+		t.Fatalf("loops = %d", len(m.loops))
+	}
+	if err := m.add(newConn(-1, false, &Config{multiEventLoop: m})); !errors.Is(err, ErrMemoryBackend) {
+		t.Fatalf("err = %v", err)
+	}
+}
@@ -1,10 +1,16 @@
+//go:build !js
+// +build !js
+
 package greatws
 
 import (
+	"context"
 	"log/slog"
 	"os"
 	"runtime"
+	"sync"
 	"sync/atomic"
+	"time"
 )
 
 type MultiEventLoop struct {
@@ -15,14 +21,68 @@ type MultiEventLoop struct {
 	curConn     int64  // 当前tcp连接数
 	flag        evFlag // 是否使用io_uring
 	level       slog.Level
+	wheel       *timingWheel // 连接的各种超时都挂在时间轮上
+	cpus        []int        // 事件循环绑定的cpu, 为空不绑定
+	debug       atomic.Bool  // Logger是否开启了debug级别, 热路径上先检查这个值, 避免构造日志参数
+	gen         uint32       // 分配给连接的代数, 每加入一个连接加1
+
+	callbackInLoop  bool // 回调在事件循环里同步调用, 不交给业务go程池
+	orderedCallback bool // 业务go程池里同一个连接的消息按顺序执行
+
+	started      int32 // 是否调用过Start
+	shutdownOnce sync.Once
+	shutdownErr  error
+
+	chaos *chaos // 故障注入, 只在测试里配置
+
+	sendZCThreshold int           // io_uring模式下, 不小于这个长度的帧使用SEND_ZC发送, 0表示不使用
+	ioUring         ioUringConfig // io_uring的ring大小, 每次取出的cqe数量, 等待时间
+	memory          bool          // 不创建事件循环, 只能使用Pipe建立的内存连接
+	manual          bool          // TestEventLoop: 不启动事件循环和时间轮的go程, 由调用方驱动
+
+	logLevel      slog.LevelVar   // 默认日志的级别, SetLogLevel在运行时修改
+	configMu      sync.RWMutex    // UpdateConfig和add互斥, 保证每个连接都能拿到所有的修改
+	configUpdates []func(*Config) // UpdateConfig保存的修改, 之后加入的连接也要应用
+
+	writeBudget int // 每个事件循环每一轮最多刷出的字节数, 0表示不限制
+
+	loopMaxRestarts    int                                         // 事件循环连续出错时最多重建几次poller, 超过之后关闭这个事件循环上的连接
+	loopRestartBackoff time.Duration                               // 第n次重建之前等待n倍的时间
+	onLoopError        func(el *EventLoop, err error, attempt int) // apiPoll出错的时候调用, attempt是连续出错的次数
 	*slog.Logger
 }
 
+// io_uring的配置, 为0的字段使用默认值
+type ioUringConfig struct {
+	entries     uint32        // sq的长度, 默认16384
+	cqeBatch    int           // 每次最多处理的cqe数量, 默认按entries计算
+	waitTimeout time.Duration // 每次等待完成事件的最长时间, 默认1ms
+
+	writeTimeout time.Duration // 写请求提交之后多久还没有完成就回收, 并关闭连接, 默认30s, 小于0表示不检查
+}
+
 // 获取当前连接数
 func (m *MultiEventLoop) GetCurConnNum() int64 {
 	return atomic.LoadInt64(&m.curConn)
 }
 
+// 当前连接数, 和GetCurConnNum一样
+func (m *MultiEventLoop) ConnCount() int {
+	return int(m.GetCurConnNum())
+}
+
+// 遍历所有的连接, f返回false停止遍历
+// 每个事件循环遍历的是当时的快照, 遍历过程中新建的连接可能看不到, 关闭的连接可能还会看到
+func (m *MultiEventLoop) Range(f func(c *Conn) bool) {
+	for _, el := range m.loops {
+		for _, c := range el.snapshotConns() {
+			if !f(c) {
+				return
+			}
+		}
+	}
+}
+
 // 获取当前运行的任务数
 func (m *MultiEventLoop) GetCurTaskNum() int64 {
 	return m.t.getCurTask()
@@ -36,6 +96,9 @@ func (m *MultiEventLoop) GetApiName() string {
 	return m.loops[0].GetApiName()
 }
 
+// io_uring零拷贝发送的默认阈值, 小包拷贝到内核的开销比等完成通知小
+const defaultSendZCThreshold = 64 * 1024
+
 func (m *MultiEventLoop) initDefaultSettingBefore() {
 	m.level = slog.LevelError // 默认打印error级别的日志
 	m.numLoops = 0
@@ -43,6 +106,9 @@ func (m *MultiEventLoop) initDefaultSettingBefore() {
 	m.t.min = 50
 	m.t.initCount = 1000
 	m.t.max = 30000
+	m.sendZCThreshold = defaultSendZCThreshold
+	m.loopMaxRestarts = defaultLoopMaxRestarts
+	m.loopRestartBackoff = defaultLoopRestartBackoff
 }
 
 func (m *MultiEventLoop) initDefaultSettingAfter() {
@@ -80,7 +146,6 @@ func NewMultiEventLoopMust(opts ...EvOption) *MultiEventLoop {
 		panic(err)
 	}
 
-	m.Logger = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: m.level}))
 	return m
 }
 
@@ -93,34 +158,69 @@ func NewMultiEventLoop(opts ...EvOption) (e *MultiEventLoop, err error) {
 		o(m)
 	}
 	m.initDefaultSettingAfter()
+	if err = m.validate(); err != nil {
+		return nil, err
+	}
+	if m.Logger == nil {
+		m.logLevel.Set(m.level)
+		m.Logger = slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: &m.logLevel}))
+	}
+	m.debug.Store(m.Logger.Enabled(context.Background(), slog.LevelDebug))
 
 	m.t.init()
+	m.wheel = newTimingWheel(defaultWheelInterval, defaultWheelSlots)
+	m.wheel.manual = m.manual
+
+	if m.memory {
+		return m, nil
+	}
 
 	m.loops = make([]*EventLoop, m.numLoops)
 
 	for i := 0; i < m.numLoops; i++ {
-		m.loops[i], err = CreateEventLoop(m.maxEventNum, m.flag)
+		m.loops[i], err = createEventLoop(m, m.maxEventNum, m.flag)
 		if err != nil {
 			return nil, err
 		}
-		m.loops[i].parent = m
+		if len(m.cpus) > 0 {
+			m.loops[i].pinned = true
+			m.loops[i].cpu = m.cpus[i%len(m.cpus)]
+			m.loops[i].bufPool = &loopBytesPool{}
+		}
 	}
 	return m, nil
 }
 
 // 启动多路事件循环
 func (m *MultiEventLoop) Start() {
+	if m.manual || !atomic.CompareAndSwapInt32(&m.started, 0, 1) {
+		return
+	}
 	for _, loop := range m.loops {
+		if loop.isShutdown() {
+			continue
+		}
 		go loop.Loop()
 	}
 }
 
 // 添加一个连接到多路事件循环
+// 出错时fd还归调用方, 由调用方关闭
 func (m *MultiEventLoop) add(c *Conn) error {
+	if m.memory {
+		return ErrMemoryBackend
+	}
 	index := c.getFd() % len(m.loops)
-	m.loops[index].conns.Store(c.getFd(), c)
+	c.gen = atomic.AddUint32(&m.gen, 1)
+	m.configMu.RLock()
+	defer m.configMu.RUnlock()
+	// 连接还没有加入事件循环, 直接修改
+	for _, f := range m.configUpdates {
+		c.updateConfig(f)
+	}
+	m.loops[index].storeConn(c.getFd(), c)
 	if err := m.loops[index].addRead(c); err != nil {
-		m.del(c)
+		m.loops[index].deleteConn(c.getFd(), c)
 		return err
 	}
 	c.setParent(m.loops[index])
@@ -129,12 +229,12 @@ func (m *MultiEventLoop) add(c *Conn) error {
 }
 
 // 添加一个可写事件到多路事件循环
-func (m *MultiEventLoop) addWrite(c *Conn, writeSeq uint16) error {
+func (m *MultiEventLoop) addWrite(c *Conn, writeSeq uint32) error {
 	index := c.getFd() % len(m.loops)
 	if err := m.loops[index].addWrite(c, writeSeq); err != nil {
 		return err
 	}
-	m.loops[index].conns.LoadOrStore(c.getFd(), c)
+	m.loops[index].loadOrStoreConn(c.getFd(), c)
 	return nil
 }
 
@@ -144,10 +244,16 @@ func (m *MultiEventLoop) delWrite(c *Conn) error {
 	if err := m.loops[index].delWrite(c); err != nil {
 		return err
 	}
-	m.loops[index].conns.LoadOrStore(c.getFd(), c)
+	m.loops[index].loadOrStoreConn(c.getFd(), c)
 	return nil
 }
 
+// 恢复读之后, 让事件循环重新检查一次是否可读
+func (m *MultiEventLoop) rearmRead(c *Conn) error {
+	index := c.getFd() % len(m.loops)
+	return m.loops[index].rearmRead(c)
+}
+
 // 从多路事件循环中删除一个连接
 func (m *MultiEventLoop) del(c *Conn) {
 	if c.fd == -1 {
@@ -155,16 +261,116 @@ func (m *MultiEventLoop) del(c *Conn) {
 	}
 	atomic.AddInt64(&m.curConn, -1)
 	index := c.getFd() % len(m.loops)
-	m.loops[index].conns.Delete(c.getFd())
+	m.loops[index].deleteConn(c.getFd(), c)
 	closeFd(c.getFd())
 }
 
+// CloseAll每关闭一批连接让出一次cpu
+const closeAllBatch = 256
+
+// 优雅关闭filter返回true的连接, filter为nil表示所有连接, 返回发起关闭的连接数
+// 每个事件循环各自遍历自己的连接, 分批发送close帧, 避免一次性写大量close帧把cpu占满
+// 返回的时候close帧已经发出, 连接在收到对端的close帧或者closeLinger超时之后才真正关闭
+// close帧发送失败(比如code不合法)或者是Hijack的连接, 直接关闭
+func (m *MultiEventLoop) CloseAll(code StatusCode, reason string, filter func(*Conn) bool) int {
+	var (
+		wg    sync.WaitGroup
+		total int64
+	)
+	for _, el := range m.loops {
+		wg.Add(1)
+		go func(el *EventLoop) {
+			defer wg.Done()
+			n := 0
+			for _, c := range el.snapshotConns() {
+				if c.isClosed() || filter != nil && !filter(c) {
+					continue
+				}
+
+				if c.isHijacked() || c.WriteClose(code, reason) != nil {
+					c.Close()
+				}
+				n++
+				if n%closeAllBatch == 0 {
+					runtime.Gosched()
+				}
+			}
+			atomic.AddInt64(&total, int64(n))
+		}(el)
+	}
+	wg.Wait()
+	return int(total)
+}
+
 // 获取一个连接
 func (m *MultiEventLoop) getConn(fd int) *Conn {
 	index := fd % len(m.loops)
-	v, ok := m.loops[index].conns.Load(fd)
-	if !ok {
-		return nil
+	return m.loops[index].loadConn(fd)
+}
+
+// 获取一个连接, 代数不一致返回nil
+func (m *MultiEventLoop) getConnGen(fd int, gen uint32) *Conn {
+	index := fd % len(m.loops)
+	return m.loops[index].loadConnGen(fd, gen)
+}
+
+// 关闭所有的连接, 停止事件循环, 业务go程池和时间轮, 释放epoll/kqueue的fd和io_uring的ring
+// 先给所有的连接发送close帧(EndpointGoingAway), 等对端回应, ctx结束时还没有关闭的连接直接关闭, 返回ctx.Err()
+// 只有第一次调用生效, 之后的调用返回第一次的结果. 返回之后调用Wait等待所有的go程退出
+func (m *MultiEventLoop) Shutdown(ctx context.Context) error {
+	m.shutdownOnce.Do(func() {
+		m.shutdownErr = m.shutdown(ctx)
+	})
+	return m.shutdownErr
+}
+
+func (m *MultiEventLoop) shutdown(ctx context.Context) (err error) {
+	m.CloseAll(EndpointGoingAway, "", nil)
+
+	tk := time.NewTicker(10 * time.Millisecond)
+	defer tk.Stop()
+	for m.ConnCount() > 0 && err == nil {
+		select {
+		case <-tk.C:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+
+	// 先停止事件循环, 之后没有别的go程会读写连接的读缓冲区
+	for _, el := range m.loops {
+		if atomic.LoadInt32(&m.started) == 1 {
+			el.Shutdown(context.Background())
+			continue
+		}
+		// 没有启动过的事件循环, 直接释放
+		if atomic.CompareAndSwapInt32(&el.shutdown, 0, 1) {
+			el.apiFree()
+			close(el.done)
+		}
+	}
+
+	// 没有按时完成关闭握手的连接
+	m.Range(func(c *Conn) bool {
+		c.Close()
+		c.releaseReadBuffer()
+		return true
+	})
+
+	m.t.stop()
+	m.wheel.stop()
+	for _, el := range m.loops {
+		el.bufPool = nil
+	}
+	return err
+}
+
+// 等待Shutdown之后所有的go程退出, 包括事件循环, 业务go程池和时间轮
+// 没有调用Shutdown会一直阻塞
+func (m *MultiEventLoop) Wait() {
+	for _, el := range m.loops {
+		<-el.done
 	}
-	return v.(*Conn)
+	m.t.wait()
+	m.wheel.wait()
 }
@@ -1,6 +1,12 @@
+//go:build !js
+// +build !js
+
 package greatws
 
-import "log/slog"
+import (
+	"log/slog"
+	"time"
+)
 
 type EvOption func(e *MultiEventLoop)
 
@@ -30,6 +36,13 @@ func WithLogLevel(level slog.Level) EvOption {
 	}
 }
 
+// 设置日志, 设置之后WithLogLevel不再生效, 日志级别由l自己控制
+func WithLogger(l *slog.Logger) EvOption {
+	return func(e *MultiEventLoop) {
+		e.Logger = l
+	}
+}
+
 // 设置每个事件循环一次返回的最大事件数量
 func WithMaxEventNum(num int) EvOption {
 	return func(e *MultiEventLoop) {
@@ -37,6 +50,48 @@ func WithMaxEventNum(num int) EvOption {
 	}
 }
 
+// 每个事件循环的os线程绑定到一个cpu上, 第i个循环绑定cpus[i%len(cpus)]
+// 绑定之后读缓冲区扩容使用循环自己的池子, 减少跨核(numa节点)的缓存流量
+// linux使用sched_setaffinity, macos没有绑定接口, 只会锁定os线程
+func WithEventLoopCPUAffinity(cpus []int) EvOption {
+	return func(e *MultiEventLoop) {
+		e.cpus = append([]int(nil), cpus...)
+	}
+}
+
+// OnMessage在事件循环里同步调用, 不交给业务go程池
+// 同一个连接的消息严格按顺序处理, 少一次go程切换, 延迟最低
+// 回调里不能阻塞, 阻塞会卡住这个事件循环上的所有连接, 耗时的操作请自己交给别的go程
+// 默认是每条消息交给业务go程池(见WithBusinessGoNum), 同一个连接的消息可能并发执行, 不保证顺序, 需要顺序见WithOrderedCallback
+func WithCallbackInEventLoop() EvOption {
+	return func(e *MultiEventLoop) {
+		e.callbackInLoop = true
+	}
+}
+
+// OnMessage仍然交给业务go程池, 但同一个连接的消息按收到的顺序一条一条执行, 不同的连接之间并发
+// 和WithCallbackInEventLoop同时配置时, WithCallbackInEventLoop优先
+func WithOrderedCallback() EvOption {
+	return func(e *MultiEventLoop) {
+		e.orderedCallback = true
+	}
+}
+
+// 给这个MultiEventLoop上的所有连接注入故障(丢消息, 延迟发送, 读到一半断开), 只用于测试, 详见ChaosConfig
+func WithChaos(conf ChaosConfig) EvOption {
+	return func(e *MultiEventLoop) {
+		e.chaos = newChaos(conf)
+	}
+}
+
+// 不创建epoll/kqueue/io_uring的事件循环, 只能通过MultiEventLoop.Pipe建立内存里的连接
+// 用于在没有epoll权限的ci沙箱里跑单元测试, Upgrade和Dial会返回ErrMemoryBackend
+func WithMemoryBackend() EvOption {
+	return func(e *MultiEventLoop) {
+		e.memory = true
+	}
+}
+
 // 暂时不可用
 // 是否使用io_uring, 支持linux系统，需要内核版本6.2.0以上(以后只会在>=6.2.0的版本上测试)
 func WithIoUring() EvOption {
@@ -44,3 +99,72 @@ func WithIoUring() EvOption {
 		e.flag |= EVENT_IOURING
 	}
 }
+
+// io_uring模式下, 帧的长度不小于threshold时使用零拷贝发送(IORING_OP_SEND_ZC), 需要内核>=6.0, 不支持时自动退回普通的send
+// 默认阈值是64KB, 小于等于0表示不使用零拷贝
+func WithIoUringSendZC(threshold int) EvOption {
+	return func(e *MultiEventLoop) {
+		e.sendZCThreshold = threshold
+	}
+}
+
+// io_uring的sq长度, 内核会向上取整到2的幂, cq的长度是它的两倍, 默认16384
+// 没有配置WithIoUringCQEBatch时, 每次处理的cqe数量按这个值计算
+func WithIoUringEntries(n uint32) EvOption {
+	return func(e *MultiEventLoop) {
+		e.ioUring.entries = n
+	}
+}
+
+// io_uring事件循环每次最多取出处理的cqe数量, 超过cq长度时按cq长度
+// 默认是entries/64, 限制在32到4096之间, 16384个entry对应256
+func WithIoUringCQEBatch(n int) EvOption {
+	return func(e *MultiEventLoop) {
+		e.ioUring.cqeBatch = n
+	}
+}
+
+// io_uring事件循环每次等待完成事件的最长时间, 默认1ms
+// 时间越长空闲时占用的cpu越少, 但Shutdown要等这么久才能让事件循环退出
+func WithIoUringWaitTimeout(d time.Duration) EvOption {
+	return func(e *MultiEventLoop) {
+		e.ioUring.waitTimeout = d
+	}
+}
+
+// io_uring的写请求提交之后超过d还没有完成(比如cqe丢了), 提交IORING_OP_ASYNC_CANCEL取消它, 并关闭连接, OnClose收到ErrIoUringWriteTimeout
+// 写请求的内存等内核不再引用(它自己的cqe到了, 或者取消的结果是找不到这个请求)再释放
+// 每d/2检查一次, 默认30s, 小于0表示不检查
+func WithIoUringWriteTimeout(d time.Duration) EvOption {
+	return func(e *MultiEventLoop) {
+		e.ioUring.writeTimeout = d
+	}
+}
+
+// 事件循环的epoll_wait/kevent/io_uring提交出错时, 等attempt*backoff之后重建poller, 把连接重新注册上去, 默认最多连续重建3次, 间隔10ms
+// 连续出错超过maxRestarts次, 关闭这个事件循环上的所有连接, OnClose收到ErrLoopFailed, 事件循环退出
+// maxRestarts为0表示出错之后直接关闭连接
+func WithLoopRestart(maxRestarts int, backoff time.Duration) EvOption {
+	return func(e *MultiEventLoop) {
+		e.loopMaxRestarts = maxRestarts
+		e.loopRestartBackoff = backoff
+	}
+}
+
+// 事件循环出错的时候调用, attempt是连续出错的次数, 在事件循环的go程里调用, 不要阻塞
+// 用于报警和统计, 调用之后按WithLoopRestart的配置重建或者关闭
+func WithOnLoopError(f func(el *EventLoop, err error, attempt int)) EvOption {
+	return func(e *MultiEventLoop) {
+		e.onLoopError = f
+	}
+}
+
+// 每个事件循环每一轮最多从写缓冲区刷出的字节数, 超出的部分推迟到下一轮, 连接之间轮流刷
+// 避免一批大的广播消息积压在写缓冲区里之后, 刷数据占住事件循环, 读不到别的连接的数据
+// 只限制事件循环在可写事件里刷的数据, 不限制业务go程直接写到内核的数据, io_uring模式不生效
+// 默认是0, 不限制
+func WithLoopWriteBudget(n int) EvOption {
+	return func(e *MultiEventLoop) {
+		e.writeBudget = n
+	}
+}
@@ -0,0 +1,453 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+// mux 在一个greatws连接上复用多个逻辑流, 比如通过一条websocket转发多个后端会话
+// 每个流有自己的流控窗口, 一个流读得慢不会卡住别的流
+// 通过中间件接入, mux占用binary消息, text消息交给下一个callback
+// 帧要按顺序处理, MultiEventLoop需要配置greatws.WithOrderedCallback或者greatws.WithCallbackInEventLoop
+package mux
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/antlabs/greatws"
+)
+
+// 帧格式: type(1字节) + 流id(4字节, 大端) + payload
+// 两端各自分配流id, 最高位是1表示流是这一帧的发送方打开的, 是0表示是接收方打开的, 两端同时打开不会冲突
+const (
+	frameOpen   byte = 1 // 打开一个流
+	frameData   byte = 2 // 数据
+	frameWindow byte = 3 // 归还窗口, payload是4字节的增量
+	frameClose  byte = 4 // 关闭流, 之后不再发送这个流的帧
+
+	headerSize  = 1 + 4
+	openerBit   = 1 << 31
+	maxStreamID = openerBit - 1
+)
+
+var (
+	ErrBadFrame       = errors.New("mux: bad frame")              // 收到的binary消息不是mux的帧
+	ErrStreamClosed   = errors.New("mux: stream closed")          // 流已经关闭
+	ErrAcceptBacklog  = errors.New("mux: accept backlog full")    // 对端打开的流太多, 来不及Accept
+	ErrWindowExceeded = errors.New("mux: flow control violation") // 对端发送的数据超过了窗口
+	ErrStreamIDs      = errors.New("mux: stream ids exhausted")   // 流id用完了
+)
+
+type Option func(*Mux)
+
+// 1.每个流的接收窗口, 对端最多发送这么多还没有被Read的数据, 默认256KB
+func WithWindow(n int) Option {
+	return func(m *Mux) {
+		m.window = n
+	}
+}
+
+// 2.一个数据帧最多带的字节数, 默认32KB
+func WithMaxFrameSize(n int) Option {
+	return func(m *Mux) {
+		m.maxFrame = n
+	}
+}
+
+// 3.对端打开, 还没有Accept的流最多的个数, 超过之后直接关闭新的流, 默认64
+func WithAcceptBacklog(n int) Option {
+	return func(m *Mux) {
+		m.backlog = n
+	}
+}
+
+type Mux struct {
+	window   int
+	maxFrame int
+	backlog  int
+	sessions sync.Map // *greatws.Conn -> *Session
+}
+
+func New(opts ...Option) *Mux {
+	m := &Mux{window: 256 * 1024, maxFrame: 32 * 1024, backlog: 64}
+	for _, o := range opts {
+		o(m)
+	}
+	if m.window <= 0 {
+		m.window = 256 * 1024
+	}
+	if m.maxFrame <= 0 {
+		m.maxFrame = 32 * 1024
+	}
+	if m.backlog <= 0 {
+		m.backlog = 64
+	}
+	return m
+}
+
+// 通过greatws.WithServerMiddleware或者greatws.WithClientMiddleware使用
+func (m *Mux) Middleware() greatws.Middleware {
+	return func(next greatws.Callback) greatws.Callback {
+		return &callback{next: next, m: m}
+	}
+}
+
+// c上的会话, 用来打开和接受流, 第一次调用的时候创建
+// c的callback里要有Middleware, 不然收不到对端的帧
+func (m *Mux) Session(c *greatws.Conn) *Session {
+	if v, ok := m.sessions.Load(c); ok {
+		return v.(*Session)
+	}
+	s := &Session{
+		m:       m,
+		c:       c,
+		streams: make(map[streamKey]*Stream),
+		accept:  make(chan *Stream, m.backlog),
+		done:    make(chan struct{}),
+	}
+	v, _ := m.sessions.LoadOrStore(c, s)
+	return v.(*Session)
+}
+
+type streamKey struct {
+	id    uint32
+	local bool // 本端打开的流
+}
+
+// 一个连接上的所有流
+type Session struct {
+	m *Mux
+	c *greatws.Conn
+
+	mu      sync.Mutex
+	nextID  uint32
+	streams map[streamKey]*Stream
+	err     error // 连接断开的原因, 不为空之后不能再打开流
+
+	accept chan *Stream  // 对端打开, 等待Accept的流
+	done   chan struct{} // 连接断开之后关闭
+}
+
+// 打开一个流, 对端通过Accept拿到
+func (s *Session) Open() (*Stream, error) {
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return nil, s.err
+	}
+	if s.nextID >= maxStreamID {
+		s.mu.Unlock()
+		return nil, ErrStreamIDs
+	}
+	s.nextID++
+	st := s.newStream(streamKey{id: s.nextID, local: true})
+	s.mu.Unlock()
+
+	if err := st.writeFrame(frameOpen, nil); err != nil {
+		s.remove(st.key)
+		return nil, err
+	}
+	return st, nil
+}
+
+// 等待对端打开的流, 连接断开或者ctx结束时返回错误
+func (s *Session) Accept(ctx context.Context) (*Stream, error) {
+	select {
+	case st := <-s.accept:
+		return st, nil
+	case <-s.done:
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return nil, s.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// 当前打开的流的个数
+func (s *Session) NumStreams() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.streams)
+}
+
+// 调用方持有s.mu
+func (s *Session) newStream(key streamKey) *Stream {
+	st := &Stream{s: s, key: key, sendWindow: s.m.window}
+	st.cond = sync.NewCond(&st.mu)
+	s.streams[key] = st
+	return st
+}
+
+func (s *Session) remove(key streamKey) {
+	s.mu.Lock()
+	delete(s.streams, key)
+	s.mu.Unlock()
+}
+
+func (s *Session) handle(b []byte) error {
+	if len(b) < headerSize {
+		return fmt.Errorf("%w: %d bytes", ErrBadFrame, len(b))
+	}
+	typ, raw, payload := b[0], binary.BigEndian.Uint32(b[1:5]), b[headerSize:]
+	// 发送方打开的流, 在本端看是对端打开的
+	key := streamKey{id: raw &^ openerBit, local: raw&openerBit == 0}
+
+	s.mu.Lock()
+	st := s.streams[key]
+	if typ == frameOpen {
+		if key.local || st != nil {
+			s.mu.Unlock()
+			return fmt.Errorf("%w: open stream %d twice", ErrBadFrame, key.id)
+		}
+		st = s.newStream(key)
+		select {
+		case s.accept <- st:
+		default:
+			// 来不及Accept, 直接关掉
+			delete(s.streams, key)
+			s.mu.Unlock()
+			return st.writeFrame(frameClose, nil)
+		}
+		s.mu.Unlock()
+		return nil
+	}
+	s.mu.Unlock()
+
+	// 本端已经关闭的流, 对端还没收到close帧之前发过来的帧直接丢掉
+	if st == nil {
+		return nil
+	}
+
+	switch typ {
+	case frameData:
+		return st.receive(payload)
+	case frameWindow:
+		if len(payload) != 4 {
+			return fmt.Errorf("%w: window update %d bytes", ErrBadFrame, len(payload))
+		}
+		st.addWindow(int(binary.BigEndian.Uint32(payload)))
+	case frameClose:
+		st.closeRemote(io.EOF)
+	default:
+		return fmt.Errorf("%w: type %d", ErrBadFrame, typ)
+	}
+	return nil
+}
+
+// 连接断开, 所有的流都不能再读写
+func (s *Session) closeAll(err error) {
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return
+	}
+	s.err = err
+	streams := s.streams
+	s.streams = make(map[streamKey]*Stream)
+	close(s.done)
+	s.mu.Unlock()
+
+	for _, st := range streams {
+		st.closeRemote(err)
+	}
+}
+
+// 一个逻辑流, 实现了io.ReadWriteCloser
+// Read和Write可以在不同的go程里同时调用
+type Stream struct {
+	s   *Session
+	key streamKey
+
+	mu         sync.Mutex
+	cond       *sync.Cond
+	buf        []byte // 收到还没有Read的数据
+	consumed   int    // Read之后还没有归还给对端的窗口
+	sendWindow int    // 还能发送的字节数
+	rerr       error  // 对端关闭或者连接断开之后, 读完buf返回这个错误
+	closed     bool   // 本端调用过Close
+}
+
+// 流的id, 两端看到的一样
+func (st *Stream) ID() uint32 {
+	return st.key.id
+}
+
+func (st *Stream) Read(p []byte) (int, error) {
+	st.mu.Lock()
+	for len(st.buf) == 0 && st.rerr == nil && !st.closed {
+		st.cond.Wait()
+	}
+	if len(st.buf) == 0 {
+		err := st.rerr
+		if st.closed {
+			err = ErrStreamClosed
+		}
+		st.mu.Unlock()
+		return 0, err
+	}
+
+	n := copy(p, st.buf)
+	st.buf = st.buf[n:]
+	st.consumed += n
+	// 读掉一半窗口之后再归还, 减少窗口帧的数量
+	var credit int
+	if st.consumed >= st.s.m.window/2 && st.rerr == nil {
+		credit, st.consumed = st.consumed, 0
+	}
+	st.mu.Unlock()
+
+	if credit > 0 {
+		var b [4]byte
+		binary.BigEndian.PutUint32(b[:], uint32(credit))
+		if err := st.writeFrame(frameWindow, b[:]); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// 按窗口分成多个数据帧发送, 窗口用完之后等对端Read归还
+func (st *Stream) Write(p []byte) (int, error) {
+	total := 0
+	for len(p) > 0 {
+		st.mu.Lock()
+		for st.sendWindow == 0 && st.rerr == nil && !st.closed {
+			st.cond.Wait()
+		}
+		if st.closed {
+			st.mu.Unlock()
+			return total, ErrStreamClosed
+		}
+		if st.rerr != nil {
+			err := st.rerr
+			if err == io.EOF {
+				err = ErrStreamClosed
+			}
+			st.mu.Unlock()
+			return total, err
+		}
+		n := min(len(p), st.sendWindow, st.s.m.maxFrame)
+		st.sendWindow -= n
+		st.mu.Unlock()
+
+		if err := st.writeFrame(frameData, p[:n]); err != nil {
+			return total, err
+		}
+		p = p[n:]
+		total += n
+	}
+	return total, nil
+}
+
+// 关闭流, 通知对端, 对端读完已经收到的数据之后返回io.EOF
+func (st *Stream) Close() error {
+	st.mu.Lock()
+	if st.closed {
+		st.mu.Unlock()
+		return nil
+	}
+	st.closed = true
+	remoteClosed := st.rerr != nil
+	st.cond.Broadcast()
+	st.mu.Unlock()
+
+	st.s.remove(st.key)
+	if remoteClosed {
+		return nil
+	}
+	return st.writeFrame(frameClose, nil)
+}
+
+func (st *Stream) receive(p []byte) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.closed {
+		return nil
+	}
+	if len(st.buf)+len(p) > st.s.m.window {
+		return fmt.Errorf("%w: stream %d", ErrWindowExceeded, st.key.id)
+	}
+	// OnMessage返回之后p会被复用
+	st.buf = append(st.buf, p...)
+	st.cond.Broadcast()
+	return nil
+}
+
+func (st *Stream) addWindow(n int) {
+	st.mu.Lock()
+	st.sendWindow += n
+	st.cond.Broadcast()
+	st.mu.Unlock()
+}
+
+func (st *Stream) closeRemote(err error) {
+	st.mu.Lock()
+	if st.rerr == nil {
+		st.rerr = err
+	}
+	closed := st.closed
+	st.cond.Broadcast()
+	st.mu.Unlock()
+
+	// 两端都关闭了, 从会话里移除
+	if closed && err == io.EOF {
+		st.s.remove(st.key)
+	}
+}
+
+func (st *Stream) writeFrame(typ byte, payload []byte) error {
+	id := st.key.id
+	if st.key.local {
+		id |= openerBit
+	}
+	b := make([]byte, headerSize, headerSize+len(payload))
+	b[0] = typ
+	binary.BigEndian.PutUint32(b[1:5], id)
+	return st.s.c.WriteMessage(greatws.Binary, append(b, payload...))
+}
+
+type callback struct {
+	next greatws.Callback
+	m    *Mux
+}
+
+func (cb *callback) OnOpen(c *greatws.Conn) {
+	cb.next.OnOpen(c)
+}
+
+func (cb *callback) OnMessage(c *greatws.Conn, op greatws.Opcode, payload []byte) {
+	if op != greatws.Binary {
+		cb.next.OnMessage(c, op, payload)
+		return
+	}
+	if err := cb.m.Session(c).handle(payload); err != nil {
+		c.WriteClose(greatws.ProtocolError, err.Error())
+	}
+}
+
+func (cb *callback) OnClose(c *greatws.Conn, err error) {
+	if v, ok := cb.m.sessions.LoadAndDelete(c); ok {
+		closeErr := greatws.ErrClosed
+		if err != nil {
+			closeErr = fmt.Errorf("%w: %w", greatws.ErrClosed, err)
+		}
+		v.(*Session).closeAll(closeErr)
+	}
+	cb.next.OnClose(c, err)
+}
@@ -0,0 +1,211 @@
+//go:build !js
+// +build !js
+
+package mux
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/antlabs/greatws"
+)
+
+// 返回客户端的连接和服务端的连接
+func newTestPair(t *testing.T, server, client *Mux) (*greatws.Conn, *greatws.Conn) {
+	m := greatws.NewMultiEventLoopMust(greatws.WithEventLoops(1), greatws.WithOrderedCallback())
+	m.Start()
+
+	sc := make(chan *greatws.Conn, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := greatws.Upgrade(w, r, greatws.WithServerMultiEventLoop(m), greatws.WithServerMiddleware(server.Middleware()))
+		if err != nil {
+			t.Error(err)
+		}
+		sc <- c
+	}))
+	t.Cleanup(ts.Close)
+
+	c, err := greatws.Dial("ws://"+strings.TrimPrefix(ts.URL, "http://"),
+		greatws.WithClientMultiEventLoop(m), greatws.WithClientMiddleware(client.Middleware()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	return c, <-sc
+}
+
+// 把对端打开的流原样写回去
+func echoStreams(s *Session) {
+	for {
+		st, err := s.Accept(context.Background())
+		if err != nil {
+			return
+		}
+		go func() {
+			io.Copy(st, st)
+			st.Close()
+		}()
+	}
+}
+
+func Test_MuxEcho(t *testing.T) {
+	server, client := New(WithWindow(16*1024), WithMaxFrameSize(4096)), New(WithWindow(16*1024), WithMaxFrameSize(4096))
+	c, sc := newTestPair(t, server, client)
+	go echoStreams(server.Session(sc))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			st, err := client.Session(c).Open()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer st.Close()
+
+			// 比窗口大得多, 要靠对端Read归还窗口
+			want := make([]byte, 256*1024)
+			rand.Read(want)
+			got := make(chan []byte, 1)
+			go func() {
+				b := make([]byte, len(want))
+				io.ReadFull(st, b)
+				got <- b
+			}()
+			if _, err := st.Write(want); err != nil {
+				t.Error(err)
+				return
+			}
+			if b := <-got; !bytes.Equal(b, want) {
+				t.Errorf("stream %d: payload mismatch", st.ID())
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func Test_MuxIndependentFlowControl(t *testing.T) {
+	server, client := New(WithWindow(4096)), New(WithWindow(4096))
+	c, sc := newTestPair(t, server, client)
+	ss, cs := server.Session(sc), client.Session(c)
+
+	// 对端不读的流, 窗口用完之后Write阻塞
+	slow, err := cs.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	written := make(chan error, 1)
+	go func() {
+		_, err := slow.Write(make([]byte, 3*4096))
+		written <- err
+	}()
+	peer, err := ss.Accept(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 别的流不受影响
+	go echoStreams(ss)
+	fast, err := cs.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fast.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 5)
+	if _, err := io.ReadFull(fast, b); err != nil || string(b) != "hello" {
+		t.Fatalf("got %q, %v", b, err)
+	}
+	select {
+	case err := <-written:
+		t.Fatalf("write not blocked: %v", err)
+	default:
+	}
+
+	// 对端读完之后Write返回
+	if _, err := io.ReadFull(peer, make([]byte, 3*4096)); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-written:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("write still blocked")
+	}
+
+	// 关闭之后对端读到EOF
+	slow.Close()
+	if _, err := peer.Read(b); err != io.EOF {
+		t.Fatalf("err = %v", err)
+	}
+	if _, err := slow.Write(b); !errors.Is(err, ErrStreamClosed) {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func Test_MuxBothSidesOpen(t *testing.T) {
+	server, client := New(), New()
+	c, sc := newTestPair(t, server, client)
+	ss, cs := server.Session(sc), client.Session(c)
+
+	// 两端分配的id都是1, 不会冲突
+	a, err := cs.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ss.Open()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Write([]byte("from client"))
+	b.Write([]byte("from server"))
+
+	for _, tc := range []struct {
+		s    *Session
+		want string
+	}{{ss, "from client"}, {cs, "from server"}} {
+		st, err := tc.s.Accept(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, len(tc.want))
+		if _, err := io.ReadFull(st, buf); err != nil || string(buf) != tc.want {
+			t.Fatalf("got %q, %v", buf, err)
+		}
+	}
+
+	// 连接断开, 流都不能再读写
+	c.Close()
+	if _, err := a.Read(make([]byte, 1)); !errors.Is(err, greatws.ErrClosed) {
+		t.Fatalf("err = %v", err)
+	}
+	if _, err := cs.Open(); !errors.Is(err, greatws.ErrClosed) {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func Test_MuxBadFrame(t *testing.T) {
+	s := New().Session(nil)
+	for _, b := range [][]byte{
+		{frameOpen, 0, 0},
+		{frameOpen, 0, 0, 0, 1}, // 对端打开的流最高位应该是1
+	} {
+		if err := s.handle(b); !errors.Is(err, ErrBadFrame) {
+			t.Fatalf("%v: err = %v", b, err)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+module github.com/antlabs/greatws/otelgreatws
+
+go 1.21
+
+require (
+	github.com/antlabs/greatws v0.0.0-00010101000000-000000000000
+	go.opentelemetry.io/otel v1.28.0
+	go.opentelemetry.io/otel/metric v1.28.0
+	go.opentelemetry.io/otel/trace v1.28.0
+)
+
+require (
+	github.com/antlabs/wsutil v0.1.4 // indirect
+	github.com/go-logr/logr v1.4.2 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	github.com/pawelgaczynski/giouring v0.0.0-20230826085535-69588b89acb9 // indirect
+	golang.org/x/sys v0.12.0 // indirect
+)
+
+replace github.com/antlabs/greatws => ../
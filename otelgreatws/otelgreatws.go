@@ -0,0 +1,263 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// otelgreatws 给greatws加上OpenTelemetry的链路追踪和监控指标
+// 单独一个module, 不使用的话greatws不会依赖otel
+package otelgreatws
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/antlabs/greatws"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/metric"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/antlabs/greatws/otelgreatws"
+
+// 从第一条消息里取出对端的trace上下文, 比如消息里带了traceparent字段
+// 之后这个连接上所有消息的span都会link到这个上下文
+type Extractor func(ctx context.Context, op greatws.Opcode, payload []byte) context.Context
+
+type config struct {
+	tp         trace.TracerProvider
+	mp         metric.MeterProvider
+	propagator propagation.TextMapPropagator
+	extractor  Extractor
+}
+
+type Option func(*config)
+
+// 1.配置TracerProvider, 默认otel.GetTracerProvider()
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(c *config) {
+		c.tp = tp
+	}
+}
+
+// 2.配置MeterProvider, 默认otel.GetMeterProvider()
+func WithMeterProvider(mp metric.MeterProvider) Option {
+	return func(c *config) {
+		c.mp = mp
+	}
+}
+
+// 3.配置握手时http头的propagator, 默认otel.GetTextMapPropagator()
+func WithPropagators(p propagation.TextMapPropagator) Option {
+	return func(c *config) {
+		c.propagator = p
+	}
+}
+
+// 4.配置从第一条消息里取trace上下文的函数
+func WithExtractor(e Extractor) Option {
+	return func(c *config) {
+		c.extractor = e
+	}
+}
+
+type Instrumentation struct {
+	config
+	tracer      trace.Tracer
+	activeConns metric.Int64UpDownCounter
+	msgSize     metric.Int64Histogram
+	conns       sync.Map // *greatws.Conn -> *connState
+}
+
+// 每个连接的状态
+type connState struct {
+	mu        sync.Mutex
+	links     []trace.Link // 握手的span和第一条消息里取出的上下文
+	extracted bool
+	attrs     []attribute.KeyValue
+}
+
+func New(opts ...Option) (*Instrumentation, error) {
+	in := &Instrumentation{}
+	for _, o := range opts {
+		o(&in.config)
+	}
+
+	if in.tp == nil {
+		in.tp = otel.GetTracerProvider()
+	}
+	if in.mp == nil {
+		in.mp = otel.GetMeterProvider()
+	}
+	if in.propagator == nil {
+		in.propagator = otel.GetTextMapPropagator()
+	}
+
+	in.tracer = in.tp.Tracer(instrumentationName)
+	meter := in.mp.Meter(instrumentationName)
+
+	var err error
+	in.activeConns, err = meter.Int64UpDownCounter("greatws.connections.active",
+		metric.WithDescription("Number of active websocket connections"),
+		metric.WithUnit("{connection}"))
+	if err != nil {
+		return nil, err
+	}
+
+	in.msgSize, err = meter.Int64Histogram("greatws.message.size",
+		metric.WithDescription("Size of received websocket messages"),
+		metric.WithUnit("By"))
+	if err != nil {
+		return nil, err
+	}
+	return in, nil
+}
+
+// 第一次见到这个连接的时候计数, OnOpen, OnMessage, Upgrade/Dial返回都有可能是第一次
+func (in *Instrumentation) track(c *greatws.Conn, client bool) *connState {
+	if v, ok := in.conns.Load(c); ok {
+		return v.(*connState)
+	}
+
+	side := "server"
+	if client {
+		side = "client"
+	}
+	st := &connState{attrs: []attribute.KeyValue{attribute.String("websocket.side", side)}}
+	v, loaded := in.conns.LoadOrStore(c, st)
+	if !loaded {
+		in.activeConns.Add(context.Background(), 1, metric.WithAttributes(st.attrs...))
+	}
+	return v.(*connState)
+}
+
+func (in *Instrumentation) linkHandshake(c *greatws.Conn, client bool, span trace.Span) {
+	st := in.track(c, client)
+	st.mu.Lock()
+	st.links = append(st.links, trace.Link{SpanContext: span.SpanContext()})
+	st.mu.Unlock()
+}
+
+// 服务端的中间件, 通过greatws.WithServerMiddleware使用
+func (in *Instrumentation) ServerMiddleware() greatws.Middleware {
+	return in.middleware(false)
+}
+
+// 客户端的中间件, 通过greatws.WithClientMiddleware使用
+func (in *Instrumentation) ClientMiddleware() greatws.Middleware {
+	return in.middleware(true)
+}
+
+func (in *Instrumentation) middleware(client bool) greatws.Middleware {
+	return func(next greatws.Callback) greatws.Callback {
+		return &callback{next: next, in: in, client: client}
+	}
+}
+
+// 握手的span, 从http头里取出上游的trace上下文
+// http2的Upgrade会阻塞到连接关闭, 这时候握手的span覆盖整个连接
+func (in *Instrumentation) Upgrade(w http.ResponseWriter, r *http.Request, opts ...greatws.ServerOption) (*greatws.Conn, error) {
+	ctx := in.propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	_, span := in.tracer.Start(ctx, "websocket.handshake",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attribute.String("url.path", r.URL.Path)))
+	defer span.End()
+
+	opts = append(opts, greatws.WithServerMiddleware(in.ServerMiddleware()))
+	c, err := greatws.Upgrade(w, r, opts...)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	in.linkHandshake(c, false, span)
+	return c, nil
+}
+
+// 握手的span, 把trace上下文注入到握手的http头里
+func (in *Instrumentation) Dial(ctx context.Context, rawUrl string, opts ...greatws.ClientOption) (*greatws.Conn, error) {
+	ctx, span := in.tracer.Start(ctx, "websocket.handshake",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("url.full", rawUrl)))
+	defer span.End()
+
+	opts = append(opts, func(o *greatws.DialOption) {
+		h := make(http.Header)
+		if o.Header != nil {
+			h = o.Header.Clone()
+		}
+		in.propagator.Inject(ctx, propagation.HeaderCarrier(h))
+		o.Header = h
+	}, greatws.WithClientMiddleware(in.ClientMiddleware()))
+
+	c, err := greatws.Dial(rawUrl, opts...)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+
+	in.linkHandshake(c, true, span)
+	return c, nil
+}
+
+type callback struct {
+	next   greatws.Callback
+	in     *Instrumentation
+	client bool
+}
+
+func (cb *callback) OnOpen(c *greatws.Conn) {
+	cb.in.track(c, cb.client)
+	cb.next.OnOpen(c)
+}
+
+func (cb *callback) OnMessage(c *greatws.Conn, op greatws.Opcode, payload []byte) {
+	in := cb.in
+	st := in.track(c, cb.client)
+
+	ctx := context.Background()
+	st.mu.Lock()
+	if !st.extracted && in.extractor != nil && !op.IsControl() {
+		st.extracted = true
+		if sc := trace.SpanContextFromContext(in.extractor(ctx, op, payload)); sc.IsValid() {
+			st.links = append(st.links, trace.Link{SpanContext: sc})
+		}
+	}
+	links := st.links
+	st.mu.Unlock()
+
+	attrs := []attribute.KeyValue{
+		attribute.String("websocket.opcode", op.String()),
+		attribute.Int("websocket.message.size", len(payload)),
+	}
+	ctx, span := in.tracer.Start(ctx, "websocket.message",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithLinks(links...),
+		trace.WithAttributes(attrs...))
+	defer span.End()
+
+	in.msgSize.Record(ctx, int64(len(payload)), metric.WithAttributes(st.attrs...))
+	cb.next.OnMessage(c, op, payload)
+}
+
+func (cb *callback) OnClose(c *greatws.Conn, err error) {
+	if v, ok := cb.in.conns.LoadAndDelete(c); ok {
+		st := v.(*connState)
+		cb.in.activeConns.Add(context.Background(), -1, metric.WithAttributes(st.attrs...))
+	}
+	cb.next.OnClose(c, err)
+}
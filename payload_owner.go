@@ -0,0 +1,77 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+package greatws
+
+// OnMessage的payload默认是借给回调的: 库的缓冲区, OnMessage返回之后会被回收, 之后的消息可能复用同一块内存
+// 返回之后还要用(放进channel, 交给别的go程, 攒批处理)需要自己拷贝一份, 或者配置WithServerCopyPayload
+// 调试时配置WithServerPoisonPayload, 回调返回之后payload被填满payloadPoison, 违反约定的代码读到的是一串0xdb
+
+// 调试模式下填充payload的字节
+const payloadPoison = 0xdb
+
+// 回调怎么使用库的payload, loopCallback和goCallback共用
+type payloadMode struct {
+	copyPayload bool // 交给回调之前拷贝一份, 回调拿到的payload归回调所有, 库的缓冲区马上回收
+	poison      bool // 回收之前把缓冲区填满payloadPoison
+}
+
+// 交给回调之前调用, 返回回调拿到的payload
+// copyPayload的时候在这里就回收了库的缓冲区, 回调返回之后不用再release
+func (p payloadMode) lend(data []byte) []byte {
+	if !p.copyPayload || len(data) == 0 {
+		return data
+	}
+	owned := make([]byte, len(data))
+	copy(owned, data)
+	p.giveBack(data)
+	return owned
+}
+
+// 回调返回之后调用, 回收库的缓冲区
+func (p payloadMode) release(data []byte) {
+	if p.copyPayload && len(data) > 0 {
+		return
+	}
+	p.giveBack(data)
+}
+
+func (p payloadMode) giveBack(data []byte) {
+	if p.poison {
+		for i := range data {
+			data[i] = payloadPoison
+		}
+	}
+	PutPayloadBytes(&data)
+}
+
+// dispatchMessage之后payload还归不归连接, 要在dispatchMessage之前调用
+// 代理和回调的包装都会把payload还到池子里(copyPayload的时候在lend里, 否则在release里, 异步回调要等它返回), NetConn是拷贝
+// 合并分片的缓冲区交出去之后就不能再复用, 否则池子(或者还没返回的回调)和下一条分片消息会用同一块内存
+func (c *Conn) keepsDispatchedPayload() bool {
+	if c.proxyPeer.Load() != nil {
+		return false
+	}
+	if c.netConn.Load() != nil {
+		return true
+	}
+	switch c.Callback.(type) {
+	case *loopCallback, *goCallback:
+		return false
+	}
+	return true
+}
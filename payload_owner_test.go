@@ -0,0 +1,155 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package greatws
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_PayloadModePoison(t *testing.T) {
+	var kept []byte
+	l := &loopCallback{
+		c:           OnMessageFunc(func(_ *Conn, _ Opcode, payload []byte) { kept = payload }),
+		payloadMode: payloadMode{poison: true},
+	}
+	l.OnMessage(nil, Text, []byte("hello"))
+	// 违反约定, 返回之后还在用payload
+	if !bytes.Equal(kept, bytes.Repeat([]byte{payloadPoison}, 5)) {
+		t.Fatalf("kept = %q", kept)
+	}
+}
+
+func Test_PayloadModeCopy(t *testing.T) {
+	var kept []byte
+	l := &loopCallback{
+		c:           OnMessageFunc(func(_ *Conn, _ Opcode, payload []byte) { kept = payload }),
+		payloadMode: payloadMode{copyPayload: true, poison: true},
+	}
+	data := []byte("hello")
+	l.OnMessage(nil, Text, data)
+	// 回调拿到的是拷贝, 库的缓冲区照常回收
+	if string(kept) != "hello" || !bytes.Equal(data, bytes.Repeat([]byte{payloadPoison}, 5)) {
+		t.Fatalf("kept = %q, data = %q", kept, data)
+	}
+}
+
+func withPayloadMode(mode payloadMode) testConnOption {
+	return withTestConfig(func(conf *Config) {
+		conf.copyPayload, conf.poisonPayload = mode.copyPayload, mode.poison
+	})
+}
+
+// 发送两条分片消息
+func writeTwoFragmented(t *testing.T, remote net.Conn, a, b string) {
+	var wire bytes.Buffer
+	for _, s := range []string{a, b} {
+		appendClientFrame(t, &wire, false, false, Text, s[:len(s)/2])
+		appendClientFrame(t, &wire, true, false, Continuation, s[len(s)/2:])
+	}
+	if _, err := remote.Write(wire.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 回调在业务go程池里异步执行, 第一条分片消息的回调还没返回时, 第二条分片消息不能覆盖它的payload
+func Test_PayloadBorrowedUntilReturn(t *testing.T) {
+	a, b := strings.Repeat("a", 64), strings.Repeat("b", 64)
+	second := make(chan struct{})
+	release := make(chan struct{})
+	got := make(chan string, 1)
+	_, remote := newTestConn(t, withPayloadMode(payloadMode{poison: true}), withTestCallback(OnMessageFunc(func(_ *Conn, _ Opcode, payload []byte) {
+		if payload[0] == 'b' {
+			close(second)
+			return
+		}
+		<-release
+		got <- string(payload)
+	})))
+
+	writeTwoFragmented(t, remote, a, b)
+	select {
+	case <-second:
+	case <-time.After(3 * time.Second):
+		t.Fatal("second message timeout")
+	}
+	close(release)
+	if s := <-got; s != a {
+		t.Fatalf("first payload = %q", s)
+	}
+}
+
+// WithServerCopyPayload: 回调返回之后还可以继续持有payload
+func Test_PayloadCopyOwned(t *testing.T) {
+	a, b := strings.Repeat("a", 64), strings.Repeat("b", 64)
+	kept := make(chan []byte, 2)
+	_, remote := newTestConn(t, withPayloadMode(payloadMode{copyPayload: true, poison: true}), withTestCallback(OnMessageFunc(func(_ *Conn, _ Opcode, payload []byte) {
+		kept <- payload
+	})))
+
+	writeTwoFragmented(t, remote, a, b)
+	var msgs []string
+	for i := 0; i < 2; i++ {
+		select {
+		case p := <-kept:
+			msgs = append(msgs, string(p))
+		case <-time.After(3 * time.Second):
+			t.Fatal("message timeout")
+		}
+	}
+	// 库的缓冲区已经回收并且填满了payloadPoison, 拿到的拷贝还是原来的内容
+	if (msgs[0] != a || msgs[1] != b) && (msgs[0] != b || msgs[1] != a) {
+		t.Fatalf("messages = %q", msgs)
+	}
+}
+
+// WithServerCopyPayload: lend已经把合并分片的缓冲区还到了池子里, 连接不能再用它拼下一条分片消息
+func Test_PayloadCopyFragmentGivenBack(t *testing.T) {
+	got := make(chan string, 1)
+	c, remote := newTestConn(t, withTestCallback(OnMessageFunc(func(_ *Conn, _ Opcode, payload []byte) {
+		got <- string(payload)
+	})), withPayloadMode(payloadMode{copyPayload: true}))
+
+	// 长度是page的整数倍才会放进池子
+	a := strings.Repeat("a", 1024)
+	var wire bytes.Buffer
+	appendClientFrame(t, &wire, false, false, Text, a[:512])
+	appendClientFrame(t, &wire, true, false, Continuation, a[512:])
+	if _, err := remote.Write(wire.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case s := <-got:
+		if s != a {
+			t.Fatalf("payload = %q", s)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("message timeout")
+	}
+
+	kept := make(chan bool, 1)
+	c.getParent().Execute(func() {
+		kept <- c.fragmentFramePayload != nil
+	})
+	if <-kept {
+		t.Fatal("fragment buffer reused after it was given back")
+	}
+}
+
+func Test_PayloadOptions(t *testing.T) {
+	m := NewMultiEventLoopMust(WithEventLoops(1))
+	u := NewUpgrade(WithServerMultiEventLoop(m), WithServerCopyPayload(), WithServerPoisonPayload())
+	g, ok := u.config.Callback.(*goCallback)
+	if !ok || g.payloadMode != (payloadMode{copyPayload: true, poison: true}) {
+		t.Fatalf("callback = %#v", u.config.Callback)
+	}
+
+	d := ClientOptionToConf(WithClientCopyPayload(), WithClientPoisonPayload())
+	if !d.copyPayload || !d.poisonPayload {
+		t.Fatal("client options not applied")
+	}
+}
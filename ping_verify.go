@@ -0,0 +1,62 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"bytes"
+	"encoding/binary"
+	"time"
+)
+
+// 对端回的pong和最近一次发送的ping的payload不一致时调用
+// 在事件循环里同步调用, 不要阻塞, sent和received只在回调期间有效
+// 常见的原因是中间设备自己生成了控制帧, 而不是转发对端的
+type PongMismatchFunc func(c *Conn, sent, received []byte)
+
+// 记录最近一次发送的ping, 对端只需要回应最近的一个
+func (c *Conn) rememberPing(payload []byte) {
+	if c.pongMismatch == nil {
+		return
+	}
+	p := append([]byte{}, payload...)
+	c.lastPing.Store(&p)
+}
+
+// 没有发送过ping时收到的pong是对端主动发的, 不检查
+func (c *Conn) verifyPong(payload []byte) {
+	if c.pongMismatch == nil {
+		return
+	}
+	p := c.lastPing.Swap(nil)
+	if p == nil {
+		return
+	}
+	if !bytes.Equal(*p, payload) {
+		c.pongMismatch(c, *p, payload)
+	}
+}
+
+// 开启校验时, 心跳的ping带上发送的时间, 每次都不一样
+func (c *Conn) heartbeatPayload() []byte {
+	if c.pongMismatch == nil {
+		return nil
+	}
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(time.Now().UnixNano()))
+	return b[:]
+}
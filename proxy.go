@@ -0,0 +1,80 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"errors"
+)
+
+// 把两个连接对接起来, 任意一端收到的text/binary消息都转发给另一端, 用于搭建websocket网关
+// 转发在事件循环里同步完成, 不经过OnMessage, payload只在组帧的时候拷贝一次
+// 两端的掩码方向可能不一样(服务端收到的带掩码, 客户端发出去要重新生成掩码), 所以不能直接splice原始字节,
+// 转发的是消息, 只重新生成帧头, 分片的消息会合并成一条
+// ping/pong由每一跳自己处理, 一端关闭之后用同样的关闭码关闭另一端
+func Proxy(src, dst *Conn) error {
+	if src.isClosed() || dst.isClosed() {
+		return ErrClosed
+	}
+
+	if src == dst || !src.proxyPeer.CompareAndSwap(nil, dst) {
+		return ErrProxied
+	}
+	if !dst.proxyPeer.CompareAndSwap(nil, src) {
+		src.proxyPeer.Store(nil)
+		return ErrProxied
+	}
+	return nil
+}
+
+// 转发给对端, 没有调用过Proxy返回false
+func (c *Conn) proxyMessage(op Opcode, payload []byte) bool {
+	peer := c.proxyPeer.Load()
+	if peer == nil {
+		return false
+	}
+
+	if err := peer.WriteMessage(op, payload); err != nil {
+		c.asyncClose(err)
+	}
+	// 不直接取参数的地址, 否则没有代理的时候payload也会逃逸到堆上
+	buf := payload
+	PutPayloadBytes(&buf)
+	return true
+}
+
+// 连接关闭的时候, 用同样的关闭码关闭对端
+func (c *Conn) proxyClose(err error) {
+	peer := c.proxyPeer.Swap(nil)
+	if peer == nil {
+		return
+	}
+	// 对端关闭的时候不要再关回来
+	peer.proxyPeer.CompareAndSwap(c, nil)
+
+	code, reason := NormalClosure, ""
+	var ce *CloseErrMsg
+	if errors.As(err, &ce) {
+		code, reason = ce.Code, ce.Msg
+	} else if err != nil {
+		code = EndpointGoingAway
+	}
+
+	if peer.WriteClose(code, reason) != nil {
+		peer.Close()
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"github.com/antlabs/wsutil/enum"
+	"github.com/antlabs/wsutil/frame"
+)
+
+// 读缓冲区放不下一个frame时的扩容策略
+type ReadBufferGrowth int
+
+const (
+	// 按payload的大小(乘以windowsMultipleTimesPayloadSize)分配, 默认值
+	ReadBufferGrowthExact ReadBufferGrowth = iota
+	// 每次翻倍, 直到放得下, 适合大小变化频繁的场景, 减少重复扩容
+	ReadBufferGrowthDouble
+	// 读缓冲区不扩容, 放不下的payload边读边拷贝到payload的缓冲区里
+	// 适合大量连接+偶尔有大包的场景, 每个连接的读缓冲区不会被大包撑大
+	ReadBufferGrowthFixed
+)
+
+// 读缓冲区扩容之后的大小
+func (c *Conn) growReadBufferSize() int {
+	need := c.rh.PayloadLen + enum.MaxFrameHeaderSize
+	if c.readBufferGrowth == ReadBufferGrowthDouble {
+		size := int64(c.rbuf.Cap())
+		if size < 1024 {
+			size = 1024
+		}
+		for size < need {
+			size *= 2
+		}
+		return int(size)
+	}
+
+	return int(float32(need) * c.windowsMultipleTimesPayloadSize)
+}
+
+// ReadBufferGrowthFixed模式下, payload比读缓冲区大, 每次把读到的数据拷贝走, 读缓冲区腾出来继续读
+func (c *Conn) readPayloadStreaming() (f frame.Frame, success bool, err error) {
+	if c.streamPayload == nil {
+		c.streamPayload = GetPayloadBytes(int(c.rh.PayloadLen))
+		c.streamN = 0
+	}
+
+	c.streamN += c.rbuf.Read((*c.streamPayload)[c.streamN:c.rh.PayloadLen])
+	if int64(c.streamN) < c.rh.PayloadLen {
+		return
+	}
+
+	f.Payload = (*c.streamPayload)[:c.rh.PayloadLen]
+	f.FrameHeader = c.rh
+	c.streamPayload = nil
+	c.streamN = 0
+
+	if c.rh.Mask {
+		maskPayload(f.Payload, c.rh.MaskKey)
+	}
+
+	c.traceRead(&f)
+	return f, true, nil
+}
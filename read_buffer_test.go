@@ -0,0 +1,67 @@
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/antlabs/wsutil/frame"
+)
+
+// 把一个大frame分成小块喂给parser, 返回解析出来的payload
+func feedFrameInChunks(t *testing.T, growth ReadBufferGrowth, payload []byte) (got []byte, rbufLen int) {
+	var wire bytes.Buffer
+	if err := frame.WriteFrameToBytes(&wire, payload, true, false, true, Binary, 0x12345678); err != nil {
+		t.Fatal(err)
+	}
+
+	conf := &Config{}
+	conf.defaultSetting()
+	conf.readBufferGrowth = growth
+	c := newConn(-1, false, conf)
+
+	data := wire.Bytes()
+	for len(data) > 0 {
+		n := c.rbuf.Write(data[:min(len(data), 500)])
+		data = data[n:]
+
+		if c.curState != frameStatePayload {
+			success, err := c.readHeader()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !success {
+				continue
+			}
+		}
+
+		f, success, err := c.readPayload()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if success {
+			return f.Payload, c.rbuf.Cap()
+		}
+	}
+	t.Fatal("frame not complete")
+	return nil, 0
+}
+
+func Test_ReadBufferGrowth(t *testing.T) {
+	payload := bytes.Repeat([]byte("greatws"), 10000)
+	for _, growth := range []ReadBufferGrowth{ReadBufferGrowthExact, ReadBufferGrowthDouble, ReadBufferGrowthFixed} {
+		got, rbufLen := feedFrameInChunks(t, growth, payload)
+		if !bytes.Equal(got, payload) {
+			t.Fatalf("growth %d: payload mismatch", growth)
+		}
+
+		if growth == ReadBufferGrowthFixed && rbufLen >= len(payload) {
+			t.Fatalf("fixed growth should not grow read buffer: %d", rbufLen)
+		}
+		if growth != ReadBufferGrowthFixed && rbufLen < len(payload) {
+			t.Fatalf("growth %d: read buffer too small: %d", growth, rbufLen)
+		}
+	}
+}
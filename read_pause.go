@@ -0,0 +1,55 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+package greatws
+
+import "sync/atomic"
+
+// 暂停读, 已经读到缓冲区里的数据不受影响
+// io_uring模式不支持, 什么都不做
+func (c *Conn) pauseRead() {
+	if c.useIoUring() {
+		return
+	}
+	atomic.StoreInt32(&c.readPaused, 1)
+}
+
+// 恢复读, 暂停期间有数据到达的话, 让事件循环重新检查一次
+func (c *Conn) resumeRead() error {
+	if atomic.SwapInt32(&c.readPaused, 0) == 0 {
+		return nil
+	}
+	if atomic.CompareAndSwapInt32(&c.readPending, 1, 0) && !c.isClosed() {
+		return c.multiEventLoop.rearmRead(c)
+	}
+	return nil
+}
+
+// 事件循环里调用, 返回true表示暂停中, 这次不读
+// 先标记readPending再检查readPaused, 和resumeRead交错执行的时候, 要么这里继续读, 要么resumeRead重新触发
+func (c *Conn) readBlocked() bool {
+	if atomic.LoadInt32(&c.readPaused) == 0 {
+		return false
+	}
+
+	atomic.StoreInt32(&c.readPending, 1)
+	if atomic.LoadInt32(&c.readPaused) == 1 {
+		return true
+	}
+	// 刚好被恢复了, 抢到readPending就自己读
+	return !atomic.CompareAndSwapInt32(&c.readPending, 1, 0)
+}
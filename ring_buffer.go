@@ -0,0 +1,138 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+package greatws
+
+// 连接的读缓冲区, 环形
+// 数据写到末尾之后绕回开头继续写, 不需要把未处理的数据挪到开头
+// 只有一个frame比整个缓冲区还大时才需要扩容
+type ringBuffer struct {
+	buf *[]byte
+	r   int // 读的位置
+	w   int // 写的位置
+	n   int // 未读的数据长度
+}
+
+func newRingBuffer(buf *[]byte) ringBuffer {
+	return ringBuffer{buf: buf}
+}
+
+// 未读的数据长度
+func (rb *ringBuffer) Len() int {
+	return rb.n
+}
+
+// 缓冲区总长度
+func (rb *ringBuffer) Cap() int {
+	return len(*rb.buf)
+}
+
+// 剩余可写的长度
+func (rb *ringBuffer) Free() int {
+	return len(*rb.buf) - rb.n
+}
+
+// 返回从写位置开始的一段连续空闲空间, 给read系统调用直接写入, 写完之后调用Commit
+// 缓冲区满了返回空的slice
+func (rb *ringBuffer) WriteSlice() []byte {
+	if rb.n == 0 {
+		// 没有数据的时候回到开头, 让连续的空闲空间最大
+		rb.r, rb.w = 0, 0
+	}
+
+	if rb.n == len(*rb.buf) {
+		return nil
+	}
+
+	if rb.w >= rb.r {
+		return (*rb.buf)[rb.w:]
+	}
+	return (*rb.buf)[rb.w:rb.r]
+}
+
+// 写入了n字节
+func (rb *ringBuffer) Commit(n int) {
+	rb.w = (rb.w + n) % len(*rb.buf)
+	rb.n += n
+}
+
+// 写入数据, 返回写入的长度, 空间不够时只写一部分
+func (rb *ringBuffer) Write(p []byte) int {
+	total := 0
+	for len(p) > 0 {
+		ws := rb.WriteSlice()
+		if len(ws) == 0 {
+			break
+		}
+		n := copy(ws, p)
+		rb.Commit(n)
+		p = p[n:]
+		total += n
+	}
+	return total
+}
+
+// 返回未读数据的两段, 第二段是绕回开头的部分, 可能为空
+func (rb *ringBuffer) Segments() (a, b []byte) {
+	if rb.n == 0 {
+		return nil, nil
+	}
+
+	end := rb.r + rb.n
+	if end <= len(*rb.buf) {
+		return (*rb.buf)[rb.r:end], nil
+	}
+	return (*rb.buf)[rb.r:], (*rb.buf)[:end-len(*rb.buf)]
+}
+
+// 拷贝数据到p, 不移动读位置
+func (rb *ringBuffer) Peek(p []byte) int {
+	a, b := rb.Segments()
+	n := copy(p, a)
+	n += copy(p[n:], b)
+	return n
+}
+
+// 读位置往后移动n字节
+func (rb *ringBuffer) Discard(n int) {
+	if n > rb.n {
+		n = rb.n
+	}
+	rb.r = (rb.r + n) % len(*rb.buf)
+	rb.n -= n
+}
+
+// 读取数据到p
+func (rb *ringBuffer) Read(p []byte) int {
+	n := rb.Peek(p)
+	rb.Discard(n)
+	return n
+}
+
+// 换一个更大的缓冲区, 未读的数据按顺序拷贝到开头, 返回旧的缓冲区
+func (rb *ringBuffer) Swap(buf *[]byte) (old *[]byte) {
+	n := rb.Peek(*buf)
+	old = rb.buf
+	rb.buf = buf
+	rb.r, rb.w, rb.n = 0, n%len(*buf), n
+	return old
+}
+
+// 丢弃所有数据
+func (rb *ringBuffer) Reset() {
+	rb.r, rb.w, rb.n = 0, 0, 0
+}
@@ -0,0 +1,48 @@
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"bytes"
+	"testing"
+)
+
+func Test_RingBufferWrap(t *testing.T) {
+	buf := make([]byte, 8)
+	rb := newRingBuffer(&buf)
+
+	if n := rb.Write([]byte("abcdef")); n != 6 {
+		t.Fatalf("Write = %d", n)
+	}
+	out := make([]byte, 4)
+	rb.Read(out)
+
+	// 写到末尾之后绕回开头
+	if n := rb.Write([]byte("ghijkl")); n != 6 {
+		t.Fatalf("Write = %d", n)
+	}
+	if rb.Free() != 0 || len(rb.WriteSlice()) != 0 {
+		t.Fatalf("should be full, free = %d", rb.Free())
+	}
+
+	a, b := rb.Segments()
+	if got := string(a) + string(b); got != "efghijkl" {
+		t.Fatalf("Segments = %q", got)
+	}
+
+	// 扩容之后数据按顺序放在开头
+	big := make([]byte, 16)
+	old := rb.Swap(&big)
+	if &(*old)[0] != &buf[0] {
+		t.Fatal("Swap should return the old buffer")
+	}
+	out = make([]byte, rb.Len())
+	rb.Read(out)
+	if !bytes.Equal(out, []byte("efghijkl")) {
+		t.Fatalf("after Swap = %q", out)
+	}
+	if len(rb.WriteSlice()) != 16 {
+		t.Fatalf("empty ring should reset to the start, got %d", len(rb.WriteSlice()))
+	}
+}
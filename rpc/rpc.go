@@ -0,0 +1,272 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+// rpc 在greatws的连接上做请求/响应, 每个请求带一个id, 响应按id找到等待的调用
+// 同一个连接上可以同时发起多个调用, 响应不需要按顺序返回
+// 通过中间件接入, rpc占用binary消息, text消息交给下一个callback
+package rpc
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/antlabs/greatws"
+)
+
+// 帧格式: kind(1字节) + id(8字节, 大端) + method的长度(2字节, 大端) + method + payload
+// 响应和错误的method为空, 错误的payload是错误信息
+const (
+	kindRequest  byte = 1
+	kindResponse byte = 2
+	kindError    byte = 3
+
+	headerSize = 1 + 8 + 2
+)
+
+var (
+	ErrBadFrame      = errors.New("rpc: bad frame")       // 收到的binary消息不是rpc的帧
+	ErrMethodTooLong = errors.New("rpc: method too long") // method超过65535字节
+	ErrNoHandler     = errors.New("rpc: no handler")      // 没有配置Handler, 对端的调用收到Msg是这个文本的*Error
+)
+
+// 对端Handler返回的错误
+type Error struct {
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return "rpc: remote error: " + e.Msg
+}
+
+// 处理对端的请求, 返回的payload作为响应, 返回的错误把Error()的文本发给对端
+// 每个请求在单独的go程里调用, payload在返回之后还可以使用
+type Handler func(ctx context.Context, c *greatws.Conn, method string, payload []byte) ([]byte, error)
+
+type Option func(*Endpoint)
+
+// 1.处理对端的请求, 不配置的时候对端的调用收到ErrNoHandler
+func WithHandler(h Handler) Option {
+	return func(e *Endpoint) {
+		e.handler = h
+	}
+}
+
+// 2.ctx没有deadline时, 调用最多等待的时间, 默认10s, 小于等于0表示一直等待
+func WithTimeout(d time.Duration) Option {
+	return func(e *Endpoint) {
+		e.timeout = d
+	}
+}
+
+// 服务端和客户端都可以用, 一个Endpoint可以管理多个连接
+type Endpoint struct {
+	handler Handler
+	timeout time.Duration
+	conns   sync.Map // *greatws.Conn -> *connState
+}
+
+// 每个连接等待响应的调用
+type connState struct {
+	mu      sync.Mutex
+	next    uint64
+	pending map[uint64]chan result
+}
+
+type result struct {
+	payload []byte
+	err     error
+}
+
+func New(opts ...Option) *Endpoint {
+	e := &Endpoint{timeout: 10 * time.Second}
+	for _, o := range opts {
+		o(e)
+	}
+	return e
+}
+
+// 通过greatws.WithServerMiddleware或者greatws.WithClientMiddleware使用
+func (e *Endpoint) Middleware() greatws.Middleware {
+	return func(next greatws.Callback) greatws.Callback {
+		return &callback{next: next, e: e}
+	}
+}
+
+// 在c上调用对端的method, 等到响应, 对端返回错误, ctx结束或者连接断开
+// c的callback里要有Middleware, 不然收不到响应
+func (e *Endpoint) Call(ctx context.Context, c *greatws.Conn, method string, payload []byte) ([]byte, error) {
+	if len(method) > 0xffff {
+		return nil, ErrMethodTooLong
+	}
+	if c.IsClosed() {
+		return nil, greatws.ErrClosed
+	}
+	if _, ok := ctx.Deadline(); !ok && e.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.timeout)
+		defer cancel()
+	}
+
+	v, _ := e.conns.LoadOrStore(c, &connState{pending: make(map[uint64]chan result)})
+	st := v.(*connState)
+	ch := make(chan result, 1)
+	st.mu.Lock()
+	st.next++
+	id := st.next
+	st.pending[id] = ch
+	st.mu.Unlock()
+	defer st.remove(id)
+
+	// 放进pending之前连接可能已经关闭了, OnClose不会通知到这个调用
+	if c.IsClosed() {
+		e.conns.Delete(c)
+		return nil, greatws.ErrClosed
+	}
+
+	if err := c.WriteMessageContext(ctx, greatws.Binary, appendFrame(nil, kindRequest, id, method, payload)); err != nil {
+		return nil, err
+	}
+
+	select {
+	case r := <-ch:
+		return r.payload, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (st *connState) remove(id uint64) {
+	st.mu.Lock()
+	delete(st.pending, id)
+	st.mu.Unlock()
+}
+
+// 把响应交给等待的调用, 调用已经超时的话丢掉
+func (st *connState) complete(id uint64, r result) {
+	st.mu.Lock()
+	ch, ok := st.pending[id]
+	delete(st.pending, id)
+	st.mu.Unlock()
+	if ok {
+		ch <- r
+	}
+}
+
+func appendFrame(buf []byte, kind byte, id uint64, method string, payload []byte) []byte {
+	if buf == nil {
+		buf = make([]byte, 0, headerSize+len(method)+len(payload))
+	}
+	buf = append(buf, kind)
+	buf = binary.BigEndian.AppendUint64(buf, id)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(method)))
+	buf = append(buf, method...)
+	return append(buf, payload...)
+}
+
+func parseFrame(b []byte) (kind byte, id uint64, method string, payload []byte, err error) {
+	if len(b) < headerSize {
+		return 0, 0, "", nil, fmt.Errorf("%w: %d bytes", ErrBadFrame, len(b))
+	}
+	kind, id = b[0], binary.BigEndian.Uint64(b[1:9])
+	n := int(binary.BigEndian.Uint16(b[9:11]))
+	if kind < kindRequest || kind > kindError || len(b) < headerSize+n {
+		return 0, 0, "", nil, fmt.Errorf("%w: kind=%d, method length=%d", ErrBadFrame, kind, n)
+	}
+	return kind, id, string(b[headerSize : headerSize+n]), b[headerSize+n:], nil
+}
+
+// 在单独的go程里处理对端的请求, 把结果写回去
+func (e *Endpoint) serve(c *greatws.Conn, id uint64, method string, payload []byte) {
+	var (
+		out []byte
+		err error
+	)
+	if e.handler == nil {
+		err = ErrNoHandler
+	} else {
+		out, err = e.handler(context.Background(), c, method, payload)
+	}
+
+	frame := appendFrame(nil, kindResponse, id, "", out)
+	if err != nil {
+		frame = appendFrame(nil, kindError, id, "", []byte(err.Error()))
+	}
+	// 连接断开的时候写不出去, 对端的调用会收到ErrClosed
+	_ = c.WriteMessage(greatws.Binary, frame)
+}
+
+type callback struct {
+	next greatws.Callback
+	e    *Endpoint
+}
+
+func (cb *callback) OnOpen(c *greatws.Conn) {
+	cb.next.OnOpen(c)
+}
+
+func (cb *callback) OnMessage(c *greatws.Conn, op greatws.Opcode, payload []byte) {
+	if op != greatws.Binary {
+		cb.next.OnMessage(c, op, payload)
+		return
+	}
+
+	kind, id, method, body, err := parseFrame(payload)
+	if err != nil {
+		c.WriteClose(greatws.ProtocolError, err.Error())
+		return
+	}
+	// OnMessage返回之后payload会被复用
+	body = append([]byte(nil), body...)
+
+	switch kind {
+	case kindRequest:
+		go cb.e.serve(c, id, method, body)
+	case kindResponse, kindError:
+		v, ok := cb.e.conns.Load(c)
+		if !ok {
+			return
+		}
+		r := result{payload: body}
+		if kind == kindError {
+			r = result{err: &Error{Msg: string(body)}}
+		}
+		v.(*connState).complete(id, r)
+	}
+}
+
+func (cb *callback) OnClose(c *greatws.Conn, err error) {
+	if v, ok := cb.e.conns.LoadAndDelete(c); ok {
+		st := v.(*connState)
+		closeErr := greatws.ErrClosed
+		if err != nil {
+			closeErr = fmt.Errorf("%w: %w", greatws.ErrClosed, err)
+		}
+
+		st.mu.Lock()
+		pending := st.pending
+		st.pending = make(map[uint64]chan result)
+		st.mu.Unlock()
+		for _, ch := range pending {
+			ch <- result{err: closeErr}
+		}
+	}
+	cb.next.OnClose(c, err)
+}
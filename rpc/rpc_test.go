@@ -0,0 +1,163 @@
+//go:build !js
+// +build !js
+
+package rpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/antlabs/greatws"
+)
+
+// 返回客户端的连接和服务端的连接
+func newTestPair(t *testing.T, server, client *Endpoint, onText greatws.OnMessageFunc) (*greatws.Conn, *greatws.Conn) {
+	m := greatws.NewMultiEventLoopMust(greatws.WithEventLoops(1))
+	m.Start()
+
+	sc := make(chan *greatws.Conn, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := greatws.Upgrade(w, r, greatws.WithServerMultiEventLoop(m),
+			greatws.WithServerOnMessageFunc(func(c *greatws.Conn, op greatws.Opcode, payload []byte) {
+				c.WriteMessage(op, payload)
+			}),
+			greatws.WithServerMiddleware(server.Middleware()))
+		if err != nil {
+			t.Error(err)
+		}
+		sc <- c
+	}))
+	t.Cleanup(ts.Close)
+
+	if onText == nil {
+		onText = func(*greatws.Conn, greatws.Opcode, []byte) {}
+	}
+	c, err := greatws.Dial("ws://"+strings.TrimPrefix(ts.URL, "http://"),
+		greatws.WithClientMultiEventLoop(m), greatws.WithClientOnMessageFunc(onText),
+		greatws.WithClientMiddleware(client.Middleware()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	return c, <-sc
+}
+
+func Test_Call(t *testing.T) {
+	server := New(WithHandler(func(ctx context.Context, c *greatws.Conn, method string, payload []byte) ([]byte, error) {
+		switch method {
+		case "echo":
+			return payload, nil
+		case "slow":
+			time.Sleep(200 * time.Millisecond)
+			return payload, nil
+		}
+		return nil, fmt.Errorf("unknown method %s", method)
+	}))
+	client := New()
+	text := make(chan string, 1)
+	c, sc := newTestPair(t, server, client, func(c *greatws.Conn, op greatws.Opcode, payload []byte) {
+		text <- string(payload)
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				want := fmt.Sprintf("req-%d", i)
+				got, err := client.Call(context.Background(), c, "echo", []byte(want))
+				if err != nil || string(got) != want {
+					t.Errorf("got %q, %v, want %q", got, err, want)
+				}
+			}(i)
+		}
+		wg.Wait()
+	})
+
+	t.Run("remote error", func(t *testing.T) {
+		_, err := client.Call(context.Background(), c, "nope", nil)
+		var re *Error
+		if !errors.As(err, &re) || re.Msg != "unknown method nope" {
+			t.Fatalf("err = %v", err)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		if _, err := client.Call(ctx, c, "slow", nil); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("err = %v", err)
+		}
+		// 超时之后到达的响应被丢掉, 不影响之后的调用
+		time.Sleep(200 * time.Millisecond)
+		if got, err := client.Call(context.Background(), c, "echo", []byte("after")); err != nil || string(got) != "after" {
+			t.Fatalf("got %q, %v", got, err)
+		}
+	})
+
+	t.Run("text passthrough", func(t *testing.T) {
+		if err := c.WriteMessage(greatws.Text, []byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case s := <-text:
+			if s != "hello" {
+				t.Fatalf("got %q", s)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("text not delivered")
+		}
+	})
+
+	t.Run("no handler", func(t *testing.T) {
+		// 客户端没有配置Handler, 服务端反过来调用
+		var re *Error
+		_, err := server.Call(context.Background(), sc, "echo", nil)
+		if !errors.As(err, &re) || re.Msg != ErrNoHandler.Error() {
+			t.Fatalf("err = %v", err)
+		}
+	})
+
+	t.Run("closed", func(t *testing.T) {
+		done := make(chan error, 1)
+		go func() {
+			_, err := client.Call(context.Background(), c, "slow", nil)
+			done <- err
+		}()
+		time.Sleep(50 * time.Millisecond)
+		c.Close()
+		select {
+		case err := <-done:
+			if !errors.Is(err, greatws.ErrClosed) {
+				t.Fatalf("err = %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("pending call not failed")
+		}
+		if _, err := client.Call(context.Background(), c, "echo", nil); !errors.Is(err, greatws.ErrClosed) {
+			t.Fatalf("err = %v", err)
+		}
+	})
+}
+
+func Test_ParseFrame(t *testing.T) {
+	b := appendFrame(nil, kindRequest, 42, "sum", []byte{1, 2})
+	kind, id, method, payload, err := parseFrame(b)
+	if err != nil || kind != kindRequest || id != 42 || method != "sum" || string(payload) != "\x01\x02" {
+		t.Fatalf("kind=%d, id=%d, method=%q, payload=%v, err=%v", kind, id, method, payload, err)
+	}
+
+	for _, bad := range [][]byte{nil, b[:5], appendFrame(nil, 9, 1, "", nil), b[:headerSize+1]} {
+		if _, _, _, _, err := parseFrame(bad); !errors.Is(err, ErrBadFrame) {
+			t.Fatalf("%v: err = %v", bad, err)
+		}
+	}
+}
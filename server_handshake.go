@@ -12,6 +12,9 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+//go:build !js
+// +build !js
+
 package greatws
 
 import (
@@ -23,13 +26,15 @@ import (
 )
 
 var (
-	ErrNotFoundHijacker             = errors.New("not found Hijacker")
+	ErrNotFoundHijacker             = errors.New("not found Hijacker") // http.ResponseWriter不支持Hijack
+	ErrNotFoundFlusher              = errors.New("not found Flusher")  // http2升级时http.ResponseWriter不支持Flush
 	bytesHeaderUpgrade              = []byte("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: ")
-	bytesHeaderExtensions           = []byte("Sec-WebSocket-Extensions: permessage-deflate; server_no_context_takeover; client_no_context_takeover\r\n")
+	bytesHeaderExtensions           = []byte("Sec-WebSocket-Extensions: ")
 	bytesCRLF                       = []byte("\r\n")
 	bytesPutSecWebSocketProtocolKey = []byte("Sec-WebSocket-Protocol: ")
 	strGetSecWebSocketProtocolKey   = "Sec-WebSocket-Protocol"
 	strWebSocketKey                 = "Sec-WebSocket-Key"
+	strWebSocketVersion             = "Sec-WebSocket-Version"
 )
 
 func writeHeaderVal(w io.Writer, val []byte) (err error) {
@@ -86,10 +91,18 @@ func prepareWriteResponse(r *http.Request, w io.Writer, cnf *Config) (err error)
 		if _, err = w.Write(bytesHeaderExtensions); err != nil {
 			return
 		}
+
+		if err = writeHeaderVal(w, StringToBytes(cnf.deflate.String())); err != nil {
+			return err
+		}
 	}
 
-	v = r.Header.Get(strGetSecWebSocketProtocolKey)
-	v = subProtocol(v, cnf)
+	// 用户自己指定了子协议的话, 不再协商
+	extra := cnf.upgradeResponseHeader(r)
+	v = ""
+	if extra.Get(strGetSecWebSocketProtocolKey) == "" {
+		v = subProtocol(r.Header.Get(strGetSecWebSocketProtocolKey), cnf)
+	}
 	if len(v) > 0 {
 		if _, err = w.Write(bytesPutSecWebSocketProtocolKey); err != nil {
 			return
@@ -100,6 +113,12 @@ func prepareWriteResponse(r *http.Request, w io.Writer, cnf *Config) (err error)
 		}
 	}
 
+	if len(extra) > 0 {
+		if err = extra.Write(w); err != nil {
+			return err
+		}
+	}
+
 	_, err = w.Write(bytesCRLF)
 	return err
 }
@@ -139,11 +158,30 @@ func checkRequest(r *http.Request) (ecode int, err error) {
 		return http.StatusBadRequest, ErrSecWebSocketKey
 	}
 
-	// Sec-WebSocket-Version的版本不是13的
-	if r.Header.Get("Sec-WebSocket-Version") != "13" {
-		return http.StatusUpgradeRequired, ErrSecWebSocketVersion
+	if ecode, err := checkVersion(r); err != nil {
+		return ecode, err
 	}
 
 	// TODO Sec-WebSocket-Extensions
 	return 0, nil
 }
+
+// 服务端支持的版本
+const supportedWebSocketVersion = "13"
+
+// https://datatracker.ietf.org/doc/html/rfc6455#section-4.2.2
+// 不支持客户端的版本时回426, 响应里的Sec-WebSocket-Version列出支持的版本(见setSupportedVersion), 客户端可以换一个版本重试
+// http1和http2(rfc 8441)共用
+func checkVersion(r *http.Request) (ecode int, err error) {
+	if v := strings.TrimSpace(r.Header.Get(strWebSocketVersion)); v != supportedWebSocketVersion {
+		return http.StatusUpgradeRequired, fmt.Errorf("%w: %q", ErrSecWebSocketVersion, v)
+	}
+	return 0, nil
+}
+
+// 426的响应带上支持的版本
+func setSupportedVersion(w http.ResponseWriter, status int) {
+	if status == http.StatusUpgradeRequired {
+		w.Header().Set(strWebSocketVersion, supportedWebSocketVersion)
+	}
+}
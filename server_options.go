@@ -12,8 +12,16 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+//go:build !js
+// +build !js
+
 package greatws
 
+import (
+	"net/http"
+	"time"
+)
+
 type ServerOption func(*ConnOption)
 
 type ConnOption struct {
@@ -34,3 +42,82 @@ func WithServerSubprotocols(subprotocols []string) ServerOption {
 		o.subProtocols = subprotocols
 	}
 }
+
+// 3. 限制握手请求的请求行加请求头的大小, 超过返回431
+// 请求头是net/http读的, 想在读的时候就拒绝, 还需要ConfigureHandshakeLimits配置http.Server
+func WithServerMaxHandshakeHeaderBytes(n int) ServerOption {
+	return func(o *ConnOption) {
+		o.handshakeLimits.maxHeaderBytes = n
+	}
+}
+
+// 4. 限制握手的耗时, 包括写101响应
+// http.Server配置了HandshakeConnContext或者ConfigureHandshakeLimits时, 从accept开始算, 超时返回408
+func WithServerHandshakeTimeout(d time.Duration) ServerOption {
+	return func(o *ConnOption) {
+		o.handshakeLimits.timeout = d
+	}
+}
+
+// 5. 握手之前调用f, 返回false拒绝连接, 返回的attach通过Conn.Session取出, 详见OnAcceptFunc
+func WithServerOnAccept(f OnAcceptFunc) ServerOption {
+	return func(o *ConnOption) {
+		o.onAccept = f
+	}
+}
+
+// 6. 接受客户端没有mask的帧, 兼容一些不规范的客户端(比如嵌入式设备上的实现)
+// 默认按rfc 6455的要求, 收到没有mask的帧回close(1002)并关闭连接
+func WithServerAllowUnmaskedClientFrames() ServerOption {
+	return func(o *ConnOption) {
+		o.allowUnmasked = true
+	}
+}
+
+// 7. 在握手的响应里加上固定的http头, 比如Set-Cookie, Server等
+// Upgrade, Connection, Sec-WebSocket-Accept, Sec-WebSocket-Extensions由库自己写, 配置了也会被忽略
+// 可以和WithServerUpgradeResponseHeaderFunc一起使用, 多次配置的值会合并
+func WithServerUpgradeResponseHeader(h http.Header) ServerOption {
+	return func(o *ConnOption) {
+		hdr := h.Clone()
+		o.upgradeRespHeaders = append(o.upgradeRespHeaders, func(*http.Request) http.Header { return hdr })
+	}
+}
+
+// 8. 每次握手根据请求生成响应里的http头, 比如链路追踪的id, 详见UpgradeResponseHeaderFunc
+func WithServerUpgradeResponseHeaderFunc(f UpgradeResponseHeaderFunc) ServerOption {
+	return func(o *ConnOption) {
+		o.upgradeRespHeaders = append(o.upgradeRespHeaders, f)
+	}
+}
+
+// 9. 自定义拒绝握手时的http响应(版本不对, 缺少key, Origin不允许等), 默认DefaultReject
+func WithServerRejectFunc(f RejectFunc) ServerOption {
+	return func(o *ConnOption) {
+		o.rejectFunc = f
+	}
+}
+
+// 10. 检查请求的Origin, 返回false时回403, 默认不检查
+func WithServerCheckOrigin(f func(r *http.Request) bool) ServerOption {
+	return func(o *ConnOption) {
+		o.checkOrigin = f
+	}
+}
+
+// 11. 粘性会话, 握手响应里下发当前节点的token(http头或者cookie), 客户端重连时带上,
+// 前面的负载均衡按token路由回这个节点. 在http handler里用Affinity.RequestNode取出客户端上一次的节点
+func WithServerAffinity(a Affinity) ServerOption {
+	return func(o *ConnOption) {
+		o.upgradeRespHeaders = append(o.upgradeRespHeaders, a.responseHeader)
+	}
+}
+
+// 12. 按tls握手的SNI/ALPN给每个连接选择配置, 一个listener服务多个域名, 详见TLSRouteFunc
+// 只有这两种方式会调用路由: UpgradeConn传入*tls.Conn(通过socketpair转发), 或者Upgrade处理http2的extended CONNECT.
+// http/1.1的wss用Upgrade时劫持出来的是*tls.Conn, 在事件循环支持tls之前仍然返回ErrTLSNotSupported, 路由不会被调用
+func WithServerTLSRoute(f TLSRouteFunc) ServerOption {
+	return func(o *ConnOption) {
+		o.tlsRoute = f
+	}
+}
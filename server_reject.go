@@ -0,0 +1,49 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+package greatws
+
+import "net/http"
+
+// 握手被拒绝时给客户端回http错误, 可以自定义body的格式(比如json), 方便客户端排查
+// status是http状态码, err是拒绝的原因; 调用的时候还没有hijack, 可以正常使用w
+type RejectFunc func(w http.ResponseWriter, r *http.Request, status int, err error)
+
+// 默认的RejectFunc, text/plain, body是错误信息
+func DefaultReject(w http.ResponseWriter, r *http.Request, status int, err error) {
+	http.Error(w, err.Error(), status)
+}
+
+// 回http错误, 返回err方便调用方直接return
+func (c *Config) reject(w http.ResponseWriter, r *http.Request, status int, err error) error {
+	setSupportedVersion(w, status)
+
+	f := c.rejectFunc
+	if f == nil {
+		f = DefaultReject
+	}
+	f(w, r, status, err)
+	return err
+}
+
+// 配置了WithServerCheckOrigin才检查
+func (c *Config) checkOriginAllowed(r *http.Request) error {
+	if c.checkOrigin == nil || c.checkOrigin(r) {
+		return nil
+	}
+	return ErrOriginDenied
+}
@@ -0,0 +1,85 @@
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_UpgradeReject(t *testing.T) {
+	m := NewMultiEventLoopMust(WithEventLoops(1))
+	m.Start()
+
+	newReq := func() *http.Request {
+		r := httptest.NewRequest("GET", "/ws", nil)
+		r.Header.Set("Upgrade", "websocket")
+		r.Header.Set("Connection", "Upgrade")
+		r.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		r.Header.Set("Sec-WebSocket-Version", "13")
+		r.Header.Set("Origin", "https://evil.example")
+		return r
+	}
+
+	t.Run("bad version", func(t *testing.T) {
+		r := newReq()
+		r.Header.Set("Sec-WebSocket-Version", "8")
+		w := httptest.NewRecorder()
+		_, err := Upgrade(w, r, WithServerMultiEventLoop(m))
+		if !errors.Is(err, ErrSecWebSocketVersion) || w.Code != http.StatusUpgradeRequired {
+			t.Fatalf("err = %v, code = %d", err, w.Code)
+		}
+		if w.Header().Get("Sec-WebSocket-Version") != "13" || !strings.Contains(w.Body.String(), err.Error()) {
+			t.Fatalf("header = %v, body = %q", w.Header(), w.Body.String())
+		}
+	})
+
+	t.Run("bad version http2", func(t *testing.T) {
+		r := httptest.NewRequest("CONNECT", "/ws", nil)
+		r.ProtoMajor = 2
+		r.Header.Set(":protocol", "websocket")
+		r.Header.Set("Sec-WebSocket-Version", "8")
+		w := httptest.NewRecorder()
+		_, err := Upgrade(w, r, WithServerMultiEventLoop(m))
+		if !errors.Is(err, ErrSecWebSocketVersion) || w.Code != http.StatusUpgradeRequired {
+			t.Fatalf("err = %v, code = %d", err, w.Code)
+		}
+		if w.Header().Get("Sec-WebSocket-Version") != "13" {
+			t.Fatalf("header = %v", w.Header())
+		}
+	})
+
+	t.Run("missing key with custom body", func(t *testing.T) {
+		r := newReq()
+		r.Header.Del("Sec-WebSocket-Key")
+		w := httptest.NewRecorder()
+		_, err := Upgrade(w, r, WithServerMultiEventLoop(m),
+			WithServerRejectFunc(func(w http.ResponseWriter, r *http.Request, status int, err error) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(status)
+				fmt.Fprintf(w, `{"error":%q}`, err.Error())
+			}))
+		if !errors.Is(err, ErrSecWebSocketKey) || w.Code != http.StatusBadRequest {
+			t.Fatalf("err = %v, code = %d", err, w.Code)
+		}
+		if want := fmt.Sprintf(`{"error":%q}`, err.Error()); w.Body.String() != want {
+			t.Fatalf("body = %q", w.Body.String())
+		}
+	})
+
+	t.Run("origin denied", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		_, err := Upgrade(w, newReq(), WithServerMultiEventLoop(m),
+			WithServerCheckOrigin(func(r *http.Request) bool {
+				return r.Header.Get("Origin") == "https://good.example"
+			}))
+		if !errors.Is(err, ErrOriginDenied) || w.Code != http.StatusForbidden {
+			t.Fatalf("err = %v, code = %d", err, w.Code)
+		}
+	})
+}
@@ -0,0 +1,76 @@
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func openFds() int {
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return -1
+	}
+	return len(fds)
+}
+
+// 反复启动和停止, Wait返回说明go程都已经退出, fd也不能泄漏
+func Test_MultiEventLoopShutdown(t *testing.T) {
+	baseFd := openFds()
+
+	for i := 0; i < 3; i++ {
+		m := NewMultiEventLoopMust(WithEventLoops(2), WithBusinessGoNum(8, 8, 16))
+		m.Start()
+
+		got := make(chan string, 1)
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, err := Upgrade(w, r, WithServerMultiEventLoop(m), WithServerOnMessageFunc(func(c *Conn, op Opcode, payload []byte) {
+				got <- string(payload)
+			}))
+			if err != nil {
+				t.Error(err)
+			}
+		}))
+
+		c, err := Dial("ws://"+strings.TrimPrefix(ts.URL, "http://"), WithClientMultiEventLoop(m))
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.WriteMessage(Text, []byte("hello"))
+		select {
+		case s := <-got:
+			if s != "hello" {
+				t.Fatalf("got %q", s)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("message not received")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+		if err := m.Shutdown(ctx); err != nil {
+			t.Fatal(err)
+		}
+		cancel()
+		m.Wait()
+		if c.State() != StateClosed || m.ConnCount() != 0 {
+			t.Fatalf("state = %v, conns = %d", c.State(), m.ConnCount())
+		}
+		ts.Close()
+	}
+
+	// httptest关闭连接是异步的
+	deadline := time.Now().Add(3 * time.Second)
+	for time.Now().Before(deadline) && openFds() > baseFd {
+		time.Sleep(20 * time.Millisecond)
+	}
+	if n := openFds(); n > baseFd {
+		t.Fatalf("fds %d -> %d", baseFd, n)
+	}
+}
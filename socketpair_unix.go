@@ -0,0 +1,83 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux || darwin || netbsd || freebsd || openbsd || dragonfly
+// +build linux darwin netbsd freebsd openbsd dragonfly
+
+package greatws
+
+import (
+	"io"
+	"net"
+	"os"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// 创建一对相连的unix socket
+// localFd交给event loop管理, remote用来对接没有fd的流(比如http2的stream)
+func newSocketPair() (localFd int, remote net.Conn, err error) {
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM, 0)
+	if err != nil {
+		return 0, nil, err
+	}
+	unix.CloseOnExec(fds[0])
+	unix.CloseOnExec(fds[1])
+
+	if err = unix.SetNonblock(fds[0], true); err != nil {
+		unix.Close(fds[0])
+		unix.Close(fds[1])
+		return 0, nil, err
+	}
+
+	f := os.NewFile(uintptr(fds[1]), "greatws-socketpair")
+	// FileConn会dup一份fd, 所以这里可以关闭
+	remote, err = net.FileConn(f)
+	f.Close()
+	if err != nil {
+		unix.Close(fds[0])
+		return 0, nil, err
+	}
+	return fds[0], remote, nil
+}
+
+// 把r, w组成的流和remote对接
+// 任意一个方向结束, 都会关闭remote, event loop那一端会读到EOF
+// 两个方向都结束之后, 关闭返回的chan
+func bridgeStream(remote net.Conn, r io.ReadCloser, w io.WriteCloser) <-chan struct{} {
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		io.Copy(w, remote)
+		w.Close()
+		remote.Close()
+	}()
+
+	go func() {
+		defer wg.Done()
+		io.Copy(remote, r)
+		r.Close()
+		remote.Close()
+	}()
+
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	return done
+}
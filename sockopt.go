@@ -0,0 +1,42 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+package greatws
+
+import "time"
+
+// 拿到fd之后马上设置的socket选项, 零值表示不修改, 保持系统默认
+type socketOptions struct {
+	keepAlive         bool
+	keepAliveIdle     time.Duration // 空闲多久开始发送探测包
+	keepAliveInterval time.Duration // 探测包的间隔
+	keepAliveCount    int           // 探测多少次没有回应就断开
+	sendBufferSize    int           // SO_SNDBUF
+	recvBufferSize    int           // SO_RCVBUF
+	quickAck          bool          // TCP_QUICKACK, 只有linux支持
+	lingerSet         bool
+	linger            time.Duration // SO_LINGER, 0表示close的时候直接发送RST
+}
+
+// 转成秒, 不足1秒按1秒算
+func durationToSeconds(d time.Duration) int {
+	s := int((d + time.Second - 1) / time.Second)
+	if s < 1 {
+		s = 1
+	}
+	return s
+}
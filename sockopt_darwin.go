@@ -0,0 +1,47 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build darwin
+// +build darwin
+
+package greatws
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// darwin下空闲时间的选项是TCP_KEEPALIVE
+func setKeepAliveParams(fd int, o *socketOptions) (err error) {
+	if o.keepAliveIdle > 0 {
+		if err = unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_KEEPALIVE, durationToSeconds(o.keepAliveIdle)); err != nil {
+			return err
+		}
+	}
+	if o.keepAliveInterval > 0 {
+		if err = unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_KEEPINTVL, durationToSeconds(o.keepAliveInterval)); err != nil {
+			return err
+		}
+	}
+	if o.keepAliveCount > 0 {
+		if err = unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_KEEPCNT, o.keepAliveCount); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// darwin没有TCP_QUICKACK, 忽略
+func setQuickAck(fd int) error {
+	return nil
+}
@@ -0,0 +1,99 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux || darwin
+// +build linux darwin
+
+package greatws
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// 运行时开关nagle算法, noDelay为true表示关闭nagle, 数据马上发送
+// 比如批量发送的阶段先打开nagle, 结束之后再关闭
+func (c *Conn) SetNoDelay(noDelay bool) error {
+	if c.isClosed() {
+		return ErrClosed
+	}
+	return setNoDelay(c.getFd(), noDelay)
+}
+
+func setNoDelay(fd int, noDelay bool) error {
+	v := 0
+	if noDelay {
+		v = 1
+	}
+	return unix.SetsockoptInt(fd, unix.IPPROTO_TCP, unix.TCP_NODELAY, v)
+}
+
+// unix域套接字不支持tcp的选项
+func isUnixSocket(fd int) bool {
+	sa, err := unix.Getsockname(fd)
+	if err != nil {
+		return false
+	}
+	_, ok := sa.(*unix.SockaddrUnix)
+	return ok
+}
+
+// 设置accept或者dial得到的fd
+// 不依赖go的net库的默认值, TCP_NODELAY按配置显式设置
+// unix域套接字跳过nodelay, keepalive, quickack这些tcp的选项
+func setSocketOptions(fd int, noDelay bool, o *socketOptions) (err error) {
+	tcp := !isUnixSocket(fd)
+	if tcp {
+		if err = setNoDelay(fd, noDelay); err != nil {
+			return err
+		}
+	}
+
+	if tcp && o.keepAlive {
+		if err = unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_KEEPALIVE, 1); err != nil {
+			return err
+		}
+		if err = setKeepAliveParams(fd, o); err != nil {
+			return err
+		}
+	}
+
+	if o.sendBufferSize > 0 {
+		if err = unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_SNDBUF, o.sendBufferSize); err != nil {
+			return err
+		}
+	}
+
+	if o.recvBufferSize > 0 {
+		if err = unix.SetsockoptInt(fd, unix.SOL_SOCKET, unix.SO_RCVBUF, o.recvBufferSize); err != nil {
+			return err
+		}
+	}
+
+	if tcp && o.quickAck {
+		if err = setQuickAck(fd); err != nil {
+			return err
+		}
+	}
+
+	if o.lingerSet {
+		l := unix.Linger{Onoff: 1}
+		if o.linger > 0 {
+			l.Linger = int32(durationToSeconds(o.linger))
+		}
+		if err = unix.SetsockoptLinger(fd, unix.SOL_SOCKET, unix.SO_LINGER, &l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -18,6 +18,8 @@ import (
 	"encoding/binary"
 	"strconv"
 	"strings"
+	"sync"
+	"unicode/utf8"
 )
 
 // https://datatracker.ietf.org/doc/html/rfc6455#section-7.4.1
@@ -100,32 +102,122 @@ func bytesToCloseErrMsg(payload []byte) *CloseErrMsg {
 		ce.Code = StatusCode(binary.BigEndian.Uint16(payload))
 	}
 
-	if len(payload) >= 3 {
-		ce.Msg = string(payload[3:])
+	if len(payload) > 2 {
+		ce.Msg = string(payload[2:])
 	}
 	return &ce
 }
 
 func statusCodeToBytes(code StatusCode) (rv []byte) {
-	rv = make([]byte, 2+len(code.String()))
+	return FormatCloseMessage(code, code.String())
+}
+
+// close帧的reason最多123字节, 控制帧的125字节减去2字节的关闭码
+const maxCloseReasonSize = maxControlFrameSize - 2
+
+// 超过maxCloseReasonSize的reason截断, 不会把一个utf8字符切成两半
+func truncateCloseReason(reason string) string {
+	if len(reason) <= maxCloseReasonSize {
+		return reason
+	}
+
+	n := maxCloseReasonSize
+	for n > 0 && !utf8.RuneStart(reason[n]) {
+		n--
+	}
+	return reason[:n]
+}
+
+// 生成close帧的payload, 2字节大端的关闭码 + reason
+// reason超过123字节按utf8字符截断, 结果可以直接传给WriteControl(Close, ...)
+func FormatCloseMessage(code StatusCode, reason string) (rv []byte) {
+	reason = truncateCloseReason(reason)
+	rv = make([]byte, 2+len(reason))
 	binary.BigEndian.PutUint16(rv, uint16(code))
-	copy(rv[2:], code.String())
+	copy(rv[2:], reason)
 	return
 }
 
-func validCode(code uint16) bool {
-	switch code {
-	case 1004, 1005, 1006, 1015:
-		return false
+// 解析close帧的payload, 返回关闭码和reason
+// 没有payload是合法的, 返回的code为0. 只有1个字节返回ErrClosePayloadTooSmall,
+// 关闭码不合法(按DefaultCloseCodeValidator)返回ErrCloseValue, reason不是utf8返回ErrTextNotUTF8
+func ParseCloseMessage(payload []byte) (code StatusCode, reason string, err error) {
+	if len(payload) == 0 {
+		return 0, "", nil
 	}
 
-	if code >= 1000 && code <= 1015 {
-		return true
+	if err = DefaultCloseCodeValidator.checkPayload(payload); err != nil {
+		return 0, "", err
 	}
 
-	if code >= 3000 && code <= 4999 {
-		return true
+	code = StatusCode(binary.BigEndian.Uint16(payload))
+	if !utf8.Valid(payload[2:]) {
+		return code, "", ErrTextNotUTF8
 	}
+	return code, string(payload[2:]), nil
+}
+
+// 关闭码的校验, 收到和发送的close帧共用一张表
+// https://datatracker.ietf.org/doc/html/rfc6455#section-7.4.2
+// 1000-2999 只接受rfc和iana注册过的, 1004是保留的, 1005/1006/1015不能出现在close帧里
+// 3000-3999 给库和框架用, 全部接受
+// 4000-4999 给应用自己用, 默认全部接受, StrictPrivate为true时只接受Register过的
+type CloseCodeValidator struct {
+	StrictPrivate bool
+
+	mu      sync.RWMutex
+	private map[StatusCode]struct{}
+}
 
+// 默认的校验, 不配置时收发都用它
+var DefaultCloseCodeValidator = &CloseCodeValidator{}
+
+// 注册应用自己的关闭码, 只能是4000-4999
+func (v *CloseCodeValidator) Register(codes ...StatusCode) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, code := range codes {
+		if code < 4000 || code > 4999 {
+			return ErrCloseValue
+		}
+		if v.private == nil {
+			v.private = make(map[StatusCode]struct{})
+		}
+		v.private[code] = struct{}{}
+	}
+	return nil
+}
+
+func (v *CloseCodeValidator) Valid(code StatusCode) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1014:
+		return true
+	case code >= 3000 && code <= 3999:
+		return true
+	case code >= 4000 && code <= 4999:
+		if !v.StrictPrivate {
+			return true
+		}
+		v.mu.RLock()
+		_, ok := v.private[code]
+		v.mu.RUnlock()
+		return ok
+	}
 	return false
 }
+
+// 检查close帧的payload, 没有payload是合法的, 有的话至少要有2个字节的关闭码
+func (v *CloseCodeValidator) checkPayload(payload []byte) error {
+	if len(payload) == 0 {
+		return nil
+	}
+
+	if len(payload) < 2 {
+		return ErrClosePayloadTooSmall
+	}
+
+	if !v.Valid(StatusCode(binary.BigEndian.Uint16(payload))) {
+		return ErrCloseValue
+	}
+	return nil
+}
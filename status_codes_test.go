@@ -0,0 +1,96 @@
+package greatws
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func Test_CloseCodeValidator(t *testing.T) {
+	v := &CloseCodeValidator{}
+	for _, code := range []StatusCode{1000, 1003, 1007, 1014, 3000, 3999, 4000, 4999} {
+		if !v.Valid(code) {
+			t.Errorf("%d should be valid", code)
+		}
+	}
+	for _, code := range []StatusCode{0, 999, 1004, 1005, 1006, 1015, 1016, 2999, 5000} {
+		if v.Valid(code) {
+			t.Errorf("%d should be invalid", code)
+		}
+	}
+
+	v.StrictPrivate = true
+	if err := v.Register(4001); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Register(3001); err != ErrCloseValue {
+		t.Fatalf("Register(3001) = %v", err)
+	}
+	if !v.Valid(4001) || v.Valid(4002) {
+		t.Fatal("strict private codes")
+	}
+
+	if err := v.checkPayload([]byte{0x03}); err != ErrClosePayloadTooSmall {
+		t.Fatalf("checkPayload = %v", err)
+	}
+	if err := v.checkPayload(statusCodeToBytes(1006)); err != ErrCloseValue {
+		t.Fatalf("checkPayload = %v", err)
+	}
+}
+
+func Test_TruncateCloseReason(t *testing.T) {
+	for _, tc := range []struct {
+		reason string
+		n      int
+	}{
+		{"bye", 3},
+		{strings.Repeat("a", 123), 123},
+		{strings.Repeat("a", 200), 123},
+		// 3字节的utf8字符, 41个正好123字节, 42个截断之后不能留下半个字符
+		{strings.Repeat("中", 42), 123},
+		{"a" + strings.Repeat("中", 41), 121},
+	} {
+		got := truncateCloseReason(tc.reason)
+		if len(got) != tc.n || !utf8.ValidString(got) {
+			t.Fatalf("len = %d, want %d, valid = %t", len(got), tc.n, utf8.ValidString(got))
+		}
+		if p := FormatCloseMessage(EndpointGoingAway, tc.reason); len(p) > maxControlFrameSize {
+			t.Fatalf("payload len = %d", len(p))
+		}
+	}
+}
+
+func Test_FormatParseCloseMessage(t *testing.T) {
+	p := FormatCloseMessage(NormalClosure, "bye")
+	if want := []byte{0x03, 0xe8, 'b', 'y', 'e'}; string(p) != string(want) {
+		t.Fatalf("payload = % x", p)
+	}
+
+	code, reason, err := ParseCloseMessage(p)
+	if err != nil || code != NormalClosure || reason != "bye" {
+		t.Fatalf("code = %d, reason = %q, err = %v", code, reason, err)
+	}
+
+	// 只有关闭码, 没有reason
+	code, reason, err = ParseCloseMessage(FormatCloseMessage(4000, ""))
+	if err != nil || code != 4000 || reason != "" {
+		t.Fatalf("code = %d, reason = %q, err = %v", code, reason, err)
+	}
+
+	if code, _, err = ParseCloseMessage(nil); err != nil || code != 0 {
+		t.Fatalf("empty: code = %d, err = %v", code, err)
+	}
+
+	for _, tc := range []struct {
+		payload []byte
+		err     error
+	}{
+		{[]byte{0x03}, ErrClosePayloadTooSmall},
+		{[]byte{0x03, 0xed}, ErrCloseValue}, // 1005不能出现在close帧里
+		{[]byte{0x03, 0xe8, 0xff, 0xfe}, ErrTextNotUTF8},
+	} {
+		if _, _, err := ParseCloseMessage(tc.payload); err != tc.err {
+			t.Fatalf("payload = % x, err = %v, want %v", tc.payload, err, tc.err)
+		}
+	}
+}
@@ -1,6 +1,10 @@
+//go:build !js
+// +build !js
+
 package greatws
 
 import (
+	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -8,7 +12,10 @@ import (
 var exitFunc = func() bool { return true }
 
 type task struct {
-	c chan func() bool
+	c    chan func() bool
+	done chan struct{}  // 关闭之后所有的go程退出
+	wg   sync.WaitGroup // 等待所有的go程退出
+	once sync.Once
 
 	initCount int   // 初始化的协程数
 	min       int   // 最小协程数
@@ -19,8 +26,24 @@ type task struct {
 
 func (t *task) init() {
 	t.c = make(chan func() bool)
-	go t.manageGo()
-	go t.runConsumerLoop()
+	t.done = make(chan struct{})
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		t.manageGo()
+	}()
+	t.runConsumerLoop()
+}
+
+// 停止所有的go程, 之后添加的任务直接丢弃
+func (t *task) stop() {
+	t.once.Do(func() {
+		close(t.done)
+	})
+}
+
+func (t *task) wait() {
+	t.wg.Wait()
 }
 
 func (t *task) getCurTask() int64 {
@@ -29,7 +52,14 @@ func (t *task) getCurTask() int64 {
 
 // 消费者循环
 func (t *task) consumer() {
-	for f := range t.c {
+	for {
+		var f func() bool
+		select {
+		case f = <-t.c:
+		case <-t.done:
+			return
+		}
+
 		atomic.AddInt64(&t.curTask, 1)
 		if b := f(); b {
 			atomic.AddInt64(&t.curTask, -1)
@@ -41,12 +71,17 @@ func (t *task) consumer() {
 
 // 新增任务
 func (t *task) addTask(f func() bool) {
-	t.c <- f
+	select {
+	case t.c <- f:
+	case <-t.done:
+	}
 }
 
 // 新增go程
 func (t *task) addGo() {
+	t.wg.Add(1)
 	go func() {
+		defer t.wg.Done()
 		atomic.AddInt64(&t.curGo, 1)
 		defer atomic.AddInt64(&t.curGo, -1)
 		t.consumer()
@@ -56,15 +91,21 @@ func (t *task) addGo() {
 // 取消go程
 func (t *task) cancelGo() {
 	if atomic.LoadInt64(&t.curGo) > int64(t.min) {
-		t.c <- exitFunc
+		t.addTask(exitFunc)
 	}
 }
 
 // 管理go程
 func (t *task) manageGo() {
+	tk := time.NewTicker(time.Second * 5)
+	defer tk.Stop()
 	for {
+		select {
+		case <-tk.C:
+		case <-t.done:
+			return
+		}
 
-		time.Sleep(time.Second * 5)
 		curTask := atomic.LoadInt64(&t.curTask)
 		curGo := atomic.LoadInt64(&t.curGo)
 
@@ -78,7 +119,11 @@ func (t *task) manageGo() {
 
 // 运行任务
 func (t *task) runConsumerLoop() {
+	t.wg.Add(t.initCount)
 	for i := 0; i < t.initCount; i++ {
-		go t.consumer()
+		go func() {
+			defer t.wg.Done()
+			t.consumer()
+		}()
 	}
 }
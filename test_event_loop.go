@@ -0,0 +1,100 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// 单线程, 按需驱动的事件循环, 用于测试超时, 背压, 分片等逻辑
+// 不启动事件循环和时间轮的go程, 调用Tick才poll一次, 调用Advance时间轮才前进,
+// 回调固定在事件循环里同步调用(WithCallbackInEventLoop), 出错关闭连接也放在同一次Tick里执行,
+// 测试不需要sleep, 结果可以复现
+// 只有一个事件循环, 使用epoll/kqueue, WithEventLoops, WithIoUring和WithMemoryBackend不生效
+// Tick和Advance不能并发调用; Start什么都不做
+type TestEventLoop struct {
+	*MultiEventLoop
+	el *EventLoop
+}
+
+// 创建TestEventLoop, 用法和NewMultiEventLoop一样, 得到的MultiEventLoop可以传给WithServerMultiEventLoop和WithClientMultiEventLoop
+func NewTestEventLoop(opts ...EvOption) (*TestEventLoop, error) {
+	opts = append(opts, func(m *MultiEventLoop) {
+		m.numLoops = 1
+		m.flag = EVENT_EPOLL
+		m.memory = false
+		m.callbackInLoop = true
+		m.manual = true
+	})
+	m, err := NewMultiEventLoop(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &TestEventLoop{MultiEventLoop: m, el: m.loops[0]}, nil
+}
+
+// poll一次, 不等待, 处理已经就绪的读写事件和投递的任务, 返回就绪的事件数(包括唤醒事件)
+func (t *TestEventLoop) Tick() (int, error) {
+	atomic.StoreInt64(&t.el.goid, curGoroutineID())
+	t.el.beginTick()
+	n, err := t.el.apiPoll(0)
+	if err != nil {
+		return n, err
+	}
+	t.el.runTasks()
+	return n, nil
+}
+
+// 一直Tick, 直到没有就绪的事件, 最多max次, 返回Tick的次数
+// 回调里写出去的数据, 对端在同一个TestEventLoop上时, 要再Tick一次才能读到
+func (t *TestEventLoop) Drain(max int) (int, error) {
+	for i := 0; i < max; i++ {
+		n, err := t.Tick()
+		if err != nil {
+			return i + 1, err
+		}
+		if n == 0 {
+			return i + 1, nil
+		}
+	}
+	return max, nil
+}
+
+// 时间轮前进d, 在当前go程里按顺序触发到期的定时器(deadline, 心跳, closeLinger, ExecuteAfter等), 然后Tick一次
+// 时间轮的精度是100ms, d向上取整; 定时器是按注册时的时长挂在时间轮上的, 和墙上时间无关
+func (t *TestEventLoop) Advance(d time.Duration) (int, error) {
+	w := t.wheel
+	ticks := int((d + w.interval - 1) / w.interval)
+	atomic.StoreInt64(&t.el.goid, curGoroutineID())
+	for i := 0; i < ticks; i++ {
+		w.tick()
+	}
+	return t.Tick()
+}
+
+// 直接关闭所有的连接, 不等关闭握手, 然后释放事件循环
+func (t *TestEventLoop) Close() error {
+	t.Range(func(c *Conn) bool {
+		c.Close()
+		return true
+	})
+	t.el.runTasks()
+	return t.Shutdown(context.Background())
+}
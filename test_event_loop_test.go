@@ -0,0 +1,165 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package greatws
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+type tickRecorder struct {
+	DefCallback
+	msgs   []string
+	closed int
+	err    error
+}
+
+func (r *tickRecorder) OnMessage(_ *Conn, _ Opcode, payload []byte) {
+	r.msgs = append(r.msgs, string(payload))
+}
+
+func (r *tickRecorder) OnClose(_ *Conn, err error) {
+	r.closed++
+	r.err = err
+}
+
+// 手动驱动的事件循环, 测试结束时关闭
+func newTestLoop(t *testing.T, opts ...EvOption) *TestEventLoop {
+	tl, err := NewTestEventLoop(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tl.Close() })
+	return tl
+}
+
+func Test_TestEventLoopFragment(t *testing.T) {
+	tl := newTestLoop(t)
+	r := &tickRecorder{}
+	_, remote := newTestConn(t, withTestLoop(tl), withTestCallback(r))
+
+	var first, last bytes.Buffer
+	appendClientFrame(t, &first, false, false, Text, "hello ")
+	appendClientFrame(t, &last, true, false, Continuation, "world")
+
+	remote.Write(first.Bytes())
+	if _, err := tl.Tick(); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.msgs) != 0 {
+		t.Fatalf("msgs = %q", r.msgs)
+	}
+
+	remote.Write(last.Bytes())
+	if _, err := tl.Tick(); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.msgs) != 1 || r.msgs[0] != "hello world" {
+		t.Fatalf("msgs = %q", r.msgs)
+	}
+
+	// 没有新数据, 不会再有回调
+	tl.Tick()
+	if len(r.msgs) != 1 {
+		t.Fatalf("msgs = %q", r.msgs)
+	}
+}
+
+func Test_TestEventLoopCloseLinger(t *testing.T) {
+	tl := newTestLoop(t)
+	r := &tickRecorder{}
+	c, _ := newTestConn(t, withTestLoop(tl), withTestCallback(r), withTestConfig(func(conf *Config) {
+		conf.closeLinger = 500 * time.Millisecond
+	}))
+
+	// 对端不回close帧, closeLinger之后才关闭
+	if err := c.WriteClose(NormalClosure, ""); err != nil {
+		t.Fatal(err)
+	}
+	tl.Advance(400 * time.Millisecond)
+	if r.closed != 0 {
+		t.Fatal("closed before linger")
+	}
+	tl.Advance(100 * time.Millisecond)
+	if r.closed != 1 || !errors.Is(r.err, ErrCloseTimeout) {
+		t.Fatalf("closed = %d, err = %v", r.closed, r.err)
+	}
+}
+
+func Test_TestEventLoopProtocolError(t *testing.T) {
+	tl := newTestLoop(t)
+	r := &tickRecorder{}
+	c, remote := newTestConn(t, withTestLoop(tl), withTestCallback(r))
+
+	// opcode 3是保留的, 出错之后在同一次Tick里关闭
+	remote.Write([]byte{0x83, 0x80, 0x01, 0x02, 0x03, 0x04})
+	tl.Tick()
+	if r.closed != 1 || r.err == nil || !c.isClosed() {
+		t.Fatalf("closed = %d, err = %v", r.closed, r.err)
+	}
+}
+
+func Test_TestEventLoopBackpressure(t *testing.T) {
+	tl := newTestLoop(t)
+	c, remote := newTestConn(t, withTestLoop(tl), withTestConfig(func(conf *Config) {
+		conf.writeBufferSize = 64 * 1024
+	}))
+
+	// 对端不读, 写缓冲区积压到上限
+	payload := make([]byte, 1024)
+	var err error
+	for i := 0; i < 10000 && err == nil; i++ {
+		err = c.WriteMessage(Binary, payload)
+	}
+	if !errors.Is(err, ErrWriteBufferFull) {
+		t.Fatalf("err = %v", err)
+	}
+
+	// 对端读一点, 事件循环刷一点, 直到积压的数据全部写完
+	buf := make([]byte, 64*1024)
+	for i := 0; c.pendingWriteLen() > 0; i++ {
+		if i > 1000 {
+			t.Fatalf("pending = %d", c.pendingWriteLen())
+		}
+		remote.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := remote.Read(buf); err != nil {
+			t.Fatal(err)
+		}
+		tl.Tick()
+	}
+	if err := c.WriteMessage(Binary, payload); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_TestEventLoopExecute(t *testing.T) {
+	tl, err := NewTestEventLoop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tl.Close()
+	tl.Start()
+
+	el := tl.loops[0]
+	var got []int
+	el.Execute(func() { got = append(got, 1) })
+	el.ExecuteAfter(time.Second, func() { got = append(got, 2) })
+	if len(got) != 0 {
+		t.Fatal("task ran without Tick")
+	}
+	tl.Tick()
+	if len(got) != 1 {
+		t.Fatalf("got = %v", got)
+	}
+	tl.Advance(900 * time.Millisecond)
+	if len(got) != 1 {
+		t.Fatalf("got = %v", got)
+	}
+	tl.Advance(100 * time.Millisecond)
+	if len(got) != 2 || !el.InLoop() {
+		t.Fatalf("got = %v", got)
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultWheelInterval = 100 * time.Millisecond
+	defaultWheelSlots    = 512
+)
+
+// 时间轮, 海量连接的超时都挂在这上面, 避免每个连接一个runtime timer
+// 精度是一个interval
+type timingWheel struct {
+	mu       sync.Mutex
+	interval time.Duration
+	slots    [][]*wheelTimer
+	pos      int
+	runOnce  sync.Once
+	stopOnce sync.Once
+	done     chan struct{}
+	wg       sync.WaitGroup // 等待时间轮的go程退出
+	manual   bool           // 不启动go程, 由TestEventLoop.Advance调用tick
+}
+
+type wheelTimer struct {
+	rounds int   // 还需要转几圈
+	fired  int32 // 已经触发或者已经停止
+	f      func()
+}
+
+// 停止定时器, 如果定时器还没有触发, 返回true
+func (t *wheelTimer) Stop() bool {
+	return atomic.CompareAndSwapInt32(&t.fired, 0, 1)
+}
+
+func newTimingWheel(interval time.Duration, numSlots int) *timingWheel {
+	if interval <= 0 {
+		interval = defaultWheelInterval
+	}
+	if numSlots <= 0 {
+		numSlots = defaultWheelSlots
+	}
+	return &timingWheel{
+		interval: interval,
+		slots:    make([][]*wheelTimer, numSlots),
+		done:     make(chan struct{}),
+	}
+}
+
+// d之后在时间轮的go程里调用f, f里不要阻塞
+func (w *timingWheel) AfterFunc(d time.Duration, f func()) *wheelTimer {
+	w.runOnce.Do(func() {
+		if w.manual {
+			return
+		}
+		// 已经停止的时间轮不再启动go程
+		select {
+		case <-w.done:
+			return
+		default:
+		}
+		w.wg.Add(1)
+		go func() {
+			defer w.wg.Done()
+			w.run()
+		}()
+	})
+
+	ticks := int((d + w.interval - 1) / w.interval)
+	if ticks <= 0 {
+		ticks = 1
+	}
+
+	t := &wheelTimer{f: f}
+	w.mu.Lock()
+	t.rounds = (ticks - 1) / len(w.slots)
+	index := (w.pos + ticks) % len(w.slots)
+	w.slots[index] = append(w.slots[index], t)
+	w.mu.Unlock()
+	return t
+}
+
+func (w *timingWheel) run() {
+	tk := time.NewTicker(w.interval)
+	defer tk.Stop()
+	for {
+		select {
+		case <-tk.C:
+			w.tick()
+		case <-w.done:
+			return
+		}
+	}
+}
+
+func (w *timingWheel) tick() {
+	var expired []*wheelTimer
+
+	w.mu.Lock()
+	w.pos = (w.pos + 1) % len(w.slots)
+	slot := w.slots[w.pos]
+	keep := slot[:0]
+	for _, t := range slot {
+		if atomic.LoadInt32(&t.fired) == 1 {
+			continue
+		}
+		if t.rounds > 0 {
+			t.rounds--
+			keep = append(keep, t)
+			continue
+		}
+		expired = append(expired, t)
+	}
+	for i := len(keep); i < len(slot); i++ {
+		slot[i] = nil
+	}
+	w.slots[w.pos] = keep
+	w.mu.Unlock()
+
+	for _, t := range expired {
+		if t.Stop() {
+			t.f()
+		}
+	}
+}
+
+func (w *timingWheel) stop() {
+	w.stopOnce.Do(func() {
+		close(w.done)
+	})
+}
+
+func (w *timingWheel) wait() {
+	w.wg.Wait()
+}
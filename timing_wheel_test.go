@@ -0,0 +1,45 @@
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_TimingWheel(t *testing.T) {
+	w := newTimingWheel(5*time.Millisecond, 4)
+	defer w.stop()
+
+	var fired, stopped int32
+	done := make(chan time.Duration, 1)
+	begin := time.Now()
+	// 超过一圈, 需要多转几轮
+	w.AfterFunc(50*time.Millisecond, func() {
+		atomic.AddInt32(&fired, 1)
+		done <- time.Since(begin)
+	})
+
+	tm := w.AfterFunc(10*time.Millisecond, func() {
+		atomic.AddInt32(&stopped, 1)
+	})
+	if !tm.Stop() {
+		t.Fatal("Stop should return true before fire")
+	}
+
+	select {
+	case d := <-done:
+		if d < 45*time.Millisecond {
+			t.Fatalf("fired too early: %v", d)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timer not fired")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if atomic.LoadInt32(&fired) != 1 || atomic.LoadInt32(&stopped) != 0 {
+		t.Fatalf("fired = %d, stopped = %d", fired, stopped)
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+)
+
+// 按tls握手的结果(SNI里的域名, ALPN协商的协议)给这个连接追加服务端选项, 返回nil表示使用默认配置
+// 一个listener上的不同域名可以用不同的Callback, 中间件, 压缩, 子协议等配置(多租户网关)
+// 返回的选项追加在原来的选项后面, 相同的配置以返回的为准; 要拒绝连接可以返回WithServerOnAccept
+// 每个tls连接握手时调用一次, 不是tls的连接不调用
+type TLSRouteFunc func(cs *tls.ConnectionState) []ServerOption
+
+// 取conn的tls握手结果, conn是*tls.Conn时以它为准(UpgradeConn), 否则用http.Server填的r.TLS
+func tlsConnectionState(conn net.Conn, r *http.Request) *tls.ConnectionState {
+	if tc, ok := conn.(*tls.Conn); ok {
+		cs := tc.ConnectionState()
+		return &cs
+	}
+	return r.TLS
+}
+
+// Upgrade里只有http2的extended CONNECT用得上tls路由
+// http/1.1的wss劫持出来的是*tls.Conn, 会返回ErrTLSNotSupported, 不调用路由
+func upgradeTLSState(r *http.Request) *tls.ConnectionState {
+	if isHTTP2Upgrade(r) {
+		return r.TLS
+	}
+	return nil
+}
+
+// 配置了tlsRoute并且返回了选项时, 用opts加上返回的选项重新生成一份配置
+// 中间件和回调的包装都在initCallback里, 不能在已经包装过的配置上直接追加, 所以从头生成
+// 不是tls连接或者不需要路由时返回c
+func (c *Config) routeTLS(cs *tls.ConnectionState, opts []ServerOption) *Config {
+	if c.tlsRoute == nil || cs == nil {
+		return c
+	}
+	extra := c.tlsRoute(cs)
+	if len(extra) == 0 {
+		return c
+	}
+
+	var conf ConnOption
+	conf.defaultSetting()
+	for _, o := range opts {
+		o(&conf)
+	}
+	for _, o := range extra {
+		o(&conf)
+	}
+	conf.initCallback()
+	return &conf.Config
+}
@@ -0,0 +1,142 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package greatws
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 一个listener上按SNI选回调: b.example.com的回复带上前缀, 其他域名原样返回
+// 走UpgradeConn传入*tls.Conn的路径
+func Test_TLSRouteBySNI(t *testing.T) {
+	m := NewMultiEventLoopMust(WithEventLoops(1))
+	m.Start()
+	t.Cleanup(func() { shutdownTestLoop(m) })
+
+	var alpn = make(chan string, 4)
+	route := WithServerTLSRoute(func(cs *tls.ConnectionState) []ServerOption {
+		alpn <- cs.NegotiatedProtocol
+		if cs.ServerName != "b.example.com" {
+			return nil
+		}
+		return []ServerOption{WithServerOnMessageFunc(func(c *Conn, op Opcode, payload []byte) {
+			c.WriteMessage(op, append([]byte("b:"), payload...))
+		})}
+	})
+	addr := newTLSEchoServer(t, m, nil, route)
+
+	for _, tc := range []struct {
+		host string
+		want string
+	}{
+		{"a.example.com", "hello"},
+		{"b.example.com", "b:hello"},
+	} {
+		t.Run(tc.host, func(t *testing.T) {
+			raw, err := net.Dial("tcp", addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn := tls.Client(raw, &tls.Config{ServerName: tc.host, InsecureSkipVerify: true, NextProtos: []string{"http/1.1"}})
+
+			got := make(chan string, 1)
+			c, err := ClientFromConn(conn, "ws://"+tc.host+"/", WithClientMultiEventLoop(m),
+				WithClientOnMessageFunc(func(c *Conn, op Opcode, payload []byte) {
+					got <- string(payload)
+				}))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer c.Close()
+
+			if p := <-alpn; p != "http/1.1" {
+				t.Fatalf("alpn = %q", p)
+			}
+			if err := c.WriteMessage(Text, []byte("hello")); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case s := <-got:
+				if s != tc.want {
+					t.Fatalf("got %q, want %q", s, tc.want)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("echo timeout")
+			}
+		})
+	}
+}
+
+// 不是tls连接, 或者没有配置路由时, 用原来的配置
+func Test_TLSRouteSkipped(t *testing.T) {
+	var conf ConnOption
+	conf.defaultSetting()
+	if got := conf.routeTLS(&tls.ConnectionState{ServerName: "a"}, nil); got != &conf.Config {
+		t.Fatal("no route should keep the config")
+	}
+
+	called := false
+	WithServerTLSRoute(func(*tls.ConnectionState) []ServerOption {
+		called = true
+		return []ServerOption{WithServerReplyPing()}
+	})(&conf)
+	if got := conf.routeTLS(nil, nil); got != &conf.Config || called {
+		t.Fatal("plain conn should not be routed")
+	}
+	m := NewMultiEventLoopMust(WithEventLoops(1))
+	t.Cleanup(func() { shutdownTestLoop(m) })
+	opts := []ServerOption{WithServerMultiEventLoop(m)}
+	if got := conf.routeTLS(&tls.ConnectionState{}, opts); got == &conf.Config || !got.replyPing || !called {
+		t.Fatal("route options not applied")
+	}
+}
+
+// http/1.1的wss用Upgrade拿不到fd, 返回ErrTLSNotSupported, 不调用路由
+func Test_TLSRouteUpgradeNotSupported(t *testing.T) {
+	m := NewMultiEventLoopMust(WithEventLoops(1))
+	m.Start()
+	t.Cleanup(func() { shutdownTestLoop(m) })
+
+	var called atomic.Bool
+	errs := make(chan error, 1)
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := Upgrade(w, r, WithServerMultiEventLoop(m), WithServerTLSRoute(func(*tls.ConnectionState) []ServerOption {
+			called.Store(true)
+			return nil
+		}))
+		errs <- err
+	}))
+	defer ts.Close()
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if rsp, err := ts.Client().Do(req); err == nil {
+		rsp.Body.Close()
+	}
+
+	select {
+	case err := <-errs:
+		if !errors.Is(err, ErrTLSNotSupported) {
+			t.Fatalf("err = %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("upgrade timeout")
+	}
+	if called.Load() {
+		t.Fatal("route called on http/1.1 wss")
+	}
+}
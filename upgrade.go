@@ -12,11 +12,14 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+//go:build !js
+// +build !js
+
 package greatws
 
 import (
 	"bytes"
-	"errors"
+	"crypto/tls"
 	"net"
 	"net/http"
 	"syscall"
@@ -28,6 +31,7 @@ import (
 
 type UpgradeServer struct {
 	config Config
+	opts   []ServerOption // 按tls路由时在这些选项的基础上生成配置
 }
 
 func NewUpgrade(opts ...ServerOption) *UpgradeServer {
@@ -36,12 +40,14 @@ func NewUpgrade(opts ...ServerOption) *UpgradeServer {
 	for _, o := range opts {
 		o(&conf)
 	}
-	conf.Callback = newGoCallback(conf.Callback, &conf.multiEventLoop.t)
-	return &UpgradeServer{config: conf.Config}
+	conf.initCallback()
+	return &UpgradeServer{config: conf.Config, opts: opts}
 }
 
 func (u *UpgradeServer) Upgrade(w http.ResponseWriter, r *http.Request) (c *Conn, err error) {
-	return upgradeInner(w, r, &u.config)
+	// 握手会修改压缩相关的配置, 每个连接用一份拷贝
+	conf := u.config
+	return upgradeInner(w, r, conf.routeTLS(upgradeTLSState(r), u.opts))
 }
 
 func Upgrade(w http.ResponseWriter, r *http.Request, opts ...ServerOption) (c *Conn, err error) {
@@ -50,24 +56,37 @@ func Upgrade(w http.ResponseWriter, r *http.Request, opts ...ServerOption) (c *C
 	for _, o := range opts {
 		o(&conf)
 	}
-	conf.Callback = newGoCallback(conf.Callback, &conf.Config.multiEventLoop.t)
-	return upgradeInner(w, r, &conf.Config)
+	conf.initCallback()
+	return upgradeInner(w, r, conf.routeTLS(upgradeTLSState(r), opts))
 }
 
-func getFdFromConn(c net.Conn) (newFd int, err error) {
+// 在f里使用c的fd, fd只在f运行期间有效
+func controlConn(c net.Conn, f func(fd int)) error {
 	sc, ok := c.(interface {
 		SyscallConn() (syscall.RawConn, error)
 	})
 	if !ok {
-		return 0, errors.New("RawConn Unsupported")
+		return ErrRawConnUnsupported
 	}
 	rc, err := sc.SyscallConn()
 	if err != nil {
-		return 0, errors.New("RawConn Unsupported")
+		return ErrRawConnUnsupported
+	}
+
+	return rc.Control(func(fd uintptr) {
+		f(int(fd))
+	})
+}
+
+// 事件循环直接读写fd, tls.Conn的数据要经过它加解密, 拿到fd也用不了
+// 在事件循环里支持tls之前(到时候写路径还要把多个小帧合并成一个tls record), wss直接返回ErrTLSNotSupported
+func getFdFromConn(c net.Conn) (newFd int, err error) {
+	if _, ok := c.(*tls.Conn); ok {
+		return 0, ErrTLSNotSupported
 	}
 
-	err = rc.Control(func(fd uintptr) {
-		newFd = int(fd)
+	err = controlConn(c, func(fd int) {
+		newFd = fd
 	})
 	if err != nil {
 		return 0, err
@@ -77,9 +96,25 @@ func getFdFromConn(c net.Conn) (newFd int, err error) {
 }
 
 func upgradeInner(w http.ResponseWriter, r *http.Request, conf *Config) (c *Conn, err error) {
+	if err := conf.Validate(); err != nil {
+		return nil, conf.reject(w, r, http.StatusInternalServerError, err)
+	}
+
+	deadline := conf.handshakeLimits.deadline(r)
+	if ecode, err := conf.handshakeLimits.check(r, deadline); err != nil {
+		return nil, conf.reject(w, r, ecode, err)
+	}
+
+	if err := conf.checkOriginAllowed(r); err != nil {
+		return nil, conf.reject(w, r, http.StatusForbidden, err)
+	}
+
+	if isHTTP2Upgrade(r) {
+		return upgradeHTTP2(w, r, conf)
+	}
+
 	if ecode, err := checkRequest(r); err != nil {
-		http.Error(w, err.Error(), ecode)
-		return nil, err
+		return nil, conf.reject(w, r, ecode, err)
 	}
 
 	hi, ok := w.(http.Hijacker)
@@ -97,10 +132,36 @@ func upgradeInner(w http.ResponseWriter, r *http.Request, conf *Config) (c *Conn
 		bufio2.ClearReadWriter(rw)
 	}
 
+	return upgradeNetConn(conn, r, conf, deadline, false)
+}
+
+// 已经检查过请求, 在conn上回101, 然后把连接加入事件循环
+// conn拿不到fd的时候(比如tls.Conn, net.Pipe), bridge为true就通过socketpair转发, 否则返回错误
+// 出错时关闭conn
+func upgradeNetConn(conn net.Conn, r *http.Request, conf *Config, deadline time.Time, bridge bool) (c *Conn, err error) {
+	defer func() {
+		if err != nil {
+			conn.Close()
+		}
+	}()
+
+	// 对端不读响应的话, 写101也会卡住, 同样算在握手的时间里
+	if !deadline.IsZero() {
+		if err = conn.SetDeadline(deadline); err != nil {
+			return nil, err
+		}
+	}
+
+	session, err := conf.accept(conn)
+	if err != nil {
+		return nil, err
+	}
+
 	// 是否打开解压缩
 	// 外层接收压缩, 并且客户端发送扩展过来
 	if conf.decompression {
-		conf.decompression = needDecompression(r.Header)
+		conf.deflate, conf.decompression = negotiateDeflate(r.Header, conf.takeoverWindowBits)
+		conf.compression = conf.compression && conf.decompression
 	}
 
 	buf := bytespool.GetUpgradeRespBytes()
@@ -122,20 +183,44 @@ func upgradeInner(w http.ResponseWriter, r *http.Request, conf *Config) (c *Conn
 		return nil, err
 	}
 
+	localAddr, remoteAddr := conn.LocalAddr(), conn.RemoteAddr()
+	var remote net.Conn
 	fd, err := getFdFromConn(conn)
 	if err != nil {
-		conn.Close()
-		return nil, err
-	}
-	// 已经dup了一份fd，所以这里可以关闭
-	if err = conn.Close(); err != nil {
-		return nil, err
+		if !bridge {
+			return nil, err
+		}
+		// 拿不到fd, 事件循环读写socketpair的一端, 另一端和conn互相拷贝
+		if fd, remote, err = newSocketPair(); err != nil {
+			return nil, err
+		}
+	} else {
+		if err = setSocketOptions(fd, conf.tcpNoDelay, &conf.socketOptions); err != nil {
+			closeFd(fd)
+			return nil, err
+		}
+		// 已经dup了一份fd，所以这里可以关闭
+		if err = conn.Close(); err != nil {
+			return nil, err
+		}
 	}
 
 	c = newConn(int64(fd), false, conf)
+	c.session = session
+	c.localAddr, c.remoteAddr = localAddr, remoteAddr
 	if err = conf.multiEventLoop.add(c); err != nil {
+		// 转发还没有开始, 关闭fd和socketpair的另一端, conn在defer里关闭
+		closeFd(fd)
+		if remote != nil {
+			remote.Close()
+		}
 		return nil, err
 	}
+	if remote != nil {
+		bridgeStream(remote, conn, conn)
+	}
+	c.startTick()
+	conf.Callback.OnOpen(c)
 
 	// fmt.Printf("new fd = %d, %p\n", fd, c)
 
@@ -0,0 +1,116 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// 在调用方自己accept的连接上升级, 比如自定义的listener, quic的桥接, 测试里的net.Pipe
+// r是调用方从conn上读出来的握手请求(比如http.ReadRequest), conn上不能有已经读进缓冲区但还没处理的数据
+// conn能拿到fd(比如*net.TCPConn)的时候dup一份直接加入事件循环, 拿不到的通过socketpair转发
+// 握手被拒绝时在conn上回http错误, 失败时conn会被关闭; 成功之后conn归Conn所有, 调用方不要再读写
+// 不支持http2的extended CONNECT, 请求必须是http/1.1的GET
+func UpgradeConn(conn net.Conn, r *http.Request, opts ...ServerOption) (c *Conn, err error) {
+	var conf ConnOption
+	conf.defaultSetting()
+	for _, o := range opts {
+		o(&conf)
+	}
+	conf.initCallback()
+	return upgradeConnInner(conn, r, conf.routeTLS(tlsConnectionState(conn, r), opts))
+}
+
+func upgradeConnInner(conn net.Conn, r *http.Request, conf *Config) (c *Conn, err error) {
+	w := newConnResponseWriter(conn)
+	reject := func(status int, err error) error {
+		conf.reject(w, r, status, err)
+		w.flush()
+		conn.Close()
+		return err
+	}
+
+	if err := conf.Validate(); err != nil {
+		return nil, reject(http.StatusInternalServerError, err)
+	}
+
+	deadline := conf.handshakeLimits.deadline(r)
+	if ecode, err := conf.handshakeLimits.check(r, deadline); err != nil {
+		return nil, reject(ecode, err)
+	}
+
+	if err := conf.checkOriginAllowed(r); err != nil {
+		return nil, reject(http.StatusForbidden, err)
+	}
+
+	if ecode, err := checkRequest(r); err != nil {
+		return nil, reject(ecode, err)
+	}
+
+	return upgradeNetConn(conn, r, conf, deadline, true)
+}
+
+// 拒绝握手时给RejectFunc用的http.ResponseWriter, 直接写到conn上
+// 响应不带Content-Length, 用Connection: close表示body的结束, 写完之后关闭conn
+type connResponseWriter struct {
+	bw          *bufio.Writer
+	header      http.Header
+	wroteHeader bool
+}
+
+func newConnResponseWriter(conn net.Conn) *connResponseWriter {
+	return &connResponseWriter{bw: bufio.NewWriter(conn), header: make(http.Header)}
+}
+
+func (w *connResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *connResponseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	w.header.Set("Connection", "close")
+	w.header.Del("Content-Length")
+	w.bw.WriteString("HTTP/1.1 ")
+	w.bw.WriteString(strconv.Itoa(status))
+	w.bw.WriteString(" ")
+	w.bw.WriteString(http.StatusText(status))
+	w.bw.WriteString("\r\n")
+	w.header.Write(w.bw)
+	w.bw.WriteString("\r\n")
+}
+
+func (w *connResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.bw.Write(b)
+}
+
+func (w *connResponseWriter) flush() error {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.bw.Flush()
+}
@@ -0,0 +1,148 @@
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_UpgradeConn(t *testing.T) {
+	m := NewMultiEventLoopMust(WithEventLoops(1))
+	m.Start()
+
+	echo := WithServerOnMessageFunc(func(c *Conn, op Opcode, payload []byte) {
+		c.WriteMessage(op, payload)
+	})
+
+	// 调用方自己读请求, 再交给UpgradeConn
+	serve := func(conn net.Conn, opts ...ServerOption) {
+		r, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil {
+			t.Error(err)
+			conn.Close()
+			return
+		}
+		UpgradeConn(conn, r, append([]ServerOption{WithServerMultiEventLoop(m), echo}, opts...)...)
+	}
+
+	roundTrip := func(t *testing.T, conn net.Conn) {
+		got := make(chan string, 1)
+		c, err := ClientFromConn(conn, "ws://example.com/", WithClientMultiEventLoop(m),
+			WithClientOnMessageFunc(func(c *Conn, op Opcode, payload []byte) {
+				got <- string(payload)
+			}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+
+		if err := c.WriteMessage(Text, []byte("hello")); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case s := <-got:
+			if s != "hello" {
+				t.Fatalf("got %q", s)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("echo timeout")
+		}
+	}
+
+	t.Run("pipe", func(t *testing.T) {
+		a, b := net.Pipe()
+		go serve(b)
+		roundTrip(t, a)
+	})
+
+	t.Run("tcp", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ln.Close()
+		go func() {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			serve(conn)
+		}()
+
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		roundTrip(t, conn)
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		a, b := net.Pipe()
+		defer a.Close()
+		go serve(b)
+
+		req, _ := http.NewRequest("GET", "http://example.com/", nil)
+		req.Header.Set("Upgrade", "websocket")
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		req.Header.Set("Sec-WebSocket-Version", "8")
+		go req.Write(a)
+
+		rsp, err := http.ReadResponse(bufio.NewReader(a), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rsp.Body.Close()
+		if rsp.StatusCode != http.StatusUpgradeRequired || rsp.Header.Get("Sec-WebSocket-Version") != "13" {
+			t.Fatalf("status = %d, header = %v", rsp.StatusCode, rsp.Header)
+		}
+		var body strings.Builder
+		bufio.NewReader(rsp.Body).WriteTo(&body)
+		if body.Len() == 0 {
+			t.Fatal("empty body")
+		}
+	})
+}
+
+// 加入事件循环失败时, socketpair的两端和conn都要关闭
+func Test_UpgradeConnAddError(t *testing.T) {
+	countFds := func() int {
+		fds, err := os.ReadDir("/proc/self/fd")
+		if err != nil {
+			t.Skip("/proc/self/fd:", err)
+		}
+		return len(fds)
+	}
+
+	// 内存后端的add一定失败
+	m := NewMultiEventLoopMust(WithMemoryBackend())
+	a, b := net.Pipe()
+	defer a.Close()
+	go io.Copy(io.Discard, a)
+
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+
+	before := countFds()
+	if _, err := UpgradeConn(b, req, WithServerMultiEventLoop(m)); !errors.Is(err, ErrMemoryBackend) {
+		t.Fatalf("err = %v", err)
+	}
+	if after := countFds(); after > before {
+		t.Fatalf("fds = %d, before = %d", after, before)
+	}
+	if _, err := b.Write([]byte("x")); !errors.Is(err, io.ErrClosedPipe) {
+		t.Fatalf("conn not closed: %v", err)
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+package greatws
+
+import "net/http"
+
+// 服务端在握手的响应(http1是101, http2是200)里加的http头, 每次握手调用一次
+// 可以根据请求返回不同的值, 比如链路追踪的id, Set-Cookie, 返回nil表示不加
+// 返回了Sec-WebSocket-Protocol的话, 代替库自己协商的子协议
+type UpgradeResponseHeaderFunc func(r *http.Request) http.Header
+
+// 握手必须的头由库自己写, 用户配置的会被忽略
+var reservedUpgradeRespHeaders = []string{
+	"Upgrade",
+	"Connection",
+	"Sec-Websocket-Accept",
+	"Sec-Websocket-Extensions",
+}
+
+// 合并所有配置的头, 去掉保留的
+func (c *Config) upgradeResponseHeader(r *http.Request) http.Header {
+	if len(c.upgradeRespHeaders) == 0 {
+		return nil
+	}
+
+	h := make(http.Header)
+	for _, f := range c.upgradeRespHeaders {
+		for k, v := range f(r) {
+			k = http.CanonicalHeaderKey(k)
+			h[k] = append(h[k], v...)
+		}
+	}
+	for _, k := range reservedUpgradeRespHeaders {
+		delete(h, k)
+	}
+	return h
+}
@@ -0,0 +1,55 @@
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func Test_UpgradeResponseHeader(t *testing.T) {
+	m := NewMultiEventLoopMust(WithEventLoops(1))
+	m.Start()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := Upgrade(w, r, WithServerMultiEventLoop(m),
+			WithServerUpgradeResponseHeader(http.Header{
+				"Set-Cookie": {"session=abc"},
+				"Connection": {"close"}, // 保留的头, 会被忽略
+			}),
+			WithServerUpgradeResponseHeaderFunc(func(r *http.Request) http.Header {
+				return http.Header{
+					"X-Trace-Id":             {r.Header.Get("X-Trace-Id")},
+					"Sec-Websocket-Protocol": {"chat"},
+				}
+			}))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		c.Close()
+	}))
+	defer ts.Close()
+
+	var resp http.Header
+	h := http.Header{"X-Trace-Id": {"trace-1"}, "Sec-Websocket-Protocol": {"other"}}
+	c, err := Dial("ws://"+strings.TrimPrefix(ts.URL, "http://"),
+		WithClientMultiEventLoop(m), WithClientHTTPHeader(h), WithClientBindHTTPHeader(&resp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if resp.Get("Set-Cookie") != "session=abc" || resp.Get("X-Trace-Id") != "trace-1" {
+		t.Fatalf("resp = %v", resp)
+	}
+	if v := resp.Values("Sec-Websocket-Protocol"); len(v) != 1 || v[0] != "chat" {
+		t.Fatalf("protocol = %v", v)
+	}
+	if resp.Get("Connection") != "Upgrade" {
+		t.Fatalf("connection = %q", resp.Get("Connection"))
+	}
+}
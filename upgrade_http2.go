@@ -0,0 +1,108 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"net/http"
+	"strings"
+)
+
+// https://datatracker.ietf.org/doc/html/rfc8441#section-4
+// http2的extended CONNECT请求, :protocol的值是websocket
+func isHTTP2Upgrade(r *http.Request) bool {
+	return r.ProtoMajor == 2 && r.Method == http.MethodConnect &&
+		strings.EqualFold(r.Header.Get(":protocol"), "websocket")
+}
+
+// http2 stream的写入端, 每次写完都要flush, 不然数据会留在缓冲区里
+type http2StreamWriter struct {
+	w http.ResponseWriter
+	f http.Flusher
+}
+
+func (h *http2StreamWriter) Write(p []byte) (n int, err error) {
+	n, err = h.w.Write(p)
+	h.f.Flush()
+	return n, err
+}
+
+// handler返回之后stream才会结束, 这里什么都不用做
+func (h *http2StreamWriter) Close() error {
+	return nil
+}
+
+// http2模式下, stream的生命周期和handler绑定, handler返回stream就结束了
+// 所以Upgrade会一直阻塞到连接关闭才返回, 需要在OnOpen里拿到*Conn
+// 使用net/http的http2 server时, 需要设置GODEBUG=http2xconnect=1才会开启extended CONNECT
+func upgradeHTTP2(w http.ResponseWriter, r *http.Request, conf *Config) (c *Conn, err error) {
+	// rfc 8441 没有Sec-WebSocket-Key, 只需要检查版本
+	if ecode, err := checkVersion(r); err != nil {
+		return nil, conf.reject(w, r, ecode, err)
+	}
+
+	f, ok := w.(http.Flusher)
+	if !ok {
+		return nil, ErrNotFoundFlusher
+	}
+
+	if conf.decompression {
+		conf.deflate, conf.decompression = negotiateDeflate(r.Header, conf.takeoverWindowBits)
+		conf.compression = conf.compression && conf.decompression
+	}
+
+	if conf.decompression {
+		w.Header().Set("Sec-WebSocket-Extensions", conf.deflate.String())
+	}
+
+	if v := subProtocol(r.Header.Get(strGetSecWebSocketProtocolKey), conf); len(v) > 0 {
+		w.Header().Set(strGetSecWebSocketProtocolKey, v)
+	}
+
+	for k, v := range conf.upgradeResponseHeader(r) {
+		w.Header()[k] = v
+	}
+
+	session, err := conf.acceptHTTP2(w, r)
+	if err != nil {
+		return nil, err
+	}
+
+	localFd, remote, err := newSocketPair()
+	if err != nil {
+		return nil, conf.reject(w, r, http.StatusInternalServerError, err)
+	}
+
+	c = newConn(int64(localFd), false, conf)
+	c.session = session
+	c.localAddr, c.remoteAddr = addrFromRequest(r)
+	if err = conf.multiEventLoop.add(c); err != nil {
+		// 没有加入事件循环, fd还归这里, 直接关闭
+		closeFd(localFd)
+		remote.Close()
+		return nil, conf.reject(w, r, http.StatusInternalServerError, err)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	f.Flush()
+
+	done := bridgeStream(remote, r.Body, &http2StreamWriter{w: w, f: f})
+	c.startTick()
+	conf.Callback.OnOpen(c)
+	<-done
+	return c, nil
+}
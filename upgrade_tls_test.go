@@ -0,0 +1,21 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package greatws
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"testing"
+)
+
+func Test_GetFdFromTLSConn(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	if _, err := getFdFromConn(tls.Client(a, &tls.Config{})); !errors.Is(err, ErrTLSNotSupported) {
+		t.Fatalf("err = %v", err)
+	}
+}
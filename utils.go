@@ -12,13 +12,15 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
+//go:build !js
+// +build !js
+
 package greatws
 
 import (
 	"crypto/sha1"
 	"encoding/base64"
 	"math/rand"
-	"net/http"
 	"reflect"
 	"time"
 	"unsafe"
@@ -58,29 +60,3 @@ func secWebSocketAcceptVal(val string) string {
 	r := s.Sum(nil)
 	return base64.StdEncoding.EncodeToString(r)
 }
-
-// 是否打开解压缩
-func needDecompression(header http.Header) bool {
-	for _, ext := range parseExtensions(header) {
-		if ext[""] != "permessage-deflate" {
-			continue
-		}
-		return true
-	}
-
-	return false
-}
-
-// 客户端用的函数
-func maybeCompressionDecompression(header http.Header) bool {
-	for _, ext := range parseExtensions(header) {
-		if ext[""] != "permessage-deflate" {
-			continue
-		}
-		_, s := ext["server_no_context_takeover"]
-		_, c := ext["client_no_context_takeover"]
-		return s || c
-	}
-
-	return false
-}
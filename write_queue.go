@@ -0,0 +1,256 @@
+// Copyright 2021-2023 antlabs. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// 每一段的大小
+const writeSegmentSize = 16 * 1024
+
+type writeSegment struct {
+	buf  []byte
+	r    int // 已经写到fd的位置
+	w    int // 已经放入数据的位置
+	next *writeSegment
+
+	// 不为nil时是文件段, 数据在文件的[off, end)里, 用sendfile发送, 不占用buf
+	file *os.File
+	off  int64
+	end  int64
+}
+
+var writeSegmentPool = sync.Pool{
+	New: func() any {
+		return &writeSegment{buf: make([]byte, writeSegmentSize)}
+	},
+}
+
+// 写缓冲区, 直接write写不完的数据放在这里, 等可写事件再写
+// 由池化的固定大小的段组成链表, 写出去一部分只移动队头的偏移, 写完的段马上还回池子
+// 不会因为一次EAGAIN把剩下的数据整体拷贝一遍
+type writeQueue struct {
+	head *writeSegment
+	tail *writeSegment
+	size int
+}
+
+// 还没有写出去的数据长度
+func (q *writeQueue) Len() int {
+	return q.size
+}
+
+// 追加到队尾, 先填满最后一段的空闲空间
+func (q *writeQueue) Append(b []byte) {
+	q.size += len(b)
+	for len(b) > 0 {
+		if q.tail == nil || q.tail.file != nil || q.tail.w == len(q.tail.buf) {
+			q.link(writeSegmentPool.Get().(*writeSegment))
+		}
+
+		n := copy(q.tail.buf[q.tail.w:], b)
+		q.tail.w += n
+		b = b[n:]
+	}
+}
+
+// 追加文件的[off, off+n), 发送完之后关闭f
+func (q *writeQueue) AppendFile(f *os.File, off, n int64) {
+	q.size += int(n)
+	q.link(&writeSegment{file: f, off: off, end: off + n})
+}
+
+func (q *writeQueue) link(seg *writeSegment) {
+	if q.tail == nil {
+		q.head = seg
+	} else {
+		q.tail.next = seg
+	}
+	q.tail = seg
+}
+
+// 队头还没有写出去的数据, 队头是文件段时返回nil
+func (q *writeQueue) Front() []byte {
+	if q.head == nil || q.head.file != nil {
+		return nil
+	}
+	return q.head.buf[q.head.r:q.head.w]
+}
+
+// 队头是文件段时, 返回还没有发送的范围
+func (q *writeQueue) FrontFile() (f *os.File, off int64, n int) {
+	if q.head == nil || q.head.file == nil {
+		return nil, 0, 0
+	}
+	return q.head.file, q.head.off, int(q.head.end - q.head.off)
+}
+
+// 队头写出去了n字节, 写完的段还回池子
+func (q *writeQueue) Advance(n int) {
+	q.size -= n
+	for n > 0 && q.head != nil {
+		seg := q.head
+		if seg.file != nil {
+			m := int(seg.end - seg.off)
+			if n < m {
+				seg.off += int64(n)
+				return
+			}
+
+			n -= m
+			q.head = seg.next
+			if q.head == nil {
+				q.tail = nil
+			}
+			seg.file.Close()
+			continue
+		}
+
+		m := seg.w - seg.r
+		if n < m {
+			seg.r += n
+			return
+		}
+
+		n -= m
+		q.head = seg.next
+		if q.head == nil {
+			q.tail = nil
+		}
+		seg.r, seg.w, seg.next = 0, 0, nil
+		writeSegmentPool.Put(seg)
+	}
+}
+
+// 去掉还没有写出去的[off, off+n), off从队头算起, 用于取消还没有开始发送的帧
+// 范围里有文件段, 或者超出了队列的长度时不处理, 返回false
+func (q *writeQueue) Remove(off, n int) bool {
+	if off < 0 || n < 0 || off+n > q.size {
+		return false
+	}
+	pos := 0
+	for seg := q.head; seg != nil && pos < off+n; seg = seg.next {
+		m := seg.w - seg.r
+		if seg.file != nil {
+			m = int(seg.end - seg.off)
+			if pos+m > off {
+				return false
+			}
+		}
+		pos += m
+	}
+
+	var prev *writeSegment
+	for seg := q.head; seg != nil && n > 0; {
+		next := seg.next
+		m := seg.w - seg.r
+		if seg.file != nil {
+			m = int(seg.end - seg.off)
+		}
+		if off >= m {
+			off -= m
+			prev, seg = seg, next
+			continue
+		}
+
+		start := seg.r + off
+		k := min(n, m-off)
+		copy(seg.buf[start:], seg.buf[start+k:seg.w])
+		seg.w -= k
+		q.size -= k
+		n -= k
+		off = 0
+		if seg.r < seg.w {
+			prev, seg = seg, next
+			continue
+		}
+
+		// 这一段空了, 从链表里摘掉
+		if prev == nil {
+			q.head = next
+		} else {
+			prev.next = next
+		}
+		if q.tail == seg {
+			q.tail = prev
+		}
+		seg.r, seg.w, seg.next = 0, 0, nil
+		writeSegmentPool.Put(seg)
+		seg = next
+	}
+	return true
+}
+
+// 编码好的一帧, 等待写到fd
+type outboundFrame struct {
+	buf  *[]byte // 来自bytespool, 写出去之后还回池子
+	n    int
+	next *outboundFrame
+
+	wait *writeWaiter // WriteMessageDeadline等待这一帧写到内核, 为nil表示不等待
+	data bool         // 数据帧, 按DropPolicy统计积压
+
+	// 不为nil时, buf里只有帧头, payload是文件的[off, off+size)
+	file *os.File
+	off  int64
+	size int64
+}
+
+var outboundFramePool = sync.Pool{
+	New: func() any {
+		return &outboundFrame{}
+	},
+}
+
+// 发送队列, 多个生产者单个消费者
+// 生产者在锁外编码好整帧, 只用一次CAS放进队列, 同一时间只有一个go程把队列里的帧写到fd
+// 只会整体取出, 不会单个出队, 所以复用节点也没有ABA的问题
+type outboundQueue struct {
+	top atomic.Pointer[outboundFrame]
+}
+
+func (q *outboundQueue) Push(f *outboundFrame) {
+	for {
+		old := q.top.Load()
+		f.next = old
+		if q.top.CompareAndSwap(old, f) {
+			return
+		}
+	}
+}
+
+// 取出所有的帧, 返回按入队顺序排好的链表
+func (q *outboundQueue) PopAll() *outboundFrame {
+	f := q.top.Swap(nil)
+	// 栈是后进先出, 反转一下
+	var head *outboundFrame
+	for f != nil {
+		next := f.next
+		f.next = head
+		head = f
+		f = next
+	}
+	return head
+}
+
+func (q *outboundQueue) Empty() bool {
+	return q.top.Load() == nil
+}
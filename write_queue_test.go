@@ -0,0 +1,127 @@
+//go:build !js
+// +build !js
+
+package greatws
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func Test_WriteQueue(t *testing.T) {
+	var q writeQueue
+	data := bytes.Repeat([]byte("0123456789"), writeSegmentSize/4)
+	q.Append(data[:100])
+	q.Append(data[100:])
+	if q.Len() != len(data) {
+		t.Fatalf("Len = %d, want %d", q.Len(), len(data))
+	}
+
+	// 每次只写出去一部分, 模拟部分写
+	var out []byte
+	for q.Len() > 0 {
+		b := q.Front()
+		if len(b) > 3000 {
+			b = b[:3000]
+		}
+		out = append(out, b...)
+		q.Advance(len(b))
+	}
+
+	if !bytes.Equal(out, data) {
+		t.Fatal("data mismatch")
+	}
+	if q.head != nil || q.tail != nil {
+		t.Fatal("segments should be released")
+	}
+}
+
+func Test_WriteQueueFile(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "wq")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("0123456789")
+
+	var q writeQueue
+	q.Append([]byte("head"))
+	q.AppendFile(f, 2, 6)
+	q.Append([]byte("tail"))
+	if q.Len() != 14 {
+		t.Fatalf("Len = %d", q.Len())
+	}
+
+	var out []byte
+	for q.Len() > 0 {
+		if file, off, n := q.FrontFile(); file != nil {
+			b := make([]byte, min(n, 4))
+			file.ReadAt(b, off)
+			out = append(out, b...)
+			q.Advance(len(b))
+			continue
+		}
+		b := q.Front()
+		out = append(out, b...)
+		q.Advance(len(b))
+	}
+
+	if string(out) != "head234567tail" {
+		t.Fatalf("out = %q", out)
+	}
+	// 发送完的文件段会关闭文件
+	if _, err := f.Stat(); err == nil {
+		t.Fatal("file should be closed")
+	}
+}
+
+func Test_WriteQueueRemove(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), writeSegmentSize/4)
+	drain := func(q *writeQueue) []byte {
+		var out []byte
+		for q.Len() > 0 {
+			b := q.Front()
+			out = append(out, b...)
+			q.Advance(len(b))
+		}
+		return out
+	}
+
+	for _, tc := range []struct {
+		name    string
+		off, n  int
+		written int // 先写出去的长度
+	}{
+		{"head", 0, 100, 0},
+		{"tail", len(data) - 5000, 5000, 0},
+		{"middle across segments", writeSegmentSize - 10, writeSegmentSize + 20, 0},
+		{"whole segment", writeSegmentSize, writeSegmentSize, 0},
+		{"after partial write", 50, 300, 1000},
+		{"all", 0, len(data), 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var q writeQueue
+			q.Append(data)
+			q.Advance(tc.written)
+			if !q.Remove(tc.off, tc.n) {
+				t.Fatal("Remove failed")
+			}
+			rest := data[tc.written:]
+			want := append(append([]byte(nil), rest[:tc.off]...), rest[tc.off+tc.n:]...)
+			if q.Len() != len(want) {
+				t.Fatalf("Len = %d, want %d", q.Len(), len(want))
+			}
+			// 撤回之后还能继续追加
+			q.Append([]byte("end"))
+			if got := drain(&q); !bytes.Equal(got, append(want, "end"...)) {
+				t.Fatalf("data mismatch, len %d, want %d", len(got), len(want)+3)
+			}
+		})
+	}
+
+	var q writeQueue
+	q.Append(data[:10])
+	if q.Remove(5, 10) {
+		t.Fatal("out of range")
+	}
+}